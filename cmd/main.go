@@ -1,73 +1,321 @@
 package main
 
 import (
-	// "fmt"
-
+	"context"
+	"database/sql"
 	"fmt"
 	"log"
-	"strings"
-
-	"net/http"
 	"os"
-
-	"database/sql"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	// The time zones of OVERDUE_TIMEZONE, for hosts without them.
+	_ "time/tzdata"
 
 	"github.com/emicklei/go-restful/v3"
-	"github.com/joho/godotenv"
 	_ "github.com/mattn/go-sqlite3"
 
 	route "github.com/riszkymf/golang-rest-boilerplate/internal"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/circulation"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/config"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/events"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/fines"
 	handler "github.com/riszkymf/golang-rest-boilerplate/internal/handler"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/health"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/idempotency"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/importer"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/jobs"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/migration"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/overdue"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/server"
 	src "github.com/riszkymf/golang-rest-boilerplate/internal/src"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/tenant"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/tracing"
 )
 
-type Env struct {
-	DB_PATH    string
-	APP_ENV    string
-	APP_HOST   string
-	APP_PORT   string
-	WS_LOGGING string
-	WS_AUTH    string
-}
+const usage = `Usage:
+  server [serve] [flags]     run the REST API
+  server config show [flags] print the effective configuration, secrets redacted
+  server purge [flags]       delete for good the rows deleted longer than purge.retention ago,
+                             in the database of every tenant too when tenants.enabled
+
+Run "server serve -h" to list every flag.
+`
 
-var env Env
+var cfg *config.Config
 var Connection *sql.DB
 
-func init() {
-	var err error
-	if os.Getenv("APP_ENV") != "production" {
-		godotenv.Load()
+func main() {
+	args := os.Args[1:]
+	switch {
+	case len(args) == 0 || args[0] == "serve":
+		if len(args) > 0 {
+			args = args[1:]
+		}
+		serve(loadConfig(args))
+	case strings.HasPrefix(args[0], "-"):
+		serve(loadConfig(args))
+	case len(args) >= 2 && args[0] == "config" && args[1] == "show":
+		loadConfig(args[2:]).Print(os.Stdout)
+	case args[0] == "purge":
+		purge(loadConfig(args[1:]))
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
 	}
+}
 
-	env.APP_ENV = src.GetEnv("APP_ENV", "staging")
-	env.APP_HOST = src.GetEnv("APP_HOST", "0.0.0.0")
-	env.APP_PORT = src.GetEnv("APP_PORT", "8080")
-	env.DB_PATH = src.GetEnv("DB_PATH", "")
-	env.WS_LOGGING = strings.ToUpper(src.GetEnv("WS_LOGGING", "TRUE"))
-	env.WS_AUTH = strings.ToUpper(src.GetEnv("WS_AUTH", "TRUE"))
-
-	if env.DB_PATH == "" {
-		log.Fatal("DB Location is invalid")
+func loadConfig(args []string) *config.Config {
+	loaded, err := config.Load(args)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, warning := range loaded.Warnings {
+		src.LogWarning("[config]", "load", warning)
 	}
+	return loaded
+}
 
-	Connection, err = sql.Open("sqlite3", env.DB_PATH)
+func initDatabase() {
+	var err error
+	Connection, err = sql.Open("sqlite3", cfg.DB.Path)
 	if err != nil {
 		log.Fatal(err)
 	}
 	handler.Connection = Connection
 
+	if cfg.DB.AutoMigrate {
+		_, err = migration.Migrate(context.Background(), Connection)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	tenant.Default.SetOptions(tenant.Options{
+		Dir:         cfg.Tenants.Dir,
+		AutoMigrate: cfg.DB.AutoMigrate,
+		ReplayLimit: cfg.Events.StreamReplayLimit,
+	})
 }
 
-func main() {
+// keptTables soft delete but are never purged: the code of a deleted tenant
+// is not given to another.
+var keptTables = map[string]bool{"tenants": true}
+
+func purge(loaded *config.Config) {
+	cfg = loaded
+	initDatabase()
+	defer Connection.Close()
+
+	defer tenant.Default.Close()
+
+	before := time.Now().Add(-cfg.Purge.Retention)
+	purgeDatabase := func(ctx context.Context, prefix string) error {
+		// Every table that soft deletes, those referencing others first.
+		tables, err := handler.SoftDeleteTablesContext(ctx)
+		if err != nil {
+			return err
+		}
+		for _, table := range tables {
+			if keptTables[table] {
+				continue
+			}
+			purged, err := handler.PurgeDeletedContext(ctx, table, before)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%v%v: %v rows purged\n", prefix, table, purged)
+		}
+		return nil
+	}
+	ctx := context.Background()
+	if err := purgeDatabase(ctx, ""); err != nil {
+		log.Fatal(err)
+	}
+	if cfg.Tenants.Enabled {
+		err := tenant.Default.Each(ctx, func(ctx context.Context, code string) error {
+			return purgeDatabase(ctx, code+"/")
+		})
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+func initHealthChecks() {
+	connection := func() *sql.DB { return handler.Connection }
+	health.Default.SetTimeout(cfg.Health.CheckTimeout)
+	health.Register("database", health.DatabaseChecker(connection))
+	health.Register("migrations", health.MigrationChecker(connection))
+	health.Register("disk", health.DiskSpaceChecker(cfg.DB.Path, cfg.Health.MinFreeBytes))
+	health.Register("wal", health.WALChecker(connection, cfg.DB.Path))
+}
+
+func initTracer() *tracing.Tracer {
+	var exporter tracing.Exporter
+	switch cfg.Tracing.Exporter {
+	case "stdout":
+		exporter = tracing.NewWriterExporter(os.Stdout)
+	case "file":
+		fileExporter, err := tracing.NewFileExporter(cfg.Tracing.File)
+		if err != nil {
+			log.Fatal(err)
+		}
+		exporter = fileExporter
+	case "otlp":
+		exporter = tracing.NewOTLPExporter(cfg.Tracing.OTLPEndpoint, cfg.Tracing.Headers())
+	}
+	onError := func(err error) {
+		src.CheckError(err, "[tracing]", "export spans")
+	}
+	return tracing.NewTracer(cfg.Tracing.ServiceName, exporter, onError)
+}
+
+func serverConfig() server.Config {
+	return server.Config{
+		Host:              cfg.App.Host,
+		Port:              cfg.App.PortString(),
+		UnixSocket:        cfg.App.Socket,
+		ReadTimeout:       cfg.App.ReadTimeout,
+		ReadHeaderTimeout: cfg.App.ReadHeaderTimeout,
+		WriteTimeout:      cfg.App.WriteTimeout,
+		IdleTimeout:       cfg.App.IdleTimeout,
+		MaxHeaderBytes:    cfg.App.MaxHeaderBytes,
+		ShutdownTimeout:   cfg.App.ShutdownTimeout,
+		TLSCertFile:       cfg.App.TLSCert,
+		TLSKeyFile:        cfg.App.TLSKey,
+	}
+}
+
+// runBackground runs the background work on the database ctx queries until
+// ctx is done: sweeping the idempotency keys, dispatching the webhooks,
+// following the outbox for the event streams, flagging the overdue loans and
+// expiring the holds.
+func runBackground(ctx context.Context) {
+	var running sync.WaitGroup
+	loops := []func(){
+		func() { idempotency.Default.RunSweeper(ctx, cfg.Idempotency.SweepInterval) },
+		func() { events.Default.Run(ctx, cfg.Webhooks.DispatchInterval) },
+		// Stopping the broker ends the event streams, which would otherwise
+		// hold the shutdown.
+		func() { events.BrokerFromContext(ctx).Run(ctx, cfg.Events.PollInterval) },
+		func() { overdue.Default.Run(ctx, cfg.Overdue.Interval) },
+		func() { circulation.Default.Run(ctx, cfg.Holds.ExpiryInterval) },
+	}
+	for _, loop := range loops {
+		running.Add(1)
+		go func(loop func()) {
+			defer running.Done()
+			loop()
+		}(loop)
+	}
+	running.Wait()
+}
+
+func serve(loaded *config.Config) {
+	cfg = loaded
+	initDatabase()
+	tracing.SetTracer(initTracer())
+	initHealthChecks()
+	importer.DefaultLimits = importer.Limits{MaxBytes: int64(cfg.Import.MaxBytes), AsyncRows: cfg.Import.AsyncRows}
+	jobs.Default.SetRetention(cfg.Import.JobRetention)
+	idempotency.Default.SetTTL(cfg.Idempotency.TTL)
+	handler.OnChange(events.Record)
+	events.Default.SetOptions(events.Options{
+		Timeout:     cfg.Webhooks.Timeout,
+		MaxAttempts: cfg.Webhooks.MaxAttempts,
+		Backoff:     cfg.Webhooks.Backoff,
+		MaxBackoff:  cfg.Webhooks.MaxBackoff,
+		BatchSize:   100,
+	})
+	events.DefaultBroker.SetReplayLimit(cfg.Events.StreamReplayLimit)
+	location, err := time.LoadLocation(cfg.Overdue.Timezone)
+	if err != nil {
+		log.Fatal(err)
+	}
+	overdue.Default.SetLocation(location)
+	fines.Default.SetPolicy(fines.Policy{
+		DailyRate:  cfg.Fines.DailyRate,
+		GraceDays:  cfg.Fines.GraceDays,
+		Cap:        cfg.Fines.Cap,
+		LostCost:   cfg.Fines.LostCost,
+		MaxBalance: cfg.Fines.MaxBalance,
+		Currency:   cfg.Fines.Currency,
+	})
+	handler.OnChange(fines.Default.Assess)
+	circulation.Default.SetPolicy(circulation.Policy{
+		MaxLoans:       cfg.Loans.MaxActive,
+		LoanDays:       cfg.Loans.PeriodDays,
+		RenewalDays:    cfg.Loans.RenewalDays,
+		MaxRenewals:    cfg.Loans.MaxRenewals,
+		MaxOverdueDays: cfg.Loans.MaxOverdueDays,
+	})
+	circulation.Default.SetPickupDays(cfg.Holds.PickupDays)
+
 	wsRConfig := route.RouteFilterConfig{
-		WebServiceLogging: env.WS_LOGGING,
-		Auth:              env.WS_AUTH,
+		WebServiceLogging: cfg.WS.Logging,
+		Auth:              cfg.WS.Auth,
+		RequireIfMatch:    cfg.Concurrency.RequireIfMatch,
+		PrincipalHeader:   cfg.Audit.PrincipalHeader,
+		PrincipalKey:      cfg.Audit.TokenKey,
+		PrincipalClaim:    cfg.Audit.TokenClaim,
+		StreamHeartbeat:   cfg.Events.StreamHeartbeat,
+		StreamDuration:    cfg.App.StreamDuration(),
+		Tenants:           cfg.Tenants.Enabled,
+		TenantResolver: tenant.Resolver{
+			Header: cfg.Tenants.Header,
+			Domain: cfg.Tenants.Domain,
+			Key:    cfg.Tenants.TokenKey,
+			Claim:  cfg.Tenants.TokenClaim,
+		},
+		TenantAdminToken: cfg.Tenants.AdminToken,
 	}
 	ws := restful.NewContainer()
 	ws = route.SetFilters(ws, wsRConfig)
-	ws = route.SetRoutes(ws)
-	appHost := fmt.Sprintf("%v:%v", env.APP_HOST, env.APP_PORT)
-	log.Printf("Running App on %v", appHost)
-	log.Fatal(http.ListenAndServe(appHost, ws))
+	ws = route.SetRoutes(ws, wsRConfig)
 
+	srv, err := server.New(serverConfig(), ws)
+	if err != nil {
+		log.Fatal(err)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	backgroundDone := make(chan struct{})
+	go func() {
+		runBackground(ctx)
+		close(backgroundDone)
+	}()
+	// Each tenant has its own, from the time its database is opened until it
+	// is deleted or the server shuts down. Every tenant is opened on start, so
+	// that an idle one still has its loans flagged and its events delivered.
+	tenant.Default.OnOpen(func(tenantCtx context.Context) {
+		tenantCtx, cancel := context.WithCancel(tenantCtx)
+		defer cancel()
+		go func() {
+			select {
+			case <-ctx.Done():
+				cancel()
+			case <-tenantCtx.Done():
+			}
+		}()
+		runBackground(tenantCtx)
+	})
+	if cfg.Tenants.Enabled {
+		src.CheckError(tenant.Default.OpenAll(ctx), "[tenant]", "open databases")
+	}
+	err = srv.Run(ctx)
+	src.CheckError(err, "[server]", "run")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.App.ShutdownTimeout)
+	defer cancel()
+	src.CheckError(jobs.Default.Wait(shutdownCtx), "[jobs]", "wait for running jobs")
+	<-backgroundDone
+	src.CheckError(tenant.Default.Close(), "[tenant]", "close databases")
+	src.CheckError(tracing.GetTracer().Shutdown(shutdownCtx), "[tracing]", "shutdown")
+	src.CheckError(Connection.Close(), "db", "close connection")
+	src.LogInfo("[server]", "shutdown", "server stopped")
+	if err != nil {
+		os.Exit(1)
+	}
 }
@@ -1,53 +1,42 @@
 package test
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
-	"os"
 	"testing"
 	"time"
 
-	"github.com/joho/godotenv"
 	_ "github.com/mattn/go-sqlite3"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/config"
 	"github.com/riszkymf/golang-rest-boilerplate/internal/handler"
-	"github.com/riszkymf/golang-rest-boilerplate/internal/src"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/migration"
 )
 
-type Env struct {
-	DB_PATH  string
-	APP_ENV  string
-	APP_HOST string
-	APP_PORT string
-}
-
-var env Env
-
 type FuncTest func(*testing.T, *sql.DB)
 
 func TestDBFunctionality(t *testing.T) {
-	var err error
 	var Connection *sql.DB
-	if os.Getenv("APP_ENV") != "production" {
-		godotenv.Load()
-	}
-
-	env.APP_ENV = src.GetEnv("APP_ENV", "staging")
-	env.APP_HOST = src.GetEnv("APP_HOST", "0.0.0.0")
-	env.APP_PORT = src.GetEnv("APP_PORT", "8080")
-	env.DB_PATH = src.GetEnv("DB_PATH", "")
-	if env.DB_PATH == "" {
-		log.Fatal("DB Location is invalid")
+	cfg, err := config.Load(nil)
+	if err != nil {
+		log.Fatal(err)
 	}
 
 	dataHolder := map[string][]int{
 		"author": {6},
 	}
 
-	Connection, err = sql.Open("sqlite3", env.DB_PATH)
+	Connection, err = sql.Open("sqlite3", cfg.DB.Path)
 	if err != nil {
 		log.Fatal(err)
 	}
+	// The schema is that the server leaves behind, which migrates on start.
+	if cfg.DB.AutoMigrate {
+		if _, err := migration.Migrate(context.Background(), Connection); err != nil {
+			log.Fatal(err)
+		}
+	}
 	handler.Connection = Connection
 
 	t.Run("Setup Data", func(t *testing.T) {
@@ -64,22 +53,18 @@ func TestDBFunctionality(t *testing.T) {
 		inputBook := []map[string]any{
 			{
 				"title":     "moby dick",
-				"stock":     14,
 				"author_id": inputAuthor["id"],
 			},
 			{
 				"title":     "bartleby, the scrivener",
-				"stock":     5,
 				"author_id": inputAuthor["id"],
 			},
 			{
 				"title":     "benito cereno",
-				"stock":     5,
 				"author_id": inputAuthor["id"],
 			},
 			{
 				"title":     "isle of the cross",
-				"stock":     7,
 				"author_id": inputAuthor["id"],
 			},
 		}
@@ -91,6 +76,22 @@ func TestDBFunctionality(t *testing.T) {
 		fmt.Println("Insert Result : ", bookResult)
 		dataHolder["books"] = bookResult
 
+		// Every book is shelved as that many copies.
+		inputCopies := []map[string]any{}
+		for i, copies := range []int{14, 5, 5, 7} {
+			for n := 1; n <= copies; n++ {
+				inputCopies = append(inputCopies, map[string]any{
+					"barcode":    fmt.Sprintf("melville-%06d-%03d", bookResult[i], n),
+					"book_id":    bookResult[i],
+					"status":     "available",
+					"created_at": time.Now().UTC().Format(time.RFC3339),
+				})
+			}
+		}
+		if _, err := handler.InsertMultipleData("copies", inputCopies); err != nil {
+			t.Fatalf(`Error: %v`, err)
+		}
+
 		inputMembers := []map[string]any{
 			{
 				"email":     "janice@email.com",
@@ -137,7 +138,7 @@ func TestDBFunctionality(t *testing.T) {
 						ValueType: "string",
 					},
 				},
-				"stock": {
+				"available": {
 					{
 						Operator:  "lt",
 						Value:     "10",
@@ -166,43 +167,139 @@ func TestDBFunctionality(t *testing.T) {
 			t.Fatalf(`Error: %v`, err)
 		}
 		fmt.Println("Insert Result : ", result)
+		if len(result) != 2 {
+			t.Fatalf("bartleby and isle of the cross should match, got %v", result)
+		}
+		for _, book := range result {
+			if book["available"] != book["copies"] {
+				t.Errorf("every copy of %v should be available, got %v", book["title"], book)
+			}
+		}
 		booksData = result
 
 	})
 
-	t.Run("Update and transaction", func(t *testing.T) {
-		members, err := handler.GetRowsAll("members")
+	t.Run("Iterate", func(t *testing.T) {
+		rows, err := handler.QueryRows("v_books", handler.ListQuery{
+			Filter: handler.FilterQuery{
+				And: map[string][]handler.FieldFilter{
+					"author_name": {{Operator: "eq", Value: "Herman Melville", ValueType: "string"}},
+				},
+			},
+			Sort: []handler.SortField{{Column: "available", Desc: true}, {Column: "title"}},
+		})
 		if err != nil {
 			t.Fatalf(`Error: %v`, err)
 		}
-		fmt.Println(members)
-		fmt.Println(booksData)
-		insertRecordData := map[string]any{
-			"book_id":     booksData[0]["book_id"],
-			"member_id":   members[3]["id"],
-			"rent_date":   time.Now().Local().Format("2006-01-02"),
-			"due_date":    time.Now().AddDate(0, 0, 14).Local().Format("2006-01-02"),
-			"rent_status": "rented",
-		}
-		fmt.Println(insertRecordData)
-		res, err := handler.InsertData("records", insertRecordData)
+		defer rows.Close()
+		titles := []any{}
+		for rows.Next() {
+			titles = append(titles, rows.Row()["title"])
+		}
+		if err := rows.Close(); err != nil {
+			t.Fatalf(`Error: %v`, err)
+		}
+		expected := []any{"moby dick", "isle of the cross", "bartleby, the scrivener", "benito cereno"}
+		if fmt.Sprint(titles) != fmt.Sprint(expected) {
+			t.Errorf("unexpected order %v", titles)
+		}
+	})
+
+	t.Run("Upsert", func(t *testing.T) {
+		book := map[string]any{"title": "typee", "author_id": 6, "isbn": "9780140434880"}
+		row, err := handler.UpsertRow("books", book, []string{"isbn"}, []string{"title"})
+		if err != nil {
+			t.Fatalf(`Error: %v`, err)
+		}
+		typee := row["id"]
+		book["title"] = "typee: a peep at polynesian life"
+		row, err = handler.UpsertRow("books", book, []string{"isbn"}, []string{"title"})
+		if err != nil {
+			t.Fatalf(`Error: %v`, err)
+		}
+		if row["id"] != typee || row["title"] != book["title"] {
+			t.Errorf("book with the same isbn should be updated, got %v", row)
+		}
+		book["title"] = "omoo"
+		id, err := handler.Upsert("books", book, []string{"isbn"}, nil)
+		if err != nil {
+			t.Fatalf(`Error: %v`, err)
+		}
+		row, _ = handler.GetRowById("books", id)
+		if id != typee || row["title"] != "typee: a peep at polynesian life" {
+			t.Errorf("existing book should be left as is, got %v", row)
+		}
+		_, err = handler.InsertData("books", book)
+		if !handler.IsConflict(err) {
+			t.Errorf("duplicate isbn should be a conflict, got %v", err)
+		}
+		// Titles are not unique, and a book without an ISBN matches no other:
+		// upserting it inserts a new book every time.
+		untitled := map[string]any{"title": "moby dick", "author_id": 6, "isbn": nil}
+		row, err = handler.UpsertRow("books", untitled, []string{"isbn"}, []string{"title"})
 		if err != nil {
 			t.Fatalf(`Error: %v`, err)
 		}
-		fmt.Println("Record inserted with id ", res)
+		if row["id"] == dataHolder["books"][0] {
+			t.Errorf("book without isbn should not match another, got %v", row)
+		}
+	})
 
-		bookStock, ok := booksData[0]["stock"].(int)
+	t.Run("Update and transaction", func(t *testing.T) {
+		if len(booksData) == 0 {
+			t.Fatalf("Query found no book to lend")
+		}
+		members, err := handler.GetRowsAll("members")
+		if err != nil {
+			t.Fatalf(`Error: %v`, err)
+		}
+		fmt.Println(members)
+		fmt.Println(booksData)
+		bookId := booksData[0]["book_id"].(int)
+		available, ok := booksData[0]["available"].(int)
 		if !ok {
 			t.Fatalf(`Error: type is not number`)
 		}
-		updateBookData := map[string]any{
-			"stock": bookStock - 1,
+		copies, err := handler.GetRowByFilter("copies", handler.FilterQuery{And: map[string][]handler.FieldFilter{
+			"book_id": {{Operator: "eq", Value: fmt.Sprint(bookId), ValueType: "int"}},
+			"status":  {{Operator: "eq", Value: "available", ValueType: "string"}},
+		}})
+		if err != nil || len(copies) == 0 {
+			t.Fatalf(`Error: no copy to lend: %v`, err)
 		}
-		bookId := booksData[0]["book_id"].(int)
-		err = handler.UpdateData("books", updateBookData, bookId)
+		copyId := copies[0]["id"].(int)
+
+		// The loan and the copy leaving the shelf commit together.
+		err = handler.WithTransaction(context.Background(), func(ctx context.Context) error {
+			insertRecordData := map[string]any{
+				"book_id":     bookId,
+				"copy_id":     copyId,
+				"member_id":   members[3]["id"],
+				"rent_date":   time.Now().Local().Format("2006-01-02"),
+				"due_date":    time.Now().AddDate(0, 0, 14).Local().Format("2006-01-02"),
+				"rent_status": "rented",
+			}
+			fmt.Println(insertRecordData)
+			res, err := handler.InsertDataContext(ctx, "records", insertRecordData)
+			if err != nil {
+				return err
+			}
+			fmt.Println("Record inserted with id ", res)
+			return handler.UpdateDataContext(ctx, "copies", map[string]any{"status": "on_loan"}, copyId)
+		})
 		if err != nil {
 			t.Fatalf(`Error: %v`, err)
 		}
+
+		book, err := handler.GetRowByFilter("v_books", handler.FilterQuery{And: map[string][]handler.FieldFilter{
+			"book_id": {{Operator: "eq", Value: fmt.Sprint(bookId), ValueType: "int"}},
+		}})
+		if err != nil || len(book) != 1 {
+			t.Fatalf(`Error: %v`, err)
+		}
+		if book[0]["available"] != available-1 || book[0]["copies"] != booksData[0]["copies"] {
+			t.Errorf("lent copy should leave the shelf, got %v", book[0])
+		}
 	})
 
 	t.Run("Cleanup", func(t *testing.T) {
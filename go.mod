@@ -3,11 +3,14 @@ module github.com/riszkymf/golang-rest-boilerplate
 go 1.18
 
 require (
+	github.com/BurntSushi/toml v1.2.1
 	github.com/emicklei/go-restful/v3 v3.9.0
 	github.com/google/uuid v1.3.0
 	github.com/joho/godotenv v1.4.0
 	github.com/mattn/go-sqlite3 v1.14.15
 	github.com/sirupsen/logrus v1.9.0
+	github.com/swaggo/files/v2 v2.0.2
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
@@ -0,0 +1,205 @@
+package catalogue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/riszkymf/golang-rest-boilerplate/internal/handler"
+)
+
+const subjectTable = "book_subjects"
+
+// ErrInvalidBook wraps the reasons the description of a book is refused.
+var ErrInvalidBook = errors.New("invalid book")
+
+// Columns are the optional columns describing a book, which Normalize checks.
+// isbn10 is not among them: it follows isbn.
+var Columns = []string{"isbn", "publisher", "publication_year", "edition", "language", "page_count", "description"}
+
+// languagePattern is an ISO 639-1 or 639-2 code, e.g. en or eng.
+var languagePattern = regexp.MustCompile(`^[a-z]{2,3}$`)
+
+// Normalize checks the columns of data describing a book and writes them as
+// they are stored: an ISBN as its ISBN-13, setting isbn10 along, text trimmed
+// and the language lowercase. Empty values are stored as NULL. Numbers may be
+// given as JSON numbers or as text.
+func Normalize(data map[string]any) error {
+	for _, column := range Columns {
+		value, ok := data[column]
+		if !ok {
+			continue
+		}
+		if text, isText := value.(string); isText && column != "description" {
+			value = strings.TrimSpace(text)
+		}
+		if value == "" {
+			value = nil
+		}
+		var err error
+		switch column {
+		case "isbn":
+			data["isbn10"] = nil
+			if value == nil {
+				break
+			}
+			var isbn ISBN
+			if isbn, err = ParseISBN(fmt.Sprint(value)); err == nil {
+				value = isbn.ISBN13
+				if isbn.ISBN10 != "" {
+					data["isbn10"] = isbn.ISBN10
+				}
+			}
+		case "publication_year":
+			if value == nil {
+				break
+			}
+			var year int
+			if year, err = integer(column, value); err == nil && (year <= 0 || year > time.Now().Year()+1) {
+				err = fmt.Errorf("%w: publication_year %v is not a year of publication", ErrInvalidBook, year)
+			}
+			value = year
+		case "page_count":
+			if value == nil {
+				break
+			}
+			var pages int
+			if pages, err = integer(column, value); err == nil && pages <= 0 {
+				err = fmt.Errorf("%w: page_count must be positive", ErrInvalidBook)
+			}
+			value = pages
+		case "language":
+			if value == nil {
+				break
+			}
+			language := strings.ToLower(fmt.Sprint(value))
+			if !languagePattern.MatchString(language) {
+				err = fmt.Errorf("%w: language %q must be an ISO 639 code, e.g. en", ErrInvalidBook, value)
+			}
+			value = language
+		default:
+			if value != nil {
+				if _, isText := value.(string); !isText {
+					err = fmt.Errorf("%w: %v must be text", ErrInvalidBook, column)
+				}
+			}
+		}
+		if err != nil {
+			return err
+		}
+		data[column] = value
+	}
+	return nil
+}
+
+func integer(column string, value any) (int, error) {
+	switch v := value.(type) {
+	case int:
+		return v, nil
+	case float64:
+		if v == math.Trunc(v) {
+			return int(v), nil
+		}
+	case string:
+		if n, err := strconv.Atoi(v); err == nil {
+			return n, nil
+		}
+	case json.Number:
+		if n, err := strconv.Atoi(v.String()); err == nil {
+			return n, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %v must be an integer, got %v", ErrInvalidBook, column, value)
+}
+
+// NormalizeSubjects trims the subject headings and drops the repeated ones,
+// keeping their order. It refuses an empty heading.
+func NormalizeSubjects(headings []string) ([]string, error) {
+	seen := map[string]bool{}
+	subjects := []string{}
+	for _, heading := range headings {
+		heading = strings.TrimSpace(heading)
+		if heading == "" {
+			return nil, fmt.Errorf("%w: a subject heading is empty", ErrInvalidBook)
+		}
+		if len(heading) > 255 {
+			return nil, fmt.Errorf("%w: a subject heading is longer than 255 bytes", ErrInvalidBook)
+		}
+		if !seen[heading] {
+			seen[heading] = true
+			subjects = append(subjects, heading)
+		}
+	}
+	return subjects, nil
+}
+
+// Subjects returns the subject headings of the book bookId, alphabetically.
+func Subjects(ctx context.Context, bookId int) ([]string, error) {
+	rows, err := handler.GetRowsContext(ctx, subjectTable, handler.ListQuery{
+		Filter: bookFilter(bookId),
+		Sort:   []handler.SortField{{Column: "heading"}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	subjects := make([]string, 0, len(rows))
+	for _, row := range rows {
+		heading, _ := row["heading"].(string)
+		subjects = append(subjects, heading)
+	}
+	return subjects, nil
+}
+
+// SetSubjects makes headings the subject headings of the book bookId,
+// removing those it no longer has. The headings are normalized first.
+func SetSubjects(ctx context.Context, bookId int, headings []string) error {
+	subjects, err := NormalizeSubjects(headings)
+	if err != nil {
+		return err
+	}
+	return handler.WithTransaction(ctx, func(ctx context.Context) error {
+		rows, err := handler.GetRowsContext(ctx, subjectTable, handler.ListQuery{Filter: bookFilter(bookId)})
+		if err != nil {
+			return err
+		}
+		wanted := map[string]bool{}
+		for _, heading := range subjects {
+			wanted[heading] = true
+		}
+		for _, row := range rows {
+			heading, _ := row["heading"].(string)
+			if wanted[heading] {
+				delete(wanted, heading)
+				continue
+			}
+			id, _ := row["id"].(int)
+			if err := handler.DeleteDataContext(ctx, subjectTable, id); err != nil {
+				return err
+			}
+		}
+		added := make([]string, 0, len(wanted))
+		for heading := range wanted {
+			added = append(added, heading)
+		}
+		sort.Strings(added)
+		for _, heading := range added {
+			if _, err := handler.InsertDataContext(ctx, subjectTable, map[string]any{"book_id": bookId, "heading": heading}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func bookFilter(bookId int) handler.FilterQuery {
+	return handler.FilterQuery{And: map[string][]handler.FieldFilter{
+		"book_id": {{Operator: "eq", Value: strconv.Itoa(bookId), ValueType: "int"}},
+	}}
+}
@@ -0,0 +1,131 @@
+package catalogue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/riszkymf/golang-rest-boilerplate/internal/handler"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/testdb"
+)
+
+func TestParseISBN(t *testing.T) {
+	tests := []struct {
+		input string
+		want  ISBN
+		ok    bool
+	}{
+		{"0-306-40615-2", ISBN{"9780306406157", "0306406152"}, true},
+		{"978-0-306-40615-7", ISBN{"9780306406157", "0306406152"}, true},
+		{"ISBN: 978 0 306 40615 7", ISBN{"9780306406157", "0306406152"}, true},
+		{"0-8044-2957-x", ISBN{"9780804429573", "080442957X"}, true},
+		{"979-10-90636-07-1", ISBN{"9791090636071", ""}, true},
+		{"0-306-40615-3", ISBN{}, false},
+		{"978-0-306-40615-8", ISBN{}, false},
+		{"977-0-306-40615-4", ISBN{}, false},
+		{"X306406152", ISBN{}, false},
+		{"12345", ISBN{}, false},
+	}
+	for _, test := range tests {
+		got, err := ParseISBN(test.input)
+		if test.ok && (err != nil || got != test.want) {
+			t.Errorf("%q should be %v, got %v %v", test.input, test.want, got, err)
+		}
+		if !test.ok && !errors.Is(err, ErrInvalidBook) {
+			t.Errorf("%q should be refused, got %v", test.input, got)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	data := map[string]any{
+		"title":            "Moby Dick",
+		"isbn":             "0-306-40615-2",
+		"publisher":        "  Harper & Brothers ",
+		"publication_year": float64(1851),
+		"edition":          "",
+		"language":         "EN",
+		"page_count":       json.Number("635"),
+	}
+	if err := Normalize(data); err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	want := map[string]any{
+		"title":            "Moby Dick",
+		"isbn":             "9780306406157",
+		"isbn10":           "0306406152",
+		"publisher":        "Harper & Brothers",
+		"publication_year": 1851,
+		"edition":          nil,
+		"language":         "en",
+		"page_count":       635,
+	}
+	if !reflect.DeepEqual(data, want) {
+		t.Errorf("normalized data should be %v, got %v", want, data)
+	}
+
+	cleared := map[string]any{"isbn": ""}
+	if err := Normalize(cleared); err != nil || cleared["isbn"] != nil || cleared["isbn10"] != nil {
+		t.Errorf("empty isbn should clear both forms, got %v %v", cleared, err)
+	}
+
+	for _, invalid := range []map[string]any{
+		{"isbn": "0-306-40615-3"},
+		{"publication_year": float64(3000)},
+		{"publication_year": 1851.5},
+		{"page_count": float64(0)},
+		{"page_count": "many"},
+		{"page_count": json.Number("1.5")},
+		{"language": "english"},
+		{"publisher": float64(1)},
+	} {
+		if err := Normalize(invalid); !errors.Is(err, ErrInvalidBook) {
+			t.Errorf("%v should be refused, got %v", invalid, err)
+		}
+	}
+}
+
+func TestSubjects(t *testing.T) {
+	handler.Connection = testdb.Open(t)
+	ctx := context.Background()
+	author, _ := handler.InsertData("author", map[string]any{"name": "Ursula K. Le Guin"})
+	book, err := handler.InsertData("books", map[string]any{"title": "The Dispossessed", "author_id": author})
+	if err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	// Titles no longer have to be unique, ISBNs do.
+	if _, err := handler.InsertData("books", map[string]any{"title": "The Dispossessed", "author_id": author, "isbn": "9780060512750"}); err != nil {
+		t.Errorf("a second book with the same title should be inserted, got %v", err)
+	}
+	if _, err := handler.InsertData("books", map[string]any{"title": "Another", "author_id": author, "isbn": "9780060512750"}); !handler.IsConflict(err) {
+		t.Errorf("a second book with the same isbn should conflict, got %v", err)
+	}
+
+	if err := SetSubjects(ctx, book, []string{" Utopias ", "Science fiction", "Anarchism", "Utopias"}); err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	if got, _ := Subjects(ctx, book); !reflect.DeepEqual(got, []string{"Anarchism", "Science fiction", "Utopias"}) {
+		t.Errorf("subjects should be sorted and distinct, got %v", got)
+	}
+	if err := SetSubjects(ctx, book, []string{"Science fiction", "Physics"}); err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	if got, _ := Subjects(ctx, book); !reflect.DeepEqual(got, []string{"Physics", "Science fiction"}) {
+		t.Errorf("subjects should be replaced, got %v", got)
+	}
+	if err := SetSubjects(ctx, book, []string{"Physics", " "}); !errors.Is(err, ErrInvalidBook) {
+		t.Errorf("empty heading should be refused, got %v", err)
+	}
+
+	rows, err := handler.GetRowsContext(ctx, "v_books", handler.ListQuery{Filter: handler.FilterQuery{And: map[string][]handler.FieldFilter{
+		"subjects": {{Operator: "like", Value: "%Physics%", ValueType: "string"}},
+	}}})
+	if err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	if len(rows) != 1 || rows[0]["subjects"] != "Physics; Science fiction" {
+		t.Errorf("v_books should list the subjects of the book, got %v", rows)
+	}
+}
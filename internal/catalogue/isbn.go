@@ -0,0 +1,85 @@
+package catalogue
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ISBN is an International Standard Book Number in both its forms: ISBN13
+// always, ISBN10 for the numbers starting with 978, which had one.
+type ISBN struct {
+	ISBN13 string
+	ISBN10 string
+}
+
+// ParseISBN reads an ISBN-10 or ISBN-13, with or without its hyphens, spaces
+// or "ISBN" prefix, and checks its check digit.
+func ParseISBN(s string) (ISBN, error) {
+	digits := strings.ToUpper(strings.TrimSpace(s))
+	digits = strings.TrimSpace(strings.TrimPrefix(strings.TrimPrefix(digits, "ISBN"), ":"))
+	digits = strings.NewReplacer("-", "", " ", "").Replace(digits)
+	switch len(digits) {
+	case 10:
+		if !isDigits(digits[:9]) || !(isDigits(digits[9:]) || digits[9] == 'X') {
+			return ISBN{}, fmt.Errorf("%w: isbn %q has characters other than digits", ErrInvalidBook, s)
+		}
+		if check10(digits[:9]) != digits[9] {
+			return ISBN{}, fmt.Errorf("%w: isbn %q has a wrong check digit", ErrInvalidBook, s)
+		}
+		isbn13 := "978" + digits[:9]
+		return ISBN{ISBN13: isbn13 + string(check13(isbn13)), ISBN10: digits}, nil
+	case 13:
+		if !isDigits(digits) {
+			return ISBN{}, fmt.Errorf("%w: isbn %q has characters other than digits", ErrInvalidBook, s)
+		}
+		if !strings.HasPrefix(digits, "978") && !strings.HasPrefix(digits, "979") {
+			return ISBN{}, fmt.Errorf("%w: isbn %q must start with 978 or 979", ErrInvalidBook, s)
+		}
+		if check13(digits[:12]) != digits[12] {
+			return ISBN{}, fmt.Errorf("%w: isbn %q has a wrong check digit", ErrInvalidBook, s)
+		}
+		isbn := ISBN{ISBN13: digits}
+		if strings.HasPrefix(digits, "978") {
+			isbn.ISBN10 = digits[3:12] + string(check10(digits[3:12]))
+		}
+		return isbn, nil
+	}
+	return ISBN{}, fmt.Errorf("%w: isbn %q must have 10 or 13 digits", ErrInvalidBook, s)
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// check10 is the check digit of the first nine digits of an ISBN-10: their
+// sum weighted 10 down to 2, plus it, is a multiple of 11, 10 written X.
+func check10(digits string) byte {
+	sum := 0
+	for i := 0; i < 9; i++ {
+		sum += (10 - i) * int(digits[i]-'0')
+	}
+	check := (11 - sum%11) % 11
+	if check == 10 {
+		return 'X'
+	}
+	return byte('0' + check)
+}
+
+// check13 is the check digit of the first twelve digits of an ISBN-13: their
+// sum weighted alternately 1 and 3, plus it, is a multiple of 10.
+func check13(digits string) byte {
+	sum := 0
+	for i := 0; i < 12; i++ {
+		weight := 1
+		if i%2 == 1 {
+			weight = 3
+		}
+		sum += weight * int(digits[i]-'0')
+	}
+	return byte('0' + (10-sum%10)%10)
+}
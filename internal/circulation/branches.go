@@ -0,0 +1,172 @@
+package circulation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/riszkymf/golang-rest-boilerplate/internal/handler"
+	utils "github.com/riszkymf/golang-rest-boilerplate/internal/src"
+)
+
+const (
+	branchTable = "branches"
+	staffTable  = "staff"
+)
+
+// DefaultBranch is the branch of the copies added without one, other than by
+// staff, who add them to their own branch.
+const DefaultBranch = "main"
+
+// ErrInvalidBranch wraps the reasons a branch is refused.
+var ErrInvalidBranch = errors.New("invalid branch")
+
+// Branch is a branch of the library, found by its code.
+type Branch struct {
+	Code    string `json:"code"`
+	Name    string `json:"name"`
+	Address string `json:"address,omitempty"`
+}
+
+// Staff is a principal working at the branch Branch.
+type Staff struct {
+	Principal string `json:"principal"`
+	Branch    string `json:"branch"`
+}
+
+// FindBranch returns the branch code, or ErrNotFound.
+func FindBranch(ctx context.Context, code string) (map[string]any, error) {
+	rows, err := handler.GetRowByFilterContext(ctx, branchTable, handler.FilterQuery{And: map[string][]handler.FieldFilter{
+		"code": {{Operator: "eq", Value: code, ValueType: "string"}},
+	}})
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("branch %v: %w", code, handler.ErrNotFound)
+	}
+	return rows[0], nil
+}
+
+// AddBranch opens the branch and returns it.
+func (d *Desk) AddBranch(ctx context.Context, branch Branch) (map[string]any, error) {
+	if branch.Code == "" || branch.Name == "" {
+		return nil, fmt.Errorf("%w: code and name are required", ErrInvalidBranch)
+	}
+	data := map[string]any{
+		"code":       branch.Code,
+		"name":       branch.Name,
+		"created_at": d.now().UTC().Format(time.RFC3339),
+	}
+	if branch.Address != "" {
+		data["address"] = branch.Address
+	}
+	id, err := handler.InsertDataContext(ctx, branchTable, data)
+	if handler.IsConflict(err) {
+		return nil, refuse(fmt.Sprintf("branch %v exists", branch.Code))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return handler.GetRowByIdContext(ctx, branchTable, id)
+}
+
+// DeleteBranch closes the branch code, once no copy belongs to it nor is on
+// its way to it. Its staff are unassigned.
+func DeleteBranch(ctx context.Context, code string) error {
+	return handler.WithTransaction(ctx, func(ctx context.Context) error {
+		branch, err := FindBranch(ctx, code)
+		if err != nil {
+			return err
+		}
+		copies, err := handler.CountRowsContext(ctx, copyTable, handler.FilterQuery{And: map[string][]handler.FieldFilter{
+			"branch": {{Operator: "eq", Value: code, ValueType: "string"}},
+		}})
+		if err != nil {
+			return err
+		}
+		if copies > 0 {
+			return refuse(fmt.Sprintf("branch %v has %v copies", code, copies))
+		}
+		incoming, err := handler.CountRowsContext(ctx, transferTable, handler.FilterQuery{And: map[string][]handler.FieldFilter{
+			"to_branch": {{Operator: "eq", Value: code, ValueType: "string"}},
+			"status":    {{Operator: "eq", Value: TransferInTransit, ValueType: "string"}},
+		}})
+		if err != nil {
+			return err
+		}
+		if incoming > 0 {
+			return refuse(fmt.Sprintf("%v copies are on their way to branch %v", incoming, code))
+		}
+		_, err = handler.DeleteByFilterContext(ctx, staffTable, handler.FilterQuery{And: map[string][]handler.FieldFilter{
+			"branch": {{Operator: "eq", Value: code, ValueType: "string"}},
+		}})
+		if err != nil {
+			return err
+		}
+		id, _ := branch["id"].(int)
+		return handler.DeleteDataContext(ctx, branchTable, id)
+	})
+}
+
+// StaffBranch returns the branch principal works at, "" if they are not
+// staff.
+func StaffBranch(ctx context.Context, principal string) (string, error) {
+	rows, err := handler.GetRowByFilterContext(ctx, staffTable, handler.FilterQuery{And: map[string][]handler.FieldFilter{
+		"principal": {{Operator: "eq", Value: principal, ValueType: "string"}},
+	}})
+	if err != nil || len(rows) == 0 {
+		return "", err
+	}
+	branch, _ := rows[0]["branch"].(string)
+	return branch, nil
+}
+
+// ListStaff returns the staff of the branch code, by principal.
+func ListStaff(ctx context.Context, code string) ([]map[string]any, error) {
+	return handler.GetRowsContext(ctx, staffTable, handler.ListQuery{
+		Filter: handler.FilterQuery{And: map[string][]handler.FieldFilter{
+			"branch": {{Operator: "eq", Value: code, ValueType: "string"}},
+		}},
+		Sort: []handler.SortField{{Column: "principal"}},
+	})
+}
+
+// AssignStaff makes principal staff of the branch staff.Branch, moving them
+// from the one they worked at if any.
+func (d *Desk) AssignStaff(ctx context.Context, staff Staff) error {
+	if staff.Principal == "" {
+		return fmt.Errorf("%w: principal is required", ErrInvalidBranch)
+	}
+	if _, err := FindBranch(ctx, staff.Branch); err != nil {
+		return err
+	}
+	_, err := handler.UpsertContext(ctx, staffTable, map[string]any{
+		"principal":  staff.Principal,
+		"branch":     staff.Branch,
+		"created_at": d.now().UTC().Format(time.RFC3339),
+	}, []string{"principal"}, []string{"branch"})
+	return err
+}
+
+// RemoveStaff unassigns principal from the branch code.
+func RemoveStaff(ctx context.Context, code string, principal string) error {
+	deleted, err := handler.DeleteByFilterContext(ctx, staffTable, handler.FilterQuery{And: map[string][]handler.FieldFilter{
+		"branch":    {{Operator: "eq", Value: code, ValueType: "string"}},
+		"principal": {{Operator: "eq", Value: principal, ValueType: "string"}},
+	}})
+	if err == nil && deleted == 0 {
+		err = fmt.Errorf("staff %v of branch %v: %w", principal, code, handler.ErrNotFound)
+	}
+	return err
+}
+
+// Scope refuses what, e.g. "copy 000001-001", belonging to branch when the
+// request is made by staff of another branch.
+func Scope(ctx context.Context, what string, branch string) error {
+	if staff := utils.BranchFromContext(ctx); staff != "" && staff != branch {
+		return refuse(fmt.Sprintf("%v belongs to branch %v, not %v", what, branch, staff))
+	}
+	return nil
+}
@@ -0,0 +1,497 @@
+package circulation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/riszkymf/golang-rest-boilerplate/internal/events"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/fines"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/handler"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/overdue"
+	utils "github.com/riszkymf/golang-rest-boilerplate/internal/src"
+)
+
+const (
+	dateLayout = "2006-01-02"
+	timeLayout = "2006-01-02 15:04:05"
+)
+
+const renewalTable = "renewals"
+
+// attempts is how many times an operation is tried when a row it updates
+// changes meanwhile.
+const attempts = 3
+
+// Refusal is an operation the desk will not do, and why.
+type Refusal struct {
+	Reasons []string
+}
+
+func (r *Refusal) Error() string {
+	return "refused: " + strings.Join(r.Reasons, "; ")
+}
+
+func refuse(reasons ...string) error {
+	return &Refusal{Reasons: reasons}
+}
+
+// Desk checks copies of books out to members and back in, keeping the status
+// of the copies in step with the loans, and queues the members waiting for
+// books.
+type Desk struct {
+	mu         sync.Mutex
+	policy     Policy
+	pickupDays int
+	ledger     *fines.Ledger
+	holder     string
+	now        func() time.Time
+	today      func() time.Time
+}
+
+func NewDesk(policy Policy, ledger *fines.Ledger) *Desk {
+	host, _ := os.Hostname()
+	return &Desk{
+		policy:     policy,
+		pickupDays: 3,
+		ledger:     ledger,
+		holder:     fmt.Sprintf("%v/%v/%v", host, os.Getpid(), uuid.NewString()[:8]),
+		now:        time.Now,
+		today:      overdue.Default.Today,
+	}
+}
+
+var Default = NewDesk(Policy{LoanDays: 14, RenewalDays: 14, MaxRenewals: 2, MaxOverdueDays: 7}, fines.Default)
+
+// date renders the date days after day as a due_date.
+func date(day time.Time, days int) string {
+	return day.AddDate(0, 0, days).Format(dateLayout) + " 00:00:00"
+}
+
+// Refusals lists why the member memberId may not check out books, none if
+// they may.
+func (d *Desk) Refusals(ctx context.Context, memberId int) ([]string, error) {
+	member, err := handler.GetRowByIdContext(ctx, "members", memberId)
+	if err != nil {
+		return nil, err
+	}
+	if member["id"] == nil {
+		return nil, fmt.Errorf("member %v: %w", memberId, handler.ErrNotFound)
+	}
+	policy, err := d.PolicyOf(ctx, member)
+	if err != nil {
+		return nil, err
+	}
+	return d.refusals(ctx, member, policy)
+}
+
+// refusals lists why the member row may not check out books under policy:
+// their membership expired, they have the most loans policy allows, or they
+// owe too much.
+func (d *Desk) refusals(ctx context.Context, member map[string]any, policy Policy) ([]string, error) {
+	memberId, _ := member["id"].(int)
+	reasons := []string{}
+	if expires, _ := member["expires_at"].(string); overdue.DaysOverdue(expires, d.today()) > 0 {
+		reasons = append(reasons, fmt.Sprintf("membership expired on %v", expires[:len(dateLayout)]))
+	}
+	if policy.MaxLoans > 0 {
+		loans, err := handler.CountRowsContext(ctx, "records", handler.FilterQuery{
+			And: map[string][]handler.FieldFilter{
+				"member_id": {{Operator: "eq", Value: strconv.Itoa(memberId), ValueType: "int"}},
+			},
+			Or: map[string][]handler.FieldFilter{
+				"rent_status": {
+					{Operator: "eq", Value: events.StatusRented, ValueType: "string"},
+					{Operator: "eq", Value: events.StatusOverdue, ValueType: "string"},
+				},
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if loans >= policy.MaxLoans {
+			reasons = append(reasons, fmt.Sprintf("limit of %v active loans reached", policy.MaxLoans))
+		}
+	}
+	balance, err := d.ledger.Balance(ctx, memberId)
+	if err != nil {
+		return nil, err
+	}
+	if balance.Blocked {
+		reasons = append(reasons, fmt.Sprintf("member %v owes %v %v, more than the %v allowed", memberId, balance.Balance, balance.Currency, balance.MaxBalance))
+	}
+	return reasons, nil
+}
+
+// Checkout lends a copy of the book bookId to the member memberId, the one
+// set aside for their hold if it is ready, and returns the loan.
+func (d *Desk) Checkout(ctx context.Context, bookId int, memberId int) (map[string]any, error) {
+	return d.checkout(ctx, bookId, "", memberId)
+}
+
+// CheckoutCopy lends the copy barcode to the member memberId and returns the
+// loan. A copy set aside for a hold is only lent to its member.
+func (d *Desk) CheckoutCopy(ctx context.Context, barcode string, memberId int) (map[string]any, error) {
+	return d.checkout(ctx, 0, barcode, memberId)
+}
+
+// checkout lends the copy barcode, or a copy of the book bookId if barcode is
+// empty, to the member memberId.
+func (d *Desk) checkout(ctx context.Context, bookId int, barcode string, memberId int) (record map[string]any, err error) {
+	err = retry(ctx, func(ctx context.Context) error {
+		member, err := handler.GetRowByIdContext(ctx, "members", memberId)
+		if err != nil {
+			return err
+		}
+		if member["id"] == nil {
+			return fmt.Errorf("member %v: %w", memberId, handler.ErrNotFound)
+		}
+		var copy map[string]any
+		if barcode != "" {
+			if copy, err = FindCopy(ctx, barcode); err != nil {
+				return err
+			}
+			bookId, _ = copy["book_id"].(int)
+		}
+		book, err := handler.GetRowByIdContext(ctx, "books", bookId)
+		if err != nil {
+			return err
+		}
+		if book["id"] == nil {
+			return fmt.Errorf("book %v: %w", bookId, handler.ErrNotFound)
+		}
+		policy, err := d.PolicyOf(ctx, member)
+		if err != nil {
+			return err
+		}
+		reasons, err := d.refusals(ctx, member, policy)
+		if err != nil {
+			return err
+		}
+		queue, err := bookHolds(ctx, bookId)
+		if err != nil {
+			return err
+		}
+		// Staff lend the copies of their branch.
+		staff := utils.BranchFromContext(ctx)
+		var hold *Hold
+		for i := range queue {
+			if queue[i].MemberId == memberId {
+				hold = &queue[i]
+			}
+		}
+		if copy == nil {
+			// The member whose hold is ready takes the copy set aside for it.
+			if hold != nil && hold.CopyId != 0 {
+				if copy, err = handler.GetRowByIdContext(ctx, copyTable, hold.CopyId); err != nil {
+					return err
+				}
+			}
+			if copy == nil || fmt.Sprint(copy["status"]) != CopyOnHold || (staff != "" && fmt.Sprint(copy["branch"]) != staff) {
+				if copy, err = firstAvailable(ctx, bookId, staff); err != nil {
+					return err
+				}
+			}
+			if copy == nil {
+				held, err := countCopies(ctx, bookId, CopyOnHold)
+				if err != nil {
+					return err
+				}
+				switch {
+				case held > 0:
+					reasons = append(reasons, fmt.Sprintf("the copies of book %v are set aside for members holding it", bookId))
+				case staff != "":
+					reasons = append(reasons, fmt.Sprintf("no copy of book %v is available at branch %v", bookId, staff))
+				default:
+					reasons = append(reasons, fmt.Sprintf("no copy of book %v is available", bookId))
+				}
+			}
+		}
+		copyId := 0
+		if copy != nil {
+			copyId, _ = copy["id"].(int)
+			switch status := fmt.Sprint(copy["status"]); status {
+			case CopyAvailable:
+			case CopyOnHold:
+				if hold == nil || hold.CopyId != copyId {
+					reasons = append(reasons, fmt.Sprintf("copy %v is set aside for a member holding book %v", copy["barcode"], bookId))
+				}
+			default:
+				reasons = append(reasons, fmt.Sprintf("copy %v is %v", copy["barcode"], status))
+			}
+			if branch := fmt.Sprint(copy["branch"]); staff != "" && branch != staff {
+				reasons = append(reasons, fmt.Sprintf("copy %v belongs to branch %v, not %v", copy["barcode"], branch, staff))
+			}
+		}
+		if len(reasons) > 0 {
+			return refuse(reasons...)
+		}
+
+		if err := update(ctx, copyTable, copy, map[string]any{"status": CopyOnLoan}); err != nil {
+			return err
+		}
+		if hold != nil {
+			// Taking another copy puts the one set aside back on the shelf,
+			// or aside for the next hold.
+			if hold.CopyId != 0 && hold.CopyId != copyId {
+				err = d.release(ctx, *hold, HoldFulfilled)
+			} else {
+				err = handler.UpdateDataContext(ctx, holdTable, map[string]any{"status": HoldFulfilled}, hold.Id)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		id, err := handler.InsertDataContext(ctx, "records", map[string]any{
+			"book_id":     bookId,
+			"member_id":   memberId,
+			"copy_id":     copyId,
+			"branch":      copy["branch"],
+			"rent_date":   d.now().UTC().Format(timeLayout),
+			"due_date":    date(d.today(), policy.LoanDays),
+			"rent_status": events.StatusRented,
+		})
+		if err != nil {
+			return err
+		}
+		record, err = handler.GetRowByIdContext(ctx, "records", id)
+		return err
+	})
+	return record, err
+}
+
+// Renew extends the loan id by the renewal days of the policy of its member,
+// unless it was renewed the most times allowed, is too long overdue or its
+// book is held for another member. The renewal is kept in renewals.
+func (d *Desk) Renew(ctx context.Context, id int) (record map[string]any, err error) {
+	err = retry(ctx, func(ctx context.Context) error {
+		record, err = handler.GetRowByIdContext(ctx, "records", id)
+		if err != nil {
+			return err
+		}
+		if record["id"] == nil {
+			return fmt.Errorf("loan %v: %w", id, handler.ErrNotFound)
+		}
+		status := fmt.Sprint(record["rent_status"])
+		if status != events.StatusRented && status != events.StatusOverdue {
+			return refuse(fmt.Sprintf("loan %v is %v, only open loans are renewed", id, status))
+		}
+		memberId, _ := record["member_id"].(int)
+		bookId, _ := record["book_id"].(int)
+		member, err := handler.GetRowByIdContext(ctx, "members", memberId)
+		if err != nil {
+			return err
+		}
+		policy, err := d.PolicyOf(ctx, member)
+		if err != nil {
+			return err
+		}
+
+		reasons := []string{}
+		renewals, _ := record["renewals"].(int)
+		if renewals >= policy.MaxRenewals {
+			reasons = append(reasons, fmt.Sprintf("loan %v was renewed %v times, the most allowed", id, renewals))
+		}
+		today := d.today()
+		dueDate, _ := record["due_date"].(string)
+		if days := overdue.DaysOverdue(dueDate, today); days > policy.MaxOverdueDays {
+			reasons = append(reasons, fmt.Sprintf("loan %v is %v days overdue, more than the %v allowed", id, days, policy.MaxOverdueDays))
+		}
+		held, err := d.heldForOther(ctx, bookId, memberId)
+		if err != nil {
+			return err
+		}
+		if held {
+			reasons = append(reasons, fmt.Sprintf("book %v is on hold for another member", bookId))
+		}
+		if len(reasons) > 0 {
+			return refuse(reasons...)
+		}
+
+		from := today
+		if len(dueDate) >= len(dateLayout) {
+			if due, err := time.Parse(dateLayout, dueDate[:len(dateLayout)]); err == nil && due.After(today) {
+				from = due
+			}
+		}
+		renewed := date(from, policy.RenewalDays)
+		err = update(ctx, "records", record, map[string]any{
+			"due_date":    renewed,
+			"renewals":    renewals + 1,
+			"rent_status": events.StatusRented,
+		})
+		if err != nil {
+			return err
+		}
+		_, err = handler.InsertDataContext(ctx, renewalTable, map[string]any{
+			"record_id":         id,
+			"previous_due_date": dueDate,
+			"due_date":          renewed,
+			"created_at":        time.Now().UTC().Format(time.RFC3339),
+		})
+		if err != nil {
+			return err
+		}
+		record, err = handler.GetRowByIdContext(ctx, "records", id)
+		return err
+	})
+	return record, err
+}
+
+// Renewal is a renewal of a loan, from PreviousDueDate to DueDate.
+type Renewal struct {
+	Id              int    `json:"id"`
+	RecordId        int    `json:"record_id"`
+	PreviousDueDate string `json:"previous_due_date"`
+	DueDate         string `json:"due_date"`
+	CreatedAt       string `json:"created_at"`
+}
+
+// Renewals returns the renewals of the loan id, oldest first.
+func Renewals(ctx context.Context, id int) ([]Renewal, error) {
+	rows, err := handler.GetRowsContext(ctx, renewalTable, handler.ListQuery{
+		Filter: handler.FilterQuery{And: map[string][]handler.FieldFilter{
+			"record_id": {{Operator: "eq", Value: strconv.Itoa(id), ValueType: "int"}},
+		}},
+		Sort: []handler.SortField{{Column: "id"}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := make([]Renewal, 0, len(rows))
+	for _, row := range rows {
+		renewal := Renewal{}
+		renewal.Id, _ = row["id"].(int)
+		renewal.RecordId, _ = row["record_id"].(int)
+		renewal.PreviousDueDate, _ = row["previous_due_date"].(string)
+		renewal.DueDate, _ = row["due_date"].(string)
+		renewal.CreatedAt, _ = row["created_at"].(string)
+		result = append(result, renewal)
+	}
+	return result, nil
+}
+
+// Return checks the loan id back in, its copy back on the shelf or aside
+// for the next hold, and returns it. A lost copy found is returned as well.
+// Returning a loan late fines its member.
+func (d *Desk) Return(ctx context.Context, id int) (map[string]any, error) {
+	return d.close(ctx, id, events.StatusReturned, CopyAvailable, events.StatusRented, events.StatusOverdue, events.StatusLost)
+}
+
+// ReturnDamaged checks the loan id back in as Return does, its copy out of
+// circulation as damaged.
+func (d *Desk) ReturnDamaged(ctx context.Context, id int) (map[string]any, error) {
+	return d.close(ctx, id, events.StatusReturned, CopyDamaged, events.StatusRented, events.StatusOverdue, events.StatusLost)
+}
+
+// ReturnCopy checks the copy barcode back in, damaged or not, and returns its
+// loan.
+func (d *Desk) ReturnCopy(ctx context.Context, barcode string, damaged bool) (map[string]any, error) {
+	copy, err := FindCopy(ctx, barcode)
+	if err != nil {
+		return nil, err
+	}
+	loans, err := handler.GetRowsContext(ctx, "records", handler.ListQuery{
+		Filter: handler.FilterQuery{And: map[string][]handler.FieldFilter{
+			"copy_id":     {{Operator: "eq", Value: fmt.Sprint(copy["id"]), ValueType: "int"}},
+			"rent_status": {{Operator: "not", Value: events.StatusReturned, ValueType: "string"}},
+		}},
+		Sort:  []handler.SortField{{Column: "id", Desc: true}},
+		Limit: 1,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(loans) == 0 {
+		return nil, refuse(fmt.Sprintf("copy %v is not on loan", barcode))
+	}
+	id, _ := loans[0]["id"].(int)
+	if damaged {
+		return d.ReturnDamaged(ctx, id)
+	}
+	return d.Return(ctx, id)
+}
+
+// MarkLost closes the loan id as lost, and its copy, which fines its member
+// the replacement cost of the book.
+func (d *Desk) MarkLost(ctx context.Context, id int) (map[string]any, error) {
+	return d.close(ctx, id, events.StatusLost, CopyLost, events.StatusRented, events.StatusOverdue)
+}
+
+// close sets the status of the loan id, from one of from, to status, and
+// that of its copy to copyStatus.
+func (d *Desk) close(ctx context.Context, id int, status string, copyStatus string, from ...string) (record map[string]any, err error) {
+	err = retry(ctx, func(ctx context.Context) error {
+		record, err = handler.GetRowByIdContext(ctx, "records", id)
+		if err != nil {
+			return err
+		}
+		if record["id"] == nil {
+			return fmt.Errorf("loan %v: %w", id, handler.ErrNotFound)
+		}
+		current := fmt.Sprint(record["rent_status"])
+		if !contains(from, current) {
+			return refuse(fmt.Sprintf("loan %v is %v, it cannot become %v", id, current, status))
+		}
+		if err := update(ctx, "records", record, map[string]any{"rent_status": status}); err != nil {
+			return err
+		}
+		// Loans recorded without a copy have none to check in, nor has a
+		// loan whose copy was withdrawn since.
+		if copyId, _ := record["copy_id"].(int); copyId != 0 {
+			copy, err := handler.GetRowByIdContext(ctx, copyTable, copyId)
+			if err != nil {
+				return err
+			}
+			if copy["id"] != nil {
+				if err := update(ctx, copyTable, copy, map[string]any{"status": copyStatus}); err != nil {
+					return err
+				}
+				bookId, _ := record["book_id"].(int)
+				if err := d.assign(ctx, bookId); err != nil {
+					return err
+				}
+			}
+		}
+		record, err = handler.GetRowByIdContext(ctx, "records", id)
+		return err
+	})
+	return record, err
+}
+
+// retry runs fn in a transaction, again when a row it updates changed
+// meanwhile.
+func retry(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	for i := 0; i < attempts; i++ {
+		err = handler.WithTransaction(ctx, fn)
+		if !errors.Is(err, handler.ErrVersionMismatch) {
+			return err
+		}
+	}
+	return err
+}
+
+// update changes row of table, only if unchanged since it was read.
+func update(ctx context.Context, table string, row map[string]any, change map[string]any) error {
+	id, _ := row["id"].(int)
+	if version, ok := row["version"].(int); ok {
+		return handler.UpdateDataIfVersionContext(ctx, table, change, id, version)
+	}
+	return handler.UpdateDataContext(ctx, table, change, id)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
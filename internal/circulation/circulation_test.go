@@ -0,0 +1,493 @@
+package circulation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/riszkymf/golang-rest-boilerplate/internal/fines"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/handler"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/overdue"
+	utils "github.com/riszkymf/golang-rest-boilerplate/internal/src"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/testdb"
+)
+
+func TestDesk(t *testing.T) {
+	handler.Connection = testdb.Open(t)
+	ctx := context.Background()
+	ledger := fines.NewLedger(fines.Policy{DailyRate: 100, LostCost: 2000, MaxBalance: 500, Currency: "USD"})
+	handler.OnChange(ledger.Assess)
+	desk := NewDesk(Policy{LoanDays: 14, RenewalDays: 7}, ledger)
+	today := overdue.Default.Today()
+
+	author, _ := handler.InsertData("author", map[string]any{"name": "Herman Melville"})
+	book, _ := handler.InsertData("books", map[string]any{"title": "Moby Dick", "author_id": author})
+	member, _ := handler.InsertData("members", map[string]any{"firstname": "Ishmael", "lastname": "Sailor"})
+	if _, err := desk.AddCopy(ctx, Copy{BookId: book}); err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	stock := func() int {
+		available, _ := availableCopies(ctx, book)
+		return available
+	}
+
+	loan, err := desk.Checkout(ctx, book, member)
+	if err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	if loan["rent_status"] != "rented" || loan["due_date"] != today.AddDate(0, 0, 14).Format(time.RFC3339) || stock() != 0 {
+		t.Errorf("checkout should lend a copy for 14 days, got %v with stock %v", loan, stock())
+	}
+	var refusal *Refusal
+	if _, err := desk.Checkout(ctx, book, member); !errors.As(err, &refusal) || len(refusal.Reasons) != 1 {
+		t.Errorf("checkout without copies should be refused, got %v", err)
+	}
+	if _, err := desk.Checkout(ctx, book, 999); !errors.Is(err, handler.ErrNotFound) {
+		t.Errorf("checkout to an unknown member should not be found, got %v", err)
+	}
+
+	// Returned 3 days late, then lost on its next loan.
+	id := loan["id"].(int)
+	handler.UpdateData("records", map[string]any{"due_date": today.AddDate(0, 0, -3).Format(timeLayout)}, id)
+	if _, err := desk.Return(ctx, id); err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	if stock() != 1 {
+		t.Errorf("return should put the copy back into stock, got %v", stock())
+	}
+	if _, err := desk.Return(ctx, id); !errors.As(err, &refusal) {
+		t.Errorf("returning a returned loan should be refused, got %v", err)
+	}
+	loan, err = desk.Checkout(ctx, book, member)
+	if err != nil {
+		t.Fatalf("member owing 300 should check out, got %v", err)
+	}
+	if _, err := desk.MarkLost(ctx, loan["id"].(int)); err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	if stock() != 0 {
+		t.Errorf("lost copy should stay out of stock, got %v", stock())
+	}
+	balance, _ := ledger.Balance(ctx, member)
+	if balance.Balance != 2300 {
+		t.Errorf("member should owe the late fee and the lost book, got %+v", balance)
+	}
+
+	desk.AddCopy(ctx, Copy{BookId: book})
+	if _, err := desk.Checkout(ctx, book, member); !errors.As(err, &refusal) {
+		t.Errorf("member owing more than the maximum balance should be refused, got %v", err)
+	}
+	if _, err := desk.Return(ctx, loan["id"].(int)); err != nil {
+		t.Fatalf("lost book found should be returned, got %v", err)
+	}
+	if stock() != 2 {
+		t.Errorf("lost book found should be back into stock, got %v", stock())
+	}
+}
+
+func TestRenew(t *testing.T) {
+	handler.Connection = testdb.Open(t)
+	ctx := context.Background()
+	desk := NewDesk(Policy{LoanDays: 14, RenewalDays: 14, MaxRenewals: 1, MaxOverdueDays: 3}, fines.NewLedger(fines.Policy{MaxBalance: 1000}))
+	today := time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC)
+	desk.today = func() time.Time { return today }
+	if err := SavePolicy(ctx, Policy{Category: "staff", LoanDays: 28, RenewalDays: 28, MaxRenewals: 3, MaxOverdueDays: 0}); err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	if err := SavePolicy(ctx, Policy{Category: "guest", LoanDays: 0, RenewalDays: 7}); !errors.Is(err, ErrInvalidPolicy) {
+		t.Errorf("policy without loan days should be refused, got %v", err)
+	}
+
+	author, _ := handler.InsertData("author", map[string]any{"name": "Herman Melville"})
+	book, _ := handler.InsertData("books", map[string]any{"title": "Moby Dick", "author_id": author})
+	desk.AddCopy(ctx, Copy{BookId: book})
+	reader, _ := handler.InsertData("members", map[string]any{"firstname": "Ishmael", "lastname": "Sailor"})
+	staff, _ := handler.InsertData("members", map[string]any{"firstname": "Starbuck", "lastname": "Mate", "category": "staff"})
+	loan := func(member int, due string, status string) int {
+		id, err := handler.InsertData("records", map[string]any{"book_id": book, "member_id": member, "rent_date": "2024-03-01 00:00:00", "due_date": due, "rent_status": status})
+		if err != nil {
+			t.Fatalf(`Error: %v`, err)
+		}
+		return id
+	}
+	var refusal *Refusal
+
+	// A loan not yet due is renewed from its due date, once.
+	early := loan(reader, "2024-03-15 00:00:00", "rented")
+	renewed, err := desk.Renew(ctx, early)
+	if err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	if renewed["due_date"] != "2024-03-29T00:00:00Z" || renewed["renewals"] != 1 {
+		t.Errorf("renewal should add 14 days to the due date, got %v", renewed)
+	}
+	if _, err := desk.Renew(ctx, early); !errors.As(err, &refusal) {
+		t.Errorf("renewal past the most allowed should be refused, got %v", err)
+	}
+	renewals, err := Renewals(ctx, early)
+	if err != nil || len(renewals) != 1 || renewals[0].PreviousDueDate != "2024-03-15T00:00:00Z" || renewals[0].DueDate != "2024-03-29T00:00:00Z" {
+		t.Errorf("renewal should be kept, got %v %v", renewals, err)
+	}
+
+	// An overdue loan is renewed from today, within the days allowed.
+	late := loan(reader, "2024-03-08 00:00:00", "overdue")
+	renewed, err = desk.Renew(ctx, late)
+	if err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	if renewed["due_date"] != "2024-03-24T00:00:00Z" || renewed["rent_status"] != "rented" {
+		t.Errorf("overdue loan should be renewed from today and rented again, got %v", renewed)
+	}
+	tooLate := loan(reader, "2024-03-01 00:00:00", "overdue")
+	if _, err := desk.Renew(ctx, tooLate); !errors.As(err, &refusal) {
+		t.Errorf("loan overdue by more than allowed should be refused, got %v", err)
+	}
+	returned := loan(reader, "2024-03-15 00:00:00", "returned")
+	if _, err := desk.Renew(ctx, returned); !errors.As(err, &refusal) {
+		t.Errorf("returned loan should not be renewed, got %v", err)
+	}
+
+	// The staff policy renews by 28 days, but not books others hold.
+	staffLoan := loan(staff, "2024-03-15 00:00:00", "rented")
+	if renewed, _ := desk.Renew(ctx, staffLoan); renewed["due_date"] != "2024-04-12T00:00:00Z" {
+		t.Errorf("staff renewal should add 28 days, got %v", renewed)
+	}
+	if checkout, _ := desk.Checkout(ctx, book, staff); checkout["due_date"] != "2024-04-07T00:00:00Z" {
+		t.Errorf("staff checkout should be due in 28 days, got %v", checkout)
+	}
+	if _, err := desk.PlaceHold(ctx, book, reader); err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	if _, err := desk.Renew(ctx, staffLoan); !errors.As(err, &refusal) || len(refusal.Reasons) != 1 {
+		t.Errorf("book held for another member should not be renewed, got %v", err)
+	}
+}
+
+func TestCategories(t *testing.T) {
+	handler.Connection = testdb.Open(t)
+	ctx := context.Background()
+	desk := NewDesk(Policy{MaxLoans: 1, LoanDays: 14, RenewalDays: 14}, fines.NewLedger(fines.Policy{MaxBalance: 1000}))
+	desk.today = func() time.Time { return time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC) }
+	if err := SavePolicy(ctx, Policy{Category: "student", Description: "Enrolled students", MaxLoans: 2, LoanDays: 21, RenewalDays: 7}); err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	if err := SavePolicy(ctx, Policy{Category: "guest", MaxLoans: -1, LoanDays: 7, RenewalDays: 7}); !errors.Is(err, ErrInvalidPolicy) {
+		t.Errorf("category with negative max_loans should be refused, got %v", err)
+	}
+	if student, ok, err := GetPolicy(ctx, "student"); err != nil || !ok || student.MaxLoans != 2 || student.Description != "Enrolled students" {
+		t.Errorf("category should be saved, got %v %v %v", student, ok, err)
+	}
+	if err := ValidateMembership(ctx, map[string]any{"category": "staff"}); !errors.Is(err, ErrInvalidMembership) {
+		t.Errorf("unknown category should be refused, got %v", err)
+	}
+	if err := ValidateMembership(ctx, map[string]any{"category": "student", "expires_at": "next year"}); !errors.Is(err, ErrInvalidMembership) {
+		t.Errorf("expires_at that is not a date should be refused, got %v", err)
+	}
+
+	author, _ := handler.InsertData("author", map[string]any{"name": "Herman Melville"})
+	book, _ := handler.InsertData("books", map[string]any{"title": "Moby Dick", "author_id": author})
+	for i := 0; i < 4; i++ {
+		desk.AddCopy(ctx, Copy{BookId: book})
+	}
+	reader, _ := handler.InsertData("members", map[string]any{"firstname": "Ishmael", "lastname": "Sailor"})
+	student, _ := handler.InsertData("members", map[string]any{"firstname": "Pip", "lastname": "Cabin", "category": "student"})
+	expired, _ := handler.InsertData("members", map[string]any{"firstname": "Fedallah", "lastname": "Harpooner", "expires_at": "2024-03-09"})
+	var refusal *Refusal
+
+	// The default policy lends one book at once, the student one two.
+	if _, err := desk.Checkout(ctx, book, reader); err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	if _, err := desk.Checkout(ctx, book, reader); !errors.As(err, &refusal) || refusal.Reasons[0] != "limit of 1 active loans reached" {
+		t.Errorf("checkout past the limit should be refused, got %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := desk.Checkout(ctx, book, student); err != nil {
+			t.Fatalf(`Error: %v`, err)
+		}
+	}
+	if reasons, err := desk.Refusals(ctx, student); err != nil || len(reasons) != 1 || reasons[0] != "limit of 2 active loans reached" {
+		t.Errorf("student with two loans should be refused, got %v %v", reasons, err)
+	}
+	if err := DeletePolicy(ctx, "student"); !errors.As(err, &refusal) {
+		t.Errorf("category of members should not be deleted, got %v", err)
+	}
+
+	// A membership ends after its expires_at.
+	if _, err := desk.Checkout(ctx, book, expired); !errors.As(err, &refusal) || refusal.Reasons[0] != "membership expired on 2024-03-09" {
+		t.Errorf("checkout of expired member should be refused, got %v", err)
+	}
+	handler.UpdateData("members", map[string]any{"expires_at": "2024-03-10"}, expired)
+	if _, err := desk.Checkout(ctx, book, expired); err != nil {
+		t.Errorf("membership should last through its expires_at, got %v", err)
+	}
+}
+
+func TestBranches(t *testing.T) {
+	handler.Connection = testdb.Open(t)
+	ctx := context.Background()
+	desk := NewDesk(Policy{LoanDays: 14, RenewalDays: 14}, fines.NewLedger(fines.Policy{MaxBalance: 1000}))
+	if _, err := desk.AddBranch(ctx, Branch{Code: "east", Name: "East branch"}); err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	var refusal *Refusal
+	if _, err := desk.AddBranch(ctx, Branch{Code: "east", Name: "Another"}); !errors.As(err, &refusal) {
+		t.Errorf("branch with a taken code should be refused, got %v", err)
+	}
+	if err := desk.AssignStaff(ctx, Staff{Principal: "eve", Branch: "east"}); err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	if branch, err := StaffBranch(ctx, "eve"); err != nil || branch != "east" {
+		t.Errorf("eve should be staff of east, got %q %v", branch, err)
+	}
+	east := utils.ContextWithBranch(ctx, "east")
+
+	author, _ := handler.InsertData("author", map[string]any{"name": "Herman Melville"})
+	book, _ := handler.InsertData("books", map[string]any{"title": "Moby Dick", "author_id": author})
+	member, _ := handler.InsertData("members", map[string]any{"firstname": "Ishmael", "lastname": "Sailor"})
+	copy, err := desk.AddCopy(ctx, Copy{BookId: book})
+	if err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	if copy["branch"] != DefaultBranch {
+		t.Errorf("copy should belong to the default branch, got %v", copy["branch"])
+	}
+	if _, err := desk.AddCopy(ctx, Copy{BookId: book, Branch: "west"}); !errors.Is(err, ErrInvalidCopy) {
+		t.Errorf("copy of an unknown branch should be refused, got %v", err)
+	}
+	barcode := fmt.Sprint(copy["barcode"])
+
+	// Staff of east neither lend nor mark the copies of main.
+	if _, err := desk.Checkout(east, book, member); !errors.As(err, &refusal) {
+		t.Errorf("checkout at a branch without copies should be refused, got %v", err)
+	}
+	if _, err := desk.MarkCopy(east, barcode, CopyDamaged); !errors.As(err, &refusal) {
+		t.Errorf("copy of another branch should not be marked, got %v", err)
+	}
+
+	// The copy is moved to east, by main sending it and east receiving it.
+	transfer, err := desk.RequestTransfer(east, Transfer{Barcode: barcode})
+	if err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	if transfer["from_branch"] != "main" || transfer["to_branch"] != "east" || transfer["status"] != TransferRequested {
+		t.Errorf("transfer should be requested from main to east, got %v", transfer)
+	}
+	id, _ := transfer["id"].(int)
+	if _, err := desk.RequestTransfer(ctx, Transfer{Barcode: barcode, ToBranch: "east"}); !errors.As(err, &refusal) {
+		t.Errorf("copy should be moved by one transfer at a time, got %v", err)
+	}
+	if _, err := desk.ShipTransfer(east, id); !errors.As(err, &refusal) {
+		t.Errorf("transfer should be sent by the branch of its copy, got %v", err)
+	}
+	if _, err := desk.ShipTransfer(ctx, id); err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	if _, err := desk.CancelTransfer(ctx, id); !errors.As(err, &refusal) {
+		t.Errorf("transfer in transit should not be cancelled, got %v", err)
+	}
+	if available, _ := availableCopies(ctx, book); available != 0 {
+		t.Errorf("copy in transit should not be available, got %v", available)
+	}
+	if _, err := desk.ReceiveTransfer(east, id); err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	if copy, _ := FindCopy(ctx, barcode); copy["branch"] != "east" || copy["status"] != CopyAvailable {
+		t.Errorf("received copy should be available at east, got %v", copy)
+	}
+
+	loan, err := desk.Checkout(east, book, member)
+	if err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	if loan["branch"] != "east" {
+		t.Errorf("loan should belong to the branch of its copy, got %v", loan["branch"])
+	}
+	if err := DeleteBranch(ctx, "east"); !errors.As(err, &refusal) {
+		t.Errorf("branch with copies should not be closed, got %v", err)
+	}
+}
+
+func TestHolds(t *testing.T) {
+	handler.Connection = testdb.Open(t)
+	ctx := context.Background()
+	desk := NewDesk(Policy{LoanDays: 14, RenewalDays: 14}, fines.NewLedger(fines.Policy{MaxBalance: 1000}))
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+	desk.now = func() time.Time { return now }
+	desk.SetPickupDays(2)
+
+	author, _ := handler.InsertData("author", map[string]any{"name": "Herman Melville"})
+	book, _ := handler.InsertData("books", map[string]any{"title": "Moby Dick", "author_id": author})
+	desk.AddCopy(ctx, Copy{BookId: book})
+	members := []int{}
+	for _, name := range []string{"Ishmael", "Queequeg", "Starbuck", "Stubb"} {
+		member, _ := handler.InsertData("members", map[string]any{"firstname": name, "lastname": "Pequod"})
+		members = append(members, member)
+	}
+	ishmael, queequeg, starbuck, stubb := members[0], members[1], members[2], members[3]
+	status := func(id int) string {
+		hold, _ := getHold(ctx, id)
+		return hold.Status
+	}
+	var refusal *Refusal
+
+	if _, err := desk.PlaceHold(ctx, book, ishmael); !errors.As(err, &refusal) {
+		t.Errorf("hold on an available book should be refused, got %v", err)
+	}
+	loan, err := desk.Checkout(ctx, book, ishmael)
+	if err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	first, err := desk.PlaceHold(ctx, book, queequeg)
+	if err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	second, _ := desk.PlaceHold(ctx, book, starbuck)
+	third, _ := desk.PlaceHold(ctx, book, stubb)
+	if first.Position != 1 || second.Position != 2 || third.Position != 3 {
+		t.Errorf("holds should queue in order, got %v %v %v", first.Position, second.Position, third.Position)
+	}
+	if _, err := desk.PlaceHold(ctx, book, starbuck); !errors.As(err, &refusal) {
+		t.Errorf("second hold of a member should be refused, got %v", err)
+	}
+
+	// The returned copy is set aside for the first hold; others wait.
+	if _, err := desk.Return(ctx, loan["id"].(int)); err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	ready, _ := getHold(ctx, first.Id)
+	if ready.Status != HoldReady || ready.ExpiresAt != "2024-03-12T12:00:00Z" || ready.CopyId == 0 {
+		t.Errorf("first hold should be ready for 2 days, got %+v", ready)
+	}
+	if _, err := desk.Checkout(ctx, book, stubb); !errors.As(err, &refusal) {
+		t.Errorf("copy set aside should not be lent to another member, got %v", err)
+	}
+	if holds, _ := ListHolds(ctx, "member_id", stubb, false); len(holds) != 1 || holds[0].Position != 2 {
+		t.Errorf("third hold should be second in line once the first is ready, got %+v", holds)
+	}
+
+	// Unpicked, it expires and the copy goes to the next hold, who cancels.
+	now = now.AddDate(0, 0, 3)
+	if expired, err := desk.ExpireHolds(ctx); err != nil || expired != 1 {
+		t.Fatalf("ready hold should expire, got %v %v", expired, err)
+	}
+	if status(first.Id) != HoldExpired || status(second.Id) != HoldReady {
+		t.Errorf("expired copy should go to the second hold, got %v %v", status(first.Id), status(second.Id))
+	}
+	if _, err := desk.CancelHold(ctx, book, second.Id); err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	if _, err := desk.CancelHold(ctx, book, second.Id); !errors.As(err, &refusal) {
+		t.Errorf("cancelled hold should not be cancelled again, got %v", err)
+	}
+	if status(second.Id) != HoldCancelled || status(third.Id) != HoldReady {
+		t.Errorf("cancelled ready hold should pass its copy on, got %v %v", status(second.Id), status(third.Id))
+	}
+
+	if _, err := desk.Checkout(ctx, book, stubb); err != nil {
+		t.Fatalf("member whose hold is ready should check out, got %v", err)
+	}
+	if status(third.Id) != HoldFulfilled {
+		t.Errorf("checkout should fulfil the hold, got %v", status(third.Id))
+	}
+	if holds, _ := ListHolds(ctx, "book_id", book, true); len(holds) != 3 {
+		t.Errorf("all holds should be listed, got %v", holds)
+	}
+}
+
+func TestCopies(t *testing.T) {
+	handler.Connection = testdb.Open(t)
+	ctx := context.Background()
+	desk := NewDesk(Policy{LoanDays: 14, RenewalDays: 14}, fines.NewLedger(fines.Policy{MaxBalance: 1000}))
+
+	author, _ := handler.InsertData("author", map[string]any{"name": "Herman Melville"})
+	book, _ := handler.InsertData("books", map[string]any{"title": "Moby Dick", "author_id": author})
+	ishmael, _ := handler.InsertData("members", map[string]any{"firstname": "Ishmael", "lastname": "Sailor"})
+	queequeg, _ := handler.InsertData("members", map[string]any{"firstname": "Queequeg", "lastname": "Harpooner"})
+	status := func(barcode string) string {
+		copy, _ := FindCopy(ctx, barcode)
+		return fmt.Sprint(copy["status"])
+	}
+	var refusal *Refusal
+
+	first, err := desk.AddCopy(ctx, Copy{BookId: book, Branch: "main"})
+	if err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	if first["barcode"] != "000001-001" || first["condition"] != "good" || first["status"] != CopyAvailable {
+		t.Errorf("copy should be barcoded after its book, in good condition, got %v", first)
+	}
+	if _, err := desk.AddCopy(ctx, Copy{BookId: book, Barcode: "000001-001"}); !errors.As(err, &refusal) {
+		t.Errorf("taken barcode should be refused, got %v", err)
+	}
+	if _, err := desk.AddCopy(ctx, Copy{BookId: book, Condition: "soggy"}); !errors.Is(err, ErrInvalidCopy) {
+		t.Errorf("unknown condition should be refused, got %v", err)
+	}
+	if _, err := desk.AddCopy(ctx, Copy{BookId: 999}); !errors.Is(err, handler.ErrNotFound) {
+		t.Errorf("copy of an unknown book should not be found, got %v", err)
+	}
+	desk.AddCopy(ctx, Copy{BookId: book, Barcode: "MD-2", Condition: "fair"})
+
+	// Lent by barcode, returned damaged, then repaired.
+	loan, err := desk.CheckoutCopy(ctx, "MD-2", ishmael)
+	if err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	if loan["copy_id"] == nil || status("MD-2") != CopyOnLoan {
+		t.Errorf("checkout should lend the copy, got %v %v", loan, status("MD-2"))
+	}
+	if _, err := desk.CheckoutCopy(ctx, "MD-2", queequeg); !errors.As(err, &refusal) {
+		t.Errorf("copy on loan should not be lent again, got %v", err)
+	}
+	if _, err := desk.MarkCopy(ctx, "MD-2", CopyLost); !errors.As(err, &refusal) {
+		t.Errorf("copy on loan should be marked through its loan, got %v", err)
+	}
+	if _, err := desk.ReturnCopy(ctx, "MD-2", true); err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	if status("MD-2") != CopyDamaged {
+		t.Errorf("copy returned damaged should be out of circulation, got %v", status("MD-2"))
+	}
+	if _, err := desk.ReturnCopy(ctx, "MD-2", false); !errors.As(err, &refusal) {
+		t.Errorf("copy not on loan should not be returned, got %v", err)
+	}
+
+	// The copy set aside for a hold and found damaged passes the hold on.
+	desk.Checkout(ctx, book, ishmael)
+	hold, err := desk.PlaceHold(ctx, book, queequeg)
+	if err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	if _, err := desk.MarkCopy(ctx, "MD-2", CopyAvailable); err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	if status("MD-2") != CopyOnHold {
+		t.Errorf("repaired copy should be set aside for the hold, got %v", status("MD-2"))
+	}
+	if _, err := desk.MarkCopy(ctx, "MD-2", CopyDamaged); err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	if held, _ := getHold(ctx, hold.Id); held.Status != HoldWaiting || held.CopyId != 0 {
+		t.Errorf("hold of a damaged copy should wait again, got %+v", held)
+	}
+	if _, err := desk.ReturnCopy(ctx, "000001-001", false); err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	if held, _ := getHold(ctx, hold.Id); held.Status != HoldReady || held.CopyId != first["id"] {
+		t.Errorf("returned copy should be set aside for the hold, got %+v", held)
+	}
+	if err := desk.WithdrawCopy(ctx, "000001-001"); err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	if _, err := FindCopy(ctx, "000001-001"); !errors.Is(err, handler.ErrNotFound) {
+		t.Errorf("withdrawn copy should be gone, got %v", err)
+	}
+	if held, _ := getHold(ctx, hold.Id); held.Status != HoldWaiting {
+		t.Errorf("hold of a withdrawn copy should wait again, got %+v", held)
+	}
+	if added, _ := desk.AddCopy(ctx, Copy{BookId: book}); added["barcode"] != "000001-003" || status("000001-003") != CopyOnHold {
+		t.Errorf("new copy should be numbered after the withdrawn ones and set aside, got %v", added)
+	}
+}
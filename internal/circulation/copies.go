@@ -0,0 +1,276 @@
+package circulation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/riszkymf/golang-rest-boilerplate/internal/events"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/handler"
+	utils "github.com/riszkymf/golang-rest-boilerplate/internal/src"
+)
+
+const copyTable = "copies"
+
+// The status of a copy: on the shelf, lent, set aside for a ready hold, on
+// its way to another branch, or out of circulation.
+const (
+	CopyAvailable = events.StatusAvailable
+	CopyOnLoan    = "on_loan"
+	CopyOnHold    = "on_hold"
+	CopyInTransit = "in_transit"
+	CopyLost      = "lost"
+	CopyDamaged   = "damaged"
+	CopyWithdrawn = "withdrawn"
+)
+
+// Conditions are the conditions a copy may be in, the default first.
+var Conditions = []string{"good", "new", "fair", "poor"}
+
+// ErrInvalidCopy wraps the reasons a copy is refused.
+var ErrInvalidCopy = errors.New("invalid copy")
+
+// Copy is a physical copy of the book BookId, found by its barcode, that
+// belongs to the branch Branch.
+type Copy struct {
+	Barcode   string `json:"barcode"`
+	BookId    int    `json:"book_id"`
+	Branch    string `json:"branch,omitempty"`
+	Condition string `json:"condition,omitempty"`
+}
+
+// ValidateCondition checks that condition is one of Conditions.
+func ValidateCondition(condition string) error {
+	if !contains(Conditions, condition) {
+		return fmt.Errorf("%w: condition must be one of %v, got %q", ErrInvalidCopy, strings.Join(Conditions, ", "), condition)
+	}
+	return nil
+}
+
+// AddCopy shelves a new copy, barcoded after its book unless it has a
+// barcode, and sets it aside for the oldest waiting hold of the book if any.
+// The copy belongs to the branch of the staff adding it, DefaultBranch
+// otherwise, unless it names one.
+func (d *Desk) AddCopy(ctx context.Context, copy Copy) (row map[string]any, err error) {
+	if copy.Condition == "" {
+		copy.Condition = Conditions[0]
+	}
+	if err := ValidateCondition(copy.Condition); err != nil {
+		return nil, err
+	}
+	if copy.Branch == "" {
+		copy.Branch = utils.BranchFromContext(ctx)
+	}
+	if copy.Branch == "" {
+		copy.Branch = DefaultBranch
+	}
+	if err := Scope(ctx, fmt.Sprintf("a copy of book %v", copy.BookId), copy.Branch); err != nil {
+		return nil, err
+	}
+	err = handler.WithTransaction(ctx, func(ctx context.Context) error {
+		if _, err := FindBranch(ctx, copy.Branch); errors.Is(err, handler.ErrNotFound) {
+			return fmt.Errorf("%w: no such branch %q", ErrInvalidCopy, copy.Branch)
+		} else if err != nil {
+			return err
+		}
+		book, err := handler.GetRowByIdContext(ctx, "books", copy.BookId)
+		if err != nil {
+			return err
+		}
+		if book["id"] == nil {
+			return fmt.Errorf("book %v: %w", copy.BookId, handler.ErrNotFound)
+		}
+		if copy.Barcode == "" {
+			if copy.Barcode, err = nextBarcode(ctx, copy.BookId); err != nil {
+				return err
+			}
+		}
+		data := map[string]any{
+			"barcode":    copy.Barcode,
+			"book_id":    copy.BookId,
+			"branch":     copy.Branch,
+			"condition":  copy.Condition,
+			"status":     CopyAvailable,
+			"created_at": d.now().UTC().Format(time.RFC3339),
+		}
+		id, err := handler.InsertDataContext(ctx, copyTable, data)
+		if handler.IsConflict(err) {
+			return refuse(fmt.Sprintf("barcode %v is taken", copy.Barcode))
+		}
+		if err != nil {
+			return err
+		}
+		if err := d.assign(ctx, copy.BookId); err != nil {
+			return err
+		}
+		row, err = handler.GetRowByIdContext(ctx, copyTable, id)
+		return err
+	})
+	return row, err
+}
+
+// nextBarcode is the first free barcode numbered after the copies of the
+// book bookId, withdrawn ones included.
+func nextBarcode(ctx context.Context, bookId int) (string, error) {
+	ctx = handler.WithDeleted(ctx)
+	n, err := handler.CountRowsContext(ctx, copyTable, handler.FilterQuery{And: map[string][]handler.FieldFilter{
+		"book_id": {{Operator: "eq", Value: strconv.Itoa(bookId), ValueType: "int"}},
+	}})
+	if err != nil {
+		return "", err
+	}
+	for {
+		n++
+		barcode := fmt.Sprintf("%06d-%03d", bookId, n)
+		taken, err := handler.CountRowsContext(ctx, copyTable, handler.FilterQuery{And: map[string][]handler.FieldFilter{
+			"barcode": {{Operator: "eq", Value: barcode, ValueType: "string"}},
+		}})
+		if err != nil || taken == 0 {
+			return barcode, err
+		}
+	}
+}
+
+// FindCopy returns the copy barcoded barcode, or ErrNotFound.
+func FindCopy(ctx context.Context, barcode string) (map[string]any, error) {
+	rows, err := handler.GetRowByFilterContext(ctx, copyTable, handler.FilterQuery{And: map[string][]handler.FieldFilter{
+		"barcode": {{Operator: "eq", Value: barcode, ValueType: "string"}},
+	}})
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("copy %v: %w", barcode, handler.ErrNotFound)
+	}
+	return rows[0], nil
+}
+
+// MarkCopy sets the copy barcode lost, damaged or back available. A copy on
+// loan is marked through its loan; a copy set aside for a hold passes the
+// hold on to another copy.
+func (d *Desk) MarkCopy(ctx context.Context, barcode string, status string) (row map[string]any, err error) {
+	if status != CopyAvailable && status != CopyLost && status != CopyDamaged {
+		return nil, fmt.Errorf("%w: a copy is marked %v, %v or %v, not %q", ErrInvalidCopy, CopyAvailable, CopyLost, CopyDamaged, status)
+	}
+	err = retry(ctx, func(ctx context.Context) error {
+		row, err = FindCopy(ctx, barcode)
+		if err != nil {
+			return err
+		}
+		if err := Scope(ctx, "copy "+barcode, fmt.Sprint(row["branch"])); err != nil {
+			return err
+		}
+		current := fmt.Sprint(row["status"])
+		switch {
+		case current == status:
+			return refuse(fmt.Sprintf("copy %v is already %v", barcode, status))
+		case current == CopyOnLoan:
+			return refuse(fmt.Sprintf("copy %v is on loan, return it or mark its loan lost", barcode))
+		case current == CopyOnHold && status == CopyAvailable:
+			return refuse(fmt.Sprintf("copy %v is set aside for a hold", barcode))
+		case current == CopyInTransit:
+			return refuse(fmt.Sprintf("copy %v is in transit, receive it first", barcode))
+		}
+		if err := d.shelve(ctx, row, status); err != nil {
+			return err
+		}
+		id, _ := row["id"].(int)
+		row, err = handler.GetRowByIdContext(ctx, copyTable, id)
+		return err
+	})
+	return row, err
+}
+
+// WithdrawCopy takes the copy barcode out of the inventory for good. A copy
+// on loan is returned first.
+func (d *Desk) WithdrawCopy(ctx context.Context, barcode string) error {
+	return retry(ctx, func(ctx context.Context) error {
+		row, err := FindCopy(ctx, barcode)
+		if err != nil {
+			return err
+		}
+		if err := Scope(ctx, "copy "+barcode, fmt.Sprint(row["branch"])); err != nil {
+			return err
+		}
+		switch fmt.Sprint(row["status"]) {
+		case CopyOnLoan:
+			return refuse(fmt.Sprintf("copy %v is on loan, return it first", barcode))
+		case CopyInTransit:
+			return refuse(fmt.Sprintf("copy %v is in transit, receive it first", barcode))
+		}
+		if err := d.shelve(ctx, row, CopyWithdrawn); err != nil {
+			return err
+		}
+		id, _ := row["id"].(int)
+		return handler.DeleteDataContext(ctx, copyTable, id)
+	})
+}
+
+// shelve sets the status of the copy row, not on loan. The hold it was set
+// aside for goes back to the head of the queue, and the copies available
+// are set aside for the waiting holds.
+func (d *Desk) shelve(ctx context.Context, row map[string]any, status string) error {
+	id, _ := row["id"].(int)
+	bookId, _ := row["book_id"].(int)
+	if fmt.Sprint(row["status"]) == CopyOnHold {
+		holds, err := handler.GetRowByFilterContext(ctx, holdTable, handler.FilterQuery{And: map[string][]handler.FieldFilter{
+			"copy_id": {{Operator: "eq", Value: strconv.Itoa(id), ValueType: "int"}},
+			"status":  {{Operator: "eq", Value: HoldReady, ValueType: "string"}},
+		}})
+		if err != nil {
+			return err
+		}
+		for _, hold := range holds {
+			holdId, _ := hold["id"].(int)
+			err := handler.UpdateDataContext(ctx, holdTable, map[string]any{
+				"status":     HoldWaiting,
+				"copy_id":    nil,
+				"ready_at":   nil,
+				"expires_at": nil,
+			}, holdId)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if err := update(ctx, copyTable, row, map[string]any{"status": status}); err != nil {
+		return err
+	}
+	return d.assign(ctx, bookId)
+}
+
+// availableCopies counts the copies of the book bookId on the shelf.
+func availableCopies(ctx context.Context, bookId int) (int, error) {
+	return countCopies(ctx, bookId, CopyAvailable)
+}
+
+func countCopies(ctx context.Context, bookId int, status string) (int, error) {
+	return handler.CountRowsContext(ctx, copyTable, handler.FilterQuery{And: map[string][]handler.FieldFilter{
+		"book_id": {{Operator: "eq", Value: strconv.Itoa(bookId), ValueType: "int"}},
+		"status":  {{Operator: "eq", Value: status, ValueType: "string"}},
+	}})
+}
+
+// firstAvailable returns the available copy of the book bookId shelved
+// first, at the branch branch unless it is empty, nil if there is none.
+func firstAvailable(ctx context.Context, bookId int, branch string) (map[string]any, error) {
+	filter := map[string][]handler.FieldFilter{
+		"book_id": {{Operator: "eq", Value: strconv.Itoa(bookId), ValueType: "int"}},
+		"status":  {{Operator: "eq", Value: CopyAvailable, ValueType: "string"}},
+	}
+	if branch != "" {
+		filter["branch"] = []handler.FieldFilter{{Operator: "eq", Value: branch, ValueType: "string"}}
+	}
+	rows, err := handler.GetRowsContext(ctx, copyTable, handler.ListQuery{
+		Filter: handler.FilterQuery{And: filter},
+		Sort:   []handler.SortField{{Column: "id"}},
+		Limit:  1,
+	})
+	if err != nil || len(rows) == 0 {
+		return nil, err
+	}
+	return rows[0], nil
+}
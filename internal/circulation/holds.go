@@ -0,0 +1,338 @@
+package circulation
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/riszkymf/golang-rest-boilerplate/internal/events"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/handler"
+	utils "github.com/riszkymf/golang-rest-boilerplate/internal/src"
+)
+
+const holdTable = "holds"
+
+// leaseName is the lease held by the instance expiring the holds.
+const leaseName = "hold-expiry"
+
+// The status of a hold: waiting in the queue of its book, then ready for
+// pickup once a copy is set aside for it, until its member checks the book
+// out or it expires.
+const (
+	HoldWaiting   = "waiting"
+	HoldReady     = events.StatusReadyForPickup
+	HoldFulfilled = "fulfilled"
+	HoldCancelled = "cancelled"
+	HoldExpired   = "expired"
+)
+
+// Hold is a member waiting for a book. Position is its place in the queue
+// of the book while it waits, 1 being next; CopyId the copy set aside for it
+// once it is ready.
+type Hold struct {
+	Id        int    `json:"id"`
+	BookId    int    `json:"book_id"`
+	MemberId  int    `json:"member_id"`
+	Status    string `json:"status"`
+	Position  int    `json:"position,omitempty"`
+	CopyId    int    `json:"copy_id,omitempty"`
+	CreatedAt string `json:"created_at"`
+	ReadyAt   string `json:"ready_at,omitempty"`
+	ExpiresAt string `json:"expires_at,omitempty"`
+}
+
+// Active reports whether the hold is waiting or ready.
+func (h Hold) Active() bool {
+	return h.Status == HoldWaiting || h.Status == HoldReady
+}
+
+// SetPickupDays sets how many days a copy set aside for a hold waits for its
+// member.
+func (d *Desk) SetPickupDays(days int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.pickupDays = days
+}
+
+// PlaceHold queues the member memberId for the book bookId, which has no
+// copy available to them.
+func (d *Desk) PlaceHold(ctx context.Context, bookId int, memberId int) (hold Hold, err error) {
+	err = handler.WithTransaction(ctx, func(ctx context.Context) error {
+		member, err := handler.GetRowByIdContext(ctx, "members", memberId)
+		if err != nil {
+			return err
+		}
+		if member["id"] == nil {
+			return fmt.Errorf("member %v: %w", memberId, handler.ErrNotFound)
+		}
+		book, err := handler.GetRowByIdContext(ctx, "books", bookId)
+		if err != nil {
+			return err
+		}
+		if book["id"] == nil {
+			return fmt.Errorf("book %v: %w", bookId, handler.ErrNotFound)
+		}
+		queue, err := bookHolds(ctx, bookId)
+		if err != nil {
+			return err
+		}
+		for _, queued := range queue {
+			if queued.MemberId == memberId {
+				return refuse(fmt.Sprintf("member %v already holds book %v, hold %v", memberId, bookId, queued.Id))
+			}
+		}
+		available, err := availableCopies(ctx, bookId)
+		if err != nil {
+			return err
+		}
+		if available > 0 {
+			return refuse(fmt.Sprintf("a copy of book %v is available, check it out", bookId))
+		}
+		hold = Hold{BookId: bookId, MemberId: memberId, Status: HoldWaiting, CreatedAt: d.now().UTC().Format(timeLayout)}
+		hold.Id, err = handler.InsertDataContext(ctx, holdTable, map[string]any{
+			"book_id":    bookId,
+			"member_id":  memberId,
+			"status":     HoldWaiting,
+			"created_at": hold.CreatedAt,
+		})
+		if err != nil {
+			return err
+		}
+		hold, err = getHold(ctx, hold.Id)
+		return err
+	})
+	return hold, err
+}
+
+// CancelHold cancels the hold id of the book bookId. The copy set aside for
+// it, if any, goes to the next hold.
+func (d *Desk) CancelHold(ctx context.Context, bookId int, id int) (hold Hold, err error) {
+	err = handler.WithTransaction(ctx, func(ctx context.Context) error {
+		hold, err = getHold(ctx, id)
+		if err != nil {
+			return err
+		}
+		if hold.Id == 0 || hold.BookId != bookId {
+			return fmt.Errorf("hold %v: %w", id, handler.ErrNotFound)
+		}
+		if !hold.Active() {
+			return refuse(fmt.Sprintf("hold %v is already %v", id, hold.Status))
+		}
+		if err := d.release(ctx, hold, HoldCancelled); err != nil {
+			return err
+		}
+		hold, err = getHold(ctx, id)
+		return err
+	})
+	return hold, err
+}
+
+// ExpireHolds expires the holds not picked up in time, setting their copies
+// aside for the next holds, and returns how many expired.
+func (d *Desk) ExpireHolds(ctx context.Context) (expired int, err error) {
+	rows, err := handler.GetRowByFilterContext(ctx, holdTable, handler.FilterQuery{And: map[string][]handler.FieldFilter{
+		"status":     {{Operator: "eq", Value: HoldReady, ValueType: "string"}},
+		"expires_at": {{Operator: "lt", Value: d.now().UTC().Format(timeLayout), ValueType: "string"}},
+	}})
+	if err != nil {
+		return 0, err
+	}
+	for _, row := range rows {
+		hold := holdFromRow(row)
+		err := handler.WithTransaction(ctx, func(ctx context.Context) error {
+			// Picked up or cancelled since it was read.
+			current, err := getHold(ctx, hold.Id)
+			if err != nil || current.Status != HoldReady {
+				return err
+			}
+			expired++
+			return d.release(ctx, current, HoldExpired)
+		})
+		if err != nil {
+			return expired, err
+		}
+	}
+	return expired, nil
+}
+
+// Run expires the holds every interval until ctx is done, on the instance
+// holding the lease, as the overdue scheduler does.
+func (d *Desk) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			err := handler.ReleaseLeaseContext(handler.Detach(ctx), leaseName, d.holder)
+			utils.CheckError(err, "[holds]", "release lease")
+			return
+		case <-ticker.C:
+			acquired, err := handler.AcquireLeaseContext(ctx, leaseName, d.holder, interval)
+			if err != nil {
+				utils.CheckErrorContext(ctx, err, "[holds]", "acquire lease")
+				continue
+			}
+			if !acquired {
+				continue
+			}
+			expired, err := d.ExpireHolds(ctx)
+			if err != nil {
+				utils.CheckErrorContext(ctx, err, "[holds]", "expire holds")
+				continue
+			}
+			if expired > 0 {
+				utils.LogInfo("[holds]", "expire holds", strconv.Itoa(expired)+" holds expired")
+			}
+		}
+	}
+}
+
+// release closes the active hold with status, its copy going back to the
+// shelf or to the next hold.
+func (d *Desk) release(ctx context.Context, hold Hold, status string) error {
+	if err := handler.UpdateDataContext(ctx, holdTable, map[string]any{"status": status}, hold.Id); err != nil {
+		return err
+	}
+	if hold.CopyId != 0 {
+		copy, err := handler.GetRowByIdContext(ctx, copyTable, hold.CopyId)
+		if err != nil {
+			return err
+		}
+		if fmt.Sprint(copy["status"]) == CopyOnHold {
+			if err := update(ctx, copyTable, copy, map[string]any{"status": CopyAvailable}); err != nil {
+				return err
+			}
+		}
+	}
+	return d.assign(ctx, hold.BookId)
+}
+
+// assign sets the available copies of the book bookId aside for its oldest
+// waiting holds.
+func (d *Desk) assign(ctx context.Context, bookId int) error {
+	book, err := handler.GetRowByIdContext(ctx, "books", bookId)
+	if err != nil || book["id"] == nil {
+		return err
+	}
+	queue, err := bookHolds(ctx, bookId)
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	pickupDays := d.pickupDays
+	d.mu.Unlock()
+	now := d.now().UTC()
+	for _, hold := range queue {
+		if hold.Status != HoldWaiting {
+			continue
+		}
+		copy, err := firstAvailable(ctx, bookId, "")
+		if err != nil || copy == nil {
+			return err
+		}
+		if err := update(ctx, copyTable, copy, map[string]any{"status": CopyOnHold}); err != nil {
+			return err
+		}
+		err = handler.UpdateDataContext(ctx, holdTable, map[string]any{
+			"status":     HoldReady,
+			"copy_id":    copy["id"],
+			"ready_at":   now.Format(timeLayout),
+			"expires_at": now.AddDate(0, 0, pickupDays).Format(timeLayout),
+		}, hold.Id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// heldForOther reports whether the book bookId is held for another member
+// than memberId.
+func (d *Desk) heldForOther(ctx context.Context, bookId int, memberId int) (bool, error) {
+	queue, err := bookHolds(ctx, bookId)
+	if err != nil {
+		return false, err
+	}
+	for _, hold := range queue {
+		if hold.MemberId != memberId {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// bookHolds returns the active holds of the book bookId, in queue order.
+func bookHolds(ctx context.Context, bookId int) ([]Hold, error) {
+	return ListHolds(ctx, "book_id", bookId, false)
+}
+
+// ListHolds returns the holds whose column, book_id or member_id, is id,
+// oldest first; only the active ones unless all is set.
+func ListHolds(ctx context.Context, column string, id int, all bool) ([]Hold, error) {
+	filter := map[string][]handler.FieldFilter{
+		column: {{Operator: "eq", Value: strconv.Itoa(id), ValueType: "int"}},
+	}
+	if !all {
+		filter["status"] = []handler.FieldFilter{
+			{Operator: "not", Value: HoldFulfilled, ValueType: "string"},
+			{Operator: "not", Value: HoldCancelled, ValueType: "string"},
+			{Operator: "not", Value: HoldExpired, ValueType: "string"},
+		}
+	}
+	rows, err := handler.GetRowsContext(ctx, holdTable, handler.ListQuery{
+		Filter: handler.FilterQuery{And: filter},
+		Sort:   []handler.SortField{{Column: "id"}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	holds := make([]Hold, 0, len(rows))
+	for _, row := range rows {
+		holds = append(holds, holdFromRow(row))
+	}
+	return holds, position(ctx, holds)
+}
+
+// position numbers the waiting holds within the queues of their books.
+func position(ctx context.Context, holds []Hold) error {
+	for i, hold := range holds {
+		if hold.Status != HoldWaiting {
+			continue
+		}
+		ahead, err := handler.CountRowsContext(ctx, holdTable, handler.FilterQuery{And: map[string][]handler.FieldFilter{
+			"book_id": {{Operator: "eq", Value: strconv.Itoa(hold.BookId), ValueType: "int"}},
+			"status":  {{Operator: "eq", Value: HoldWaiting, ValueType: "string"}},
+			"id":      {{Operator: "lt", Value: strconv.Itoa(hold.Id), ValueType: "int"}},
+		}})
+		if err != nil {
+			return err
+		}
+		holds[i].Position = ahead + 1
+	}
+	return nil
+}
+
+func getHold(ctx context.Context, id int) (Hold, error) {
+	row, err := handler.GetRowByIdContext(ctx, holdTable, id)
+	if err != nil || row["id"] == nil {
+		return Hold{}, err
+	}
+	hold := holdFromRow(row)
+	holds := []Hold{hold}
+	err = position(ctx, holds)
+	return holds[0], err
+}
+
+func holdFromRow(row map[string]any) Hold {
+	hold := Hold{}
+	hold.Id, _ = row["id"].(int)
+	hold.BookId, _ = row["book_id"].(int)
+	hold.MemberId, _ = row["member_id"].(int)
+	hold.Status, _ = row["status"].(string)
+	hold.CopyId, _ = row["copy_id"].(int)
+	hold.CreatedAt, _ = row["created_at"].(string)
+	hold.ReadyAt, _ = row["ready_at"].(string)
+	hold.ExpiresAt, _ = row["expires_at"].(string)
+	return hold
+}
@@ -0,0 +1,185 @@
+package circulation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/riszkymf/golang-rest-boilerplate/internal/handler"
+)
+
+const policies = "categories"
+
+// ErrInvalidPolicy wraps the reasons a membership category is refused.
+var ErrInvalidPolicy = errors.New("invalid category")
+
+// ErrInvalidMembership wraps the reasons the membership of a member is
+// refused.
+var ErrInvalidMembership = errors.New("invalid membership")
+
+// Policy is the loan policy of the membership category Category: how many
+// books its members may borrow at once, for how long and how they may renew
+// them. The default policy has no category.
+type Policy struct {
+	Category    string `json:"category,omitempty"`
+	Description string `json:"description,omitempty"`
+	// MaxLoans is how many books a member may have on loan at once, 0 for
+	// no limit.
+	MaxLoans int `json:"max_loans"`
+	// LoanDays is how many days after their checkout loans are due.
+	LoanDays int `json:"loan_days"`
+	// RenewalDays is how many days a renewal adds, from the due date or
+	// from today if that is later.
+	RenewalDays int `json:"renewal_days"`
+	MaxRenewals int `json:"max_renewals"`
+	// MaxOverdueDays is the most days overdue a loan may be and still be
+	// renewed.
+	MaxOverdueDays int `json:"max_overdue_days"`
+}
+
+// Validate checks the durations and limits of the policy.
+func (p Policy) Validate() error {
+	if p.LoanDays <= 0 || p.RenewalDays <= 0 {
+		return fmt.Errorf("%w: loan_days and renewal_days must be greater than zero", ErrInvalidPolicy)
+	}
+	if p.MaxLoans < 0 || p.MaxRenewals < 0 || p.MaxOverdueDays < 0 {
+		return fmt.Errorf("%w: max_loans, max_renewals and max_overdue_days must not be negative", ErrInvalidPolicy)
+	}
+	return nil
+}
+
+// ValidateMembership checks the category and expires_at of the member data,
+// if set: the category must exist and expires_at be a date.
+func ValidateMembership(ctx context.Context, data map[string]any) error {
+	if expires, ok := data["expires_at"]; ok && expires != nil {
+		expires, _ := expires.(string)
+		if _, err := time.Parse(dateLayout, expires); err != nil {
+			return fmt.Errorf("%w: expires_at must be a date, e.g. 2030-12-31, got %q", ErrInvalidMembership, expires)
+		}
+	}
+	if category, ok := data["category"]; ok && category != nil && category != "" {
+		category, _ := category.(string)
+		_, exist, err := GetPolicy(ctx, category)
+		if err != nil {
+			return err
+		}
+		if !exist {
+			return fmt.Errorf("%w: no such category %q", ErrInvalidMembership, category)
+		}
+	}
+	return nil
+}
+
+// SetPolicy sets the default policy, of the members without a category or
+// whose category has none.
+func (d *Desk) SetPolicy(policy Policy) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	policy.Category = ""
+	d.policy = policy
+}
+
+// Policy returns the default policy.
+func (d *Desk) Policy() Policy {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.policy
+}
+
+// PolicyOf returns the policy of the member row, that of their category if
+// it has one.
+func (d *Desk) PolicyOf(ctx context.Context, member map[string]any) (Policy, error) {
+	category, _ := member["category"].(string)
+	if category != "" {
+		policy, ok, err := GetPolicy(ctx, category)
+		if err != nil || ok {
+			return policy, err
+		}
+	}
+	return d.Policy(), nil
+}
+
+// GetPolicy returns the policy of category; ok is false if there is no such
+// category.
+func GetPolicy(ctx context.Context, category string) (policy Policy, ok bool, err error) {
+	rows, err := handler.GetRowByFilterContext(ctx, policies, handler.FilterQuery{And: map[string][]handler.FieldFilter{
+		"category": {{Operator: "eq", Value: category, ValueType: "string"}},
+	}})
+	if err != nil || len(rows) == 0 {
+		return Policy{}, false, err
+	}
+	return policyFromRow(rows[0]), true, nil
+}
+
+// ListPolicies returns the categories, by name.
+func ListPolicies(ctx context.Context) ([]Policy, error) {
+	rows, err := handler.GetRowsContext(ctx, policies, handler.ListQuery{Sort: []handler.SortField{{Column: "category"}}})
+	if err != nil {
+		return nil, err
+	}
+	result := make([]Policy, 0, len(rows))
+	for _, row := range rows {
+		result = append(result, policyFromRow(row))
+	}
+	return result, nil
+}
+
+// SavePolicy creates or replaces the category of the policy.
+func SavePolicy(ctx context.Context, policy Policy) error {
+	if policy.Category == "" {
+		return fmt.Errorf("%w: category is required", ErrInvalidPolicy)
+	}
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+	var description any
+	if policy.Description != "" {
+		description = policy.Description
+	}
+	_, err := handler.UpsertContext(ctx, policies, map[string]any{
+		"category":         policy.Category,
+		"description":      description,
+		"max_loans":        policy.MaxLoans,
+		"loan_days":        policy.LoanDays,
+		"renewal_days":     policy.RenewalDays,
+		"max_renewals":     policy.MaxRenewals,
+		"max_overdue_days": policy.MaxOverdueDays,
+	}, []string{"category"}, []string{"description", "max_loans", "loan_days", "renewal_days", "max_renewals", "max_overdue_days"})
+	return err
+}
+
+// DeletePolicy deletes the category category. It is refused while members
+// belong to it.
+func DeletePolicy(ctx context.Context, category string) error {
+	return handler.WithTransaction(ctx, func(ctx context.Context) error {
+		members, err := handler.CountRowsContext(ctx, "members", handler.FilterQuery{And: map[string][]handler.FieldFilter{
+			"category": {{Operator: "eq", Value: category, ValueType: "string"}},
+		}})
+		if err != nil {
+			return err
+		}
+		if members > 0 {
+			return refuse(fmt.Sprintf("category %v has %v members", category, members))
+		}
+		deleted, err := handler.DeleteByFilterContext(ctx, policies, handler.FilterQuery{And: map[string][]handler.FieldFilter{
+			"category": {{Operator: "eq", Value: category, ValueType: "string"}},
+		}})
+		if err == nil && deleted == 0 {
+			err = fmt.Errorf("category %v: %w", category, handler.ErrNotFound)
+		}
+		return err
+	})
+}
+
+func policyFromRow(row map[string]any) Policy {
+	policy := Policy{}
+	policy.Category, _ = row["category"].(string)
+	policy.Description, _ = row["description"].(string)
+	policy.MaxLoans, _ = row["max_loans"].(int)
+	policy.LoanDays, _ = row["loan_days"].(int)
+	policy.RenewalDays, _ = row["renewal_days"].(int)
+	policy.MaxRenewals, _ = row["max_renewals"].(int)
+	policy.MaxOverdueDays, _ = row["max_overdue_days"].(int)
+	return policy
+}
@@ -0,0 +1,176 @@
+package circulation
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/riszkymf/golang-rest-boilerplate/internal/handler"
+	utils "github.com/riszkymf/golang-rest-boilerplate/internal/src"
+)
+
+const transferTable = "transfers"
+
+// The status of a transfer: asked for by the branch receiving the copy, sent
+// by the branch it belongs to, received, or cancelled before it was sent.
+const (
+	TransferRequested = "requested"
+	TransferInTransit = "in_transit"
+	TransferReceived  = "received"
+	TransferCancelled = "cancelled"
+)
+
+// Transfer moves the copy Barcode to the branch ToBranch.
+type Transfer struct {
+	Barcode  string `json:"barcode"`
+	ToBranch string `json:"to_branch,omitempty"`
+}
+
+// RequestTransfer asks for the copy transfer.Barcode to be moved to the
+// branch transfer.ToBranch, that of the staff asking by default. A copy is
+// moved by one transfer at a time.
+func (d *Desk) RequestTransfer(ctx context.Context, transfer Transfer) (row map[string]any, err error) {
+	if transfer.ToBranch == "" {
+		transfer.ToBranch = utils.BranchFromContext(ctx)
+	}
+	if transfer.ToBranch == "" {
+		return nil, fmt.Errorf("%w: to_branch is required", ErrInvalidBranch)
+	}
+	err = handler.WithTransaction(ctx, func(ctx context.Context) error {
+		if _, err := FindBranch(ctx, transfer.ToBranch); err != nil {
+			return err
+		}
+		copy, err := FindCopy(ctx, transfer.Barcode)
+		if err != nil {
+			return err
+		}
+		copyId, _ := copy["id"].(int)
+		from, _ := copy["branch"].(string)
+		if from == transfer.ToBranch {
+			return refuse(fmt.Sprintf("copy %v belongs to branch %v already", transfer.Barcode, from))
+		}
+		if status := fmt.Sprint(copy["status"]); status == CopyLost {
+			return refuse(fmt.Sprintf("copy %v is %v", transfer.Barcode, status))
+		}
+		open, err := openTransfers(ctx, copyId)
+		if err != nil {
+			return err
+		}
+		if open > 0 {
+			return refuse(fmt.Sprintf("copy %v is being transferred already", transfer.Barcode))
+		}
+		data := map[string]any{
+			"copy_id":      copyId,
+			"from_branch":  from,
+			"to_branch":    transfer.ToBranch,
+			"status":       TransferRequested,
+			"requested_at": d.now().UTC().Format(time.RFC3339),
+		}
+		if principal := utils.PrincipalFromContext(ctx); principal != "" {
+			data["requested_by"] = principal
+		}
+		id, err := handler.InsertDataContext(ctx, transferTable, data)
+		if err != nil {
+			return err
+		}
+		row, err = handler.GetRowByIdContext(ctx, transferTable, id)
+		return err
+	})
+	return row, err
+}
+
+// ShipTransfer sends the copy of the requested transfer id on its way, by
+// the branch it belongs to. The copy must be on the shelf, and is in transit
+// until it is received.
+func (d *Desk) ShipTransfer(ctx context.Context, id int) (map[string]any, error) {
+	return d.moveTransfer(ctx, id, TransferRequested, TransferInTransit, "shipped_at", func(ctx context.Context, transfer map[string]any, copy map[string]any) error {
+		if err := Scope(ctx, fmt.Sprintf("transfer %v", id), fmt.Sprint(transfer["from_branch"])); err != nil {
+			return err
+		}
+		if status := fmt.Sprint(copy["status"]); status != CopyAvailable {
+			return refuse(fmt.Sprintf("copy %v is %v", copy["barcode"], status))
+		}
+		return update(ctx, copyTable, copy, map[string]any{"status": CopyInTransit})
+	})
+}
+
+// ReceiveTransfer shelves the copy of the transfer id in transit at the
+// branch it was sent to, which it belongs to from then on. It is set aside
+// for the oldest waiting hold of its book, if any.
+func (d *Desk) ReceiveTransfer(ctx context.Context, id int) (map[string]any, error) {
+	return d.moveTransfer(ctx, id, TransferInTransit, TransferReceived, "received_at", func(ctx context.Context, transfer map[string]any, copy map[string]any) error {
+		to := fmt.Sprint(transfer["to_branch"])
+		if err := Scope(ctx, fmt.Sprintf("transfer %v", id), to); err != nil {
+			return err
+		}
+		if err := update(ctx, copyTable, copy, map[string]any{"status": CopyAvailable, "branch": to}); err != nil {
+			return err
+		}
+		bookId, _ := copy["book_id"].(int)
+		return d.assign(ctx, bookId)
+	})
+}
+
+// CancelTransfer cancels the transfer id, by either branch, before its copy
+// is sent.
+func (d *Desk) CancelTransfer(ctx context.Context, id int) (map[string]any, error) {
+	return d.moveTransfer(ctx, id, TransferRequested, TransferCancelled, "", func(ctx context.Context, transfer map[string]any, copy map[string]any) error {
+		from, to := fmt.Sprint(transfer["from_branch"]), fmt.Sprint(transfer["to_branch"])
+		if staff := utils.BranchFromContext(ctx); staff != "" && staff != from && staff != to {
+			return refuse(fmt.Sprintf("transfer %v is between branches %v and %v, not %v", id, from, to, staff))
+		}
+		return nil
+	})
+}
+
+// moveTransfer moves the transfer id from the status from to the status to,
+// stamping the column stamp if any, once move has done its part with the copy.
+func (d *Desk) moveTransfer(ctx context.Context, id int, from string, to string, stamp string, move func(ctx context.Context, transfer map[string]any, copy map[string]any) error) (transfer map[string]any, err error) {
+	err = retry(ctx, func(ctx context.Context) error {
+		transfer, err = handler.GetRowByIdContext(ctx, transferTable, id)
+		if err != nil {
+			return err
+		}
+		if transfer["id"] == nil {
+			return fmt.Errorf("transfer %v: %w", id, handler.ErrNotFound)
+		}
+		if status := fmt.Sprint(transfer["status"]); status != from {
+			return refuse(fmt.Sprintf("transfer %v is %v, not %v", id, status, from))
+		}
+		copyId, _ := transfer["copy_id"].(int)
+		copy, err := handler.GetRowByIdContext(handler.WithDeleted(ctx), copyTable, copyId)
+		if err != nil {
+			return err
+		}
+		if err := move(ctx, transfer, copy); err != nil {
+			return err
+		}
+		change := map[string]any{"status": to}
+		if stamp != "" {
+			change[stamp] = d.now().UTC().Format(time.RFC3339)
+		}
+		if err := update(ctx, transferTable, transfer, change); err != nil {
+			return err
+		}
+		transfer, err = handler.GetRowByIdContext(ctx, transferTable, id)
+		return err
+	})
+	return transfer, err
+}
+
+// openTransfers counts the transfers of the copy copyId requested or in
+// transit.
+func openTransfers(ctx context.Context, copyId int) (int, error) {
+	return handler.CountRowsContext(ctx, transferTable, handler.FilterQuery{
+		And: map[string][]handler.FieldFilter{
+			"copy_id": {{Operator: "eq", Value: strconv.Itoa(copyId), ValueType: "int"}},
+		},
+		Or: map[string][]handler.FieldFilter{
+			"status": {
+				{Operator: "eq", Value: TransferRequested, ValueType: "string"},
+				{Operator: "eq", Value: TransferInTransit, ValueType: "string"},
+			},
+		},
+	})
+}
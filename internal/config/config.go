@@ -0,0 +1,210 @@
+package config
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config is the typed application configuration. Every setting carries its
+// file key, environment variable, default and validation rule in tags; the
+// command-line flag is the key with dots and underscores turned into dashes
+// (app.read_timeout -> --app-read-timeout).
+type Config struct {
+	App         AppConfig         `key:"app"`
+	DB          DBConfig          `key:"db"`
+	WS          WSConfig          `key:"ws"`
+	Health      HealthConfig      `key:"health"`
+	Tracing     TracingConfig     `key:"tracing"`
+	Import      ImportConfig      `key:"import"`
+	Idempotency IdempotencyConfig `key:"idempotency"`
+	Concurrency ConcurrencyConfig `key:"concurrency"`
+	Purge       PurgeConfig       `key:"purge"`
+	Audit       AuditConfig       `key:"audit"`
+	Webhooks    WebhooksConfig    `key:"webhooks"`
+	Events      EventsConfig      `key:"events"`
+	Overdue     OverdueConfig     `key:"overdue"`
+	Loans       LoansConfig       `key:"loans"`
+	Fines       FinesConfig       `key:"fines"`
+	Holds       HoldsConfig       `key:"holds"`
+	Tenants     TenantsConfig     `key:"tenants"`
+
+	// Sources records which layer provided each key, Warnings the
+	// non-fatal problems found while loading (e.g. unknown keys).
+	Sources  map[string]string
+	Warnings []string
+}
+
+type AppConfig struct {
+	Env               string        `key:"env" env:"APP_ENV" default:"staging" validate:"oneof=development staging production test"`
+	Host              string        `key:"host" env:"APP_HOST" default:"0.0.0.0"`
+	Port              int           `key:"port" env:"APP_PORT" default:"8080" validate:"port"`
+	Socket            string        `key:"socket" env:"APP_SOCKET" validate:"dir"`
+	ReadTimeout       time.Duration `key:"read_timeout" env:"APP_READ_TIMEOUT" default:"15s"`
+	ReadHeaderTimeout time.Duration `key:"read_header_timeout" env:"APP_READ_HEADER_TIMEOUT" default:"5s"`
+	WriteTimeout      time.Duration `key:"write_timeout" env:"APP_WRITE_TIMEOUT" default:"30s"`
+	IdleTimeout       time.Duration `key:"idle_timeout" env:"APP_IDLE_TIMEOUT" default:"120s"`
+	MaxHeaderBytes    int           `key:"max_header_bytes" env:"APP_MAX_HEADER_BYTES" default:"1048576" validate:"positive"`
+	ShutdownTimeout   time.Duration `key:"shutdown_timeout" env:"APP_SHUTDOWN_TIMEOUT" default:"20s"`
+	TLSCert           string        `key:"tls_cert" env:"APP_TLS_CERT" validate:"file"`
+	TLSKey            string        `key:"tls_key" env:"APP_TLS_KEY" validate:"file"`
+}
+
+type DBConfig struct {
+	Path        string `key:"path" env:"DB_PATH" validate:"required,dir"`
+	AutoMigrate bool   `key:"auto_migrate" env:"DB_AUTO_MIGRATE" default:"true"`
+}
+
+type WSConfig struct {
+	Logging bool `key:"logging" env:"WS_LOGGING" default:"true"`
+	Auth    bool `key:"auth" env:"WS_AUTH" default:"true"`
+}
+
+type HealthConfig struct {
+	CheckTimeout time.Duration `key:"check_timeout" env:"HEALTH_CHECK_TIMEOUT" default:"2s" validate:"positive"`
+	MinFreeBytes uint64        `key:"min_free_bytes" env:"HEALTH_MIN_FREE_BYTES" default:"52428800"`
+}
+
+type TracingConfig struct {
+	Exporter     string `key:"exporter" env:"TRACING_EXPORTER" default:"none" validate:"oneof=none stdout file otlp"`
+	File         string `key:"file" env:"TRACING_FILE" default:"traces.jsonl" validate:"dir"`
+	ServiceName  string `key:"service_name" env:"OTEL_SERVICE_NAME" default:"golang-rest-boilerplate"`
+	OTLPEndpoint string `key:"otlp_endpoint" env:"OTEL_EXPORTER_OTLP_ENDPOINT" default:"http://localhost:4318"`
+	OTLPHeaders  string `key:"otlp_headers" env:"OTEL_EXPORTER_OTLP_HEADERS" secret:"true"`
+}
+
+type ImportConfig struct {
+	MaxBytes     int           `key:"max_bytes" env:"IMPORT_MAX_BYTES" default:"33554432" validate:"positive"`
+	AsyncRows    int           `key:"async_rows" env:"IMPORT_ASYNC_ROWS" default:"1000" validate:"positive"`
+	JobRetention time.Duration `key:"job_retention" env:"IMPORT_JOB_RETENTION" default:"1h" validate:"positive"`
+}
+
+type IdempotencyConfig struct {
+	TTL           time.Duration `key:"ttl" env:"IDEMPOTENCY_TTL" default:"24h" validate:"positive"`
+	SweepInterval time.Duration `key:"sweep_interval" env:"IDEMPOTENCY_SWEEP_INTERVAL" default:"10m" validate:"positive"`
+}
+
+type ConcurrencyConfig struct {
+	RequireIfMatch bool `key:"require_if_match" env:"CONCURRENCY_REQUIRE_IF_MATCH" default:"false"`
+}
+
+type PurgeConfig struct {
+	Retention time.Duration `key:"retention" env:"PURGE_RETENTION" default:"720h" validate:"positive"`
+}
+
+// AuditConfig names the principal of a request by PrincipalHeader or, when
+// TokenKey is set, by the TokenClaim of a bearer token signed with it. Only
+// the latter scopes staff to their branch.
+type AuditConfig struct {
+	PrincipalHeader string `key:"principal_header" env:"AUDIT_PRINCIPAL_HEADER"`
+	TokenKey        string `key:"token_key" env:"AUDIT_TOKEN_KEY" secret:"true"`
+	TokenClaim      string `key:"token_claim" env:"AUDIT_TOKEN_CLAIM" default:"sub" validate:"required"`
+}
+
+type WebhooksConfig struct {
+	DispatchInterval time.Duration `key:"dispatch_interval" env:"WEBHOOKS_DISPATCH_INTERVAL" default:"5s" validate:"positive"`
+	Timeout          time.Duration `key:"timeout" env:"WEBHOOKS_TIMEOUT" default:"10s" validate:"positive"`
+	MaxAttempts      int           `key:"max_attempts" env:"WEBHOOKS_MAX_ATTEMPTS" default:"8" validate:"positive"`
+	Backoff          time.Duration `key:"backoff" env:"WEBHOOKS_BACKOFF" default:"30s" validate:"positive"`
+	MaxBackoff       time.Duration `key:"max_backoff" env:"WEBHOOKS_MAX_BACKOFF" default:"1h" validate:"positive"`
+}
+
+type EventsConfig struct {
+	PollInterval      time.Duration `key:"poll_interval" env:"EVENTS_POLL_INTERVAL" default:"1s" validate:"positive"`
+	StreamHeartbeat   time.Duration `key:"stream_heartbeat" env:"EVENTS_STREAM_HEARTBEAT" default:"15s" validate:"positive"`
+	StreamReplayLimit int           `key:"stream_replay_limit" env:"EVENTS_STREAM_REPLAY_LIMIT" default:"1000" validate:"positive"`
+}
+
+type OverdueConfig struct {
+	Interval time.Duration `key:"interval" env:"OVERDUE_INTERVAL" default:"1h" validate:"positive"`
+	Timezone string        `key:"timezone" env:"OVERDUE_TIMEZONE" default:"UTC" validate:"timezone"`
+}
+
+// LoansConfig is the default loan policy, of the members whose category has
+// none.
+type LoansConfig struct {
+	MaxActive      int `key:"max_active" env:"LOANS_MAX_ACTIVE" default:"5" validate:"nonnegative"`
+	PeriodDays     int `key:"period_days" env:"LOANS_PERIOD_DAYS" default:"14" validate:"positive"`
+	RenewalDays    int `key:"renewal_days" env:"LOANS_RENEWAL_DAYS" default:"14" validate:"positive"`
+	MaxRenewals    int `key:"max_renewals" env:"LOANS_MAX_RENEWALS" default:"2" validate:"nonnegative"`
+	MaxOverdueDays int `key:"max_overdue_days" env:"LOANS_MAX_OVERDUE_DAYS" default:"7" validate:"nonnegative"`
+}
+
+type HoldsConfig struct {
+	PickupDays     int           `key:"pickup_days" env:"HOLDS_PICKUP_DAYS" default:"3" validate:"positive"`
+	ExpiryInterval time.Duration `key:"expiry_interval" env:"HOLDS_EXPIRY_INTERVAL" default:"15m" validate:"positive"`
+}
+
+// TenantsConfig hosts several libraries, each in its own database under Dir;
+// the database of db.path keeps their registry.
+type TenantsConfig struct {
+	Enabled    bool   `key:"enabled" env:"TENANTS_ENABLED" default:"false"`
+	Dir        string `key:"dir" env:"TENANTS_DIR" default:"tenants"`
+	Header     string `key:"header" env:"TENANTS_HEADER" default:"X-Tenant"`
+	Domain     string `key:"domain" env:"TENANTS_DOMAIN"`
+	TokenKey   string `key:"token_key" env:"TENANTS_TOKEN_KEY" secret:"true"`
+	TokenClaim string `key:"token_claim" env:"TENANTS_TOKEN_CLAIM" default:"tenant" validate:"required"`
+	AdminToken string `key:"admin_token" env:"TENANTS_ADMIN_TOKEN" secret:"true"`
+}
+
+// FinesConfig amounts are in the minor unit of the currency, e.g. cents.
+type FinesConfig struct {
+	DailyRate  int    `key:"daily_rate" env:"FINES_DAILY_RATE" default:"25" validate:"nonnegative"`
+	GraceDays  int    `key:"grace_days" env:"FINES_GRACE_DAYS" default:"0" validate:"nonnegative"`
+	Cap        int    `key:"cap" env:"FINES_CAP" default:"1000" validate:"nonnegative"`
+	LostCost   int    `key:"lost_cost" env:"FINES_LOST_COST" default:"2500" validate:"nonnegative"`
+	MaxBalance int    `key:"max_balance" env:"FINES_MAX_BALANCE" default:"1000" validate:"nonnegative"`
+	Currency   string `key:"currency" env:"FINES_CURRENCY" default:"USD" validate:"required"`
+}
+
+func (c AppConfig) Address() string {
+	return fmt.Sprintf("%v:%v", c.Host, c.Port)
+}
+
+func (c AppConfig) PortString() string {
+	return strconv.Itoa(c.Port)
+}
+
+// StreamDuration is how long an event stream stays open: a little less than
+// the write timeout, which would otherwise cut it, or forever without one.
+func (c AppConfig) StreamDuration() time.Duration {
+	if c.WriteTimeout <= 0 {
+		return 0
+	}
+	if c.WriteTimeout <= 10*time.Second {
+		return c.WriteTimeout / 2
+	}
+	return c.WriteTimeout - 5*time.Second
+}
+
+// Headers parses the comma separated key=value list used by OTLP exporters.
+func (c TracingConfig) Headers() map[string]string {
+	headers := map[string]string{}
+	for _, pair := range strings.Split(c.OTLPHeaders, ",") {
+		k, v, found := strings.Cut(pair, "=")
+		if !found {
+			continue
+		}
+		headers[http.CanonicalHeaderKey(strings.TrimSpace(k))] = strings.TrimSpace(v)
+	}
+	return headers
+}
+
+func (c *Config) validate() error {
+	errs := validateFields(c)
+	if (c.App.TLSCert == "") != (c.App.TLSKey == "") {
+		errs = append(errs, "app.tls_cert and app.tls_key must be set together")
+	}
+	if c.Tenants.Enabled && c.Tenants.Header == "" && c.Tenants.Domain == "" && c.Tenants.TokenKey == "" {
+		errs = append(errs, "tenants.enabled needs tenants.header, tenants.domain or tenants.token_key to resolve tenants")
+	}
+	if c.Tenants.Enabled && c.Tenants.AdminToken == "" {
+		errs = append(errs, "tenants.enabled needs tenants.admin_token to guard /tenants")
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %v", strings.Join(errs, "; "))
+	}
+	return nil
+}
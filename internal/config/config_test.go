@@ -0,0 +1,93 @@
+package config
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func lookup(values map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		value, exist := values[key]
+		return value, exist
+	}
+}
+
+func TestLoadLayers(t *testing.T) {
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "config.yaml")
+	os.WriteFile(configFile, []byte(`
+app:
+  port: 9000
+  read_timeout: 3s
+  colour: blue
+db:
+  path: `+filepath.Join(dir, "file.sqlite")+`
+tracing:
+  otlp_headers: authorization=Bearer secret
+`), 0o644)
+	dotEnv := filepath.Join(dir, ".env")
+	os.WriteFile(dotEnv, []byte("APP_HOST=127.0.0.1\nWS_LOGGING=FALSE\nUNRELATED=1\n"), 0o644)
+
+	cfg, err := LoadWith(Options{
+		Args:       []string{"--config", configFile, "--app-port", "9100"},
+		LookupEnv:  lookup(map[string]string{"APP_PORT": "9050", "WS_AUTH": "no"}),
+		DotEnvFile: dotEnv,
+		Output:     io.Discard,
+	})
+	if err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+
+	if cfg.App.Port != 9100 || cfg.Sources["app.port"] != SourceFlag {
+		t.Errorf("flag should win over env and file, got %v from %v", cfg.App.Port, cfg.Sources["app.port"])
+	}
+	if cfg.App.ReadTimeout != 3*time.Second || cfg.Sources["app.read_timeout"] != SourceFile {
+		t.Errorf("file value not applied, got %v", cfg.App.ReadTimeout)
+	}
+	if cfg.App.Host != "127.0.0.1" || cfg.WS.Logging {
+		t.Errorf(".env values not applied, got host %v logging %v", cfg.App.Host, cfg.WS.Logging)
+	}
+	if cfg.WS.Auth {
+		t.Errorf("env WS_AUTH=no should disable auth")
+	}
+	if cfg.App.WriteTimeout != 30*time.Second || cfg.Sources["app.write_timeout"] != SourceDefault {
+		t.Errorf("default not applied, got %v", cfg.App.WriteTimeout)
+	}
+	warnings := strings.Join(cfg.Warnings, "\n")
+	if !strings.Contains(warnings, `"app.colour"`) || !strings.Contains(warnings, `"UNRELATED"`) {
+		t.Errorf("unknown keys should warn, got %v", cfg.Warnings)
+	}
+
+	var out strings.Builder
+	cfg.Print(&out)
+	if strings.Contains(out.String(), "secret") || !strings.Contains(out.String(), redacted) {
+		t.Errorf("secrets should be redacted:\n%v", out.String())
+	}
+}
+
+func TestLoadValidation(t *testing.T) {
+	invalid := map[string]map[string]string{
+		"missing db path":  {},
+		"port range":       {"DB_PATH": "db.sqlite", "APP_PORT": "70000"},
+		"port type":        {"DB_PATH": "db.sqlite", "APP_PORT": "http"},
+		"boolean":          {"DB_PATH": "db.sqlite", "WS_LOGGING": "maybe"},
+		"duration":         {"DB_PATH": "db.sqlite", "APP_READ_TIMEOUT": "10"},
+		"db directory":     {"DB_PATH": "/does/not/exist/db.sqlite"},
+		"tracing exporter": {"DB_PATH": "db.sqlite", "TRACING_EXPORTER": "jaeger"},
+		"tls pair":         {"DB_PATH": "db.sqlite", "APP_TLS_CERT": "config.go"},
+		"time zone":        {"DB_PATH": "db.sqlite", "OVERDUE_TIMEZONE": "Mars/Olympus"},
+		"negative fine":    {"DB_PATH": "db.sqlite", "FINES_DAILY_RATE": "-25"},
+		"tenant source":    {"DB_PATH": "db.sqlite", "TENANTS_ENABLED": "true", "TENANTS_HEADER": "", "TENANTS_ADMIN_TOKEN": "s3cr3t"},
+		"tenant admin":     {"DB_PATH": "db.sqlite", "TENANTS_ENABLED": "true"},
+	}
+	for name, env := range invalid {
+		_, err := LoadWith(Options{LookupEnv: lookup(env), DotEnvFile: "missing.env", Output: io.Discard})
+		if err == nil {
+			t.Errorf("%v: expected a validation error", name)
+		}
+	}
+}
@@ -0,0 +1,395 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceDotEnv  = ".env"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+)
+
+const redacted = "******"
+
+type Options struct {
+	// Args are the command-line flags, without the program name.
+	Args []string
+	// LookupEnv defaults to os.LookupEnv.
+	LookupEnv func(string) (string, bool)
+	// DotEnvFile defaults to ".env"; it is skipped when APP_ENV is production.
+	DotEnvFile string
+	// Output receives flag usage and errors, defaults to os.Stderr.
+	Output io.Writer
+}
+
+type field struct {
+	key      string
+	env      string
+	flag     string
+	def      string
+	secret   bool
+	validate string
+	value    reflect.Value
+}
+
+// Load merges, from lowest to highest precedence, defaults, the config file
+// given by --config or CONFIG_FILE (YAML or TOML), .env, environment
+// variables and command-line flags, then validates the result.
+func Load(args []string) (*Config, error) {
+	return LoadWith(Options{Args: args})
+}
+
+func LoadWith(opts Options) (*Config, error) {
+	if opts.LookupEnv == nil {
+		opts.LookupEnv = os.LookupEnv
+	}
+	if opts.DotEnvFile == "" {
+		opts.DotEnvFile = ".env"
+	}
+	if opts.Output == nil {
+		opts.Output = os.Stderr
+	}
+	cfg := &Config{Sources: map[string]string{}}
+	fields := fieldsOf(cfg)
+	byKey := map[string]field{}
+	byEnv := map[string]field{}
+	for _, f := range fields {
+		byKey[f.key] = f
+		byEnv[f.env] = f
+	}
+
+	flags := flag.NewFlagSet("server", flag.ContinueOnError)
+	flags.SetOutput(opts.Output)
+	configFile := flags.String("config", "", "path to a YAML or TOML config file (env CONFIG_FILE)")
+	flagValues := map[string]*string{}
+	for _, f := range fields {
+		usage := fmt.Sprintf("%v (env %v)", f.key, f.env)
+		flagValues[f.flag] = flags.String(f.flag, f.def, usage)
+	}
+	if err := flags.Parse(opts.Args); err != nil {
+		return nil, err
+	}
+	if flags.NArg() > 0 {
+		return nil, fmt.Errorf("unexpected arguments: %v", strings.Join(flags.Args(), " "))
+	}
+
+	var errs []string
+	set := func(f field, raw string, source string) {
+		if err := setValue(f.value, raw); err != nil {
+			errs = append(errs, fmt.Sprintf("%v from %v: %v", f.key, source, err))
+			return
+		}
+		cfg.Sources[f.key] = source
+	}
+
+	for _, f := range fields {
+		set(f, f.def, SourceDefault)
+	}
+
+	if *configFile == "" {
+		*configFile, _ = opts.LookupEnv("CONFIG_FILE")
+	}
+	if *configFile != "" {
+		values, err := readFile(*configFile)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range sortedKeys(values) {
+			f, known := byKey[key]
+			if !known {
+				cfg.Warnings = append(cfg.Warnings, fmt.Sprintf("unknown key %q in %v", key, *configFile))
+				continue
+			}
+			set(f, values[key], SourceFile)
+		}
+	}
+
+	appEnv, _ := opts.LookupEnv("APP_ENV")
+	if appEnv != "production" {
+		dotEnv, err := godotenv.Read(opts.DotEnvFile)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		for _, name := range sortedKeys(dotEnv) {
+			f, known := byEnv[name]
+			if !known {
+				cfg.Warnings = append(cfg.Warnings, fmt.Sprintf("unknown key %q in %v", name, opts.DotEnvFile))
+				continue
+			}
+			set(f, dotEnv[name], SourceDotEnv)
+		}
+	}
+
+	for _, f := range fields {
+		if raw, exist := opts.LookupEnv(f.env); exist {
+			set(f, raw, SourceEnv)
+		}
+	}
+
+	flags.Visit(func(fl *flag.Flag) {
+		for _, f := range fields {
+			if f.flag == fl.Name {
+				set(f, *flagValues[f.flag], SourceFlag)
+			}
+		}
+	})
+
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid configuration: %v", strings.Join(errs, "; "))
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// Print writes the effective configuration with secrets redacted.
+func (c *Config) Print(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "KEY\tVALUE\tSOURCE")
+	for _, f := range fieldsOf(c) {
+		value := formatValue(f.value)
+		if f.secret && value != "" {
+			value = redacted
+		}
+		source := c.Sources[f.key]
+		if source == SourceEnv || source == SourceDotEnv {
+			source = fmt.Sprintf("%v (%v)", source, f.env)
+		}
+		fmt.Fprintf(tw, "%v\t%v\t%v\n", f.key, value, source)
+	}
+	return tw.Flush()
+}
+
+func fieldsOf(cfg *Config) []field {
+	fields := []field{}
+	root := reflect.ValueOf(cfg).Elem()
+	for i := 0; i < root.NumField(); i++ {
+		section := root.Type().Field(i)
+		prefix, tagged := section.Tag.Lookup("key")
+		if !tagged {
+			continue
+		}
+		sectionValue := root.Field(i)
+		for j := 0; j < sectionValue.NumField(); j++ {
+			setting := sectionValue.Type().Field(j)
+			key := prefix + "." + setting.Tag.Get("key")
+			fields = append(fields, field{
+				key:      key,
+				env:      setting.Tag.Get("env"),
+				flag:     strings.NewReplacer(".", "-", "_", "-").Replace(key),
+				def:      setting.Tag.Get("default"),
+				secret:   setting.Tag.Get("secret") == "true",
+				validate: setting.Tag.Get("validate"),
+				value:    sectionValue.Field(j),
+			})
+		}
+	}
+	return fields
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+func setValue(value reflect.Value, raw string) error {
+	raw = strings.TrimSpace(raw)
+	if value.Type() == durationType {
+		if raw == "" {
+			value.SetInt(0)
+			return nil
+		}
+		duration, err := time.ParseDuration(raw)
+		if err != nil {
+			return err
+		}
+		if duration < 0 {
+			return errors.New("duration must not be negative")
+		}
+		value.SetInt(int64(duration))
+		return nil
+	}
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString(raw)
+	case reflect.Bool:
+		parsed, err := parseBool(raw)
+		if err != nil {
+			return err
+		}
+		value.SetBool(parsed)
+	case reflect.Int:
+		if raw == "" {
+			value.SetInt(0)
+			return nil
+		}
+		parsed, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", raw)
+		}
+		value.SetInt(int64(parsed))
+	case reflect.Uint64:
+		if raw == "" {
+			value.SetUint(0)
+			return nil
+		}
+		parsed, err := strconv.ParseUint(raw, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a positive integer", raw)
+		}
+		value.SetUint(parsed)
+	default:
+		return fmt.Errorf("unsupported setting type %v", value.Type())
+	}
+	return nil
+}
+
+// parseBool accepts the TRUE/FALSE spelling used by older .env files as
+// well as the usual boolean words.
+func parseBool(raw string) (bool, error) {
+	switch strings.ToLower(raw) {
+	case "1", "t", "true", "yes", "y", "on":
+		return true, nil
+	case "0", "f", "false", "no", "n", "off", "":
+		return false, nil
+	}
+	return false, fmt.Errorf("%q is not a boolean", raw)
+}
+
+func formatValue(value reflect.Value) string {
+	if value.Type() == durationType {
+		return time.Duration(value.Int()).String()
+	}
+	return fmt.Sprintf("%v", value.Interface())
+}
+
+func validateFields(cfg *Config) []string {
+	errs := []string{}
+	for _, f := range fieldsOf(cfg) {
+		if f.validate == "" {
+			continue
+		}
+		for _, rule := range strings.Split(f.validate, ",") {
+			if err := checkRule(rule, f.value); err != nil {
+				errs = append(errs, fmt.Sprintf("%v: %v", f.key, err))
+			}
+		}
+	}
+	return errs
+}
+
+func checkRule(rule string, value reflect.Value) error {
+	name, arg, _ := strings.Cut(rule, "=")
+	str := formatValue(value)
+	switch name {
+	case "required":
+		if value.IsZero() {
+			return errors.New("is required")
+		}
+	case "port":
+		if port := value.Int(); port < 1 || port > 65535 {
+			return fmt.Errorf("%v is not a valid port", port)
+		}
+	case "positive":
+		if value.IsZero() || (value.Kind() == reflect.Int && value.Int() < 0) {
+			return errors.New("must be greater than zero")
+		}
+	case "nonnegative":
+		if value.Int() < 0 {
+			return errors.New("must not be negative")
+		}
+	case "oneof":
+		for _, allowed := range strings.Fields(arg) {
+			if str == allowed {
+				return nil
+			}
+		}
+		return fmt.Errorf("%q must be one of %v", str, arg)
+	case "dir":
+		if str == "" {
+			return nil
+		}
+		if info, err := os.Stat(filepath.Dir(str)); err != nil || !info.IsDir() {
+			return fmt.Errorf("directory of %v does not exist", str)
+		}
+	case "file":
+		if str == "" {
+			return nil
+		}
+		if info, err := os.Stat(str); err != nil || info.IsDir() {
+			return fmt.Errorf("file %v does not exist", str)
+		}
+	case "timezone":
+		if _, err := time.LoadLocation(str); err != nil {
+			return fmt.Errorf("unknown time zone %q", str)
+		}
+	default:
+		return fmt.Errorf("unknown validation rule %v", name)
+	}
+	return nil
+}
+
+// readFile flattens a YAML or TOML document into dotted keys.
+func readFile(path string) (map[string]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	document := map[string]any{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, &document)
+	case ".toml":
+		err = toml.Unmarshal(content, &document)
+	default:
+		return nil, fmt.Errorf("unsupported config file format %v, use .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse %v: %w", path, err)
+	}
+	values := map[string]string{}
+	flatten("", document, values)
+	return values, nil
+}
+
+func flatten(prefix string, node map[string]any, values map[string]string) {
+	for k, v := range node {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+		switch typed := v.(type) {
+		case map[string]any:
+			flatten(key, typed, values)
+		case nil:
+			values[key] = ""
+		default:
+			values[key] = fmt.Sprintf("%v", typed)
+		}
+	}
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
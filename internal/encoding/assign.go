@@ -0,0 +1,114 @@
+package encoding
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Assign stores a text record, as read from CSV or XML, into target: a
+// pointer to a struct, whose fields are matched by json name and parsed to
+// their type, or to a map[string]interface{}, which keeps the raw strings.
+// Pointers on the way are allocated as needed.
+func Assign(target interface{}, record map[string]string) error {
+	v := reflect.ValueOf(target)
+	if v.Kind() != reflect.Pointer || v.IsNil() {
+		return errors.New("assign target must be a non-nil pointer")
+	}
+	v = v.Elem()
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		v = v.Elem()
+	}
+	switch {
+	case v.Kind() == reflect.Map && v.Type().Key().Kind() == reflect.String:
+		if v.IsNil() {
+			v.Set(reflect.MakeMap(v.Type()))
+		}
+		for key, value := range record {
+			element := reflect.ValueOf(value)
+			if !element.Type().AssignableTo(v.Type().Elem()) {
+				return fmt.Errorf("cannot store text into a map of %v", v.Type().Elem())
+			}
+			v.SetMapIndex(reflect.ValueOf(key).Convert(v.Type().Key()), element)
+		}
+		return nil
+	case v.Kind() == reflect.Struct:
+		errs := []string{}
+		for _, f := range structFields(v.Type()) {
+			raw, exist := record[f.name]
+			if !exist {
+				continue
+			}
+			if err := setText(v.FieldByIndex(f.index), raw); err != nil {
+				errs = append(errs, fmt.Sprintf("%v: %v", f.name, err))
+			}
+		}
+		if len(errs) > 0 {
+			return errors.New(strings.Join(errs, "; "))
+		}
+		return nil
+	}
+	return fmt.Errorf("cannot assign a record to %v", v.Type())
+}
+
+func setText(field reflect.Value, raw string) error {
+	raw = strings.TrimSpace(raw)
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(raw)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if raw == "" {
+			return nil
+		}
+		parsed, err := strconv.ParseInt(raw, 10, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("%q is not an integer", raw)
+		}
+		field.SetInt(parsed)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if raw == "" {
+			return nil
+		}
+		parsed, err := strconv.ParseUint(raw, 10, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("%q is not a positive integer", raw)
+		}
+		field.SetUint(parsed)
+	case reflect.Float32, reflect.Float64:
+		if raw == "" {
+			return nil
+		}
+		parsed, err := strconv.ParseFloat(raw, field.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("%q is not a number", raw)
+		}
+		field.SetFloat(parsed)
+	case reflect.Bool:
+		if raw == "" {
+			return nil
+		}
+		parsed, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", raw)
+		}
+		field.SetBool(parsed)
+	case reflect.Pointer:
+		if raw == "" {
+			return nil
+		}
+		if field.IsNil() {
+			field.Set(reflect.New(field.Type().Elem()))
+		}
+		return setText(field.Elem(), raw)
+	case reflect.Interface:
+		field.Set(reflect.ValueOf(raw))
+	default:
+		return fmt.Errorf("unsupported field type %v", field.Type())
+	}
+	return nil
+}
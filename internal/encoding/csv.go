@@ -0,0 +1,134 @@
+package encoding
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// flushEvery is the number of rows buffered before they are pushed to the
+// client, so large collections start downloading right away.
+const flushEvery = 100
+
+// CSVWriter writes records under a fixed header row.
+type CSVWriter struct {
+	writer  *csv.Writer
+	out     io.Writer
+	columns []string
+	written int
+}
+
+func NewCSVWriter(out io.Writer, columns []string) *CSVWriter {
+	return &CSVWriter{writer: csv.NewWriter(out), out: out, columns: columns}
+}
+
+func (w *CSVWriter) WriteHeader() error {
+	return w.writer.Write(w.columns)
+}
+
+// Write appends one record, matching its fields to the header by name.
+func (w *CSVWriter) Write(record interface{}) error {
+	values := map[string]interface{}{}
+	for _, field := range Record(record, nil) {
+		values[field.Name] = field.Value
+	}
+	line := make([]string, len(w.columns))
+	for i, column := range w.columns {
+		line[i] = Format(values[column])
+	}
+	if err := w.writer.Write(line); err != nil {
+		return err
+	}
+	w.written++
+	if w.written%flushEvery == 0 {
+		return w.Flush()
+	}
+	return nil
+}
+
+func (w *CSVWriter) Flush() error {
+	w.writer.Flush()
+	if flusher, ok := w.out.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return w.writer.Error()
+}
+
+// WriteCSV writes a collection with a header row, one line per row.
+func WriteCSV(out io.Writer, value interface{}, columns []string) error {
+	rows := Rows(value)
+	writer := NewCSVWriter(out, Header(rows, columns))
+	if err := writer.WriteHeader(); err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if err := writer.Write(row); err != nil {
+			return err
+		}
+	}
+	return writer.Flush()
+}
+
+// CSVReader reads records keyed by the header row.
+type CSVReader struct {
+	reader *csv.Reader
+	header []string
+}
+
+func NewCSVReader(in io.Reader) (*CSVReader, error) {
+	reader := csv.NewReader(in)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, errors.New("csv body is empty, a header row is required")
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i := range header {
+		header[i] = strings.TrimSpace(strings.TrimPrefix(header[i], "\ufeff"))
+	}
+	return &CSVReader{reader: reader, header: header}, nil
+}
+
+// Read returns the next record and its line number, io.EOF at the end.
+func (r *CSVReader) Read() (map[string]string, int, error) {
+	line, err := r.reader.Read()
+	if err != nil {
+		return nil, 0, err
+	}
+	lineNumber, _ := r.reader.FieldPos(0)
+	record := map[string]string{}
+	for i, column := range r.header {
+		if i < len(line) {
+			record[column] = line[i]
+		}
+	}
+	return record, lineNumber, nil
+}
+
+// ReadCSVRecord reads a body holding a header and exactly one record.
+func ReadCSVRecord(in io.Reader) (map[string]string, error) {
+	reader, err := NewCSVReader(in)
+	if err != nil {
+		return nil, err
+	}
+	record, _, err := reader.Read()
+	if errors.Is(err, io.EOF) {
+		return nil, errors.New("csv body has no record after the header row")
+	}
+	if err != nil {
+		return nil, err
+	}
+	_, line, err := reader.Read()
+	if err == nil {
+		return nil, fmt.Errorf("csv body must hold a single record, found another on line %v", line)
+	}
+	if !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return record, nil
+}
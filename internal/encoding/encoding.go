@@ -0,0 +1,160 @@
+package encoding
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	MIME_CSV    = "text/csv"
+	MIME_NDJSON = "application/x-ndjson"
+)
+
+// Field is one named value of a record, in output order.
+type Field struct {
+	Name  string
+	Value interface{}
+}
+
+// Rows returns the elements of a slice or array, or the value itself when it
+// is a single record. A nil value has no rows.
+func Rows(value interface{}) []interface{} {
+	if value == nil {
+		return nil
+	}
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return []interface{}{value}
+	}
+	if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 {
+		return []interface{}{value}
+	}
+	rows := make([]interface{}, v.Len())
+	for i := range rows {
+		rows[i] = v.Index(i).Interface()
+	}
+	return rows
+}
+
+// Record returns the fields of a map or struct. Struct fields use their json
+// names in declaration order; map keys follow columns, then the remaining keys
+// sorted. Any other value is a single field named value.
+func Record(value interface{}, columns []string) []Field {
+	v := reflect.ValueOf(value)
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+	switch {
+	case v.Kind() == reflect.Map && v.Type().Key().Kind() == reflect.String:
+		fields := []Field{}
+		seen := map[string]bool{}
+		for _, column := range columns {
+			fields = append(fields, Field{Name: column, Value: mapValue(v, column)})
+			seen[column] = true
+		}
+		keys := []string{}
+		for _, key := range v.MapKeys() {
+			if !seen[key.String()] {
+				keys = append(keys, key.String())
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fields = append(fields, Field{Name: key, Value: mapValue(v, key)})
+		}
+		return fields
+	case v.Kind() == reflect.Struct && v.Type() != timeType:
+		fields := []Field{}
+		for _, f := range structFields(v.Type()) {
+			fields = append(fields, Field{Name: f.name, Value: v.FieldByIndex(f.index).Interface()})
+		}
+		return fields
+	}
+	return []Field{{Name: "value", Value: v.Interface()}}
+}
+
+func mapValue(m reflect.Value, key string) interface{} {
+	value := m.MapIndex(reflect.ValueOf(key).Convert(m.Type().Key()))
+	if !value.IsValid() {
+		return nil
+	}
+	return value.Interface()
+}
+
+// Header returns the column names of a collection: the given columns, or the
+// fields of its first row.
+func Header(rows []interface{}, columns []string) []string {
+	if len(columns) > 0 || len(rows) == 0 {
+		return columns
+	}
+	header := []string{}
+	for _, field := range Record(rows[0], nil) {
+		header = append(header, field.Name)
+	}
+	return header
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// Format renders a scalar as text, nil being the empty string.
+func Format(value interface{}) string {
+	switch typed := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return typed
+	case []byte:
+		return string(typed)
+	case time.Time:
+		return typed.Format(time.RFC3339)
+	case float64:
+		return strconv.FormatFloat(typed, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(typed), 'f', -1, 32)
+	case fmt.Stringer:
+		return typed.String()
+	}
+	return fmt.Sprint(value)
+}
+
+type structField struct {
+	name  string
+	index []int
+	kind  reflect.Kind
+}
+
+// structFields lists the exported fields of a struct by json name, skipping
+// json:"-" and flattening embedded structs.
+func structFields(t reflect.Type) []structField {
+	fields := []structField{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			for _, inner := range structFields(field.Type) {
+				inner.index = append([]int{i}, inner.index...)
+				fields = append(fields, inner)
+			}
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields = append(fields, structField{name: name, index: []int{i}, kind: field.Type.Kind()})
+	}
+	return fields
+}
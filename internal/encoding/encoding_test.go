@@ -0,0 +1,119 @@
+package encoding
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+)
+
+type book struct {
+	Id       int    `json:"id"`
+	Title    string `json:"title"`
+	AuthorId int    `json:"author_id"`
+	Stock    int    `json:"stock"`
+}
+
+func TestWriteCSV(t *testing.T) {
+	rows := []map[string]any{
+		{"title": "moby dick", "id": 1, "stock": 2},
+		{"title": "typee, a peep", "id": 2, "stock": nil},
+	}
+	var out bytes.Buffer
+	if err := WriteCSV(&out, rows, []string{"id", "title", "stock"}); err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	expected := "id,title,stock\n1,moby dick,2\n2,\"typee, a peep\",\n"
+	if out.String() != expected {
+		t.Errorf("unexpected csv:\n%v", out.String())
+	}
+
+	out.Reset()
+	WriteCSV(&out, []book{{Id: 1, Title: "moby dick", AuthorId: 3}}, nil)
+	if !strings.HasPrefix(out.String(), "id,title,author_id,stock\n") {
+		t.Errorf("struct columns should follow the json names, got:\n%v", out.String())
+	}
+}
+
+func TestReadCSVRecord(t *testing.T) {
+	record, err := ReadCSVRecord(strings.NewReader("\ufefftitle, author_id,stock\nmoby dick,3,2\n"))
+	if err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	target := new(book)
+	if err := Assign(&target, record); err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	if target.Title != "moby dick" || target.AuthorId != 3 || target.Stock != 2 {
+		t.Errorf("unexpected book %+v", target)
+	}
+
+	if _, err := ReadCSVRecord(strings.NewReader("title\na\nb\n")); err == nil {
+		t.Errorf("more than one record should be rejected")
+	}
+	if err := Assign(target, map[string]string{"stock": "many"}); err == nil {
+		t.Errorf("non numeric stock should be rejected")
+	}
+}
+
+func TestXML(t *testing.T) {
+	var out bytes.Buffer
+	enc := xml.NewEncoder(&out)
+	rows := []map[string]any{{"id": 1, "COUNT(*)": 2, "title": "a < b"}}
+	if err := EncodeXML(enc, Element("books"), rows, "", []string{"id"}); err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	enc.Flush()
+	expected := "<books><book><id>1</id><COUNT___>2</COUNT___><title>a &lt; b</title></book></books>"
+	if out.String() != expected {
+		t.Errorf("unexpected xml:\n%v", out.String())
+	}
+
+	record, err := DecodeXMLRecord(strings.NewReader("<book><title> moby dick </title><stock>2</stock></book>"))
+	if err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	values := map[string]interface{}{}
+	Assign(&values, record)
+	if values["title"] != "moby dick" || values["stock"] != "2" {
+		t.Errorf("unexpected record %v", values)
+	}
+	if _, err := DecodeXMLRecord(strings.NewReader("<book><author><name>x</name></author></book>")); err == nil {
+		t.Errorf("nested fields should be rejected")
+	}
+}
+
+func TestXLSXWriter(t *testing.T) {
+	var out bytes.Buffer
+	writer, err := NewXLSXWriter(&out, "books", []string{"id", "title"})
+	if err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	writer.WriteHeader()
+	writer.Write(map[string]any{"id": 1, "title": "a < b"})
+	writer.Write(map[string]any{"id": 2, "title": nil})
+	if err := writer.Close(); err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(out.Bytes()), int64(out.Len()))
+	if err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	var sheet []byte
+	for _, file := range archive.File {
+		if file.Name == "xl/worksheets/sheet1.xml" {
+			content, _ := file.Open()
+			sheet, _ = io.ReadAll(content)
+		}
+	}
+	expected := `<row r="2"><c r="A2"><v>1</v></c><c r="B2" t="inlineStr"><is><t xml:space="preserve">a &lt; b</t></is></c></row><row r="3"><c r="A3"><v>2</v></c></row></sheetData>`
+	if !strings.Contains(string(sheet), expected) {
+		t.Errorf("unexpected sheet:\n%s", sheet)
+	}
+	if cellColumn(0) != "A" || cellColumn(25) != "Z" || cellColumn(26) != "AA" || cellColumn(27) != "AB" {
+		t.Errorf("unexpected column names")
+	}
+}
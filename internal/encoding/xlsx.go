@@ -0,0 +1,154 @@
+package encoding
+
+import (
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+const MIME_XLSX = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+
+// The parts of a workbook with a single worksheet, which is written last so
+// that it can be streamed.
+var xlsxParts = []struct{ name, content string }{
+	{"[Content_Types].xml", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Types xmlns="http://schemas.openxmlformats.org/package/2006/content-types"><Default Extension="rels" ContentType="application/vnd.openxmlformats-package.relationships+xml"/><Default Extension="xml" ContentType="application/xml"/><Override PartName="/xl/workbook.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.sheet.main+xml"/><Override PartName="/xl/worksheets/sheet1.xml" ContentType="application/vnd.openxmlformats-officedocument.spreadsheetml.worksheet+xml"/></Types>`},
+	{"_rels/.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/officeDocument" Target="xl/workbook.xml"/></Relationships>`},
+	{"xl/_rels/workbook.xml.rels", `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships"><Relationship Id="rId1" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/worksheet" Target="worksheets/sheet1.xml"/></Relationships>`},
+}
+
+// XLSXWriter writes records as the rows of a one-sheet workbook. Strings are
+// written inline, so nothing but the current row is held in memory.
+type XLSXWriter struct {
+	archive *zip.Writer
+	sheet   *bufio.Writer
+	out     io.Writer
+	columns []string
+	written int
+}
+
+// NewXLSXWriter starts a workbook whose sheet is named sheetName.
+func NewXLSXWriter(out io.Writer, sheetName string, columns []string) (*XLSXWriter, error) {
+	archive := zip.NewWriter(out)
+	for _, part := range xlsxParts {
+		if err := writePart(archive, part.name, part.content); err != nil {
+			return nil, err
+		}
+	}
+	var workbook bytes.Buffer
+	workbook.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><sheets><sheet name="`)
+	xml.EscapeText(&workbook, []byte(sheetName))
+	workbook.WriteString(`" sheetId="1" r:id="rId1"/></sheets></workbook>`)
+	if err := writePart(archive, "xl/workbook.xml", workbook.String()); err != nil {
+		return nil, err
+	}
+	sheet, err := archive.Create("xl/worksheets/sheet1.xml")
+	if err != nil {
+		return nil, err
+	}
+	w := &XLSXWriter{archive: archive, sheet: bufio.NewWriter(sheet), out: out, columns: columns}
+	_, err = w.sheet.WriteString(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>`)
+	return w, err
+}
+
+func writePart(archive *zip.Writer, name string, content string) error {
+	part, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(part, content)
+	return err
+}
+
+func (w *XLSXWriter) WriteHeader() error {
+	header := make([]interface{}, len(w.columns))
+	for i, column := range w.columns {
+		header[i] = column
+	}
+	return w.writeRow(header)
+}
+
+// Write appends one record, matching its fields to the header by name.
+func (w *XLSXWriter) Write(record interface{}) error {
+	values := map[string]interface{}{}
+	for _, field := range Record(record, nil) {
+		values[field.Name] = field.Value
+	}
+	line := make([]interface{}, len(w.columns))
+	for i, column := range w.columns {
+		line[i] = values[column]
+	}
+	if err := w.writeRow(line); err != nil {
+		return err
+	}
+	if w.written%flushEvery == 0 {
+		return w.flush()
+	}
+	return nil
+}
+
+func (w *XLSXWriter) writeRow(values []interface{}) error {
+	w.written++
+	row := strconv.Itoa(w.written)
+	var buf bytes.Buffer
+	buf.WriteString(`<row r="` + row + `">`)
+	for i, value := range values {
+		if value == nil {
+			continue
+		}
+		ref := cellColumn(i) + row
+		switch value.(type) {
+		case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64:
+			buf.WriteString(`<c r="` + ref + `"><v>` + Format(value) + `</v></c>`)
+		default:
+			buf.WriteString(`<c r="` + ref + `" t="inlineStr"><is><t xml:space="preserve">`)
+			xml.EscapeText(&buf, []byte(Format(value)))
+			buf.WriteString(`</t></is></c>`)
+		}
+	}
+	buf.WriteString(`</row>`)
+	_, err := w.sheet.Write(buf.Bytes())
+	return err
+}
+
+func (w *XLSXWriter) flush() error {
+	if err := w.sheet.Flush(); err != nil {
+		return err
+	}
+	if err := w.archive.Flush(); err != nil {
+		return err
+	}
+	if flusher, ok := w.out.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
+
+// Close ends the sheet and the archive; the workbook is incomplete until
+// it is called.
+func (w *XLSXWriter) Close() error {
+	if _, err := w.sheet.WriteString(`</sheetData></worksheet>`); err != nil {
+		return err
+	}
+	if err := w.sheet.Flush(); err != nil {
+		return err
+	}
+	return w.archive.Close()
+}
+
+// cellColumn names the column at index i, A to Z then AA and so on.
+func cellColumn(i int) string {
+	name := ""
+	for i++; i > 0; i = (i - 1) / 26 {
+		name = string(rune('A'+(i-1)%26)) + name
+	}
+	return name
+}
@@ -0,0 +1,127 @@
+package encoding
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+// EncodeXML writes value as the element start. Maps and structs become one
+// child element per field, named like their JSON keys; slices become one
+// child per item, named item or, when empty, the singular of the parent.
+func EncodeXML(enc *xml.Encoder, start xml.StartElement, value interface{}, item string, columns []string) error {
+	if marshaler, ok := value.(xml.Marshaler); ok {
+		return enc.EncodeElement(marshaler, start)
+	}
+	if err := enc.EncodeToken(start); err != nil {
+		return err
+	}
+	v := reflect.ValueOf(value)
+	for v.IsValid() && (v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface) && !v.IsNil() {
+		v = v.Elem()
+	}
+	switch {
+	case !v.IsValid() || ((v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface ||
+		v.Kind() == reflect.Map || v.Kind() == reflect.Slice) && v.IsNil()):
+		// Written as an empty element.
+	case (v.Kind() == reflect.Slice || v.Kind() == reflect.Array) && v.Type().Elem().Kind() != reflect.Uint8:
+		if item == "" {
+			item = Singular(start.Name.Local)
+		}
+		for _, row := range Rows(v.Interface()) {
+			if err := EncodeXML(enc, Element(item), row, "", columns); err != nil {
+				return err
+			}
+		}
+	case v.Kind() == reflect.Map || (v.Kind() == reflect.Struct && v.Type() != timeType):
+		for _, field := range Record(v.Interface(), columns) {
+			if err := EncodeXML(enc, Element(field.Name), field.Value, "", nil); err != nil {
+				return err
+			}
+		}
+	default:
+		if err := enc.EncodeToken(xml.CharData(Format(v.Interface()))); err != nil {
+			return err
+		}
+	}
+	return enc.EncodeToken(start.End())
+}
+
+// Element returns a start element whose name is a valid XML name derived
+// from name, e.g. for column names like "COUNT(*)".
+func Element(name string) xml.StartElement {
+	var b strings.Builder
+	for i, r := range name {
+		valid := unicode.IsLetter(r) || r == '_' || (i > 0 && (unicode.IsDigit(r) || r == '-' || r == '.'))
+		if valid {
+			b.WriteRune(r)
+		} else {
+			b.WriteRune('_')
+		}
+	}
+	if b.Len() == 0 {
+		return xml.StartElement{Name: xml.Name{Local: "value"}}
+	}
+	return xml.StartElement{Name: xml.Name{Local: b.String()}}
+}
+
+// Singular names the items of a list element, "books" holding "book".
+func Singular(name string) string {
+	switch {
+	case strings.HasSuffix(name, "ies") && len(name) > 3:
+		return strings.TrimSuffix(name, "ies") + "y"
+	case strings.HasSuffix(name, "s") && !strings.HasSuffix(name, "ss") && len(name) > 1:
+		return strings.TrimSuffix(name, "s")
+	}
+	return "item"
+}
+
+// DecodeXMLRecord reads a single element whose children are the fields of
+// a record, e.g. <book><title>Moby Dick</title><stock>2</stock></book>.
+func DecodeXMLRecord(in io.Reader) (map[string]string, error) {
+	decoder := xml.NewDecoder(in)
+	record := map[string]string{}
+	depth := 0
+	var field string
+	var text strings.Builder
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			if depth != 0 || field != "" {
+				return nil, io.ErrUnexpectedEOF
+			}
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch typed := token.(type) {
+		case xml.StartElement:
+			depth++
+			switch depth {
+			case 2:
+				field = typed.Name.Local
+				text.Reset()
+			case 3:
+				return nil, errors.New("nested element " + typed.Name.Local + " in " + field + ", fields must hold text")
+			}
+		case xml.CharData:
+			if depth == 2 {
+				text.Write(typed)
+			}
+		case xml.EndElement:
+			if depth == 2 {
+				record[field] = strings.TrimSpace(text.String())
+				field = ""
+			}
+			depth--
+		}
+	}
+	if len(record) == 0 {
+		return nil, errors.New("xml body holds no fields")
+	}
+	return record, nil
+}
@@ -0,0 +1,288 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/riszkymf/golang-rest-boilerplate/internal/handler"
+	utils "github.com/riszkymf/golang-rest-boilerplate/internal/src"
+)
+
+// The headers of a delivery. The signature is the hex HMAC-SHA256, keyed
+// with the secret of the webhook, of the timestamp, a dot and the body.
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	TimestampHeader = "X-Webhook-Timestamp"
+	EventHeader     = "X-Webhook-Event"
+	EventIdHeader   = "X-Webhook-Event-Id"
+	DeliveryHeader  = "X-Webhook-Delivery"
+)
+
+// maxErrorLength bounds the last_error kept for a failed attempt.
+const maxErrorLength = 1024
+
+// Options tunes the delivery of events: each attempt waits Timeout for the
+// receiver, failed deliveries are retried after Backoff, doubled on every
+// further failure up to MaxBackoff, and are dead after MaxAttempts.
+type Options struct {
+	Timeout     time.Duration
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+	BatchSize   int
+}
+
+// Dispatcher delivers the events of the outbox to the webhooks subscribed to
+// them, at least once: a receiver may see an event again, with the same
+// X-Webhook-Event-Id, if its acknowledgement was lost.
+type Dispatcher struct {
+	mu      sync.Mutex
+	options Options
+	client  *http.Client
+}
+
+func NewDispatcher(options Options) *Dispatcher {
+	d := &Dispatcher{}
+	d.SetOptions(options)
+	return d
+}
+
+var Default = NewDispatcher(Options{
+	Timeout:     10 * time.Second,
+	MaxAttempts: 8,
+	Backoff:     30 * time.Second,
+	MaxBackoff:  time.Hour,
+	BatchSize:   100,
+})
+
+func (d *Dispatcher) SetOptions(options Options) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.options = options
+	d.client = &http.Client{Timeout: options.Timeout}
+}
+
+func (d *Dispatcher) Options() Options {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.options
+}
+
+// backoff is the wait before the attempt following the attempts-th failure.
+func (o Options) backoff(attempts int) time.Duration {
+	wait := o.Backoff
+	for i := 1; i < attempts && wait < o.MaxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > o.MaxBackoff {
+		wait = o.MaxBackoff
+	}
+	return wait
+}
+
+// Sign returns the signature of a delivery of body at timestamp.
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Dispatch fans the new events of the outbox out to the webhooks subscribed
+// to them, then attempts the deliveries that are due. It returns how many of
+// those succeeded and failed.
+func (d *Dispatcher) Dispatch(ctx context.Context) (delivered int, failed int, err error) {
+	if err = d.fanOut(ctx); err != nil {
+		return 0, 0, err
+	}
+	return d.deliverDue(ctx)
+}
+
+// fanOut queues a delivery of each undispatched event to every active
+// webhook subscribed to it, and marks the events dispatched.
+func (d *Dispatcher) fanOut(ctx context.Context) error {
+	return handler.WithTransaction(ctx, func(ctx context.Context) error {
+		rows, err := handler.GetRowsContext(ctx, outbox, handler.ListQuery{
+			Filter: handler.FilterQuery{And: map[string][]handler.FieldFilter{
+				"dispatched": {{Operator: "eq", Value: "0", ValueType: "int"}},
+			}},
+			Sort:  []handler.SortField{{Column: "id"}},
+			Limit: d.Options().BatchSize,
+		})
+		if err != nil || len(rows) == 0 {
+			return err
+		}
+		subscribed, err := ListWebhooks(ctx, false)
+		if err != nil {
+			return err
+		}
+		now := time.Now().Unix()
+		for _, row := range rows {
+			eventId, _ := row["id"].(int)
+			eventType, _ := row["event_type"].(string)
+			for _, webhook := range subscribed {
+				if !webhook.Subscribes(eventType) {
+					continue
+				}
+				_, err := handler.InsertDataContext(ctx, deliveries, map[string]any{
+					"webhook_id":      webhook.Id,
+					"event_id":        eventId,
+					"status":          DeliveryPending,
+					"next_attempt_at": now,
+				})
+				if err != nil {
+					return err
+				}
+			}
+			if err := handler.UpdateDataContext(ctx, outbox, map[string]any{"dispatched": 1}, eventId); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// deliverDue attempts the pending deliveries whose next attempt is due.
+func (d *Dispatcher) deliverDue(ctx context.Context) (delivered int, failed int, err error) {
+	options := d.Options()
+	now := time.Now()
+	rows, err := handler.GetRowsContext(ctx, deliveries, handler.ListQuery{
+		Filter: handler.FilterQuery{And: map[string][]handler.FieldFilter{
+			"status":          {{Operator: "eq", Value: DeliveryPending, ValueType: "string"}},
+			"next_attempt_at": {{Operator: "lte", Value: strconv.FormatInt(now.Unix(), 10), ValueType: "int"}},
+		}},
+		Sort:  []handler.SortField{{Column: "next_attempt_at"}, {Column: "id"}},
+		Limit: options.BatchSize,
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	webhookCache := map[int]Webhook{}
+	for _, row := range rows {
+		delivery := deliveryFromRow(row)
+		webhook, cached := webhookCache[delivery.WebhookId]
+		if !cached {
+			found := false
+			webhook, found, err = GetWebhook(ctx, delivery.WebhookId)
+			if err != nil {
+				return delivered, failed, err
+			}
+			if !found {
+				webhook.Id = -1
+			}
+			webhookCache[delivery.WebhookId] = webhook
+		}
+		update := map[string]any{}
+		switch {
+		case webhook.Id == -1:
+			update["status"] = DeliveryDead
+			update["last_error"] = "webhook deleted"
+		case !webhook.Active:
+			// Paused: the delivery waits for the webhook to be reactivated.
+			update["next_attempt_at"] = now.Add(options.MaxBackoff).Unix()
+		default:
+			event, found, err := Get(ctx, delivery.EventId)
+			if err != nil {
+				return delivered, failed, err
+			}
+			if !found {
+				update["status"] = DeliveryDead
+				update["last_error"] = "event deleted"
+				break
+			}
+			status, sendErr := d.Send(ctx, webhook, event, delivery.Id)
+			attempts := delivery.Attempts + 1
+			update["attempts"] = attempts
+			update["last_status"] = status
+			if sendErr == nil {
+				delivered++
+				update["status"] = DeliveryDelivered
+				update["last_error"] = nil
+				update["delivered_at"] = time.Now().UTC().Format(time.RFC3339)
+				break
+			}
+			failed++
+			message := sendErr.Error()
+			if len(message) > maxErrorLength {
+				message = message[:maxErrorLength]
+			}
+			update["last_error"] = message
+			if attempts >= options.MaxAttempts {
+				update["status"] = DeliveryDead
+				utils.LogError("[events]", "deliver event", fmt.Sprintf("delivery %v of event %v to webhook %v is dead after %v attempts: %v", delivery.Id, event.Id, webhook.Id, attempts, message))
+			} else {
+				update["next_attempt_at"] = time.Now().Add(options.backoff(attempts)).Unix()
+			}
+		}
+		if err := handler.UpdateDataContext(ctx, deliveries, update, delivery.Id); err != nil {
+			return delivered, failed, err
+		}
+	}
+	return delivered, failed, nil
+}
+
+// Send posts event to webhook, signed, and returns the status answered by the
+// receiver. Any status but 2xx is an error. deliveryId identifies the
+// delivery to the receiver, 0 for a test.
+func (d *Dispatcher) Send(ctx context.Context, webhook Webhook, event Event, deliveryId int) (int, error) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return 0, err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.Url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := time.Now().Unix()
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	request.Header.Set(SignatureHeader, Sign(webhook.Secret, timestamp, body))
+	request.Header.Set(EventHeader, event.Type)
+	request.Header.Set(EventIdHeader, strconv.Itoa(event.Id))
+	request.Header.Set(DeliveryHeader, strconv.Itoa(deliveryId))
+
+	d.mu.Lock()
+	client := d.client
+	d.mu.Unlock()
+	response, err := client.Do(request)
+	if err != nil {
+		return 0, err
+	}
+	defer response.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(response.Body, 64<<10))
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return response.StatusCode, fmt.Errorf("receiver answered %v", response.Status)
+	}
+	return response.StatusCode, nil
+}
+
+// Run dispatches every interval until ctx is done.
+func (d *Dispatcher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			delivered, failed, err := d.Dispatch(ctx)
+			if err != nil {
+				utils.CheckErrorContext(ctx, err, "[events]", "dispatch events")
+				continue
+			}
+			if delivered > 0 || failed > 0 {
+				utils.LogInfo("[events]", "dispatch events", fmt.Sprintf("%v deliveries succeeded, %v failed", delivered, failed))
+			}
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/riszkymf/golang-rest-boilerplate/internal/handler"
+)
+
+// The domain events, raised by the changes to books, copies, members, records
+// and holds.
+const (
+	BookCreated      = "book.created"
+	BookStockChanged = "book.stock_changed"
+	MemberCreated    = "member.created"
+	RentCheckedOut   = "rent.checked_out"
+	RentReturned     = "rent.returned"
+	RentOverdue      = "rent.overdue"
+	RentLost         = "rent.lost"
+	RentRenewed      = "rent.renewed"
+	HoldReady        = "hold.ready"
+)
+
+// Types lists the domain events, in the order they are documented.
+var Types = []string{BookCreated, BookStockChanged, MemberCreated, RentCheckedOut, RentReturned, RentOverdue, RentLost, RentRenewed, HoldReady}
+
+// The rent_status of records.
+const (
+	StatusRented   = "rented"
+	StatusOverdue  = "overdue"
+	StatusReturned = "returned"
+	StatusLost     = "lost"
+)
+
+// StatusReadyForPickup is the status of a hold whose copy is set aside.
+const StatusReadyForPickup = "ready_for_pickup"
+
+// StatusAvailable is the status of a copy on the shelf, one of the stock of
+// its book.
+const StatusAvailable = "available"
+
+const outbox = "outbox"
+
+// Event is an entry of the outbox: what happened to the row RowId of Table,
+// Data being the row afterwards and Previous the changed columns before.
+type Event struct {
+	Id        int            `json:"id"`
+	Type      string         `json:"type"`
+	Table     string         `json:"table"`
+	RowId     int            `json:"row_id"`
+	Data      map[string]any `json:"data"`
+	Previous  map[string]any `json:"previous,omitempty"`
+	CreatedAt string         `json:"created_at"`
+}
+
+// Record is the handler.ChangeHook writing the domain events raised by a
+// change to the outbox, in the transaction of the change: an event is
+// published if and only if its change is committed.
+func Record(ctx context.Context, change handler.Change) error {
+	eventType, previous := classify(change)
+	if eventType == "" {
+		return nil
+	}
+	return Emit(ctx, eventType, change.Table, change.RowId, change.After, previous)
+}
+
+// classify names the event raised by change, if any, and the columns of the
+// row it reports the previous values of.
+func classify(change handler.Change) (string, map[string]any) {
+	if change.After == nil || handler.IsDeleted(change.After) {
+		return "", nil
+	}
+	inserted := change.Action == handler.ActionInsert
+	updated := change.Action == handler.ActionUpdate
+	switch change.Table {
+	case "books":
+		if inserted {
+			return BookCreated, nil
+		}
+	case "copies":
+		status := fmt.Sprint(change.After["status"])
+		if inserted && status == StatusAvailable {
+			return BookStockChanged, nil
+		}
+		if updated && changed(change, "status") && (status == StatusAvailable || fmt.Sprint(change.Before["status"]) == StatusAvailable) {
+			return BookStockChanged, map[string]any{"status": change.Before["status"]}
+		}
+	case "members":
+		if inserted {
+			return MemberCreated, nil
+		}
+	case "holds":
+		if updated && changed(change, "status") && fmt.Sprint(change.After["status"]) == StatusReadyForPickup {
+			return HoldReady, map[string]any{"status": change.Before["status"]}
+		}
+	case "records":
+		status := fmt.Sprint(change.After["rent_status"])
+		if inserted && status == StatusRented {
+			return RentCheckedOut, nil
+		}
+		if updated && changed(change, "renewals") {
+			return RentRenewed, map[string]any{"due_date": change.Before["due_date"], "renewals": change.Before["renewals"]}
+		}
+		if !updated || !changed(change, "rent_status") {
+			return "", nil
+		}
+		previous := map[string]any{"rent_status": change.Before["rent_status"]}
+		switch status {
+		case StatusReturned:
+			return RentReturned, previous
+		case StatusOverdue:
+			return RentOverdue, previous
+		case StatusLost:
+			return RentLost, previous
+		}
+	}
+	return "", nil
+}
+
+func changed(change handler.Change, column string) bool {
+	return fmt.Sprint(change.Before[column]) != fmt.Sprint(change.After[column])
+}
+
+// Emit writes an event to the outbox, for the dispatcher to deliver.
+func Emit(ctx context.Context, eventType string, table string, rowId int, data map[string]any, previous map[string]any) error {
+	dataJSON, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	row := map[string]any{
+		"event_type": eventType,
+		"table_name": table,
+		"row_id":     rowId,
+		"data":       string(dataJSON),
+		"created_at": time.Now().UTC().Format(time.RFC3339),
+	}
+	if previous != nil {
+		previousJSON, err := json.Marshal(previous)
+		if err != nil {
+			return err
+		}
+		row["previous"] = string(previousJSON)
+	}
+	_, err = handler.InsertDataContext(ctx, outbox, row)
+	return err
+}
+
+// List returns at most limit events of the outbox following the event after,
+// oldest first.
+func List(ctx context.Context, after int, limit int) ([]Event, error) {
+	rows, err := handler.GetRowsContext(ctx, outbox, handler.ListQuery{
+		Filter: handler.FilterQuery{And: map[string][]handler.FieldFilter{
+			"id": {{Operator: "gt", Value: strconv.Itoa(after), ValueType: "int"}},
+		}},
+		Sort:  []handler.SortField{{Column: "id"}},
+		Limit: limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+	events := make([]Event, 0, len(rows))
+	for _, row := range rows {
+		event, err := eventFromRow(row)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// Get returns the event id of the outbox; ok is false if there is none.
+func Get(ctx context.Context, id int) (event Event, ok bool, err error) {
+	row, err := handler.GetRowByIdContext(ctx, outbox, id)
+	if err != nil || row["id"] == nil {
+		return Event{}, false, err
+	}
+	event, err = eventFromRow(row)
+	return event, err == nil, err
+}
+
+func eventFromRow(row map[string]any) (Event, error) {
+	event := Event{}
+	event.Id, _ = row["id"].(int)
+	event.Type, _ = row["event_type"].(string)
+	event.Table, _ = row["table_name"].(string)
+	event.RowId, _ = row["row_id"].(int)
+	event.CreatedAt, _ = row["created_at"].(string)
+	if text, _ := row["data"].(string); text != "" {
+		if err := json.Unmarshal([]byte(text), &event.Data); err != nil {
+			return Event{}, err
+		}
+	}
+	if text, _ := row["previous"].(string); text != "" {
+		if err := json.Unmarshal([]byte(text), &event.Previous); err != nil {
+			return Event{}, err
+		}
+	}
+	return event, nil
+}
@@ -0,0 +1,248 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/riszkymf/golang-rest-boilerplate/internal/handler"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/testdb"
+)
+
+// receiver is a webhook endpoint answering status, which keeps the events
+// whose signature checks out.
+type receiver struct {
+	mu     sync.Mutex
+	secret string
+	status int
+	events []Event
+	forged int
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, request *http.Request) {
+	body, _ := io.ReadAll(request.Body)
+	timestamp, _ := strconv.ParseInt(request.Header.Get(TimestampHeader), 10, 64)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if request.Header.Get(SignatureHeader) != Sign(r.secret, timestamp, body) {
+		r.forged++
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	var event Event
+	json.Unmarshal(body, &event)
+	if request.Header.Get(EventHeader) != event.Type {
+		r.forged++
+	}
+	r.events = append(r.events, event)
+	w.WriteHeader(r.status)
+}
+
+func (r *receiver) types() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	types := []string{}
+	for _, event := range r.events {
+		types = append(types, event.Type)
+	}
+	return types
+}
+
+func TestDispatch(t *testing.T) {
+	handler.Connection = testdb.Open(t)
+	handler.OnChange(Record)
+	ctx := context.Background()
+	dispatcher := NewDispatcher(Options{Timeout: time.Second, MaxAttempts: 2, Backoff: time.Nanosecond, MaxBackoff: time.Nanosecond, BatchSize: 10})
+
+	ok := &receiver{secret: "s3cret", status: http.StatusNoContent}
+	okServer := httptest.NewServer(ok)
+	defer okServer.Close()
+	failing := &receiver{secret: "other", status: http.StatusInternalServerError}
+	failingServer := httptest.NewServer(failing)
+	defer failingServer.Close()
+
+	books, err := CreateWebhook(ctx, Webhook{Url: okServer.URL, Secret: ok.secret, Events: []string{BookCreated, BookStockChanged}, Active: true})
+	if err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	all, err := CreateWebhook(ctx, Webhook{Url: failingServer.URL, Secret: failing.secret, Events: []string{AllEvents}, Active: true})
+	if err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	if _, err := CreateWebhook(ctx, Webhook{Url: "ftp://example.com", Events: []string{AllEvents}}); err == nil {
+		t.Errorf("non-http URL should be refused")
+	}
+	if _, err := CreateWebhook(ctx, Webhook{Url: okServer.URL, Events: []string{"book.burnt"}}); err == nil {
+		t.Errorf("unknown event should be refused")
+	}
+
+	author, _ := handler.InsertData("author", map[string]any{"name": "Herman Melville"})
+	book, _ := handler.InsertData("books", map[string]any{"title": "Moby Dick", "author_id": author})
+	member, _ := handler.InsertData("members", map[string]any{"firstname": "Ishmael", "lastname": "Sailor"})
+	if err := handler.UpdateData("books", map[string]any{"title": "Moby-Dick"}, book); err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	copy, _ := handler.InsertData("copies", map[string]any{"barcode": "MD-1", "book_id": book, "status": "damaged", "created_at": "2022-09-05"})
+	if err := handler.UpdateData("copies", map[string]any{"status": StatusAvailable}, copy); err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	record, _ := handler.InsertData("records", map[string]any{"book_id": book, "member_id": member, "rent_date": "2022-09-05", "due_date": "2022-09-19", "rent_status": StatusRented})
+	if err := handler.UpdateData("records", map[string]any{"rent_status": StatusReturned}, record); err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	// A change rolled back raises no event.
+	handler.WithTransaction(ctx, func(ctx context.Context) error {
+		handler.InsertDataContext(ctx, "members", map[string]any{"firstname": "Ahab", "lastname": "Captain"})
+		return errors.New("rollback")
+	})
+
+	outboxed, err := List(ctx, 0, 100)
+	if err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	want := []string{BookCreated, MemberCreated, BookStockChanged, RentCheckedOut, RentReturned}
+	if len(outboxed) != len(want) {
+		t.Fatalf("outbox should hold %v, got %v", want, outboxed)
+	}
+	for i, event := range outboxed {
+		if event.Type != want[i] {
+			t.Errorf("event %v should be %v, got %v", i, want[i], event.Type)
+		}
+	}
+	if status := outboxed[2].Previous["status"]; status != "damaged" {
+		t.Errorf("stock change should report the previous status of the copy, got %v", outboxed[2].Previous)
+	}
+
+	delivered, failed, err := dispatcher.Dispatch(ctx)
+	if err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	if delivered != 2 || failed != 5 {
+		t.Errorf("should deliver the 2 book events and fail the 5 others, got %v delivered %v failed", delivered, failed)
+	}
+	if got := ok.types(); len(got) != 2 || got[0] != BookCreated || got[1] != BookStockChanged {
+		t.Errorf("book webhook should receive its events in order, got %v", got)
+	}
+	if ok.forged > 0 || failing.forged > 0 {
+		t.Errorf("every delivery should be signed with the secret of its webhook")
+	}
+
+	// The failures are retried, then dead.
+	if _, failed, _ := dispatcher.Dispatch(ctx); failed != 5 {
+		t.Errorf("failed deliveries should be retried, got %v failures", failed)
+	}
+	if delivered, failed, _ := dispatcher.Dispatch(ctx); delivered+failed != 0 {
+		t.Errorf("dead deliveries should not be retried, got %v %v", delivered, failed)
+	}
+	dead, err := ListDeliveries(ctx, all.Id, DeliveryDead, 0)
+	if err != nil || len(dead) != 5 {
+		t.Fatalf("failing webhook should have 5 dead letters, got %v %v", dead, err)
+	}
+	if dead[0].Attempts != 2 || dead[0].LastStatus != http.StatusInternalServerError || dead[0].LastError == "" {
+		t.Errorf("dead letter should keep its attempts and last failure, got %+v", dead[0])
+	}
+	if pending, _ := ListDeliveries(ctx, books.Id, DeliveryPending, 0); len(pending) != 0 {
+		t.Errorf("book webhook should have nothing pending, got %v", pending)
+	}
+
+	failing.mu.Lock()
+	failing.status = http.StatusOK
+	failing.mu.Unlock()
+	if err := Redeliver(ctx, all.Id, dead[0].Id); err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	if err := Redeliver(ctx, books.Id, dead[0].Id); !errors.Is(err, handler.ErrNotFound) {
+		t.Errorf("delivery of another webhook should not be found, got %v", err)
+	}
+	if delivered, _, _ := dispatcher.Dispatch(ctx); delivered != 1 {
+		t.Errorf("redelivered dead letter should be delivered, got %v", delivered)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	options := Options{Backoff: time.Second, MaxBackoff: 10 * time.Second}
+	for attempts, want := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 4: 8 * time.Second, 5: 10 * time.Second, 30: 10 * time.Second} {
+		if got := options.backoff(attempts); got != want {
+			t.Errorf("backoff after %v attempts should be %v, got %v", attempts, want, got)
+		}
+	}
+}
+
+func TestBroker(t *testing.T) {
+	handler.Connection = testdb.Open(t)
+	ctx := context.Background()
+	emit := func(eventType string) {
+		if err := Emit(ctx, eventType, "books", 1, map[string]any{"id": 1}, nil); err != nil {
+			t.Fatalf(`Error: %v`, err)
+		}
+	}
+	emit(BookCreated)
+	broker := NewBroker(2)
+
+	live, replay, complete, err := broker.Subscribe(ctx, -1)
+	if err != nil || len(replay) != 0 || !complete || live.Start != 1 {
+		t.Fatalf("new subscriber should get no replay and start after event 1, got %v %v %v %v", live, replay, complete, err)
+	}
+	emit(MemberCreated)
+	emit(RentCheckedOut)
+	if err := broker.Poll(ctx); err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	for _, want := range []int{2, 3} {
+		if event := <-live.Events; event.Id != want {
+			t.Errorf("subscriber should receive event %v, got %v", want, event)
+		}
+	}
+
+	resumed, replay, complete, _ := broker.Subscribe(ctx, 1)
+	if !complete || len(replay) != 2 || replay[0].Id != 2 || replay[1].Id != 3 {
+		t.Errorf("resuming after event 1 should replay events 2 and 3, got %v %v", replay, complete)
+	}
+	resumed.Close()
+	fresh, replay, complete, _ := broker.Subscribe(ctx, -1)
+	fresh.Close()
+	if !complete || replay != nil {
+		t.Errorf("subscriber without last id should not replay, got %v", replay)
+	}
+	emit(RentReturned)
+	broker.Poll(ctx)
+	if _, replay, complete, _ := broker.Subscribe(ctx, 1); complete || replay != nil {
+		t.Errorf("resuming after more than the replay limit should be incomplete, got %v %v", replay, complete)
+	}
+
+	broker.close()
+	if _, open := <-live.Events; !open {
+		t.Fatalf("subscriber should still hold event 4")
+	}
+	if _, open := <-live.Events; open {
+		t.Errorf("stopping the broker should close the subscriptions")
+	}
+	if _, _, _, err := broker.Subscribe(ctx, -1); !errors.Is(err, ErrBrokerClosed) {
+		t.Errorf("stopped broker should refuse subscribers, got %v", err)
+	}
+}
+
+func TestTopics(t *testing.T) {
+	topics, err := ParseTopics("rent", "book.created, member.created")
+	if err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	for eventType, want := range map[string]bool{RentReturned: true, RentOverdue: true, BookCreated: true, BookStockChanged: false, MemberCreated: true} {
+		if topics.Match(eventType) != want {
+			t.Errorf("topics %v should match %v: %v", topics, eventType, want)
+		}
+	}
+	if _, err := ParseTopics("ren"); err == nil {
+		t.Errorf("partial topic should be refused")
+	}
+	if !(Topics{}).Match(BookCreated) {
+		t.Errorf("no topic should match every event")
+	}
+}
@@ -0,0 +1,263 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/riszkymf/golang-rest-boilerplate/internal/handler"
+	utils "github.com/riszkymf/golang-rest-boilerplate/internal/src"
+)
+
+// subscriptionBuffer is how many events a subscriber may lag behind before
+// it is dropped, to resume from the outbox.
+const subscriptionBuffer = 64
+
+// pollPage is how many events a poll reads from the outbox at a time.
+const pollPage = 500
+
+// ErrBrokerClosed is returned by Subscribe once the broker stopped.
+var ErrBrokerClosed = errors.New("event broker stopped")
+
+// Broker follows the outbox and hands its new events to the subscribers, the
+// open event streams. A subscriber resuming after an event replays the ones
+// it missed from the outbox, up to the replay limit.
+type Broker struct {
+	pollMu  sync.Mutex
+	started bool
+
+	mu          sync.Mutex
+	last        int
+	closed      bool
+	replayLimit int
+	subscribers map[*Subscription]struct{}
+}
+
+func NewBroker(replayLimit int) *Broker {
+	return &Broker{replayLimit: replayLimit, subscribers: map[*Subscription]struct{}{}}
+}
+
+var DefaultBroker = NewBroker(1000)
+
+type brokerKey struct{}
+
+// WithBroker returns a context whose event streams follow broker, e.g. that
+// of the outbox of a tenant.
+func WithBroker(ctx context.Context, broker *Broker) context.Context {
+	return context.WithValue(ctx, brokerKey{}, broker)
+}
+
+// BrokerFromContext returns the broker given to WithBroker, or
+// DefaultBroker.
+func BrokerFromContext(ctx context.Context) *Broker {
+	if broker, ok := ctx.Value(brokerKey{}).(*Broker); ok {
+		return broker
+	}
+	return DefaultBroker
+}
+
+func (b *Broker) SetReplayLimit(limit int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.replayLimit = limit
+}
+
+// Subscription receives the events following Start, the last event raised
+// before it was made, until Events is closed: when the broker stops, or when
+// the subscriber fell too far behind.
+type Subscription struct {
+	Events chan Event
+	Start  int
+	broker *Broker
+}
+
+// Close unsubscribes s.
+func (s *Subscription) Close() {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	if _, ok := s.broker.subscribers[s]; ok {
+		delete(s.broker.subscribers, s)
+		close(s.Events)
+	}
+}
+
+// Subscribe returns a subscription to the new events and, unless after is
+// negative, the events following after that were already raised, oldest
+// first. complete is false when more than the replay limit were missed:
+// replay then is empty and the subscriber should reload its state, as of
+// sub.Start.
+func (b *Broker) Subscribe(ctx context.Context, after int) (sub *Subscription, replay []Event, complete bool, err error) {
+	if err := b.start(ctx); err != nil {
+		return nil, nil, false, err
+	}
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil, nil, false, ErrBrokerClosed
+	}
+	sub = &Subscription{Events: make(chan Event, subscriptionBuffer), Start: b.last, broker: b}
+	b.subscribers[sub] = struct{}{}
+	boundary, limit := b.last, b.replayLimit
+	b.mu.Unlock()
+
+	if after < 0 || after >= boundary {
+		return sub, nil, true, nil
+	}
+	// The events up to boundary are read from the outbox, those following it
+	// reach the subscription.
+	missed, err := List(ctx, after, limit+1)
+	if err != nil {
+		sub.Close()
+		return nil, nil, false, err
+	}
+	for i, event := range missed {
+		if event.Id > boundary {
+			missed = missed[:i]
+			break
+		}
+	}
+	if len(missed) > limit {
+		return sub, nil, false, nil
+	}
+	return sub, missed, true, nil
+}
+
+// start reads where the outbox is at, the first time.
+func (b *Broker) start(ctx context.Context) error {
+	b.pollMu.Lock()
+	defer b.pollMu.Unlock()
+	if b.started {
+		return nil
+	}
+	last, err := lastEventId(ctx)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	b.last = last
+	b.mu.Unlock()
+	b.started = true
+	return nil
+}
+
+func lastEventId(ctx context.Context) (int, error) {
+	rows, err := handler.GetRowsContext(ctx, outbox, handler.ListQuery{
+		Sort:  []handler.SortField{{Column: "id", Desc: true}},
+		Limit: 1,
+	})
+	if err != nil || len(rows) == 0 {
+		return 0, err
+	}
+	last, _ := rows[0]["id"].(int)
+	return last, nil
+}
+
+// Poll hands the events written to the outbox since the last poll to the
+// subscribers. Those too far behind to take them are dropped.
+func (b *Broker) Poll(ctx context.Context) error {
+	if err := b.start(ctx); err != nil {
+		return err
+	}
+	b.pollMu.Lock()
+	defer b.pollMu.Unlock()
+	for {
+		b.mu.Lock()
+		after := b.last
+		b.mu.Unlock()
+		page, err := List(ctx, after, pollPage)
+		if err != nil {
+			return err
+		}
+		b.mu.Lock()
+		for _, event := range page {
+			for sub := range b.subscribers {
+				select {
+				case sub.Events <- event:
+				default:
+					delete(b.subscribers, sub)
+					close(sub.Events)
+				}
+			}
+			b.last = event.Id
+		}
+		b.mu.Unlock()
+		if len(page) < pollPage {
+			return nil
+		}
+	}
+}
+
+// Run polls every interval until ctx is done, then closes the
+// subscriptions, which ends the event streams.
+func (b *Broker) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			b.close()
+			return
+		case <-ticker.C:
+			if err := b.Poll(ctx); err != nil && ctx.Err() == nil {
+				utils.CheckErrorContext(ctx, err, "[events]", "poll outbox")
+			}
+		}
+	}
+}
+
+func (b *Broker) close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for sub := range b.subscribers {
+		delete(b.subscribers, sub)
+		close(sub.Events)
+	}
+}
+
+// Topics selects events by type, or by the part of the type before the dot:
+// "rent" selects rent.checked_out, rent.returned and the other rent events.
+// No topic selects every event.
+type Topics []string
+
+// ParseTopics reads a comma separated list of topics.
+func ParseTopics(values ...string) (Topics, error) {
+	topics := Topics{}
+	for _, value := range values {
+		for _, topic := range strings.Split(value, ",") {
+			topic = strings.TrimSpace(topic)
+			if topic == "" {
+				continue
+			}
+			if !knownTopic(topic) {
+				return nil, errors.New("unknown topic " + topic + ", expected book, member, rent, hold or an event type")
+			}
+			topics = append(topics, topic)
+		}
+	}
+	return topics, nil
+}
+
+func knownTopic(topic string) bool {
+	for _, eventType := range Types {
+		if eventType == topic || strings.HasPrefix(eventType, topic+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// Match reports whether the events of eventType are selected.
+func (t Topics) Match(eventType string) bool {
+	if len(t) == 0 {
+		return true
+	}
+	for _, topic := range t {
+		if eventType == topic || strings.HasPrefix(eventType, topic+".") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,255 @@
+package events
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/riszkymf/golang-rest-boilerplate/internal/handler"
+)
+
+const (
+	webhooks   = "webhooks"
+	deliveries = "webhook_deliveries"
+)
+
+// AllEvents subscribes a webhook to every event, including those added later.
+const AllEvents = "*"
+
+// The status of a delivery: pending until the receiver acknowledges it with a
+// 2xx, dead once it failed MaxAttempts times.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead"
+)
+
+// ErrInvalidWebhook wraps the reasons a webhook is refused.
+var ErrInvalidWebhook = errors.New("invalid webhook")
+
+// Webhook is a subscription of a URL to some events. Its secret signs the
+// deliveries and is only answered when the webhook is created.
+type Webhook struct {
+	Id        int      `json:"id"`
+	Url       string   `json:"url"`
+	Secret    string   `json:"secret,omitempty"`
+	Events    []string `json:"events"`
+	Active    bool     `json:"active"`
+	CreatedAt string   `json:"created_at"`
+}
+
+// Subscribes reports whether the webhook receives events of eventType.
+func (w Webhook) Subscribes(eventType string) bool {
+	for _, subscribed := range w.Events {
+		if subscribed == AllEvents || subscribed == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks the URL and events of the webhook.
+func (w Webhook) Validate() error {
+	parsed, err := url.Parse(w.Url)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("%w: url must be an absolute http or https URL, got %q", ErrInvalidWebhook, w.Url)
+	}
+	if len(w.Events) == 0 {
+		return fmt.Errorf("%w: events must list at least one event, or *", ErrInvalidWebhook)
+	}
+	for _, eventType := range w.Events {
+		if eventType != AllEvents && !known(eventType) {
+			return fmt.Errorf("%w: unknown event %q, expected one of %v or *", ErrInvalidWebhook, eventType, strings.Join(Types, ", "))
+		}
+	}
+	return nil
+}
+
+func known(eventType string) bool {
+	for _, known := range Types {
+		if known == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Delivery is the delivery of an event to a webhook.
+type Delivery struct {
+	Id            int    `json:"id"`
+	WebhookId     int    `json:"webhook_id"`
+	EventId       int    `json:"event_id"`
+	Status        string `json:"status"`
+	Attempts      int    `json:"attempts"`
+	NextAttemptAt string `json:"next_attempt_at,omitempty"`
+	LastStatus    int    `json:"last_status,omitempty"`
+	LastError     string `json:"last_error,omitempty"`
+	DeliveredAt   string `json:"delivered_at,omitempty"`
+}
+
+// CreateWebhook stores webhook, generating its secret unless it has one, and
+// returns it with its id.
+func CreateWebhook(ctx context.Context, webhook Webhook) (Webhook, error) {
+	if err := webhook.Validate(); err != nil {
+		return Webhook{}, err
+	}
+	if webhook.Secret == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return Webhook{}, err
+		}
+		webhook.Secret = hex.EncodeToString(secret)
+	}
+	webhook.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+	id, err := handler.InsertDataContext(ctx, webhooks, map[string]any{
+		"url":         webhook.Url,
+		"secret":      webhook.Secret,
+		"event_types": strings.Join(webhook.Events, ","),
+		"active":      webhook.Active,
+		"created_at":  webhook.CreatedAt,
+	})
+	if err != nil {
+		return Webhook{}, err
+	}
+	webhook.Id = id
+	return webhook, nil
+}
+
+// UpdateWebhook replaces the URL, events, activity and, if given, the secret
+// of the webhook id.
+func UpdateWebhook(ctx context.Context, webhook Webhook) error {
+	if err := webhook.Validate(); err != nil {
+		return err
+	}
+	data := map[string]any{
+		"url":         webhook.Url,
+		"event_types": strings.Join(webhook.Events, ","),
+		"active":      webhook.Active,
+	}
+	if webhook.Secret != "" {
+		data["secret"] = webhook.Secret
+	}
+	return handler.UpdateDataContext(ctx, webhooks, data, webhook.Id)
+}
+
+// DeleteWebhook deletes the webhook id and its deliveries.
+func DeleteWebhook(ctx context.Context, id int) error {
+	return handler.WithTransaction(ctx, func(ctx context.Context) error {
+		_, err := handler.DeleteByFilterContext(ctx, deliveries, handler.FilterQuery{And: map[string][]handler.FieldFilter{
+			"webhook_id": {{Operator: "eq", Value: strconv.Itoa(id), ValueType: "int"}},
+		}})
+		if err != nil {
+			return err
+		}
+		return handler.DeleteDataContext(ctx, webhooks, id)
+	})
+}
+
+// GetWebhook returns the webhook id, secret included; ok is false if there is
+// none.
+func GetWebhook(ctx context.Context, id int) (webhook Webhook, ok bool, err error) {
+	row, err := handler.GetRowByIdContext(ctx, webhooks, id)
+	if err != nil || row["id"] == nil {
+		return Webhook{}, false, err
+	}
+	return webhookFromRow(row), true, nil
+}
+
+// ListWebhooks returns the webhooks, secrets included, oldest first. Only the
+// active ones unless all is set.
+func ListWebhooks(ctx context.Context, all bool) ([]Webhook, error) {
+	query := handler.ListQuery{Sort: []handler.SortField{{Column: "id"}}}
+	if !all {
+		query.Filter = handler.FilterQuery{And: map[string][]handler.FieldFilter{
+			"active": {{Operator: "eq", Value: "1", ValueType: "int"}},
+		}}
+	}
+	rows, err := handler.GetRowsContext(ctx, webhooks, query)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]Webhook, 0, len(rows))
+	for _, row := range rows {
+		result = append(result, webhookFromRow(row))
+	}
+	return result, nil
+}
+
+func webhookFromRow(row map[string]any) Webhook {
+	webhook := Webhook{}
+	webhook.Id, _ = row["id"].(int)
+	webhook.Url, _ = row["url"].(string)
+	webhook.Secret, _ = row["secret"].(string)
+	if types, _ := row["event_types"].(string); types != "" {
+		webhook.Events = strings.Split(types, ",")
+	}
+	active, _ := row["active"].(int)
+	webhook.Active = active != 0
+	webhook.CreatedAt, _ = row["created_at"].(string)
+	return webhook
+}
+
+// ListDeliveries returns the deliveries to the webhook id, newest first, only
+// those in status unless it is empty. Those that are dead are the dead
+// letters of the webhook.
+func ListDeliveries(ctx context.Context, webhookId int, status string, limit int) ([]Delivery, error) {
+	filter := map[string][]handler.FieldFilter{
+		"webhook_id": {{Operator: "eq", Value: strconv.Itoa(webhookId), ValueType: "int"}},
+	}
+	if status != "" {
+		filter["status"] = []handler.FieldFilter{{Operator: "eq", Value: status, ValueType: "string"}}
+	}
+	rows, err := handler.GetRowsContext(ctx, deliveries, handler.ListQuery{
+		Filter: handler.FilterQuery{And: filter},
+		Sort:   []handler.SortField{{Column: "id", Desc: true}},
+		Limit:  limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := make([]Delivery, 0, len(rows))
+	for _, row := range rows {
+		result = append(result, deliveryFromRow(row))
+	}
+	return result, nil
+}
+
+// Redeliver queues the delivery id of the webhook webhookId again, with its
+// attempts reset. Delivered deliveries are sent again as well.
+func Redeliver(ctx context.Context, webhookId int, id int) error {
+	row, err := handler.GetRowByIdContext(ctx, deliveries, id)
+	if err != nil {
+		return err
+	}
+	if owner, _ := row["webhook_id"].(int); row["id"] == nil || owner != webhookId {
+		return handler.ErrNotFound
+	}
+	return handler.UpdateDataContext(ctx, deliveries, map[string]any{
+		"status":          DeliveryPending,
+		"attempts":        0,
+		"next_attempt_at": time.Now().Unix(),
+	}, id)
+}
+
+func deliveryFromRow(row map[string]any) Delivery {
+	delivery := Delivery{}
+	delivery.Id, _ = row["id"].(int)
+	delivery.WebhookId, _ = row["webhook_id"].(int)
+	delivery.EventId, _ = row["event_id"].(int)
+	delivery.Status, _ = row["status"].(string)
+	delivery.Attempts, _ = row["attempts"].(int)
+	if delivery.Status == DeliveryPending {
+		next, _ := row["next_attempt_at"].(int)
+		delivery.NextAttemptAt = time.Unix(int64(next), 0).UTC().Format(time.RFC3339)
+	}
+	delivery.LastStatus, _ = row["last_status"].(int)
+	delivery.LastError, _ = row["last_error"].(string)
+	delivery.DeliveredAt, _ = row["delivered_at"].(string)
+	return delivery
+}
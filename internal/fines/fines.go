@@ -0,0 +1,316 @@
+package fines
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/riszkymf/golang-rest-boilerplate/internal/events"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/handler"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/overdue"
+)
+
+const (
+	fines    = "fines"
+	payments = "payments"
+)
+
+// The kinds of fines: a loan is fined at most once of each.
+const (
+	KindLate = "late"
+	KindLost = "lost"
+)
+
+// The kinds of payments: a payment is money received, a waiver a debt the
+// library forgives.
+const (
+	KindPayment = "payment"
+	KindWaiver  = "waiver"
+)
+
+// ErrInvalidPayment wraps the reasons a payment is refused.
+var ErrInvalidPayment = errors.New("invalid payment")
+
+// Policy sets what loans returned late or lost cost. Amounts are in the minor
+// unit of Currency, e.g. cents.
+type Policy struct {
+	// DailyRate is charged for every day a loan is late, once it is later
+	// than GraceDays, up to Cap unless it is zero.
+	DailyRate int `json:"daily_rate"`
+	GraceDays int `json:"grace_days"`
+	Cap       int `json:"cap"`
+	// LostCost is the replacement cost of a lost book.
+	LostCost int `json:"lost_cost"`
+	// MaxBalance is the most a member may owe and still check out books.
+	MaxBalance int    `json:"max_balance"`
+	Currency   string `json:"currency"`
+}
+
+// LateFee is the fine for a loan returned daysLate days after its due date.
+func (p Policy) LateFee(daysLate int) int {
+	if daysLate <= p.GraceDays {
+		return 0
+	}
+	fee := daysLate * p.DailyRate
+	if p.Cap > 0 && fee > p.Cap {
+		fee = p.Cap
+	}
+	return fee
+}
+
+// Blocks reports whether a member owing balance may not check out books.
+func (p Policy) Blocks(balance int) bool {
+	return balance > p.MaxBalance
+}
+
+// Fine is what a member was charged for a loan.
+type Fine struct {
+	Id        int    `json:"id"`
+	MemberId  int    `json:"member_id"`
+	RecordId  int    `json:"record_id"`
+	Kind      string `json:"kind"`
+	DaysLate  int    `json:"days_late"`
+	Amount    int    `json:"amount"`
+	CreatedAt string `json:"created_at"`
+}
+
+// Payment settles part or all of the balance of a member, against one of
+// their fines if FineId is set.
+type Payment struct {
+	Id        int    `json:"id"`
+	MemberId  int    `json:"member_id"`
+	FineId    int    `json:"fine_id,omitempty"`
+	Kind      string `json:"kind"`
+	Amount    int    `json:"amount"`
+	Note      string `json:"note,omitempty"`
+	CreatedAt string `json:"created_at"`
+}
+
+// Balance is the account of a member: what they were fined, paid and were
+// waived, oldest first, and what they owe.
+type Balance struct {
+	MemberId   int       `json:"member_id"`
+	Currency   string    `json:"currency"`
+	Fined      int       `json:"fined"`
+	Paid       int       `json:"paid"`
+	Waived     int       `json:"waived"`
+	Balance    int       `json:"balance"`
+	MaxBalance int       `json:"max_balance"`
+	Blocked    bool      `json:"blocked"`
+	Fines      []Fine    `json:"fines"`
+	Payments   []Payment `json:"payments"`
+}
+
+// Ledger fines the members for their late and lost loans, under its policy,
+// and records what they pay.
+type Ledger struct {
+	mu     sync.Mutex
+	policy Policy
+	today  func() time.Time
+}
+
+func NewLedger(policy Policy) *Ledger {
+	return &Ledger{policy: policy, today: overdue.Default.Today}
+}
+
+var Default = NewLedger(Policy{DailyRate: 25, Cap: 1000, LostCost: 2500, MaxBalance: 1000, Currency: "USD"})
+
+func (l *Ledger) SetPolicy(policy Policy) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.policy = policy
+}
+
+func (l *Ledger) Policy() Policy {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.policy
+}
+
+// Assess is the handler.ChangeHook fining the member of a loan, in the
+// transaction of the change, when the loan is returned past its due date or
+// marked lost. A lost book costs its replacement on top of the late fee so
+// far; finding it later refunds nothing, waivers do.
+func (l *Ledger) Assess(ctx context.Context, change handler.Change) error {
+	if change.Table != "records" || change.Action != handler.ActionUpdate || change.After == nil {
+		return nil
+	}
+	switch fmt.Sprint(change.Before["rent_status"]) {
+	case events.StatusRented, events.StatusOverdue:
+	default:
+		return nil
+	}
+	status := fmt.Sprint(change.After["rent_status"])
+	if status != events.StatusReturned && status != events.StatusLost {
+		return nil
+	}
+	policy := l.Policy()
+	dueDate, _ := change.After["due_date"].(string)
+	daysLate := overdue.DaysOverdue(dueDate, l.today())
+	fine := Fine{RecordId: change.RowId, Kind: KindLate, DaysLate: daysLate, Amount: policy.LateFee(daysLate)}
+	fine.MemberId, _ = change.After["member_id"].(int)
+	if err := charge(ctx, fine); err != nil {
+		return err
+	}
+	if status == events.StatusLost {
+		fine.Kind, fine.DaysLate, fine.Amount = KindLost, 0, policy.LostCost
+		return charge(ctx, fine)
+	}
+	return nil
+}
+
+// charge stores fine unless it is nothing or the loan was already fined the
+// same.
+func charge(ctx context.Context, fine Fine) error {
+	if fine.Amount <= 0 {
+		return nil
+	}
+	count, err := handler.CountRowsContext(ctx, fines, handler.FilterQuery{And: map[string][]handler.FieldFilter{
+		"record_id": {{Operator: "eq", Value: strconv.Itoa(fine.RecordId), ValueType: "int"}},
+		"kind":      {{Operator: "eq", Value: fine.Kind, ValueType: "string"}},
+	}})
+	if err != nil || count > 0 {
+		return err
+	}
+	_, err = handler.InsertDataContext(ctx, fines, map[string]any{
+		"member_id":  fine.MemberId,
+		"record_id":  fine.RecordId,
+		"kind":       fine.Kind,
+		"days_late":  fine.DaysLate,
+		"amount":     fine.Amount,
+		"created_at": time.Now().UTC().Format(time.RFC3339),
+	})
+	return err
+}
+
+// Balance returns the account of the member memberId.
+func (l *Ledger) Balance(ctx context.Context, memberId int) (Balance, error) {
+	policy := l.Policy()
+	balance := Balance{MemberId: memberId, Currency: policy.Currency, MaxBalance: policy.MaxBalance, Fines: []Fine{}, Payments: []Payment{}}
+	query := handler.ListQuery{
+		Filter: handler.FilterQuery{And: map[string][]handler.FieldFilter{
+			"member_id": {{Operator: "eq", Value: strconv.Itoa(memberId), ValueType: "int"}},
+		}},
+		Sort: []handler.SortField{{Column: "id"}},
+	}
+	rows, err := handler.GetRowsContext(ctx, fines, query)
+	if err != nil {
+		return balance, err
+	}
+	for _, row := range rows {
+		fine := fineFromRow(row)
+		balance.Fined += fine.Amount
+		balance.Fines = append(balance.Fines, fine)
+	}
+	rows, err = handler.GetRowsContext(ctx, payments, query)
+	if err != nil {
+		return balance, err
+	}
+	for _, row := range rows {
+		payment := paymentFromRow(row)
+		if payment.Kind == KindWaiver {
+			balance.Waived += payment.Amount
+		} else {
+			balance.Paid += payment.Amount
+		}
+		balance.Payments = append(balance.Payments, payment)
+	}
+	balance.Balance = balance.Fined - balance.Paid - balance.Waived
+	balance.Blocked = policy.Blocks(balance.Balance)
+	return balance, nil
+}
+
+// Pay records payment, a payment or a waiver of part or all of the balance
+// of its member, and returns it with its id. It may not exceed the balance,
+// nor what is left of its fine if it names one.
+func (l *Ledger) Pay(ctx context.Context, payment Payment) (Payment, error) {
+	if payment.Kind == "" {
+		payment.Kind = KindPayment
+	}
+	if payment.Kind != KindPayment && payment.Kind != KindWaiver {
+		return Payment{}, fmt.Errorf("%w: kind must be %v or %v, got %q", ErrInvalidPayment, KindPayment, KindWaiver, payment.Kind)
+	}
+	if payment.Amount <= 0 {
+		return Payment{}, fmt.Errorf("%w: amount must be greater than zero", ErrInvalidPayment)
+	}
+	err := handler.WithTransaction(ctx, func(ctx context.Context) error {
+		balance, err := l.Balance(ctx, payment.MemberId)
+		if err != nil {
+			return err
+		}
+		owed := balance.Balance
+		if payment.FineId != 0 {
+			if owed, err = outstanding(balance, payment.FineId); err != nil {
+				return err
+			}
+		}
+		if payment.Amount > owed {
+			return fmt.Errorf("%w: amount %v exceeds the %v owed", ErrInvalidPayment, payment.Amount, owed)
+		}
+		payment.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+		row := map[string]any{
+			"member_id":  payment.MemberId,
+			"kind":       payment.Kind,
+			"amount":     payment.Amount,
+			"note":       payment.Note,
+			"created_at": payment.CreatedAt,
+		}
+		if payment.FineId != 0 {
+			row["fine_id"] = payment.FineId
+		}
+		payment.Id, err = handler.InsertDataContext(ctx, payments, row)
+		return err
+	})
+	if err != nil {
+		return Payment{}, err
+	}
+	return payment, nil
+}
+
+// outstanding is what is left to pay of the fine id of balance, never more
+// than the balance itself.
+func outstanding(balance Balance, id int) (int, error) {
+	for _, fine := range balance.Fines {
+		if fine.Id != id {
+			continue
+		}
+		owed := fine.Amount
+		for _, payment := range balance.Payments {
+			if payment.FineId == id {
+				owed -= payment.Amount
+			}
+		}
+		if owed > balance.Balance {
+			owed = balance.Balance
+		}
+		return owed, nil
+	}
+	return 0, fmt.Errorf("%w: fine %v is not a fine of member %v", ErrInvalidPayment, id, balance.MemberId)
+}
+
+func fineFromRow(row map[string]any) Fine {
+	fine := Fine{}
+	fine.Id, _ = row["id"].(int)
+	fine.MemberId, _ = row["member_id"].(int)
+	fine.RecordId, _ = row["record_id"].(int)
+	fine.Kind, _ = row["kind"].(string)
+	fine.DaysLate, _ = row["days_late"].(int)
+	fine.Amount, _ = row["amount"].(int)
+	fine.CreatedAt, _ = row["created_at"].(string)
+	return fine
+}
+
+func paymentFromRow(row map[string]any) Payment {
+	payment := Payment{}
+	payment.Id, _ = row["id"].(int)
+	payment.MemberId, _ = row["member_id"].(int)
+	payment.FineId, _ = row["fine_id"].(int)
+	payment.Kind, _ = row["kind"].(string)
+	payment.Amount, _ = row["amount"].(int)
+	payment.Note, _ = row["note"].(string)
+	payment.CreatedAt, _ = row["created_at"].(string)
+	return payment
+}
@@ -0,0 +1,105 @@
+package fines
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/riszkymf/golang-rest-boilerplate/internal/handler"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/testdb"
+)
+
+func TestLateFee(t *testing.T) {
+	policy := Policy{DailyRate: 25, GraceDays: 2, Cap: 500}
+	for days, want := range map[int]int{0: 0, 2: 0, 3: 75, 10: 250, 30: 500} {
+		if got := policy.LateFee(days); got != want {
+			t.Errorf("late fee after %v days should be %v, got %v", days, want, got)
+		}
+	}
+	if got := (Policy{DailyRate: 25}).LateFee(100); got != 2500 {
+		t.Errorf("late fee without cap should not be capped, got %v", got)
+	}
+}
+
+func TestLedger(t *testing.T) {
+	handler.Connection = testdb.Open(t)
+	ctx := context.Background()
+	ledger := NewLedger(Policy{DailyRate: 25, GraceDays: 1, Cap: 1000, LostCost: 2500, MaxBalance: 500, Currency: "EUR"})
+	ledger.today = func() time.Time { return time.Date(2024, 3, 11, 0, 0, 0, 0, time.UTC) }
+	handler.OnChange(ledger.Assess)
+
+	author, _ := handler.InsertData("author", map[string]any{"name": "Herman Melville"})
+	book, _ := handler.InsertData("books", map[string]any{"title": "Moby Dick", "author_id": author})
+	member, _ := handler.InsertData("members", map[string]any{"firstname": "Ishmael", "lastname": "Sailor"})
+	loan := func(due string) int {
+		id, err := handler.InsertData("records", map[string]any{"book_id": book, "member_id": member, "rent_date": "2024-02-01 00:00:00", "due_date": due, "rent_status": "rented"})
+		if err != nil {
+			t.Fatalf(`Error: %v`, err)
+		}
+		return id
+	}
+	setStatus := func(id int, status string) {
+		if err := handler.UpdateData("records", map[string]any{"rent_status": status}, id); err != nil {
+			t.Fatalf(`Error: %v`, err)
+		}
+	}
+	late := loan("2024-03-01 00:00:00")
+	inGrace := loan("2024-03-10 00:00:00")
+	lost := loan("2024-03-07 00:00:00")
+	setStatus(late, "returned")
+	setStatus(inGrace, "returned")
+	setStatus(lost, "lost")
+	// Finding the lost book does not fine it late again.
+	setStatus(lost, "returned")
+
+	balance, err := ledger.Balance(ctx, member)
+	if err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	if len(balance.Fines) != 3 {
+		t.Fatalf("member should be fined late twice and lost once, got %+v", balance.Fines)
+	}
+	if fine := balance.Fines[0]; fine.RecordId != late || fine.Kind != KindLate || fine.DaysLate != 10 || fine.Amount != 250 {
+		t.Errorf("loan 10 days late should be fined 250, got %+v", fine)
+	}
+	if fine := balance.Fines[2]; fine.RecordId != lost || fine.Kind != KindLost || fine.Amount != 2500 {
+		t.Errorf("lost loan should be fined its replacement cost, got %+v", fine)
+	}
+	if balance.Fined != 2850 || balance.Balance != 2850 || !balance.Blocked || balance.Currency != "EUR" {
+		t.Errorf("member owing 2850 should be blocked, got %+v", balance)
+	}
+
+	lostFine := balance.Fines[2].Id
+	if _, err := ledger.Pay(ctx, Payment{MemberId: member, Amount: 3000}); !errors.Is(err, ErrInvalidPayment) {
+		t.Errorf("paying more than the balance should be refused, got %v", err)
+	}
+	if _, err := ledger.Pay(ctx, Payment{MemberId: member, Amount: 0}); !errors.Is(err, ErrInvalidPayment) {
+		t.Errorf("paying nothing should be refused, got %v", err)
+	}
+	if _, err := ledger.Pay(ctx, Payment{MemberId: member, Amount: 10, FineId: 999}); !errors.Is(err, ErrInvalidPayment) {
+		t.Errorf("paying the fine of another member should be refused, got %v", err)
+	}
+	if _, err := ledger.Pay(ctx, Payment{MemberId: member, Kind: KindWaiver, Amount: 2000, FineId: lostFine}); err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	if _, err := ledger.Pay(ctx, Payment{MemberId: member, Kind: KindWaiver, Amount: 600, FineId: lostFine}); !errors.Is(err, ErrInvalidPayment) {
+		t.Errorf("waiving more than is left of a fine should be refused, got %v", err)
+	}
+	payment, err := ledger.Pay(ctx, Payment{MemberId: member, Amount: 300, Note: "cash"})
+	if err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	if payment.Id == 0 || payment.Kind != KindPayment {
+		t.Errorf("payment should be stored as a payment, got %+v", payment)
+	}
+
+	balance, _ = ledger.Balance(ctx, member)
+	if balance.Paid != 300 || balance.Waived != 2000 || balance.Balance != 550 || !balance.Blocked {
+		t.Errorf("member should owe 550 after the waiver and a partial payment, got %+v", balance)
+	}
+	ledger.Pay(ctx, Payment{MemberId: member, Amount: 50})
+	if balance, _ = ledger.Balance(ctx, member); balance.Balance != 500 || balance.Blocked {
+		t.Errorf("member owing the maximum balance should not be blocked, got %+v", balance)
+	}
+}
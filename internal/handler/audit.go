@@ -0,0 +1,166 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	utils "github.com/riszkymf/golang-rest-boilerplate/internal/src"
+)
+
+const AuditTable = "audit_log"
+
+// The actions recorded in the audit log.
+const (
+	ActionInsert  = "insert"
+	ActionUpdate  = "update"
+	ActionDelete  = "delete"
+	ActionRestore = "restore"
+	ActionPurge   = "purge"
+)
+
+// unaudited are the tables whose writes are not logged: the log itself and
+// the bookkeeping of the service.
+var unaudited = map[string]bool{
+	AuditTable:           true,
+	"idempotency_keys":   true,
+	"schema_migrations":  true,
+	LeaseTable:           true,
+	"outbox":             true,
+	"webhooks":           true,
+	"webhook_deliveries": true,
+}
+
+// audited reports whether the writes to table are logged, which they are
+// once the audit_log table exists.
+func audited(ctx context.Context, table string) bool {
+	return !unaudited[table] && hasColumn(ctx, AuditTable, "id")
+}
+
+// auditInTransaction reports whether a write to table must first start a
+// transaction, for its audit entries to commit with it.
+func auditInTransaction(ctx context.Context, table string) bool {
+	return !InTransaction(ctx) && audited(ctx, table)
+}
+
+// snapshot reads the row id of table as it is, deleted or not; nil if there
+// is none or the table is not audited.
+func snapshot(ctx context.Context, table string, id int) (map[string]any, error) {
+	if !audited(ctx, table) {
+		return nil, nil
+	}
+	row, err := GetRowByIdContext(WithDeleted(ctx), table, id)
+	if err != nil || len(row) == 0 {
+		return nil, err
+	}
+	return row, nil
+}
+
+// audit logs the change of the row id of table from before to after, either
+// being nil for inserts and hard deletes. Only the columns that changed are
+// kept, and nothing is logged when none did.
+func audit(ctx context.Context, table string, id int, action string, before map[string]any, after map[string]any) error {
+	if !audited(ctx, table) {
+		return nil
+	}
+	changedBefore, changedAfter := diff(before, after)
+	if changedBefore == nil && changedAfter == nil {
+		return nil
+	}
+	beforeJSON, err := marshalChange(changedBefore)
+	if err != nil {
+		return err
+	}
+	afterJSON, err := marshalChange(changedAfter)
+	if err != nil {
+		return err
+	}
+	query := fmt.Sprintf("INSERT INTO %v (table_name, row_id, action, before, after, principal, request_id, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?);", AuditTable)
+	_, err = conn(ctx).ExecContext(ctx, query, table, id, action, beforeJSON, afterJSON,
+		utils.PrincipalFromContext(ctx), utils.RequestIdFromContext(ctx), timestamp(time.Now()))
+	if err != nil {
+		utils.CheckErrorContext(ctx, err, "db", "write audit log", err.Error())
+		return err
+	}
+	return notify(ctx, Change{Table: table, RowId: id, Action: action, Before: before, After: after})
+}
+
+// Change is a write to an audited row, before and after being the whole row
+// on either side of it, nil for inserts and hard deletes.
+type Change struct {
+	Table  string
+	RowId  int
+	Action string
+	Before map[string]any
+	After  map[string]any
+}
+
+// ChangeHook is told of every audited change, in its transaction: an error
+// rolls the change back.
+type ChangeHook func(ctx context.Context, change Change) error
+
+var (
+	hooksMu sync.RWMutex
+	hooks   []ChangeHook
+)
+
+// OnChange registers hook for the changes written from now on.
+func OnChange(hook ChangeHook) {
+	hooksMu.Lock()
+	defer hooksMu.Unlock()
+	hooks = append(hooks, hook)
+}
+
+func notify(ctx context.Context, change Change) error {
+	hooksMu.RLock()
+	registered := hooks
+	hooksMu.RUnlock()
+	for _, hook := range registered {
+		if err := hook(ctx, change); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// auditDelete logs the deletion of the row id, which is gone or, in tables
+// that soft delete, marked deleted.
+func auditDelete(ctx context.Context, table string, id int, before map[string]any) error {
+	after, err := snapshot(ctx, table, id)
+	if err != nil {
+		return err
+	}
+	return audit(ctx, table, id, ActionDelete, before, after)
+}
+
+// diff returns the columns of before and after whose values differ.
+func diff(before map[string]any, after map[string]any) (map[string]any, map[string]any) {
+	if before == nil || after == nil {
+		return before, after
+	}
+	changedBefore := map[string]any{}
+	changedAfter := map[string]any{}
+	for column, value := range after {
+		if fmt.Sprint(before[column]) != fmt.Sprint(value) {
+			changedBefore[column] = before[column]
+			changedAfter[column] = value
+		}
+	}
+	if len(changedAfter) == 0 {
+		return nil, nil
+	}
+	return changedBefore, changedAfter
+}
+
+func marshalChange(change map[string]any) (any, error) {
+	if change == nil {
+		return nil, nil
+	}
+	encoded, err := json.Marshal(change)
+	if err != nil {
+		return nil, err
+	}
+	return string(encoded), nil
+}
@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	utils "github.com/riszkymf/golang-rest-boilerplate/internal/src"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/testdb"
+)
+
+func TestAudit(t *testing.T) {
+	Connection = testdb.Open(t)
+	ctx := utils.ContextWithRequestId(utils.ContextWithPrincipal(context.Background(), "alice"), "request-1")
+
+	id, err := InsertDataContext(ctx, "members", map[string]any{"firstname": "Ishmael", "lastname": "-", "address": "Nantucket"})
+	if err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	if err := UpdateDataContext(ctx, "members", map[string]any{"address": "Pequod"}, id); err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	if err := DeleteDataContext(ctx, "members", id); err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	// The change and its entry are rolled back together.
+	WithTransaction(ctx, func(ctx context.Context) error {
+		UpdateDataContext(ctx, "members", map[string]any{"address": "Ahab"}, id)
+		return errors.New("rolled back")
+	})
+
+	entries, err := GetRows(AuditTable, ListQuery{Sort: []SortField{{Column: "id"}}})
+	if err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	actions := []string{}
+	for _, entry := range entries {
+		actions = append(actions, entry["action"].(string))
+		if entry["table_name"] != "members" || entry["row_id"] != id || entry["principal"] != "alice" || entry["request_id"] != "request-1" {
+			t.Errorf("unexpected entry %v", entry)
+		}
+	}
+	if len(actions) != 3 || actions[0] != ActionInsert || actions[1] != ActionUpdate || actions[2] != ActionDelete {
+		t.Fatalf("expected insert, update and delete, got %v", actions)
+	}
+	var before, after map[string]any
+	json.Unmarshal([]byte(entries[1]["before"].(string)), &before)
+	json.Unmarshal([]byte(entries[1]["after"].(string)), &after)
+	if before["address"] != "Nantucket" || after["address"] != "Pequod" || after["firstname"] != nil {
+		t.Errorf("update should keep the changed columns only, got %v and %v", before, after)
+	}
+}
@@ -5,7 +5,7 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
-	"reflect"
+	"sort"
 	"strings"
 	"time"
 
@@ -28,85 +28,111 @@ type FieldFilter struct {
 	ValueType string
 }
 
-func buildFilter(filterObj FilterQuery) (string, error) {
+// buildFilter renders the WHERE clause of a FilterQuery; values are bound
+// as arguments rather than written into the query.
+func buildFilter(filterObj FilterQuery) (string, []any, error) {
 	andFilters := []string{}
 	orFilters := []string{}
+	args := []any{}
 	var andFilter, orFilter string
+	if filterObj.And == nil && filterObj.Or == nil {
+		return "", args, nil
+	}
 	if filterObj.And != nil {
-		for k, v := range filterObj.And {
-			filter, err := buildFilterString(v, k)
+		for _, k := range sortedFields(filterObj.And) {
+			filter, filterArgs, err := buildFilterString(filterObj.And[k], k)
 			if err != nil {
-				return "", err
+				return "", nil, err
 			}
 			andFilters = append(andFilters, strings.Join(filter, " AND "))
+			args = append(args, filterArgs...)
 		}
 		andFilter = strings.Join(andFilters, " AND ")
 		if filterObj.Or == nil {
-			return andFilter, nil
+			return fmt.Sprintf("WHERE (%v)", andFilter), args, nil
 		}
 	}
 	if filterObj.Or != nil {
-		for k, v := range filterObj.Or {
-			filter, err := buildFilterString(v, k)
+		for _, k := range sortedFields(filterObj.Or) {
+			filter, filterArgs, err := buildFilterString(filterObj.Or[k], k)
 			if err != nil {
-				return "", err
+				return "", nil, err
 			}
 			orFiltersTmp := fmt.Sprintf("(%v)", strings.Join(filter, " OR "))
 			orFilters = append(orFilters, orFiltersTmp)
+			args = append(args, filterArgs...)
 		}
 		orFilter = strings.Join(orFilters, " AND ")
 		if filterObj.And == nil {
-			return orFilter, nil
+			return fmt.Sprintf("WHERE %v", orFilter), args, nil
 		}
 	}
-	return fmt.Sprintf("WHERE (%v) AND %v", andFilter, orFilter), nil
+	return fmt.Sprintf("WHERE (%v) AND %v", andFilter, orFilter), args, nil
+
+}
 
+func sortedFields(filters map[string][]FieldFilter) []string {
+	fields := make([]string, 0, len(filters))
+	for k := range filters {
+		fields = append(fields, k)
+	}
+	sort.Strings(fields)
+	return fields
 }
 
-func buildFilterString(filter []FieldFilter, fieldName string) ([]string, error) {
+func buildFilterString(filter []FieldFilter, fieldName string) ([]string, []any, error) {
 	qfilters := []string{}
+	args := []any{}
 	for _, i := range filter {
-		var searchParameter, operator, parseValue string
-		if i.ValueType == "string" {
-			parseValue = fmt.Sprintf("'%v'", i.Value)
-		} else {
-			parseValue = i.Value
+		var searchParameter, operator string
+		var value any = i.Value
+		switch i.ValueType {
+		case "int":
+			parsed, err := strconv.Atoi(i.Value)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid integer %q for %v", i.Value, fieldName)
+			}
+			value = parsed
+		case "float":
+			parsed, err := strconv.ParseFloat(i.Value, 64)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid number %q for %v", i.Value, fieldName)
+			}
+			value = parsed
 		}
 		switch i.Operator {
 		case "eq":
 			operator = "="
-			searchParameter = fmt.Sprintf("%v %v %v", fieldName, operator, parseValue)
 		case "gt":
 			operator = ">"
-			searchParameter = fmt.Sprintf("%v %v %v", fieldName, operator, parseValue)
 		case "gte":
 			operator = ">="
-			searchParameter = fmt.Sprintf("%v %v %v", fieldName, operator, parseValue)
 		case "lt":
 			operator = "<"
-			searchParameter = fmt.Sprintf("%v %v %v", fieldName, operator, parseValue)
 		case "lte":
 			operator = "<="
-			searchParameter = fmt.Sprintf("%v %v %v", fieldName, operator, parseValue)
 		case "like":
 			operator = "LIKE"
-			searchParameter = fmt.Sprintf("%v %v %v", fieldName, operator, parseValue)
 		case "not":
-			searchParameter = fmt.Sprintf("NOT %v=%v", fieldName, parseValue)
+			searchParameter = fmt.Sprintf("NOT %v=?", fieldName)
 		case "isEmpty":
-			operator = "IS NULL"
-			searchParameter = fmt.Sprintf("%v %v", fieldName, operator)
+			searchParameter = fmt.Sprintf("%v IS NULL", fieldName)
 		case "isNotEmpty":
-			operator = "IS NOT NULL"
-			searchParameter = fmt.Sprintf("%v %v", fieldName, operator)
+			searchParameter = fmt.Sprintf("%v IS NOT NULL", fieldName)
 		default:
 			err := errors.New("Invalid parameter")
-			return nil, err
+			return nil, nil, err
+		}
+		if operator != "" {
+			searchParameter = fmt.Sprintf("%v %v ?", fieldName, operator)
+		}
+		if operator != "" || i.Operator == "not" {
+			args = append(args, value)
 		}
 		qfilters = append(qfilters, searchParameter)
 	}
 
-	return qfilters, nil
+	return qfilters, args, nil
 }
 
 func prepareStatements(query string) *sql.Stmt {
@@ -118,35 +144,42 @@ func prepareStatements(query string) *sql.Stmt {
 }
 
 func GetRowById(table string, id int) (map[string]any, error) {
-	var result = map[string]any{}
+	return GetRowByIdContext(context.Background(), table, id)
+}
+
+func GetRowByIdContext(ctx context.Context, table string, id int) (result map[string]any, err error) {
+	ctx, observer := startQuery(ctx, table, "select")
+	defer observer.finish(&err)
+	result = map[string]any{}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
-	err := Connection.PingContext(ctx)
+	err = Database(ctx).PingContext(ctx)
 	if err != nil {
-		utils.CheckError(err, "db", "Database Ping", err.Error())
+		utils.CheckErrorContext(ctx, err, "db", "Database Ping", err.Error())
 		return nil, err
 	}
-	query := fmt.Sprintf("SELECT * FROM %v WHERE id=%v;", table, id)
-	rows, err := Connection.Query(query)
+	query := fmt.Sprintf("SELECT * FROM %v %v;", table, live(ctx, table, "WHERE id=?"))
+	rows, err := conn(ctx).QueryContext(ctx, query, id)
 	if err != nil {
-		utils.CheckError(err, "db", "retrieve db", err.Error())
+		utils.CheckErrorContext(ctx, err, "db", "retrieve db", err.Error())
 		return nil, err
 	}
+	defer rows.Close()
 	col, err := rows.Columns()
 	if err != nil {
-		utils.CheckError(err, "db", "retrieve columns", err.Error())
+		utils.CheckErrorContext(ctx, err, "db", "retrieve columns", err.Error())
 		return nil, err
 	}
 	colTypes, err := rows.ColumnTypes()
 	if err != nil {
-		utils.CheckError(err, "db", "retrieve columns", err.Error())
+		utils.CheckErrorContext(ctx, err, "db", "retrieve columns", err.Error())
 		return nil, err
 	}
 	row := make([][]byte, len(col))
 	rowPtr := make([]any, len(col))
 	if err != nil {
-		utils.CheckError(err, "db", "Retrieve column", err.Error())
+		utils.CheckErrorContext(ctx, err, "db", "Retrieve column", err.Error())
 		return nil, err
 	}
 	for i := range row {
@@ -155,44 +188,52 @@ func GetRowById(table string, id int) (map[string]any, error) {
 	for rows.Next() {
 		err = rows.Scan(rowPtr...)
 		if err != nil {
-			utils.CheckError(err, "db", "Retrieve data", "error during data retrieval")
+			utils.CheckErrorContext(ctx, err, "db", "Retrieve data", "error during data retrieval")
 			return nil, err
 		}
 		result = getData(row, col, colTypes)
+		observer.rows++
 	}
 	return result, nil
 }
 
 func GetRowsAll(table string) ([]map[string]any, error) {
-	var result = []map[string]any{}
+	return GetRowsAllContext(context.Background(), table)
+}
+
+func GetRowsAllContext(ctx context.Context, table string) (result []map[string]any, err error) {
+	ctx, observer := startQuery(ctx, table, "select")
+	defer observer.finish(&err)
+	result = []map[string]any{}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
-	err := Connection.PingContext(ctx)
+	err = Database(ctx).PingContext(ctx)
 	if err != nil {
-		utils.CheckError(err, "db", "Database Ping", err.Error())
+		utils.CheckErrorContext(ctx, err, "db", "Database Ping", err.Error())
 		return nil, err
 	}
-	query := fmt.Sprintf("SELECT * FROM %v;", table)
-	rows, err := Connection.Query(query)
+	query := fmt.Sprintf("SELECT * FROM %v %v;", table, live(ctx, table, ""))
+	rows, err := conn(ctx).QueryContext(ctx, query)
 	if err != nil {
-		utils.CheckError(err, "db", "retrieve db", err.Error())
+		utils.CheckErrorContext(ctx, err, "db", "retrieve db", err.Error())
 		return nil, err
 	}
+	defer rows.Close()
 	col, err := rows.Columns()
 	if err != nil {
-		utils.CheckError(err, "db", "retrieve columns", err.Error())
+		utils.CheckErrorContext(ctx, err, "db", "retrieve columns", err.Error())
 		return nil, err
 	}
 	colTypes, err := rows.ColumnTypes()
 	if err != nil {
-		utils.CheckError(err, "db", "retrieve columns", err.Error())
+		utils.CheckErrorContext(ctx, err, "db", "retrieve columns", err.Error())
 		return nil, err
 	}
 	row := make([][]byte, len(col))
 	rowPtr := make([]any, len(col))
 	if err != nil {
-		utils.CheckError(err, "db", "Retrieve column", err.Error())
+		utils.CheckErrorContext(ctx, err, "db", "Retrieve column", err.Error())
 		return nil, err
 	}
 	for i := range row {
@@ -201,49 +242,57 @@ func GetRowsAll(table string) ([]map[string]any, error) {
 	for rows.Next() {
 		err = rows.Scan(rowPtr...)
 		if err != nil {
-			utils.CheckError(err, "db", "Retrieve data", "error during data retrieval")
+			utils.CheckErrorContext(ctx, err, "db", "Retrieve data", "error during data retrieval")
 			return nil, err
 		}
 		result = append(result, getData(row, col, colTypes))
 	}
+	observer.rows = len(result)
 	return result, nil
 }
 
 func GetRowByFilter(table string, filter FilterQuery) ([]map[string]any, error) {
-	var result = []map[string]any{}
+	return GetRowByFilterContext(context.Background(), table, filter)
+}
+
+func GetRowByFilterContext(ctx context.Context, table string, filter FilterQuery) (result []map[string]any, err error) {
+	ctx, observer := startQuery(ctx, table, "select")
+	defer observer.finish(&err)
+	result = []map[string]any{}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
-	err := Connection.PingContext(ctx)
+	err = Database(ctx).PingContext(ctx)
 	if err != nil {
-		utils.CheckError(err, "db", "Database Ping", err.Error())
+		utils.CheckErrorContext(ctx, err, "db", "Database Ping", err.Error())
 		return nil, err
 	}
-	queryFilter, err := buildFilter(filter)
+	queryFilter, args, err := buildFilter(filter)
 	if err != nil {
-		utils.CheckError(err, "db", "Database Ping", err.Error())
+		utils.CheckErrorContext(ctx, err, "db", "Database Ping", err.Error())
 		return nil, err
 	}
-	query := fmt.Sprintf("SELECT * FROM %v %v;", table, queryFilter)
-	rows, err := Connection.Query(query)
+	query := fmt.Sprintf("SELECT * FROM %v %v;", table, live(ctx, table, queryFilter))
+	rows, err := conn(ctx).QueryContext(ctx, query, args...)
 	if err != nil {
-		utils.CheckError(err, "db", "retrieve db", err.Error())
+		utils.CheckErrorContext(ctx, err, "db", "retrieve db", err.Error())
 		return nil, err
 	}
+	defer rows.Close()
 	col, err := rows.Columns()
 	if err != nil {
-		utils.CheckError(err, "db", "retrieve columns", err.Error())
+		utils.CheckErrorContext(ctx, err, "db", "retrieve columns", err.Error())
 		return nil, err
 	}
 	colTypes, err := rows.ColumnTypes()
 	if err != nil {
-		utils.CheckError(err, "db", "retrieve columns", err.Error())
+		utils.CheckErrorContext(ctx, err, "db", "retrieve columns", err.Error())
 		return nil, err
 	}
 	row := make([][]byte, len(col))
 	rowPtr := make([]any, len(col))
 	if err != nil {
-		utils.CheckError(err, "db", "Retrieve column", err.Error())
+		utils.CheckErrorContext(ctx, err, "db", "Retrieve column", err.Error())
 		return nil, err
 	}
 	for i := range row {
@@ -252,63 +301,155 @@ func GetRowByFilter(table string, filter FilterQuery) ([]map[string]any, error)
 	for rows.Next() {
 		err = rows.Scan(rowPtr...)
 		if err != nil {
-			utils.CheckError(err, "db", "Retrieve data", "error during data retrieval")
+			utils.CheckErrorContext(ctx, err, "db", "Retrieve data", "error during data retrieval")
 			return nil, err
 		}
 		result = append(result, getData(row, col, colTypes))
 	}
+	observer.rows = len(result)
 	return result, nil
 }
 
+func CountRows(table string, filter FilterQuery) (int, error) {
+	return CountRowsContext(context.Background(), table, filter)
+}
+
+func CountRowsContext(ctx context.Context, table string, filter FilterQuery) (count int, err error) {
+	ctx, observer := startQuery(ctx, table, "count")
+	defer observer.finish(&err)
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	err = Database(ctx).PingContext(ctx)
+	if err != nil {
+		utils.CheckErrorContext(ctx, err, "db", "Database Ping", err.Error())
+		return 0, err
+	}
+	queryFilter, args, err := buildFilter(filter)
+	if err != nil {
+		utils.CheckErrorContext(ctx, err, "db", "Build filter", err.Error())
+		return 0, err
+	}
+	query := fmt.Sprintf("SELECT COUNT(*) FROM %v %v;", table, live(ctx, table, queryFilter))
+	err = conn(ctx).QueryRowContext(ctx, query, args...).Scan(&count)
+	if err != nil {
+		utils.CheckErrorContext(ctx, err, "db", "count rows", err.Error())
+		return 0, err
+	}
+	observer.rows = count
+	return count, nil
+}
+
+func Columns(table string) ([]string, error) {
+	return ColumnsContext(context.Background(), table)
+}
+
+// ColumnsContext returns the column names of a table or view in their
+// declared order, which the row maps of the other queries do not keep.
+func ColumnsContext(ctx context.Context, table string) (columns []string, err error) {
+	ctx, observer := startQuery(ctx, table, "select")
+	defer observer.finish(&err)
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	query := fmt.Sprintf("SELECT * FROM %v LIMIT 0;", table)
+	rows, err := conn(ctx).QueryContext(ctx, query)
+	if err != nil {
+		utils.CheckErrorContext(ctx, err, "db", "retrieve db", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+	columns, err = rows.Columns()
+	if err != nil {
+		utils.CheckErrorContext(ctx, err, "db", "retrieve columns", err.Error())
+		return nil, err
+	}
+	return columns, nil
+}
+
 func InsertData(table string, inputData map[string]any) (int, error) {
+	return InsertDataContext(context.Background(), table, inputData)
+}
+
+func InsertDataContext(ctx context.Context, table string, inputData map[string]any) (id int, err error) {
+	if auditInTransaction(ctx, table) {
+		err = WithTransaction(ctx, func(ctx context.Context) error {
+			id, err = InsertDataContext(ctx, table, inputData)
+			return err
+		})
+		return id, err
+	}
+	ctx, observer := startQuery(ctx, table, "insert")
+	defer observer.finish(&err)
 	var fields, values []string
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
-	err := Connection.PingContext(ctx)
+	err = Database(ctx).PingContext(ctx)
 	if err != nil {
-		utils.CheckError(err, "db", "Database Ping", err.Error())
+		utils.CheckErrorContext(ctx, err, "db", "Database Ping", err.Error())
 		return 0, err
 	}
 
+	args := []any{}
 	for k, v := range inputData {
 		fields = append(fields, k)
-		var parseValue string
-		if typeVal := reflect.TypeOf(v).Kind(); typeVal == reflect.String {
-			parseValue = fmt.Sprintf("'%v'", v)
-		} else {
-			parseValue = fmt.Sprintf("%v", v)
-		}
-		values = append(values, parseValue)
+		values = append(values, "?")
+		args = append(args, v)
+	}
+	if _, exist := inputData["updated_at"]; !exist && versioned(ctx, table) {
+		fields = append(fields, "updated_at")
+		values = append(values, "?")
+		args = append(args, timestamp(time.Now()))
 	}
 
 	inputFields := strings.Join(fields, ",")
 	inputValues := strings.Join(values, ",")
 	insertStmt := fmt.Sprintf("INSERT INTO %v (%v) VALUES (%v);", table, inputFields, inputValues)
-	res, err := Connection.Exec(insertStmt)
+	res, err := conn(ctx).ExecContext(ctx, insertStmt, args...)
 	if err != nil {
-		utils.CheckError(err, "db", "insert data to db", err.Error())
+		utils.CheckErrorContext(ctx, err, "db", "insert data to db", err.Error())
 		return 0, err
 	}
 	tmpInt, err := res.LastInsertId()
 	if err != nil {
-		utils.CheckError(err, "db", "Converting Id", err.Error())
+		utils.CheckErrorContext(ctx, err, "db", "Converting Id", err.Error())
 		return 0, err
 	}
 
+	observer.rows = 1
+	after, err := snapshot(ctx, table, int(tmpInt))
+	if err == nil {
+		err = audit(ctx, table, int(tmpInt), ActionInsert, nil, after)
+	}
+	if err != nil {
+		return 0, err
+	}
 	return int(tmpInt), nil
 
 }
 
 func InsertMultipleData(table string, inputDatas []map[string]any) ([]int, error) {
+	return InsertMultipleDataContext(context.Background(), table, inputDatas)
+}
+
+func InsertMultipleDataContext(ctx context.Context, table string, inputDatas []map[string]any) (result []int, err error) {
+	if auditInTransaction(ctx, table) {
+		err = WithTransaction(ctx, func(ctx context.Context) error {
+			result, err = InsertMultipleDataContext(ctx, table, inputDatas)
+			return err
+		})
+		return result, err
+	}
+	ctx, observer := startQuery(ctx, table, "insert")
+	defer observer.finish(&err)
 	var fields, vPlaceHolder []string
-	var result []int
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
-	err := Connection.PingContext(ctx)
+	err = Database(ctx).PingContext(ctx)
 	if err != nil {
-		utils.CheckError(err, "db", "Database Ping", err.Error())
+		utils.CheckErrorContext(ctx, err, "db", "Database Ping", err.Error())
 		return result, err
 	}
 
@@ -316,14 +457,20 @@ func InsertMultipleData(table string, inputDatas []map[string]any) ([]int, error
 		fields = append(fields, k)
 		vPlaceHolder = append(vPlaceHolder, "?")
 	}
+	_, hasUpdatedAt := inputDatas[0]["updated_at"]
+	addUpdatedAt := !hasUpdatedAt && versioned(ctx, table)
+	if addUpdatedAt {
+		fields = append(fields, "updated_at")
+		vPlaceHolder = append(vPlaceHolder, "?")
+	}
 	inputFields := strings.Join(fields, ",")
 	vFormats := strings.Join(vPlaceHolder, ",")
 
 	insertStmt := fmt.Sprintf("INSERT INTO %v (%v)  VALUES (%v);", table, inputFields, vFormats)
-	stmt, err := Connection.Prepare(insertStmt)
+	stmt, err := conn(ctx).PrepareContext(ctx, insertStmt)
 
 	if err != nil {
-		utils.LogError("db", "Query Prep", "Error during Query preparation")
+		utils.LogErrorContext(ctx, "db", "Query Prep", "Error during Query preparation")
 		return result, err
 	}
 
@@ -333,118 +480,214 @@ func InsertMultipleData(table string, inputDatas []map[string]any) ([]int, error
 		inputData := inputDatas[idx]
 		var values []any
 		for _, k := range fields {
-			v := inputData[k]
-			var parseValue string
-			if typeVal := reflect.TypeOf(v).Kind(); typeVal == reflect.String {
-				parseValue = fmt.Sprintf("'%v'", v)
-			} else {
-				parseValue = fmt.Sprintf("%v", v)
-			}
-			values = append(values, parseValue)
+			values = append(values, inputData[k])
 		}
-		res, err := stmt.Exec(values...)
+		if addUpdatedAt {
+			values[len(values)-1] = timestamp(time.Now())
+		}
+		res, err := stmt.ExecContext(ctx, values...)
 		if err != nil {
-			utils.LogError("db", "Query Prep", "Error during insert execution")
+			utils.LogErrorContext(ctx, "db", "Query Prep", "Error during insert execution")
 			return result, err
 		}
 		var id int64
 		id, err = res.LastInsertId()
 		if err != nil {
-			utils.LogError("db", "Query Prep", "Error during id retrieval")
+			utils.LogErrorContext(ctx, "db", "Query Prep", "Error during id retrieval")
 			return result, err
 		}
 		result = append(result, int(id))
+		after, err := snapshot(ctx, table, int(id))
+		if err == nil {
+			err = audit(ctx, table, int(id), ActionInsert, nil, after)
+		}
+		if err != nil {
+			return result, err
+		}
 	}
 
+	observer.rows = len(result)
 	return result, nil
 
 }
 
 func UpdateData(table string, inputData map[string]any, id int) error {
+	return UpdateDataContext(context.Background(), table, inputData, id)
+}
+
+func UpdateDataContext(ctx context.Context, table string, inputData map[string]any, id int) error {
 	// This function use int Id as parameter. Change according your own requirements
+	return updateData(ctx, table, inputData, id, nil)
+}
+
+// updateData updates the row id, only if its version matches when version
+// is given.
+func updateData(ctx context.Context, table string, inputData map[string]any, id int, version *int) (err error) {
+	if auditInTransaction(ctx, table) {
+		return WithTransaction(ctx, func(ctx context.Context) error {
+			return updateData(ctx, table, inputData, id, version)
+		})
+	}
+	ctx, observer := startQuery(ctx, table, "update")
+	defer observer.finish(&err)
 	var newValues []string
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
-	err := Connection.PingContext(ctx)
+	err = Database(ctx).PingContext(ctx)
 	if err != nil {
-		utils.CheckError(err, "db", "Database Ping", err.Error())
+		utils.CheckErrorContext(ctx, err, "db", "Database Ping", err.Error())
 		return err
 	}
+	isVersioned := versioned(ctx, table)
+	if version != nil && !isVersioned {
+		return fmt.Errorf("table %v has no version column", table)
+	}
 
+	args := []any{}
 	for k, v := range inputData {
-		if k == "id" {
+		if k == "id" || (isVersioned && (k == "version" || k == "updated_at")) {
 			continue
 		}
-		var parseValue string
-		if typeVal := reflect.TypeOf(v).Kind(); typeVal == reflect.String {
-			parseValue = fmt.Sprintf("'%v'", v)
-		} else {
-			parseValue = fmt.Sprintf("%v", v)
+		newValues = append(newValues, fmt.Sprintf("%v=?", k))
+		args = append(args, v)
+	}
+	if len(newValues) == 0 {
+		if version != nil {
+			return checkVersion(ctx, table, id, *version)
 		}
-		newValues = append(newValues, fmt.Sprintf("%v=%v", k, parseValue))
+		return nil
+	}
+	if isVersioned {
+		newValues = append(newValues, "version=version+1", "updated_at=?")
+		args = append(args, timestamp(time.Now()))
 	}
 
 	inputValues := strings.Join(newValues, ",")
-	filter := fmt.Sprintf("id=%v", id)
+	condition := "id=?"
+	args = append(args, id)
+	if version != nil {
+		condition += " AND version=?"
+		args = append(args, *version)
+	}
 
-	query := fmt.Sprintf("UPDATE %v SET %v WHERE %v;", table, inputValues, filter)
-	_, err = Connection.Exec(query)
+	before, err := snapshot(ctx, table, id)
 	if err != nil {
-		utils.CheckError(err, "db", "update db", err.Error())
 		return err
 	}
-	return nil
+	query := fmt.Sprintf("UPDATE %v SET %v %v;", table, inputValues, live(ctx, table, "WHERE "+condition))
+	res, err := conn(ctx).ExecContext(ctx, query, args...)
+	if err != nil {
+		utils.CheckErrorContext(ctx, err, "db", "update db", err.Error())
+		return err
+	}
+	affected, _ := res.RowsAffected()
+	observer.rows = int(affected)
+	if affected == 0 && version != nil {
+		if err = checkVersion(ctx, table, id, *version); err == nil {
+			err = ErrVersionMismatch
+		}
+		return err
+	}
+	if affected == 0 {
+		return nil
+	}
+	after, err := snapshot(ctx, table, id)
+	if err != nil {
+		return err
+	}
+	return audit(ctx, table, id, ActionUpdate, before, after)
 
 }
 
 func DeleteData(table string, id int) error {
+	return DeleteDataContext(context.Background(), table, id)
+}
+
+func DeleteDataContext(ctx context.Context, table string, id int) (err error) {
 	// This function use int Id as parameter. Change according your own requirements
+	if auditInTransaction(ctx, table) {
+		return WithTransaction(ctx, func(ctx context.Context) error {
+			return DeleteDataContext(ctx, table, id)
+		})
+	}
+	ctx, observer := startQuery(ctx, table, "delete")
+	defer observer.finish(&err)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
-	err := Connection.PingContext(ctx)
+	err = Database(ctx).PingContext(ctx)
 	if err != nil {
-		utils.CheckError(err, "db", "Database Ping", err.Error())
+		utils.CheckErrorContext(ctx, err, "db", "Database Ping", err.Error())
 		return err
 	}
-	filter := fmt.Sprintf("id=%v", id)
-
-	query := fmt.Sprintf("DELETE FROM %v  WHERE %v;", table, filter)
-	_, err = Connection.Exec(query)
+	before, err := snapshot(ctx, table, id)
 	if err != nil {
-		utils.CheckError(err, "db", "delete data from db", err.Error())
 		return err
 	}
-	return nil
+	query, args := deleteStatement(ctx, table, "id=?")
+	res, err := conn(ctx).ExecContext(ctx, query, append(args, id)...)
+	if err != nil {
+		utils.CheckErrorContext(ctx, err, "db", "delete data from db", err.Error())
+		return err
+	}
+	affected, _ := res.RowsAffected()
+	observer.rows = int(affected)
+	if affected == 0 {
+		return nil
+	}
+	return auditDelete(ctx, table, id, before)
 
 }
 
 func DeleteMultipleData(table string, id []int) error {
+	return DeleteMultipleDataContext(context.Background(), table, id)
+}
+
+func DeleteMultipleDataContext(ctx context.Context, table string, id []int) (err error) {
 	// This function use int Id as parameter. Change according your own requirements
+	if auditInTransaction(ctx, table) {
+		return WithTransaction(ctx, func(ctx context.Context) error {
+			return DeleteMultipleDataContext(ctx, table, id)
+		})
+	}
+	ctx, observer := startQuery(ctx, table, "delete")
+	defer observer.finish(&err)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
-	err := Connection.PingContext(ctx)
+	err = Database(ctx).PingContext(ctx)
 	if err != nil {
-		utils.CheckError(err, "db", "Database Ping", err.Error())
+		utils.CheckErrorContext(ctx, err, "db", "Database Ping", err.Error())
 		return err
 	}
 
-	deleteQuery := fmt.Sprintf("DELETE FROM %v  WHERE id=?", table)
+	deleteQuery, args := deleteStatement(ctx, table, "id=?")
 
-	stmt, err := Connection.Prepare(deleteQuery)
+	stmt, err := conn(ctx).PrepareContext(ctx, deleteQuery)
 	if err != nil {
-		utils.CheckError(err, "db", "prepare delete query", err.Error())
+		utils.CheckErrorContext(ctx, err, "db", "prepare delete query", err.Error())
 		return err
 	}
 
 	defer stmt.Close()
 
 	for _, i := range id {
-		_, err := stmt.Exec(i)
+		before, err := snapshot(ctx, table, i)
 		if err != nil {
-			utils.CheckError(err, "db", "delete data from db", err.Error())
+			return err
+		}
+		res, err := stmt.ExecContext(ctx, append(args, i)...)
+		if err != nil {
+			utils.CheckErrorContext(ctx, err, "db", "delete data from db", err.Error())
+			return err
+		}
+		affected, _ := res.RowsAffected()
+		observer.rows += int(affected)
+		if affected == 0 {
+			continue
+		}
+		if err = auditDelete(ctx, table, i, before); err != nil {
 			return err
 		}
 	}
@@ -452,6 +695,56 @@ func DeleteMultipleData(table string, id []int) error {
 
 }
 
+func DeleteByFilter(table string, filter FilterQuery) (int, error) {
+	return DeleteByFilterContext(context.Background(), table, filter)
+}
+
+// DeleteByFilterContext deletes the rows matching filter and returns how
+// many were deleted. An empty filter is refused rather than emptying table.
+func DeleteByFilterContext(ctx context.Context, table string, filter FilterQuery) (deleted int, err error) {
+	if auditInTransaction(ctx, table) {
+		err = WithTransaction(ctx, func(ctx context.Context) error {
+			deleted, err = DeleteByFilterContext(ctx, table, filter)
+			return err
+		})
+		return deleted, err
+	}
+	ctx, observer := startQuery(ctx, table, "delete")
+	defer observer.finish(&err)
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	queryFilter, args, err := buildFilter(filter)
+	if err != nil {
+		utils.CheckErrorContext(ctx, err, "db", "Build filter", err.Error())
+		return 0, err
+	}
+	if queryFilter == "" {
+		return 0, errors.New("delete by filter needs a filter")
+	}
+	var before []map[string]any
+	if audited(ctx, table) {
+		if before, err = GetRowByFilterContext(ctx, table, filter); err != nil {
+			return 0, err
+		}
+	}
+	query, deleteArgs := deleteStatement(ctx, table, strings.TrimPrefix(queryFilter, "WHERE "))
+	res, err := conn(ctx).ExecContext(ctx, query, append(deleteArgs, args...)...)
+	if err != nil {
+		utils.CheckErrorContext(ctx, err, "db", "delete data from db", err.Error())
+		return 0, err
+	}
+	affected, _ := res.RowsAffected()
+	observer.rows = int(affected)
+	for _, row := range before {
+		id, _ := row["id"].(int)
+		if err = auditDelete(ctx, table, id, row); err != nil {
+			return 0, err
+		}
+	}
+	return int(affected), nil
+}
+
 func getData(row [][]byte, colNames []string, colTypes []*sql.ColumnType) map[string]any {
 	result := map[string]any{}
 	for i := 0; i < len(colNames); i++ {
@@ -465,13 +758,28 @@ func convert(val []byte, colType *sql.ColumnType) any {
 	typeName := colType.DatabaseTypeName()
 	switch true {
 	case utils.Contains(typeName, "INT"):
-		result, _ = strconv.Atoi(string(val))
+		// SQLite keeps text written to an INT column as text, e.g. the
+		// rent_status of records.
+		number, err := strconv.Atoi(string(val))
+		if err != nil && len(val) > 0 {
+			result = string(val)
+		} else {
+			result = number
+		}
 	case utils.Contains(typeName, "VARCHAR"):
 		result = string(val)
 	case utils.Contains(typeName, "TEXT"):
 		result = string(val)
 	case utils.Contains(typeName, "TIMESTAMP"):
 		result = string(val)
+	case typeName == "" && val != nil:
+		// The computed columns of views, e.g. the counts of v_books, have
+		// no declared type.
+		if number, err := strconv.Atoi(string(val)); err == nil {
+			result = number
+		} else {
+			result = string(val)
+		}
 	}
 	return result
 }
@@ -0,0 +1,43 @@
+package handler
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBuildFilter(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter FilterQuery
+		want   string
+		args   []any
+	}{
+		{"none", FilterQuery{}, "", []any{}},
+		{"and", FilterQuery{And: map[string][]FieldFilter{
+			"title": {{Operator: "eq", Value: "Dune", ValueType: "string"}, {Operator: "isNotEmpty"}},
+		}}, "WHERE (title = ? AND title IS NOT NULL)", []any{"Dune"}},
+		{"or", FilterQuery{Or: map[string][]FieldFilter{
+			"stock": {{Operator: "lt", Value: "1", ValueType: "int"}, {Operator: "gte", Value: "10", ValueType: "int"}},
+		}}, "WHERE (stock < ? OR stock >= ?)", []any{1, 10}},
+		{"and or", FilterQuery{
+			And: map[string][]FieldFilter{"title": {{Operator: "like", Value: "D%", ValueType: "string"}}},
+			Or:  map[string][]FieldFilter{"stock": {{Operator: "not", Value: "0", ValueType: "int"}}},
+		}, "WHERE (title LIKE ?) AND (NOT stock=?)", []any{"D%", 0}},
+		// A value is bound, never written into the query, whatever quotes it
+		// holds.
+		{"injection", FilterQuery{And: map[string][]FieldFilter{
+			"title": {{Operator: "eq", Value: "x' OR '1'='1", ValueType: "string"}},
+		}}, "WHERE (title = ?)", []any{"x' OR '1'='1"}},
+	}
+	for _, test := range tests {
+		got, args, err := buildFilter(test.filter)
+		if err != nil || got != test.want || !reflect.DeepEqual(args, test.args) {
+			t.Errorf("%v: got %q %v %v, want %q %v", test.name, got, args, err, test.want, test.args)
+		}
+	}
+	for _, invalid := range []FieldFilter{{Operator: "matches"}, {Operator: "eq", Value: "1 OR 1=1", ValueType: "int"}} {
+		if _, _, err := buildFilter(FilterQuery{And: map[string][]FieldFilter{"stock": {invalid}}}); err == nil {
+			t.Errorf("%+v should be refused", invalid)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/riszkymf/golang-rest-boilerplate/internal/metrics"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/tracing"
+)
+
+var queryTotal = metrics.NewCounterVec(
+	"handler_queries_total",
+	"Number of handler queries by table, operation and result.",
+	"table", "operation", "result",
+)
+
+var queryDuration = metrics.NewHistogramVec(
+	"handler_query_duration_seconds",
+	"Duration of handler queries by table and operation.",
+	nil,
+	"table", "operation",
+)
+
+type queryObserver struct {
+	table     string
+	operation string
+	start     time.Time
+	span      *tracing.Span
+	rows      int
+}
+
+// startQuery opens a child span of the request span carried by ctx. Every
+// handler call defers finish with a pointer to its named error result so
+// failures are counted and recorded on the span.
+func startQuery(ctx context.Context, table string, operation string) (context.Context, *queryObserver) {
+	ctx, span := tracing.StartSpan(ctx, fmt.Sprintf("handler.%v %v", operation, table), tracing.SpanKindClient)
+	span.SetAttribute("db.system", "sqlite")
+	span.SetAttribute("db.sql.table", table)
+	span.SetAttribute("db.operation", operation)
+	return ctx, &queryObserver{
+		table:     table,
+		operation: operation,
+		start:     time.Now(),
+		span:      span,
+	}
+}
+
+func (o *queryObserver) finish(err *error) {
+	result := "success"
+	if err != nil && *err != nil {
+		result = "error"
+		o.span.SetError(*err)
+	}
+	queryTotal.Inc(o.table, o.operation, result)
+	queryDuration.Observe(time.Since(o.start).Seconds(), o.table, o.operation)
+	o.span.SetAttribute("db.rows", o.rows)
+	o.span.Finish()
+}
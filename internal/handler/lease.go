@@ -0,0 +1,55 @@
+package handler
+
+import (
+	"context"
+	"time"
+
+	utils "github.com/riszkymf/golang-rest-boilerplate/internal/src"
+)
+
+const LeaseTable = "leases"
+
+func AcquireLease(name string, holder string, ttl time.Duration) (bool, error) {
+	return AcquireLeaseContext(context.Background(), name, holder, ttl)
+}
+
+// AcquireLeaseContext makes holder the holder of the lease name for ttl,
+// unless another holder has it and it has not expired. It reports whether
+// holder has the lease.
+func AcquireLeaseContext(ctx context.Context, name string, holder string, ttl time.Duration) (acquired bool, err error) {
+	ctx, observer := startQuery(ctx, LeaseTable, "acquire")
+	defer observer.finish(&err)
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	now := time.Now()
+	query := `INSERT INTO leases (name, holder, expires_at) VALUES (?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET holder=excluded.holder, expires_at=excluded.expires_at
+		WHERE leases.holder=excluded.holder OR leases.expires_at <= ?;`
+	res, err := conn(ctx).ExecContext(ctx, query, name, holder, now.Add(ttl).Unix(), now.Unix())
+	if err != nil {
+		utils.CheckErrorContext(ctx, err, "db", "acquire lease", err.Error())
+		return false, err
+	}
+	affected, _ := res.RowsAffected()
+	observer.rows = int(affected)
+	return affected > 0, nil
+}
+
+func ReleaseLease(name string, holder string) error {
+	return ReleaseLeaseContext(context.Background(), name, holder)
+}
+
+// ReleaseLeaseContext gives up the lease name if holder has it.
+func ReleaseLeaseContext(ctx context.Context, name string, holder string) (err error) {
+	ctx, observer := startQuery(ctx, LeaseTable, "release")
+	defer observer.finish(&err)
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	_, err = conn(ctx).ExecContext(ctx, "DELETE FROM leases WHERE name=? AND holder=?;", name, holder)
+	if err != nil {
+		utils.CheckErrorContext(ctx, err, "db", "release lease", err.Error())
+	}
+	return err
+}
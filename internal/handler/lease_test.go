@@ -0,0 +1,43 @@
+package handler
+
+import (
+	"testing"
+	"time"
+
+	"github.com/riszkymf/golang-rest-boilerplate/internal/testdb"
+)
+
+func TestLease(t *testing.T) {
+	Connection = testdb.Open(t)
+
+	if acquired, err := AcquireLease("job", "a", time.Hour); err != nil || !acquired {
+		t.Fatalf("free lease should be acquired, got %v %v", acquired, err)
+	}
+	if acquired, _ := AcquireLease("job", "b", time.Hour); acquired {
+		t.Errorf("lease held by another should not be acquired")
+	}
+	if acquired, _ := AcquireLease("job", "a", time.Hour); !acquired {
+		t.Errorf("holder should renew its lease")
+	}
+	if acquired, _ := AcquireLease("other", "b", time.Hour); !acquired {
+		t.Errorf("leases should be independent")
+	}
+
+	if acquired, _ := AcquireLease("expiring", "a", -time.Second); !acquired {
+		t.Fatalf("free lease should be acquired")
+	}
+	if acquired, _ := AcquireLease("expiring", "b", time.Hour); !acquired {
+		t.Errorf("expired lease should be taken over")
+	}
+
+	if err := ReleaseLease("job", "b"); err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	if acquired, _ := AcquireLease("job", "b", time.Hour); acquired {
+		t.Errorf("only the holder should release a lease")
+	}
+	ReleaseLease("job", "a")
+	if acquired, _ := AcquireLease("job", "b", time.Hour); !acquired {
+		t.Errorf("released lease should be acquired")
+	}
+}
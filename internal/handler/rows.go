@@ -0,0 +1,178 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	utils "github.com/riszkymf/golang-rest-boilerplate/internal/src"
+)
+
+type SortField struct {
+	Column string
+	Desc   bool
+}
+
+// ListQuery selects and orders the rows of a table or view, at most Limit of
+// them when positive. Column names are written into the query: callers check
+// them against ColumnsContext.
+type ListQuery struct {
+	Filter FilterQuery
+	Sort   []SortField
+	Limit  int
+}
+
+func buildOrder(sort []SortField) string {
+	if len(sort) == 0 {
+		return ""
+	}
+	order := make([]string, len(sort))
+	for i, field := range sort {
+		order[i] = field.Column
+		if field.Desc {
+			order[i] += " DESC"
+		}
+	}
+	return "ORDER BY " + strings.Join(order, ", ")
+}
+
+// Rows iterates over the result of QueryRowsContext one row at a time, so
+// that large results are never held in memory. It must be closed.
+type Rows struct {
+	rows     *sql.Rows
+	columns  []string
+	colTypes []*sql.ColumnType
+	row      [][]byte
+	rowPtr   []any
+	observer *queryObserver
+	err      error
+}
+
+func QueryRows(table string, query ListQuery) (*Rows, error) {
+	return QueryRowsContext(context.Background(), table, query)
+}
+
+// QueryRowsContext starts a query and returns an iterator over its rows.
+// Unlike the other queries it has no timeout of its own: the rows are read
+// for as long as ctx lives.
+func QueryRowsContext(ctx context.Context, table string, query ListQuery) (_ *Rows, err error) {
+	ctx, observer := startQuery(ctx, table, "select")
+	defer func() {
+		if err != nil {
+			observer.finish(&err)
+		}
+	}()
+
+	queryFilter, args, err := buildFilter(query.Filter)
+	if err != nil {
+		utils.CheckErrorContext(ctx, err, "db", "Build filter", err.Error())
+		return nil, err
+	}
+	limit := ""
+	if query.Limit > 0 {
+		limit = "LIMIT ?"
+		args = append(args, query.Limit)
+	}
+	statement := fmt.Sprintf("SELECT * FROM %v %v %v %v;", table, live(ctx, table, queryFilter), buildOrder(query.Sort), limit)
+	rows, err := conn(ctx).QueryContext(ctx, statement, args...)
+	if err != nil {
+		utils.CheckErrorContext(ctx, err, "db", "retrieve db", err.Error())
+		return nil, err
+	}
+	columns, err := rows.Columns()
+	if err != nil {
+		rows.Close()
+		utils.CheckErrorContext(ctx, err, "db", "retrieve columns", err.Error())
+		return nil, err
+	}
+	colTypes, err := rows.ColumnTypes()
+	if err != nil {
+		rows.Close()
+		utils.CheckErrorContext(ctx, err, "db", "retrieve columns", err.Error())
+		return nil, err
+	}
+	result := &Rows{
+		rows:     rows,
+		columns:  columns,
+		colTypes: colTypes,
+		row:      make([][]byte, len(columns)),
+		rowPtr:   make([]any, len(columns)),
+		observer: observer,
+	}
+	for i := range result.row {
+		result.rowPtr[i] = &result.row[i]
+	}
+	return result, nil
+}
+
+// Columns returns the column names in their declared order.
+func (r *Rows) Columns() []string {
+	return r.columns
+}
+
+// Next advances to the next row, returning false when there are no more rows
+// or an error occurred; see Err.
+func (r *Rows) Next() bool {
+	if r.err != nil || !r.rows.Next() {
+		return false
+	}
+	r.err = r.rows.Scan(r.rowPtr...)
+	if r.err != nil {
+		return false
+	}
+	r.observer.rows++
+	return true
+}
+
+// Row returns the current row, keyed by column.
+func (r *Rows) Row() map[string]any {
+	return getData(r.row, r.columns, r.colTypes)
+}
+
+func (r *Rows) Err() error {
+	if r.err != nil {
+		return r.err
+	}
+	return r.rows.Err()
+}
+
+// Close releases the cursor and records the query; it is safe to call more
+// than once.
+func (r *Rows) Close() error {
+	if r.observer == nil {
+		return nil
+	}
+	err := r.rows.Close()
+	if err == nil {
+		err = r.Err()
+	}
+	r.observer.finish(&err)
+	r.observer = nil
+	return err
+}
+
+func GetRows(table string, query ListQuery) ([]map[string]any, error) {
+	return GetRowsContext(context.Background(), table, query)
+}
+
+// GetRowsContext collects the rows selected by query.
+func GetRowsContext(ctx context.Context, table string, query ListQuery) ([]map[string]any, error) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	rows, err := QueryRowsContext(ctx, table, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := []map[string]any{}
+	for rows.Next() {
+		result = append(result, rows.Row())
+	}
+	if err := rows.Close(); err != nil {
+		utils.CheckErrorContext(ctx, err, "db", "Retrieve data", "error during data retrieval")
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,236 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	utils "github.com/riszkymf/golang-rest-boilerplate/internal/src"
+)
+
+// Tables with a deleted_at column keep their rows on delete: DeleteData sets
+// deleted_at, the reads leave such rows out unless the context says
+// otherwise, RestoreData brings them back and PurgeDeleted removes them for
+// good.
+func softDeletes(ctx context.Context, table string) bool {
+	return hasColumn(ctx, table, "deleted_at")
+}
+
+type includeDeletedKey struct{}
+
+// WithDeleted returns a context whose reads and updates also see the
+// soft-deleted rows.
+func WithDeleted(ctx context.Context) context.Context {
+	return context.WithValue(ctx, includeDeletedKey{}, true)
+}
+
+// IncludesDeleted reports whether ctx was returned by WithDeleted.
+func IncludesDeleted(ctx context.Context) bool {
+	include, _ := ctx.Value(includeDeletedKey{}).(bool)
+	return include
+}
+
+// IsDeleted reports whether a row read with WithDeleted is soft-deleted.
+func IsDeleted(row map[string]any) bool {
+	deletedAt, _ := row["deleted_at"].(string)
+	return deletedAt != ""
+}
+
+// live restricts the WHERE clause where, possibly empty, to the rows that are
+// not soft-deleted, unless ctx includes them.
+func live(ctx context.Context, table string, where string) string {
+	if IncludesDeleted(ctx) || !softDeletes(ctx, table) {
+		return where
+	}
+	if where == "" {
+		return "WHERE deleted_at IS NULL"
+	}
+	return where + " AND deleted_at IS NULL"
+}
+
+// deleteStatement deletes the rows of table matching condition, marking them
+// deleted when the table soft deletes. args come before the condition's.
+func deleteStatement(ctx context.Context, table string, condition string) (query string, args []any) {
+	if !softDeletes(ctx, table) {
+		return fmt.Sprintf("DELETE FROM %v WHERE %v;", table, condition), nil
+	}
+	now := timestamp(time.Now())
+	assignments := "deleted_at=?"
+	args = []any{now}
+	if versioned(ctx, table) {
+		assignments += ", version=version+1, updated_at=?"
+		args = append(args, now)
+	}
+	return fmt.Sprintf("UPDATE %v SET %v WHERE (%v) AND deleted_at IS NULL;", table, assignments, condition), args
+}
+
+func RestoreData(table string, id int) error {
+	return RestoreDataContext(context.Background(), table, id)
+}
+
+// RestoreDataContext undoes the soft delete of the row id. Restoring a row
+// that is not deleted does nothing; ErrNotFound means there is no such row.
+func RestoreDataContext(ctx context.Context, table string, id int) (err error) {
+	if auditInTransaction(ctx, table) {
+		return WithTransaction(ctx, func(ctx context.Context) error {
+			return RestoreDataContext(ctx, table, id)
+		})
+	}
+	ctx, observer := startQuery(ctx, table, "restore")
+	defer observer.finish(&err)
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if !softDeletes(ctx, table) {
+		return fmt.Errorf("table %v has no deleted_at column", table)
+	}
+	assignments := "deleted_at=NULL"
+	args := []any{}
+	if versioned(ctx, table) {
+		assignments += ", version=version+1, updated_at=?"
+		args = append(args, timestamp(time.Now()))
+	}
+	before, err := snapshot(ctx, table, id)
+	if err != nil {
+		return err
+	}
+	query := fmt.Sprintf("UPDATE %v SET %v WHERE id=? AND deleted_at IS NOT NULL;", table, assignments)
+	res, err := conn(ctx).ExecContext(ctx, query, append(args, id)...)
+	if err != nil {
+		utils.CheckErrorContext(ctx, err, "db", "restore data", err.Error())
+		return err
+	}
+	affected, _ := res.RowsAffected()
+	observer.rows = int(affected)
+	if affected > 0 {
+		after, err := snapshot(ctx, table, id)
+		if err != nil {
+			return err
+		}
+		return audit(ctx, table, id, ActionRestore, before, after)
+	}
+	var exist int
+	query = fmt.Sprintf("SELECT COUNT(*) FROM %v WHERE id=?;", table)
+	if err = conn(ctx).QueryRowContext(ctx, query, id).Scan(&exist); err != nil {
+		utils.CheckErrorContext(ctx, err, "db", "restore data", err.Error())
+		return err
+	}
+	if exist == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func PurgeDeleted(table string, before time.Time) (int, error) {
+	return PurgeDeletedContext(context.Background(), table, before)
+}
+
+// PurgeDeletedContext removes for good the rows of table soft-deleted before
+// before and returns how many there were.
+func PurgeDeletedContext(ctx context.Context, table string, before time.Time) (purged int, err error) {
+	if auditInTransaction(ctx, table) {
+		err = WithTransaction(ctx, func(ctx context.Context) error {
+			purged, err = PurgeDeletedContext(ctx, table, before)
+			return err
+		})
+		return purged, err
+	}
+	ctx, observer := startQuery(ctx, table, "purge")
+	defer observer.finish(&err)
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if !softDeletes(ctx, table) {
+		return 0, fmt.Errorf("table %v has no deleted_at column", table)
+	}
+	condition := "WHERE deleted_at IS NOT NULL AND deleted_at < ?"
+	var rows []map[string]any
+	if audited(ctx, table) {
+		rows, err = GetRowByFilterContext(WithDeleted(ctx), table, FilterQuery{
+			And: map[string][]FieldFilter{
+				"deleted_at": {{Operator: "lt", Value: timestamp(before), ValueType: "string"}},
+			},
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	query := fmt.Sprintf("DELETE FROM %v %v;", table, condition)
+	res, err := conn(ctx).ExecContext(ctx, query, timestamp(before))
+	if err != nil {
+		utils.CheckErrorContext(ctx, err, "db", "purge deleted data", err.Error())
+		return 0, err
+	}
+	affected, _ := res.RowsAffected()
+	observer.rows = int(affected)
+	for _, row := range rows {
+		id, _ := row["id"].(int)
+		if err = audit(ctx, table, id, ActionPurge, row, nil); err != nil {
+			return 0, err
+		}
+	}
+	return int(affected), nil
+}
+
+func SoftDeleteTables() ([]string, error) {
+	return SoftDeleteTablesContext(context.Background())
+}
+
+// SoftDeleteTablesContext returns the tables with a deleted_at column, each
+// after the tables referencing it by foreign key, so that purging them in
+// order removes the rows referencing a row before the row itself.
+func SoftDeleteTablesContext(ctx context.Context) (tables []string, err error) {
+	ctx, observer := startQuery(ctx, "sqlite_master", "select")
+	defer observer.finish(&err)
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	rows, err := conn(ctx).QueryContext(ctx, `SELECT m.name, f."table" FROM sqlite_master m LEFT JOIN pragma_foreign_key_list(m.name) f WHERE m.type = 'table' ORDER BY m.name;`)
+	if err != nil {
+		utils.CheckErrorContext(ctx, err, "db", "list tables", err.Error())
+		return nil, err
+	}
+	defer rows.Close()
+	var names []string
+	referencedBy := map[string][]string{}
+	for rows.Next() {
+		var name string
+		var references sql.NullString
+		if err = rows.Scan(&name, &references); err != nil {
+			return nil, err
+		}
+		if len(names) == 0 || names[len(names)-1] != name {
+			names = append(names, name)
+		}
+		if references.Valid && references.String != name {
+			referencedBy[references.String] = append(referencedBy[references.String], name)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	// Each table is listed once those referencing it, directly or through
+	// tables that do not soft delete, are.
+	visited := map[string]bool{}
+	var visit func(table string)
+	visit = func(table string) {
+		if visited[table] {
+			return
+		}
+		visited[table] = true
+		for _, referencing := range referencedBy[table] {
+			visit(referencing)
+		}
+		if softDeletes(ctx, table) {
+			tables = append(tables, table)
+		}
+	}
+	for _, name := range names {
+		visit(name)
+	}
+	observer.rows = len(tables)
+	return tables, nil
+}
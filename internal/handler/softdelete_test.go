@@ -0,0 +1,86 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/riszkymf/golang-rest-boilerplate/internal/testdb"
+)
+
+func TestSoftDelete(t *testing.T) {
+	Connection = testdb.Open(t)
+	ctx := context.Background()
+
+	authorId, _ := InsertData("author", map[string]any{"name": "Herman Melville"})
+	bookId, err := InsertData("books", map[string]any{"title": "Typee", "author_id": authorId})
+	if err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	if books, _ := GetRowsContext(ctx, "v_books", ListQuery{}); len(books) != 1 || books[0]["available"] != 0 {
+		t.Errorf("book without copies should have none available, got %v", books)
+	}
+	if err := DeleteData("books", bookId); err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	if row, _ := GetRowById("books", bookId); len(row) != 0 {
+		t.Errorf("deleted book should not be read, got %v", row)
+	}
+	if books, _ := GetRowsContext(ctx, "v_books", ListQuery{}); len(books) != 0 {
+		t.Errorf("deleted book should not be in v_books, got %v", books)
+	}
+	row, _ := GetRowByIdContext(WithDeleted(ctx), "books", bookId)
+	if !IsDeleted(row) || row["version"] != 2 {
+		t.Fatalf("deleted book should be kept with deleted_at set, got %v", row)
+	}
+	if err := UpdateDataIfVersion("books", map[string]any{"title": "Omoo"}, bookId, 2); !errors.Is(err, ErrNotFound) {
+		t.Errorf("deleted book should not be updated, got %v", err)
+	}
+
+	if err := RestoreData("books", bookId); err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	if row, _ := GetRowById("books", bookId); IsDeleted(row) || row["version"] != 3 {
+		t.Errorf("restored book should be read again, got %v", row)
+	}
+	if err := RestoreData("books", bookId+1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing book should not be restored, got %v", err)
+	}
+
+	DeleteData("books", bookId)
+	if purged, err := PurgeDeleted("books", time.Now().Add(-time.Hour)); err != nil || purged != 0 {
+		t.Errorf("recently deleted book should be kept, got %v %v", purged, err)
+	}
+	if purged, err := PurgeDeleted("books", time.Now().Add(time.Hour)); err != nil || purged != 1 {
+		t.Errorf("deleted book should be purged, got %v %v", purged, err)
+	}
+	if row, _ := GetRowByIdContext(WithDeleted(ctx), "books", bookId); len(row) != 0 {
+		t.Errorf("purged book should be gone, got %v", row)
+	}
+}
+
+func TestSoftDeleteTables(t *testing.T) {
+	Connection = testdb.Open(t)
+	tables, err := SoftDeleteTables()
+	if err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	position := map[string]int{}
+	for i, table := range tables {
+		position[table] = i
+	}
+	for _, table := range []string{"author", "books", "branches", "copies", "members", "records"} {
+		if _, exist := position[table]; !exist {
+			t.Errorf("%v soft deletes and should be listed, got %v", table, tables)
+		}
+	}
+	if _, exist := position["audit_log"]; exist {
+		t.Errorf("audit_log does not soft delete and should not be listed, got %v", tables)
+	}
+	for _, pair := range [][2]string{{"records", "copies"}, {"records", "members"}, {"copies", "books"}, {"books", "author"}} {
+		if position[pair[0]] > position[pair[1]] {
+			t.Errorf("%v references %v and should come first, got %v", pair[0], pair[1], tables)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	utils "github.com/riszkymf/golang-rest-boilerplate/internal/src"
+)
+
+// queryer is what the handler functions need from *sql.DB and *sql.Tx.
+type queryer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+type txKey struct{}
+
+type databaseKey struct{}
+
+// WithDatabase returns a context whose handler functions query db instead of
+// Connection, e.g. the database of a tenant. It leaves any transaction of ctx
+// behind, which belongs to another database.
+func WithDatabase(ctx context.Context, db *sql.DB) context.Context {
+	ctx = context.WithValue(ctx, txKey{}, nil)
+	return context.WithValue(ctx, databaseKey{}, db)
+}
+
+// Database returns the database queried with ctx: that given to WithDatabase,
+// or Connection.
+func Database(ctx context.Context) *sql.DB {
+	if db, ok := ctx.Value(databaseKey{}).(*sql.DB); ok {
+		return db
+	}
+	return Connection
+}
+
+// Detach returns a context without the deadline and cancellation of ctx that
+// queries the same database, for the work that must outlive ctx.
+func Detach(ctx context.Context) context.Context {
+	return WithDatabase(context.Background(), Database(ctx))
+}
+
+// conn returns the transaction carried by ctx, if any, or its database.
+func conn(ctx context.Context) queryer {
+	if tx, ok := ctx.Value(txKey{}).(*sql.Tx); ok {
+		return tx
+	}
+	return Database(ctx)
+}
+
+// InTransaction reports whether ctx carries a transaction started by
+// WithTransaction.
+func InTransaction(ctx context.Context) bool {
+	_, ok := ctx.Value(txKey{}).(*sql.Tx)
+	return ok
+}
+
+// WithTransaction runs fn in a transaction: every handler function called
+// with the context given to fn joins it. The transaction commits when fn
+// returns nil and rolls back when it returns an error or panics. Nested calls
+// join the outer transaction.
+func WithTransaction(ctx context.Context, fn func(ctx context.Context) error) (err error) {
+	if InTransaction(ctx) {
+		return fn(ctx)
+	}
+	ctx, observer := startQuery(ctx, "", "transaction")
+	defer observer.finish(&err)
+
+	tx, err := Database(ctx).BeginTx(ctx, nil)
+	if err != nil {
+		utils.CheckErrorContext(ctx, err, "db", "begin transaction", err.Error())
+		return err
+	}
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			tx.Rollback()
+			err = fmt.Errorf("transaction rolled back: %v", recovered)
+			panic(recovered)
+		}
+	}()
+
+	err = fn(context.WithValue(ctx, txKey{}, tx))
+	if err != nil {
+		if rollbackErr := tx.Rollback(); rollbackErr != nil {
+			utils.CheckErrorContext(ctx, rollbackErr, "db", "rollback transaction", rollbackErr.Error())
+		}
+		return err
+	}
+	err = tx.Commit()
+	if err != nil {
+		utils.CheckErrorContext(ctx, err, "db", "commit transaction", err.Error())
+	}
+	return err
+}
@@ -0,0 +1,45 @@
+package handler
+
+import (
+	"context"
+	"testing"
+
+	"github.com/riszkymf/golang-rest-boilerplate/internal/testdb"
+)
+
+func TestWithDatabase(t *testing.T) {
+	Connection = testdb.Open(t)
+	other := Connection
+	Connection = testdb.Open(t)
+	ctx := WithDatabase(context.Background(), other)
+
+	if _, err := InsertDataContext(ctx, "author", map[string]any{"name": "Ursula K. Le Guin"}); err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	if count, _ := CountRowsContext(ctx, "author", FilterQuery{}); count != 1 {
+		t.Errorf("the row should be in the database of the context, got %v rows", count)
+	}
+	if count, _ := CountRows("author", FilterQuery{}); count != 0 {
+		t.Errorf("Connection should not see the row, got %v rows", count)
+	}
+
+	err := WithTransaction(context.Background(), func(txCtx context.Context) error {
+		if _, err := InsertDataContext(WithDatabase(txCtx, other), "author", map[string]any{"name": "Octavia E. Butler"}); err != nil {
+			return err
+		}
+		_, err := InsertDataContext(txCtx, "author", map[string]any{"name": "N. K. Jemisin"})
+		return err
+	})
+	if err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	if count, _ := CountRowsContext(ctx, "author", FilterQuery{}); count != 2 {
+		t.Errorf("a transaction should stay in its database, got %v rows in the other", count)
+	}
+	if count, _ := CountRows("author", FilterQuery{}); count != 1 {
+		t.Errorf("a transaction should stay in its database, got %v rows in Connection", count)
+	}
+	if Database(Detach(ctx)) != other {
+		t.Errorf("Detach should keep the database")
+	}
+}
@@ -0,0 +1,155 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+
+	utils "github.com/riszkymf/golang-rest-boilerplate/internal/src"
+)
+
+// IsConflict reports whether err is the violation of a UNIQUE or PRIMARY KEY
+// constraint.
+func IsConflict(err error) bool {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+	return sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique || sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey
+}
+
+func Upsert(table string, data map[string]any, conflictColumns []string, updateColumns []string) (int, error) {
+	return UpsertContext(context.Background(), table, data, conflictColumns, updateColumns)
+}
+
+// UpsertContext inserts data or, when a row with the same conflictColumns
+// exists, sets its updateColumns from data; with no updateColumns the
+// existing row is left as is, though restored if it was soft-deleted.
+// conflictColumns must match a UNIQUE constraint and be part of data. It
+// returns the id of the inserted or existing row.
+func UpsertContext(ctx context.Context, table string, data map[string]any, conflictColumns []string, updateColumns []string) (id int, err error) {
+	if auditInTransaction(ctx, table) {
+		err = WithTransaction(ctx, func(ctx context.Context) error {
+			id, err = UpsertContext(ctx, table, data, conflictColumns, updateColumns)
+			return err
+		})
+		return id, err
+	}
+	ctx, observer := startQuery(ctx, table, "upsert")
+	defer observer.finish(&err)
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	if len(conflictColumns) == 0 {
+		return 0, errors.New("upsert needs conflict columns")
+	}
+	conflictFilter := []string{}
+	conflictArgs := []any{}
+	for _, column := range conflictColumns {
+		value, exist := data[column]
+		if !exist {
+			return 0, fmt.Errorf("conflict column %v is missing from the data", column)
+		}
+		conflictFilter = append(conflictFilter, column+"=?")
+		conflictArgs = append(conflictArgs, value)
+	}
+
+	now := timestamp(time.Now())
+	isVersioned := versioned(ctx, table)
+	fields := make([]string, 0, len(data)+1)
+	for k := range data {
+		fields = append(fields, k)
+	}
+	if _, exist := data["updated_at"]; !exist && isVersioned {
+		fields = append(fields, "updated_at")
+	}
+	sort.Strings(fields)
+	values := make([]string, len(fields))
+	args := make([]any, len(fields))
+	for i, k := range fields {
+		values[i] = "?"
+		args[i] = data[k]
+		if _, exist := data[k]; !exist {
+			args[i] = now
+		}
+	}
+
+	// A soft-deleted row with the same key is brought back either way.
+	assignments := []string{}
+	for _, column := range updateColumns {
+		assignments = append(assignments, fmt.Sprintf("%v=excluded.%v", column, column))
+	}
+	condition := ""
+	if softDeletes(ctx, table) {
+		if len(assignments) == 0 {
+			condition = " WHERE deleted_at IS NOT NULL"
+		}
+		assignments = append(assignments, "deleted_at=NULL")
+	}
+	action := "NOTHING"
+	if len(assignments) > 0 {
+		if isVersioned {
+			assignments = append(assignments, "version=version+1", "updated_at=?")
+			args = append(args, now)
+		}
+		action = "UPDATE SET " + strings.Join(assignments, ",") + condition
+	}
+	var before map[string]any
+	if audited(ctx, table) {
+		var existing int
+		query := fmt.Sprintf("SELECT id FROM %v WHERE %v;", table, strings.Join(conflictFilter, " AND "))
+		err = conn(ctx).QueryRowContext(ctx, query, conflictArgs...).Scan(&existing)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			utils.CheckErrorContext(ctx, err, "db", "upsert data to db", err.Error())
+			return 0, err
+		}
+		if before, err = snapshot(ctx, table, existing); err != nil {
+			return 0, err
+		}
+	}
+	query := fmt.Sprintf("INSERT INTO %v (%v) VALUES (%v) ON CONFLICT(%v) DO %v RETURNING id;",
+		table, strings.Join(fields, ","), strings.Join(values, ","), strings.Join(conflictColumns, ","), action)
+	err = conn(ctx).QueryRowContext(ctx, query, args...).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		// DO NOTHING returns no row, the existing one is looked up.
+		query = fmt.Sprintf("SELECT id FROM %v WHERE %v;", table, strings.Join(conflictFilter, " AND "))
+		err = conn(ctx).QueryRowContext(ctx, query, conflictArgs...).Scan(&id)
+	}
+	if err != nil {
+		utils.CheckErrorContext(ctx, err, "db", "upsert data to db", err.Error())
+		return 0, err
+	}
+	observer.rows = 1
+	after, err := snapshot(ctx, table, id)
+	if err != nil {
+		return 0, err
+	}
+	change := ActionUpdate
+	if before == nil {
+		change = ActionInsert
+	}
+	if err = audit(ctx, table, id, change, before, after); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func UpsertRow(table string, data map[string]any, conflictColumns []string, updateColumns []string) (map[string]any, error) {
+	return UpsertRowContext(context.Background(), table, data, conflictColumns, updateColumns)
+}
+
+// UpsertRowContext is UpsertContext returning the resulting row.
+func UpsertRowContext(ctx context.Context, table string, data map[string]any, conflictColumns []string, updateColumns []string) (map[string]any, error) {
+	id, err := UpsertContext(ctx, table, data, conflictColumns, updateColumns)
+	if err != nil {
+		return nil, err
+	}
+	return GetRowByIdContext(ctx, table, id)
+}
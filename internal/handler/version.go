@@ -0,0 +1,124 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	utils "github.com/riszkymf/golang-rest-boilerplate/internal/src"
+)
+
+var (
+	ErrNotFound        = errors.New("row not found")
+	ErrVersionMismatch = errors.New("row was modified since it was read")
+)
+
+// Tables with a version column are versioned: the handler increments version
+// and sets updated_at on every update, which lets UpdateDataIfVersion and
+// DeleteDataIfVersion detect concurrent changes.
+func versioned(ctx context.Context, table string) bool {
+	return hasColumn(ctx, table, "version")
+}
+
+// tableColumns caches the columns of each table of each database, the schema
+// only changing with the migrations applied when the database is opened. A
+// missing table has none, and is not cached, for a migration creating it
+// afterwards to be seen.
+var tableColumns sync.Map
+
+type tableKey struct {
+	db    *sql.DB
+	table string
+}
+
+func hasColumn(ctx context.Context, table string, column string) bool {
+	key := tableKey{Database(ctx), table}
+	known, ok := tableColumns.Load(key)
+	if !ok {
+		columns, err := ColumnsContext(ctx, table)
+		if err != nil {
+			return false
+		}
+		set := map[string]bool{}
+		for _, name := range columns {
+			set[name] = true
+		}
+		known, _ = tableColumns.LoadOrStore(key, set)
+	}
+	return known.(map[string]bool)[column]
+}
+
+// timestamp formats t like SQLite's CURRENT_TIMESTAMP.
+func timestamp(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04:05")
+}
+
+func UpdateDataIfVersion(table string, inputData map[string]any, id int, version int) error {
+	return UpdateDataIfVersionContext(context.Background(), table, inputData, id, version)
+}
+
+// UpdateDataIfVersionContext updates the row only if its version is still
+// version, failing with ErrVersionMismatch otherwise and ErrNotFound if there
+// is no such row.
+func UpdateDataIfVersionContext(ctx context.Context, table string, inputData map[string]any, id int, version int) error {
+	return updateData(ctx, table, inputData, id, &version)
+}
+
+func DeleteDataIfVersion(table string, id int, version int) error {
+	return DeleteDataIfVersionContext(context.Background(), table, id, version)
+}
+
+// DeleteDataIfVersionContext deletes the row only if its version is still
+// version, failing like UpdateDataIfVersionContext otherwise.
+func DeleteDataIfVersionContext(ctx context.Context, table string, id int, version int) (err error) {
+	if auditInTransaction(ctx, table) {
+		return WithTransaction(ctx, func(ctx context.Context) error {
+			return DeleteDataIfVersionContext(ctx, table, id, version)
+		})
+	}
+	ctx, observer := startQuery(ctx, table, "delete")
+	defer observer.finish(&err)
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if !versioned(ctx, table) {
+		return fmt.Errorf("table %v has no version column", table)
+	}
+	before, err := snapshot(ctx, table, id)
+	if err != nil {
+		return err
+	}
+	query, args := deleteStatement(ctx, table, "id=? AND version=?")
+	res, err := conn(ctx).ExecContext(ctx, query, append(args, id, version)...)
+	if err != nil {
+		utils.CheckErrorContext(ctx, err, "db", "delete data from db", err.Error())
+		return err
+	}
+	affected, _ := res.RowsAffected()
+	observer.rows = int(affected)
+	if affected == 0 {
+		return checkVersion(ctx, table, id, version)
+	}
+	return auditDelete(ctx, table, id, before)
+}
+
+// checkVersion explains why a conditional statement affected no row.
+func checkVersion(ctx context.Context, table string, id int, version int) error {
+	var current int
+	query := fmt.Sprintf("SELECT version FROM %v %v;", table, live(ctx, table, "WHERE id=?"))
+	err := conn(ctx).QueryRowContext(ctx, query, id).Scan(&current)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		utils.CheckErrorContext(ctx, err, "db", "check version", err.Error())
+		return err
+	}
+	if current != version {
+		return ErrVersionMismatch
+	}
+	return nil
+}
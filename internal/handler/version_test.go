@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/riszkymf/golang-rest-boilerplate/internal/testdb"
+)
+
+func TestVersions(t *testing.T) {
+	Connection = testdb.Open(t)
+
+	id, err := InsertData("author", map[string]any{"name": "Herman Melville"})
+	if err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	row, _ := GetRowById("author", id)
+	if row["version"] != 1 || row["updated_at"] == nil {
+		t.Fatalf("new row should be at version 1 with updated_at, got %v", row)
+	}
+
+	if err := UpdateData("author", map[string]any{"name": "H. Melville", "version": 7}, id); err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	row, _ = GetRowById("author", id)
+	if row["version"] != 2 || row["name"] != "H. Melville" {
+		t.Errorf("update should bump the version and ignore the given one, got %v", row)
+	}
+
+	err = UpdateDataIfVersion("author", map[string]any{"name": "Melville"}, id, 1)
+	if !errors.Is(err, ErrVersionMismatch) {
+		t.Errorf("stale version should fail with ErrVersionMismatch, got %v", err)
+	}
+	if err := UpdateDataIfVersion("author", map[string]any{"name": "Melville"}, id, 2); err != nil {
+		t.Errorf("current version should update, got %v", err)
+	}
+	if err := UpdateDataIfVersion("author", map[string]any{"name": "Melville"}, id+1, 1); !errors.Is(err, ErrNotFound) {
+		t.Errorf("missing row should fail with ErrNotFound, got %v", err)
+	}
+
+	if err := DeleteDataIfVersion("author", id, 2); !errors.Is(err, ErrVersionMismatch) {
+		t.Errorf("stale version should not delete, got %v", err)
+	}
+	if err := DeleteDataIfVersion("author", id, 3); err != nil {
+		t.Errorf("current version should delete, got %v", err)
+	}
+	if err := DeleteDataIfVersion("author", id, 3); !errors.Is(err, ErrNotFound) {
+		t.Errorf("deleted row should be not found, got %v", err)
+	}
+}
+
+func TestColumnsOfMissingTable(t *testing.T) {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "empty.sqlite"))
+	if err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	defer db.Close()
+	ctx := WithDatabase(context.Background(), db)
+	if versioned(ctx, "author") {
+		t.Errorf("missing table should have no version column")
+	}
+	if _, err := db.Exec("CREATE TABLE author (id INTEGER PRIMARY KEY, name TEXT, version INTEGER);"); err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	if !versioned(ctx, "author") {
+		t.Errorf("table created after it was looked for should be seen")
+	}
+}
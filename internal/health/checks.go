@@ -0,0 +1,106 @@
+package health
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/riszkymf/golang-rest-boilerplate/internal/migration"
+)
+
+var errNoDatabase = errors.New("database connection is not initialized")
+
+var errNotSupported = errors.New("not supported on this platform")
+
+// DatabaseChecker pings the connection and reads the schema so a locked or
+// unreadable SQLite file is reported, not only a closed pool.
+func DatabaseChecker(connection func() *sql.DB) Checker {
+	return CheckerFunc(func(ctx context.Context) error {
+		db := connection()
+		if db == nil {
+			return errNoDatabase
+		}
+		if err := db.PingContext(ctx); err != nil {
+			return err
+		}
+		var tables int
+		return db.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master;").Scan(&tables)
+	})
+}
+
+func MigrationChecker(connection func() *sql.DB) Checker {
+	return CheckerFunc(func(ctx context.Context) error {
+		db := connection()
+		if db == nil {
+			return errNoDatabase
+		}
+		pending, err := migration.Pending(ctx, db)
+		if err != nil {
+			return err
+		}
+		if len(pending) > 0 {
+			versions := []string{}
+			for _, m := range pending {
+				versions = append(versions, m.Version+"_"+m.Name)
+			}
+			return fmt.Errorf("pending migrations: %v", strings.Join(versions, ", "))
+		}
+		return nil
+	})
+}
+
+// DiskSpaceChecker fails when the database file is missing or its volume
+// has less than minFree bytes available.
+func DiskSpaceChecker(dbPath string, minFree uint64) Checker {
+	return CheckerFunc(func(ctx context.Context) error {
+		if _, err := os.Stat(dbPath); err != nil {
+			return err
+		}
+		free, err := freeSpace(filepath.Dir(dbPath))
+		if errors.Is(err, errNotSupported) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if free < minFree {
+			return fmt.Errorf("%d bytes free on %v, need at least %d", free, filepath.Dir(dbPath), minFree)
+		}
+		return nil
+	})
+}
+
+// WALChecker verifies SQLite can write its journal: the -wal file in WAL
+// mode, otherwise the database directory where the rollback journal lives.
+func WALChecker(connection func() *sql.DB, dbPath string) Checker {
+	return CheckerFunc(func(ctx context.Context) error {
+		db := connection()
+		if db == nil {
+			return errNoDatabase
+		}
+		var mode string
+		if err := db.QueryRowContext(ctx, "PRAGMA journal_mode;").Scan(&mode); err != nil {
+			return err
+		}
+		walPath := dbPath + "-wal"
+		if strings.EqualFold(mode, "wal") {
+			if _, err := os.Stat(walPath); err == nil {
+				file, err := os.OpenFile(walPath, os.O_WRONLY, 0)
+				if err != nil {
+					return err
+				}
+				return file.Close()
+			}
+		}
+		probe, err := os.CreateTemp(filepath.Dir(dbPath), ".health-*")
+		if err != nil {
+			return fmt.Errorf("%v journal is not writable: %w", mode, err)
+		}
+		probe.Close()
+		return os.Remove(probe.Name())
+	})
+}
@@ -0,0 +1,141 @@
+package health
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// Checker reports a dependency as unhealthy by returning an error.
+type Checker interface {
+	Check(ctx context.Context) error
+}
+
+type CheckerFunc func(ctx context.Context) error
+
+func (f CheckerFunc) Check(ctx context.Context) error {
+	return f(ctx)
+}
+
+type Result struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+type Report struct {
+	Status string   `json:"status"`
+	Checks []Result `json:"checks"`
+}
+
+func (r Report) Healthy() bool {
+	return r.Status == StatusOK
+}
+
+type Registry struct {
+	mu       sync.RWMutex
+	timeout  time.Duration
+	checkers map[string]Checker
+}
+
+func NewRegistry(timeout time.Duration) *Registry {
+	return &Registry{timeout: timeout, checkers: map[string]Checker{}}
+}
+
+var Default = NewRegistry(2 * time.Second)
+
+// Register adds or replaces the readiness checker known under name.
+func (r *Registry) Register(name string, checker Checker) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.checkers[name] = checker
+}
+
+func (r *Registry) Unregister(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.checkers, name)
+}
+
+func (r *Registry) SetTimeout(timeout time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.timeout = timeout
+}
+
+// Run executes every checker concurrently, each bounded by the registry
+// timeout, and aggregates them. The report fails if any check fails.
+func (r *Registry) Run(ctx context.Context) Report {
+	r.mu.RLock()
+	timeout := r.timeout
+	names := make([]string, 0, len(r.checkers))
+	checkers := make([]Checker, 0, len(r.checkers))
+	for name := range r.checkers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		checkers = append(checkers, r.checkers[name])
+	}
+	r.mu.RUnlock()
+
+	results := make([]Result, len(names))
+	var wg sync.WaitGroup
+	for i := range names {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = runCheck(ctx, names[i], checkers[i], timeout)
+		}(i)
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusOK, Checks: results}
+	for _, result := range results {
+		if result.Status != StatusOK {
+			report.Status = StatusFail
+		}
+	}
+	return report
+}
+
+func runCheck(ctx context.Context, name string, checker Checker, timeout time.Duration) Result {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	start := time.Now()
+	errs := make(chan error, 1)
+	go func() {
+		errs <- checker.Check(ctx)
+	}()
+	var err error
+	select {
+	case err = <-errs:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	result := Result{
+		Name:      name,
+		Status:    StatusOK,
+		LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		result.Status = StatusFail
+		result.Error = err.Error()
+	}
+	return result
+}
+
+func Register(name string, checker Checker) {
+	Default.Register(name, checker)
+}
+
+func Run(ctx context.Context) Report {
+	return Default.Run(ctx)
+}
@@ -0,0 +1,42 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRegistryRun(t *testing.T) {
+	registry := NewRegistry(50 * time.Millisecond)
+	registry.Register("database", CheckerFunc(func(ctx context.Context) error { return nil }))
+	registry.Register("disk", CheckerFunc(func(ctx context.Context) error { return errors.New("disk full") }))
+	registry.Register("slow", CheckerFunc(func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	}))
+
+	report := registry.Run(context.Background())
+	if report.Healthy() {
+		t.Fatalf("report should fail when a checker fails")
+	}
+	statuses := map[string]Result{}
+	for _, result := range report.Checks {
+		statuses[result.Name] = result
+	}
+	if statuses["database"].Status != StatusOK {
+		t.Errorf("database check should pass, got %+v", statuses["database"])
+	}
+	if statuses["disk"].Error != "disk full" {
+		t.Errorf("disk check should report its error, got %+v", statuses["disk"])
+	}
+	if statuses["slow"].Status != StatusFail || statuses["slow"].LatencyMs > 500 {
+		t.Errorf("slow check should time out, got %+v", statuses["slow"])
+	}
+
+	registry.Unregister("disk")
+	registry.Unregister("slow")
+	if report := registry.Run(context.Background()); !report.Healthy() {
+		t.Errorf("report should pass once failing checkers are removed, got %+v", report)
+	}
+}
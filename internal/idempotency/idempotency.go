@@ -0,0 +1,194 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/riszkymf/golang-rest-boilerplate/internal/handler"
+	utils "github.com/riszkymf/golang-rest-boilerplate/internal/src"
+)
+
+const (
+	Header         = "Idempotency-Key"
+	ReplayedHeader = "Idempotent-Replayed"
+	MaxKeyLength   = 255
+)
+
+const table = "idempotency_keys"
+
+// Entry is the response stored for a key, pending while the first request
+// with the key is being processed.
+type Entry struct {
+	Id          int
+	Key         string
+	RequestHash string
+	Status      int
+	ContentType string
+	Body        string
+}
+
+func (e Entry) Pending() bool {
+	return e.Status == 0
+}
+
+// Store keeps the responses of requests sent with an idempotency key in the
+// idempotency_keys table for ttl.
+type Store struct {
+	mu  sync.Mutex
+	ttl time.Duration
+}
+
+func NewStore(ttl time.Duration) *Store {
+	return &Store{ttl: ttl}
+}
+
+var Default = NewStore(24 * time.Hour)
+
+func (s *Store) SetTTL(ttl time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ttl = ttl
+}
+
+func (s *Store) TTL() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ttl
+}
+
+// Hash identifies a request by its method, URI, content type and body, so
+// that a key reused for another request is detected.
+func Hash(request *http.Request, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(request.Method + "\n" + request.URL.RequestURI() + "\n" + request.Header.Get("Content-Type") + "\n"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Begin claims key for a request. When claimed is true the request should be
+// processed and its outcome given to Complete or Release; otherwise entry is
+// what is stored for the key, which may belong to a different request.
+func (s *Store) Begin(ctx context.Context, key string, requestHash string) (entry Entry, claimed bool, err error) {
+	// Two attempts: a concurrent request may claim the key between the
+	// lookup and the insert.
+	for attempt := 0; attempt < 2; attempt++ {
+		now := time.Now()
+		entry, exist, err := s.lookup(ctx, key, now)
+		if err != nil || exist {
+			return entry, false, err
+		}
+		id, err := handler.InsertDataContext(ctx, table, map[string]any{
+			"idempotency_key": key,
+			"request_hash":    requestHash,
+			"created_at":      now.Unix(),
+			"expires_at":      now.Add(s.TTL()).Unix(),
+		})
+		if err == nil {
+			return Entry{Id: id, Key: key, RequestHash: requestHash}, true, nil
+		}
+		if !handler.IsConflict(err) {
+			return Entry{}, false, err
+		}
+	}
+	return Entry{}, false, errors.New("idempotency key claimed concurrently, retry")
+}
+
+// lookup returns the entry stored for key, deleting it if it expired.
+func (s *Store) lookup(ctx context.Context, key string, now time.Time) (Entry, bool, error) {
+	rows, err := handler.GetRowByFilterContext(ctx, table, handler.FilterQuery{
+		And: map[string][]handler.FieldFilter{
+			"idempotency_key": {{Operator: "eq", Value: key, ValueType: "string"}},
+		},
+	})
+	if err != nil || len(rows) == 0 {
+		return Entry{}, false, err
+	}
+	row := rows[0]
+	entry := Entry{Key: key}
+	entry.Id, _ = row["id"].(int)
+	entry.RequestHash, _ = row["request_hash"].(string)
+	entry.Status, _ = row["status"].(int)
+	entry.ContentType, _ = row["content_type"].(string)
+	entry.Body, _ = row["body"].(string)
+	expiresAt, _ := row["expires_at"].(int)
+	if int64(expiresAt) > now.Unix() {
+		return entry, true, nil
+	}
+	return Entry{}, false, handler.DeleteDataContext(ctx, table, entry.Id)
+}
+
+// Complete stores the response of a claimed entry for replay.
+func (s *Store) Complete(ctx context.Context, entry Entry, status int, contentType string, body []byte) error {
+	return handler.UpdateDataContext(ctx, table, map[string]any{
+		"status":       status,
+		"content_type": contentType,
+		"body":         string(body),
+	}, entry.Id)
+}
+
+// Release forgets a claimed entry, so that the request can be retried.
+func (s *Store) Release(ctx context.Context, entry Entry) error {
+	return handler.DeleteDataContext(ctx, table, entry.Id)
+}
+
+// Sweep deletes the expired entries and returns how many there were.
+func (s *Store) Sweep(ctx context.Context) (int, error) {
+	return handler.DeleteByFilterContext(ctx, table, handler.FilterQuery{
+		And: map[string][]handler.FieldFilter{
+			"expires_at": {{Operator: "lt", Value: strconv.FormatInt(time.Now().Unix(), 10), ValueType: "int"}},
+		},
+	})
+}
+
+// RunSweeper sweeps every interval until ctx is done.
+func (s *Store) RunSweeper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			swept, err := s.Sweep(ctx)
+			if err != nil {
+				utils.CheckErrorContext(ctx, err, "[idempotency]", "sweep expired keys")
+				continue
+			}
+			if swept > 0 {
+				utils.LogInfo("[idempotency]", "sweep expired keys", strconv.Itoa(swept)+" keys deleted")
+			}
+		}
+	}
+}
+
+// Recorder copies the body written through it, for Complete.
+type Recorder struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func NewRecorder(w http.ResponseWriter) *Recorder {
+	return &Recorder{ResponseWriter: w}
+}
+
+func (r *Recorder) Write(p []byte) (int, error) {
+	r.body.Write(p)
+	return r.ResponseWriter.Write(p)
+}
+
+func (r *Recorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (r *Recorder) Body() []byte {
+	return r.body.Bytes()
+}
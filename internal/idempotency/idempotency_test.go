@@ -0,0 +1,60 @@
+package idempotency
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/riszkymf/golang-rest-boilerplate/internal/handler"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/testdb"
+)
+
+func TestStore(t *testing.T) {
+	handler.Connection = testdb.Open(t)
+	ctx := context.Background()
+	store := NewStore(time.Hour)
+
+	entry, claimed, err := store.Begin(ctx, "k1", "hash")
+	if err != nil || !claimed {
+		t.Fatalf("first request should claim the key, got %v %v", claimed, err)
+	}
+	pending, claimed, _ := store.Begin(ctx, "k1", "hash")
+	if claimed || !pending.Pending() {
+		t.Errorf("key should be pending until completed, got %+v", pending)
+	}
+	if err := store.Complete(ctx, entry, 201, "application/json", []byte(`{"id":1}`)); err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	stored, claimed, _ := store.Begin(ctx, "k1", "other")
+	if claimed || stored.Status != 201 || stored.Body != `{"id":1}` || stored.RequestHash != "hash" {
+		t.Errorf("completed response should be returned, got %+v", stored)
+	}
+
+	released, _, _ := store.Begin(ctx, "k2", "hash")
+	store.Release(ctx, released)
+	if _, claimed, _ := store.Begin(ctx, "k2", "hash"); !claimed {
+		t.Errorf("released key should be claimable again")
+	}
+
+	store.SetTTL(-time.Second)
+	store.Begin(ctx, "k3", "hash")
+	if swept, err := store.Sweep(ctx); err != nil || swept != 1 {
+		t.Errorf("expired key should be swept, got %v %v", swept, err)
+	}
+	if _, claimed, _ := store.Begin(ctx, "k3", "hash"); !claimed {
+		t.Errorf("expired key should be claimable again")
+	}
+}
+
+func TestHash(t *testing.T) {
+	request := httptest.NewRequest("POST", "/books", strings.NewReader(""))
+	other := httptest.NewRequest("POST", "/books?on_conflict=update", strings.NewReader(""))
+	if Hash(request, []byte("a")) != Hash(request, []byte("a")) {
+		t.Errorf("hash should be stable")
+	}
+	if Hash(request, []byte("a")) == Hash(request, []byte("b")) || Hash(request, []byte("a")) == Hash(other, []byte("a")) {
+		t.Errorf("hash should depend on the body and the URI")
+	}
+}
@@ -0,0 +1,196 @@
+package importer
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"sort"
+	"strings"
+
+	"github.com/riszkymf/golang-rest-boilerplate/internal/encoding"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/handler"
+)
+
+// Row is one record of an import body, keyed by column, with the line it
+// was read from.
+type Row struct {
+	Line   int
+	Values map[string]string
+}
+
+// Value returns the trimmed value of a column.
+func (r Row) Value(column string) string {
+	return strings.TrimSpace(r.Values[column])
+}
+
+type RowError struct {
+	Line   int      `json:"line"`
+	Errors []string `json:"errors"`
+}
+
+type Result struct {
+	DryRun   bool           `json:"dry_run"`
+	Rows     int            `json:"rows"`
+	Inserted int            `json:"inserted"`
+	Updated  int            `json:"updated"`
+	Created  map[string]int `json:"created,omitempty"`
+	Errors   []RowError     `json:"errors"`
+}
+
+// Applied reports whether the rows were written to the database.
+func (r Result) Applied() bool {
+	return !r.DryRun && len(r.Errors) == 0
+}
+
+// ApplyFunc validates and writes one row inside the import transaction,
+// updating the counters of result. The returned messages reject the row.
+type ApplyFunc func(ctx context.Context, row Row, result *Result) []string
+
+// Limits bound import bodies; imports of more than AsyncRows rows run as a
+// background job.
+type Limits struct {
+	MaxBytes  int64
+	AsyncRows int
+}
+
+var DefaultLimits = Limits{MaxBytes: 32 << 20, AsyncRows: 1000}
+
+var errRollback = errors.New("import rolled back")
+
+var ErrTooLarge = errors.New("import body too large")
+
+// LimitReader reads at most n bytes from r and fails with ErrTooLarge,
+// rather than a silent EOF, when r holds more.
+func LimitReader(r io.Reader, n int64) io.Reader {
+	return &limitedReader{reader: r, remaining: n}
+}
+
+type limitedReader struct {
+	reader    io.Reader
+	remaining int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.remaining <= 0 {
+		var probe [1]byte
+		if n, _ := l.reader.Read(probe[:]); n > 0 {
+			return 0, ErrTooLarge
+		}
+		return 0, io.EOF
+	}
+	if int64(len(p)) > l.remaining {
+		p = p[:l.remaining]
+	}
+	n, err := l.reader.Read(p)
+	l.remaining -= int64(n)
+	return n, err
+}
+
+// Parse reads a CSV body with a header row or an NDJSON body of flat
+// objects, depending on contentType. Rows that cannot be read are reported
+// as row errors; err is set when the body as a whole is unusable.
+func Parse(body io.Reader, contentType string) (rows []Row, rowErrors []RowError, err error) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch mediaType {
+	case encoding.MIME_CSV:
+		return parseCSV(body)
+	case encoding.MIME_NDJSON:
+		return parseNDJSON(body)
+	}
+	return nil, nil, fmt.Errorf("unsupported content type %q, use %v or %v", contentType, encoding.MIME_CSV, encoding.MIME_NDJSON)
+}
+
+func parseCSV(body io.Reader) ([]Row, []RowError, error) {
+	reader, err := encoding.NewCSVReader(body)
+	if err != nil {
+		return nil, nil, err
+	}
+	rows := []Row{}
+	rowErrors := []RowError{}
+	for {
+		values, line, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			rowErrors = append(rowErrors, RowError{Line: parseErr.StartLine, Errors: []string{parseErr.Err.Error()}})
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		rows = append(rows, Row{Line: line, Values: values})
+	}
+	return rows, rowErrors, nil
+}
+
+func parseNDJSON(body io.Reader) ([]Row, []RowError, error) {
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	rows := []Row{}
+	rowErrors := []RowError{}
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := bytes.TrimSpace(scanner.Bytes())
+		if len(text) == 0 {
+			continue
+		}
+		decoder := json.NewDecoder(bytes.NewReader(text))
+		decoder.UseNumber()
+		object := map[string]interface{}{}
+		if err := decoder.Decode(&object); err != nil {
+			rowErrors = append(rowErrors, RowError{Line: line, Errors: []string{"invalid JSON object: " + err.Error()}})
+			continue
+		}
+		values := map[string]string{}
+		messages := []string{}
+		for key, value := range object {
+			switch value.(type) {
+			case map[string]interface{}, []interface{}:
+				messages = append(messages, fmt.Sprintf("%v: nested values are not supported", key))
+			default:
+				values[key] = encoding.Format(value)
+			}
+		}
+		if len(messages) > 0 {
+			sort.Strings(messages)
+			rowErrors = append(rowErrors, RowError{Line: line, Errors: messages})
+			continue
+		}
+		rows = append(rows, Row{Line: line, Values: values})
+	}
+	return rows, rowErrors, scanner.Err()
+}
+
+// Run applies every row in a single transaction. The transaction is rolled
+// back if any row is rejected, or in dry-run mode once every row has been
+// checked, so that a dry run reports exactly what a real run would do.
+func Run(ctx context.Context, rows []Row, rowErrors []RowError, dryRun bool, apply ApplyFunc) (Result, error) {
+	result := Result{DryRun: dryRun, Rows: len(rows) + len(rowErrors), Errors: rowErrors, Created: map[string]int{}}
+	err := handler.WithTransaction(ctx, func(ctx context.Context) error {
+		for _, row := range rows {
+			if messages := apply(ctx, row, &result); len(messages) > 0 {
+				result.Errors = append(result.Errors, RowError{Line: row.Line, Errors: messages})
+			}
+		}
+		if !result.Applied() {
+			return errRollback
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errRollback) {
+		return result, err
+	}
+	sort.SliceStable(result.Errors, func(i, j int) bool {
+		return result.Errors[i].Line < result.Errors[j].Line
+	})
+	return result, nil
+}
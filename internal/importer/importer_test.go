@@ -0,0 +1,51 @@
+package importer
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestParseCSV(t *testing.T) {
+	body := "title,stock\nmoby dick,2\n\"typee,3\n"
+	rows, rowErrors, err := Parse(strings.NewReader(body), "text/csv; charset=utf-8")
+	if err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	if len(rows) != 1 || rows[0].Line != 2 || rows[0].Value("title") != "moby dick" {
+		t.Errorf("unexpected rows: %+v", rows)
+	}
+	if len(rowErrors) != 1 || rowErrors[0].Line != 3 {
+		t.Errorf("unexpected row errors: %+v", rowErrors)
+	}
+}
+
+func TestParseNDJSON(t *testing.T) {
+	body := "{\"title\":\"moby dick\",\"stock\":2}\n\n{\"title\":\n{\"tags\":[\"sea\"]}\n"
+	rows, rowErrors, err := Parse(strings.NewReader(body), "application/x-ndjson")
+	if err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	if len(rows) != 1 || rows[0].Value("stock") != "2" {
+		t.Errorf("unexpected rows: %+v", rows)
+	}
+	if len(rowErrors) != 2 || rowErrors[0].Line != 3 || rowErrors[1].Line != 4 {
+		t.Errorf("unexpected row errors: %+v", rowErrors)
+	}
+}
+
+func TestParseUnsupported(t *testing.T) {
+	if _, _, err := Parse(strings.NewReader("{}"), "application/json"); err == nil {
+		t.Error("expected an error for application/json")
+	}
+}
+
+func TestLimitReader(t *testing.T) {
+	if _, err := io.ReadAll(LimitReader(strings.NewReader("12345"), 5)); err != nil {
+		t.Errorf("body at the limit: %v", err)
+	}
+	if _, err := io.ReadAll(LimitReader(strings.NewReader("123456"), 5)); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("expected ErrTooLarge, got %v", err)
+	}
+}
@@ -0,0 +1,144 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	uuid "github.com/google/uuid"
+
+	utils "github.com/riszkymf/golang-rest-boilerplate/internal/src"
+)
+
+type Status string
+
+const (
+	StatusRunning   Status = "running"
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+)
+
+// Job is a snapshot of background work started through a Registry.
+type Job struct {
+	Id         string      `json:"id"`
+	Kind       string      `json:"kind"`
+	Status     Status      `json:"status"`
+	CreatedAt  time.Time   `json:"created_at"`
+	FinishedAt *time.Time  `json:"finished_at,omitempty"`
+	Result     interface{} `json:"result,omitempty"`
+	Error      string      `json:"error,omitempty"`
+	// Tenant is the library the job works for, only it may see the job.
+	Tenant string `json:"-"`
+}
+
+// Func is the work of a job; its result is kept, successful or not, so
+// that clients polling the job can see it.
+type Func func(ctx context.Context) (interface{}, error)
+
+// Registry runs jobs in goroutines and keeps finished ones for retention.
+// Jobs live in memory only: they are lost on restart.
+type Registry struct {
+	mu        sync.Mutex
+	jobs      map[string]*Job
+	retention time.Duration
+	running   sync.WaitGroup
+}
+
+func NewRegistry(retention time.Duration) *Registry {
+	return &Registry{jobs: map[string]*Job{}, retention: retention}
+}
+
+var Default = NewRegistry(time.Hour)
+
+func (r *Registry) SetRetention(retention time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.retention = retention
+}
+
+// Start runs fn in the background with ctx, which should not be tied to the
+// request that started it.
+func (r *Registry) Start(ctx context.Context, kind string, fn Func) Job {
+	job := &Job{
+		Id:        uuid.NewString(),
+		Kind:      kind,
+		Status:    StatusRunning,
+		CreatedAt: time.Now().UTC(),
+		Tenant:    utils.TenantFromContext(ctx),
+	}
+	r.mu.Lock()
+	r.prune()
+	r.jobs[job.Id] = job
+	snapshot := *job
+	r.mu.Unlock()
+
+	r.running.Add(1)
+	go func() {
+		defer r.running.Done()
+		result, err := r.run(ctx, fn)
+		finished := time.Now().UTC()
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		job.Result = result
+		job.FinishedAt = &finished
+		job.Status = StatusSucceeded
+		if err != nil {
+			job.Status = StatusFailed
+			job.Error = err.Error()
+			utils.CheckErrorContext(ctx, err, "[jobs]", kind, job.Id)
+		}
+	}()
+	return snapshot
+}
+
+func (r *Registry) run(ctx context.Context, fn Func) (result interface{}, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("job panicked: %v", recovered)
+		}
+	}()
+	return fn(ctx)
+}
+
+func (r *Registry) Get(id string) (Job, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	job, exist := r.jobs[id]
+	if !exist {
+		return Job{}, false
+	}
+	return *job, true
+}
+
+// Wait blocks until every running job is done or ctx expires.
+func (r *Registry) Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		r.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *Registry) prune() {
+	for id, job := range r.jobs {
+		if job.FinishedAt != nil && time.Since(*job.FinishedAt) > r.retention {
+			delete(r.jobs, id)
+		}
+	}
+}
+
+func Start(ctx context.Context, kind string, fn Func) Job {
+	return Default.Start(ctx, kind, fn)
+}
+
+func Get(id string) (Job, bool) {
+	return Default.Get(id)
+}
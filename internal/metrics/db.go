@@ -0,0 +1,42 @@
+package metrics
+
+import (
+	"database/sql"
+	"errors"
+)
+
+var errNoDatabase = errors.New("database connection is not initialized")
+
+// RegisterDBStats exposes the sql.DB connection pool statistics. The
+// connection is resolved on every scrape so it can be assigned after startup.
+func (r *Registry) RegisterDBStats(connection func() *sql.DB) {
+	stat := func(value func(sql.DBStats) float64) func() (float64, error) {
+		return func() (float64, error) {
+			db := connection()
+			if db == nil {
+				return 0, errNoDatabase
+			}
+			return value(db.Stats()), nil
+		}
+	}
+	r.NewGaugeFunc("db_max_open_connections", "Maximum number of open connections to the database.",
+		stat(func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) }))
+	r.NewGaugeFunc("db_open_connections", "The number of established connections both in use and idle.",
+		stat(func(s sql.DBStats) float64 { return float64(s.OpenConnections) }))
+	r.NewGaugeFunc("db_in_use_connections", "The number of connections currently in use.",
+		stat(func(s sql.DBStats) float64 { return float64(s.InUse) }))
+	r.NewGaugeFunc("db_idle_connections", "The number of idle connections.",
+		stat(func(s sql.DBStats) float64 { return float64(s.Idle) }))
+	r.NewCounterFunc("db_wait_count_total", "The total number of connections waited for.",
+		stat(func(s sql.DBStats) float64 { return float64(s.WaitCount) }))
+	r.NewCounterFunc("db_wait_duration_seconds_total", "The total time blocked waiting for a new connection.",
+		stat(func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() }))
+	r.NewCounterFunc("db_max_idle_closed_total", "The total number of connections closed due to SetMaxIdleConns.",
+		stat(func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed) }))
+	r.NewCounterFunc("db_max_lifetime_closed_total", "The total number of connections closed due to SetConnMaxLifetime.",
+		stat(func(s sql.DBStats) float64 { return float64(s.MaxLifetimeClosed) }))
+}
+
+func RegisterDBStats(connection func() *sql.DB) {
+	Default.RegisterDBStats(connection)
+}
@@ -0,0 +1,372 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the Prometheus text exposition format served on /metrics.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+var Default = NewRegistry()
+
+type collector interface {
+	describe() desc
+	collect(w *bufio.Writer)
+}
+
+type desc struct {
+	Name   string
+	Help   string
+	Type   string
+	Labels []string
+}
+
+type Registry struct {
+	mu         sync.RWMutex
+	collectors map[string]collector
+}
+
+func NewRegistry() *Registry {
+	return &Registry{collectors: map[string]collector{}}
+}
+
+func (r *Registry) register(c collector) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	name := c.describe().Name
+	if _, exist := r.collectors[name]; exist {
+		return fmt.Errorf("metric %v is already registered", name)
+	}
+	r.collectors[name] = c
+	return nil
+}
+
+func (r *Registry) mustRegister(c collector) {
+	if err := r.register(c); err != nil {
+		panic(err)
+	}
+}
+
+// Unregister removes a metric family, mostly useful to swap collectors in tests.
+func (r *Registry) Unregister(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.collectors, name)
+}
+
+// WriteText renders every registered metric family sorted by name.
+func (r *Registry) WriteText(out io.Writer) error {
+	r.mu.RLock()
+	names := make([]string, 0, len(r.collectors))
+	for name := range r.collectors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	collectors := make([]collector, 0, len(names))
+	for _, name := range names {
+		collectors = append(collectors, r.collectors[name])
+	}
+	r.mu.RUnlock()
+
+	w := bufio.NewWriter(out)
+	for _, c := range collectors {
+		d := c.describe()
+		fmt.Fprintf(w, "# HELP %v %v\n", d.Name, escapeHelp(d.Help))
+		fmt.Fprintf(w, "# TYPE %v %v\n", d.Name, d.Type)
+		c.collect(w)
+	}
+	return w.Flush()
+}
+
+func (r *Registry) NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	c := &CounterVec{vec: newVec(desc{Name: name, Help: help, Type: "counter", Labels: labels})}
+	r.mustRegister(c)
+	return c
+}
+
+func (r *Registry) NewGaugeVec(name string, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{vec: newVec(desc{Name: name, Help: help, Type: "gauge", Labels: labels})}
+	r.mustRegister(g)
+	return g
+}
+
+func (r *Registry) NewHistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	sorted := append([]float64{}, buckets...)
+	sort.Float64s(sorted)
+	h := &HistogramVec{
+		desc:    desc{Name: name, Help: help, Type: "histogram", Labels: labels},
+		buckets: sorted,
+		series:  map[string]*histogramSeries{},
+	}
+	r.mustRegister(h)
+	return h
+}
+
+// NewGaugeFunc registers a gauge whose value is computed on every scrape.
+// The sample is omitted when fn returns an error.
+func (r *Registry) NewGaugeFunc(name string, help string, fn func() (float64, error)) {
+	r.mustRegister(&valueFunc{desc: desc{Name: name, Help: help, Type: "gauge"}, fn: fn})
+}
+
+// NewCounterFunc registers a counter read from a monotonic source on every scrape.
+func (r *Registry) NewCounterFunc(name string, help string, fn func() (float64, error)) {
+	r.mustRegister(&valueFunc{desc: desc{Name: name, Help: help, Type: "counter"}, fn: fn})
+}
+
+// NewGaugeVecFunc registers a gauge computed on every scrape, fn reporting a
+// sample for each set of label values through set. No sample is written when
+// fn returns an error.
+func (r *Registry) NewGaugeVecFunc(name string, help string, fn func(set func(value float64, labelValues ...string)) error, labels ...string) {
+	r.mustRegister(&vecFunc{desc: desc{Name: name, Help: help, Type: "gauge", Labels: labels}, fn: fn})
+}
+
+func NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	return Default.NewCounterVec(name, help, labels...)
+}
+
+func NewGaugeVec(name string, help string, labels ...string) *GaugeVec {
+	return Default.NewGaugeVec(name, help, labels...)
+}
+
+func NewHistogramVec(name string, help string, buckets []float64, labels ...string) *HistogramVec {
+	return Default.NewHistogramVec(name, help, buckets, labels...)
+}
+
+func NewGaugeFunc(name string, help string, fn func() (float64, error)) {
+	Default.NewGaugeFunc(name, help, fn)
+}
+
+func NewCounterFunc(name string, help string, fn func() (float64, error)) {
+	Default.NewCounterFunc(name, help, fn)
+}
+
+func NewGaugeVecFunc(name string, help string, fn func(set func(value float64, labelValues ...string)) error, labels ...string) {
+	Default.NewGaugeVecFunc(name, help, fn, labels...)
+}
+
+type series struct {
+	labelValues []string
+	value       float64
+}
+
+type vec struct {
+	desc
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+func newVec(d desc) vec {
+	return vec{desc: d, series: map[string]*series{}}
+}
+
+func (v *vec) describe() desc {
+	return v.desc
+}
+
+func (v *vec) get(labelValues []string) *series {
+	if len(labelValues) != len(v.Labels) {
+		panic(fmt.Sprintf("metric %v expects %d label values, got %d", v.Name, len(v.Labels), len(labelValues)))
+	}
+	key := strings.Join(labelValues, "\xff")
+	s, exist := v.series[key]
+	if !exist {
+		s = &series{labelValues: append([]string{}, labelValues...)}
+		v.series[key] = s
+	}
+	return s
+}
+
+func (v *vec) collect(w *bufio.Writer) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for _, key := range sortedKeys(v.series) {
+		s := v.series[key]
+		fmt.Fprintf(w, "%v%v %v\n", v.Name, formatLabels(v.Labels, s.labelValues), formatFloat(s.value))
+	}
+}
+
+type CounterVec struct {
+	vec
+}
+
+func (c *CounterVec) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+func (c *CounterVec) Add(value float64, labelValues ...string) {
+	if value < 0 {
+		panic(fmt.Sprintf("counter %v cannot decrease", c.Name))
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.get(labelValues).value += value
+}
+
+type GaugeVec struct {
+	vec
+}
+
+func (g *GaugeVec) Set(value float64, labelValues ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.get(labelValues).value = value
+}
+
+func (g *GaugeVec) Add(value float64, labelValues ...string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.get(labelValues).value += value
+}
+
+type histogramSeries struct {
+	labelValues []string
+	counts      []uint64
+	sum         float64
+	count       uint64
+}
+
+type HistogramVec struct {
+	desc
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+func (h *HistogramVec) describe() desc {
+	return h.desc
+}
+
+func (h *HistogramVec) Observe(value float64, labelValues ...string) {
+	if len(labelValues) != len(h.Labels) {
+		panic(fmt.Sprintf("metric %v expects %d label values, got %d", h.Name, len(h.Labels), len(labelValues)))
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	key := strings.Join(labelValues, "\xff")
+	s, exist := h.series[key]
+	if !exist {
+		s = &histogramSeries{labelValues: append([]string{}, labelValues...), counts: make([]uint64, len(h.buckets))}
+		h.series[key] = s
+	}
+	for i, upper := range h.buckets {
+		if value <= upper {
+			s.counts[i]++
+		}
+	}
+	s.sum += value
+	s.count++
+}
+
+func (h *HistogramVec) collect(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	bucketLabels := append(append([]string{}, h.Labels...), "le")
+	for _, key := range sortedKeys(h.series) {
+		s := h.series[key]
+		for i, upper := range h.buckets {
+			values := append(append([]string{}, s.labelValues...), formatFloat(upper))
+			fmt.Fprintf(w, "%v_bucket%v %d\n", h.Name, formatLabels(bucketLabels, values), s.counts[i])
+		}
+		values := append(append([]string{}, s.labelValues...), "+Inf")
+		fmt.Fprintf(w, "%v_bucket%v %d\n", h.Name, formatLabels(bucketLabels, values), s.count)
+		fmt.Fprintf(w, "%v_sum%v %v\n", h.Name, formatLabels(h.Labels, s.labelValues), formatFloat(s.sum))
+		fmt.Fprintf(w, "%v_count%v %d\n", h.Name, formatLabels(h.Labels, s.labelValues), s.count)
+	}
+}
+
+type valueFunc struct {
+	desc
+	fn func() (float64, error)
+}
+
+func (f *valueFunc) describe() desc {
+	return f.desc
+}
+
+func (f *valueFunc) collect(w *bufio.Writer) {
+	value, err := f.fn()
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "%v %v\n", f.Name, formatFloat(value))
+}
+
+type vecFunc struct {
+	desc
+	fn func(set func(value float64, labelValues ...string)) error
+}
+
+func (f *vecFunc) describe() desc {
+	return f.desc
+}
+
+func (f *vecFunc) collect(w *bufio.Writer) {
+	samples := []series{}
+	err := f.fn(func(value float64, labelValues ...string) {
+		if len(labelValues) != len(f.Labels) {
+			panic(fmt.Sprintf("metric %v expects %d label values, got %d", f.Name, len(f.Labels), len(labelValues)))
+		}
+		samples = append(samples, series{labelValues: append([]string{}, labelValues...), value: value})
+	})
+	if err != nil {
+		return
+	}
+	for _, s := range samples {
+		fmt.Fprintf(w, "%v%v %v\n", f.Name, formatLabels(f.Labels, s.labelValues), formatFloat(s.value))
+	}
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatLabels(names []string, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf(`%v="%v"`, name, escapeLabel(values[i]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeLabel(value string) string {
+	return labelEscaper.Replace(value)
+}
+
+func escapeHelp(value string) string {
+	return helpEscaper.Replace(value)
+}
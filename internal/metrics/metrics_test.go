@@ -0,0 +1,72 @@
+package metrics
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	registry := NewRegistry()
+	requests := registry.NewCounterVec("http_requests_total", "Number of HTTP requests.", "route", "status")
+	latency := registry.NewHistogramVec("http_request_duration_seconds", "Latency of HTTP requests.", []float64{0.1, 1}, "route")
+	registry.NewGaugeFunc("library_books_out_of_stock", "Books with no stock.", func() (float64, error) { return 3, nil })
+	registry.NewGaugeFunc("library_rentals_overdue", "Overdue rentals.", func() (float64, error) { return 0, errors.New("db down") })
+	registry.NewGaugeVecFunc("library_holds_ready", "Ready holds.", func(set func(float64, ...string)) error {
+		set(2, "east")
+		set(0, "west")
+		return nil
+	}, "tenant")
+	registry.NewGaugeVecFunc("library_copies_lost", "Lost copies.", func(set func(float64, ...string)) error {
+		set(1, "east")
+		return errors.New("west down")
+	}, "tenant")
+
+	requests.Inc("/books/{book-id}", "200")
+	requests.Inc("/books/{book-id}", "200")
+	requests.Inc(`/quote"d`, "500")
+	latency.Observe(0.05, "/books")
+	latency.Observe(0.5, "/books")
+
+	var out strings.Builder
+	if err := registry.WriteText(&out); err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	expected := []string{
+		"# TYPE http_requests_total counter",
+		`http_requests_total{route="/books/{book-id}",status="200"} 2`,
+		`http_requests_total{route="/quote\"d",status="500"} 1`,
+		"# TYPE http_request_duration_seconds histogram",
+		`http_request_duration_seconds_bucket{route="/books",le="0.1"} 1`,
+		`http_request_duration_seconds_bucket{route="/books",le="1"} 2`,
+		`http_request_duration_seconds_bucket{route="/books",le="+Inf"} 2`,
+		`http_request_duration_seconds_sum{route="/books"} 0.55`,
+		`http_request_duration_seconds_count{route="/books"} 2`,
+		"library_books_out_of_stock 3",
+		"# TYPE library_holds_ready gauge",
+		`library_holds_ready{tenant="east"} 2`,
+		`library_holds_ready{tenant="west"} 0`,
+	}
+	for _, line := range expected {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Errorf("missing line %q in output:\n%v", line, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "library_rentals_overdue 0") || strings.Contains(out.String(), "library_copies_lost{") {
+		t.Errorf("failed gauge should not produce a sample")
+	}
+	if strings.Index(out.String(), "http_request_duration_seconds") > strings.Index(out.String(), "http_requests_total") {
+		t.Errorf("metric families should be sorted by name")
+	}
+}
+
+func TestDuplicateRegistration(t *testing.T) {
+	registry := NewRegistry()
+	registry.NewCounterVec("dup_total", "Duplicate.")
+	defer func() {
+		if recover() == nil {
+			t.Errorf("registering the same metric twice should panic")
+		}
+	}()
+	registry.NewCounterVec("dup_total", "Duplicate.")
+}
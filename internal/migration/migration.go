@@ -0,0 +1,139 @@
+package migration
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	utils "github.com/riszkymf/golang-rest-boilerplate/internal/src"
+)
+
+// Migrations are plain SQL files named <version>_<name>.sql, applied in
+// version order and recorded in schema_migrations.
+//
+//go:embed sql/*.sql
+var files embed.FS
+
+type Migration struct {
+	Version string
+	Name    string
+	SQL     string
+}
+
+const createMigrationTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+	version TEXT NOT NULL PRIMARY KEY,
+	name TEXT NOT NULL,
+	applied_at TIMESTAMP NOT NULL
+);`
+
+func Load() ([]Migration, error) {
+	entries, err := files.ReadDir("sql")
+	if err != nil {
+		return nil, err
+	}
+	migrations := []Migration{}
+	for _, entry := range entries {
+		fileName := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(fileName, ".sql") {
+			continue
+		}
+		version, name, found := strings.Cut(strings.TrimSuffix(fileName, ".sql"), "_")
+		if !found {
+			return nil, fmt.Errorf("migration %v must be named <version>_<name>.sql", fileName)
+		}
+		content, err := files.ReadFile(path.Join("sql", fileName))
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, Migration{Version: version, Name: name, SQL: string(content)})
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+func Applied(ctx context.Context, db *sql.DB) (map[string]bool, error) {
+	applied := map[string]bool{}
+	var exist int
+	err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE type='table' AND name='schema_migrations';").Scan(&exist)
+	if err != nil || exist == 0 {
+		return applied, err
+	}
+	rows, err := db.QueryContext(ctx, "SELECT version FROM schema_migrations;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var version string
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+	return applied, rows.Err()
+}
+
+func Pending(ctx context.Context, db *sql.DB) ([]Migration, error) {
+	migrations, err := Load()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := Applied(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	pending := []Migration{}
+	for _, m := range migrations {
+		if !applied[m.Version] {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// Migrate applies every pending migration, each one in its own transaction,
+// and returns the applied versions.
+func Migrate(ctx context.Context, db *sql.DB) ([]string, error) {
+	if _, err := db.ExecContext(ctx, createMigrationTable); err != nil {
+		return nil, err
+	}
+	pending, err := Pending(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	applied := []string{}
+	for _, m := range pending {
+		if err := apply(ctx, db, m); err != nil {
+			return applied, fmt.Errorf("migration %v_%v: %w", m.Version, m.Name, err)
+		}
+		utils.LogInfo("[migration]", "apply", fmt.Sprintf("applied %v_%v", m.Version, m.Name))
+		applied = append(applied, m.Version)
+	}
+	return applied, nil
+}
+
+func apply(ctx context.Context, db *sql.DB, m Migration) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, m.SQL); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?);",
+		m.Version, m.Name, time.Now().UTC().Format("2006-01-02 15:04:05"),
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
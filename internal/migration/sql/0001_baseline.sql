@@ -0,0 +1,71 @@
+CREATE TABLE IF NOT EXISTS "author" (
+	"id"	INTEGER NOT NULL UNIQUE,
+	"name"	VARCHAR(255) NOT NULL UNIQUE,
+	PRIMARY KEY("id" AUTOINCREMENT)
+);
+
+CREATE TABLE IF NOT EXISTS "books" (
+	"id"	INTEGER NOT NULL UNIQUE,
+	"title"	varchar(255) NOT NULL UNIQUE,
+	"stock"	int NOT NULL,
+	"author_id"	int NOT NULL,
+	FOREIGN KEY("author_id") REFERENCES "author"("id") on delete cascade on update cascade,
+	PRIMARY KEY("id" AUTOINCREMENT)
+);
+
+CREATE TABLE IF NOT EXISTS "members" (
+	"id"	INTEGER NOT NULL UNIQUE,
+	"email"	varchar(255) UNIQUE,
+	"firstname"	varchar(255) NOT NULL,
+	"lastname"	varchar(255) NOT NULL,
+	"address"	varchar(255),
+	PRIMARY KEY("id" AUTOINCREMENT)
+);
+
+CREATE TABLE IF NOT EXISTS "records" (
+	"id"	INTEGER NOT NULL UNIQUE,
+	"book_id"	int NOT NULL,
+	"member_id"	int NOT NULL,
+	"rent_date"	timestamp NOT NULL,
+	"due_date"	timestamp NOT NULL,
+	"rent_status"	INTEGER NOT NULL,
+	FOREIGN KEY("book_id") REFERENCES "books"("id") on delete cascade on update cascade,
+	FOREIGN KEY("member_id") REFERENCES "members"("id") on delete cascade on update cascade,
+	PRIMARY KEY("id" AUTOINCREMENT)
+);
+
+CREATE VIEW IF NOT EXISTS v_rent
+AS
+SELECT
+	records.id,
+	records.book_id,
+	records.member_id,
+	books.title as title,
+	author.name as author_name,
+	members.email,
+	members.firstname,
+	members.lastname,
+	records.rent_date,
+	records.due_date,
+	records.rent_status
+FROM
+	records
+INNER JOIN
+	members on records.member_id=members.id
+INNER JOIN
+	books on records.book_id=books.id
+INNER JOIN
+	author on books.author_id=author.id;
+
+CREATE VIEW IF NOT EXISTS v_books
+AS
+SELECT
+	books.id as book_id,
+	author.id as author_id,
+	books.title as title,
+	books.stock as stock,
+	author.name as author_name
+FROM
+	books
+INNER JOIN
+	author on books.author_id=author.id;
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS "idempotency_keys" (
+	"id"	INTEGER NOT NULL UNIQUE,
+	"idempotency_key"	varchar(255) NOT NULL UNIQUE,
+	"request_hash"	varchar(64) NOT NULL,
+	"status"	INTEGER NOT NULL DEFAULT 0,
+	"content_type"	varchar(255),
+	"body"	TEXT,
+	"created_at"	INTEGER NOT NULL,
+	"expires_at"	INTEGER NOT NULL,
+	PRIMARY KEY("id" AUTOINCREMENT)
+);
+
+CREATE INDEX IF NOT EXISTS "idempotency_keys_expires_at" ON "idempotency_keys" ("expires_at");
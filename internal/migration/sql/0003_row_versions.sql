@@ -0,0 +1,15 @@
+-- version is incremented by the handler on every update and exposed as the
+-- ETag of single resources; updated_at is set by the handler too.
+ALTER TABLE "author" ADD COLUMN "version" INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE "author" ADD COLUMN "updated_at" TIMESTAMP;
+ALTER TABLE "books" ADD COLUMN "version" INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE "books" ADD COLUMN "updated_at" TIMESTAMP;
+ALTER TABLE "members" ADD COLUMN "version" INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE "members" ADD COLUMN "updated_at" TIMESTAMP;
+ALTER TABLE "records" ADD COLUMN "version" INTEGER NOT NULL DEFAULT 1;
+ALTER TABLE "records" ADD COLUMN "updated_at" TIMESTAMP;
+
+UPDATE "author" SET "updated_at" = CURRENT_TIMESTAMP;
+UPDATE "books" SET "updated_at" = CURRENT_TIMESTAMP;
+UPDATE "members" SET "updated_at" = CURRENT_TIMESTAMP;
+UPDATE "records" SET "updated_at" = CURRENT_TIMESTAMP;
@@ -0,0 +1,47 @@
+-- Deleted rows keep their deleted_at time until purged, so that the loans of
+-- a deleted book or member stay in records. The views expose the deleted_at
+-- of their main table so that the handler can leave deleted rows out.
+ALTER TABLE "author" ADD COLUMN "deleted_at" TIMESTAMP;
+ALTER TABLE "books" ADD COLUMN "deleted_at" TIMESTAMP;
+ALTER TABLE "members" ADD COLUMN "deleted_at" TIMESTAMP;
+ALTER TABLE "records" ADD COLUMN "deleted_at" TIMESTAMP;
+
+DROP VIEW IF EXISTS v_rent;
+CREATE VIEW v_rent
+AS
+SELECT
+	records.id,
+	records.book_id,
+	records.member_id,
+	books.title as title,
+	author.name as author_name,
+	members.email,
+	members.firstname,
+	members.lastname,
+	records.rent_date,
+	records.due_date,
+	records.rent_status,
+	records.deleted_at
+FROM
+	records
+INNER JOIN
+	members on records.member_id=members.id
+INNER JOIN
+	books on records.book_id=books.id
+INNER JOIN
+	author on books.author_id=author.id;
+
+DROP VIEW IF EXISTS v_books;
+CREATE VIEW v_books
+AS
+SELECT
+	books.id as book_id,
+	author.id as author_id,
+	books.title as title,
+	books.stock as stock,
+	author.name as author_name,
+	books.deleted_at
+FROM
+	books
+INNER JOIN
+	author on books.author_id=author.id;
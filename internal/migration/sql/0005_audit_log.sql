@@ -0,0 +1,19 @@
+-- One row per change written through the handler, in the same transaction.
+-- before and after hold the changed columns as JSON objects; before is NULL
+-- for inserts and after for hard deletes.
+CREATE TABLE IF NOT EXISTS "audit_log" (
+	"id"	INTEGER NOT NULL UNIQUE,
+	"table_name"	VARCHAR(255) NOT NULL,
+	"row_id"	INTEGER NOT NULL,
+	"action"	VARCHAR(16) NOT NULL,
+	"before"	TEXT,
+	"after"	TEXT,
+	"principal"	VARCHAR(255),
+	"request_id"	VARCHAR(255),
+	"created_at"	TIMESTAMP NOT NULL,
+	PRIMARY KEY("id" AUTOINCREMENT)
+);
+
+CREATE INDEX IF NOT EXISTS "audit_log_row" ON "audit_log" ("table_name", "row_id");
+CREATE INDEX IF NOT EXISTS "audit_log_principal" ON "audit_log" ("principal");
+CREATE INDEX IF NOT EXISTS "audit_log_created_at" ON "audit_log" ("created_at");
@@ -0,0 +1,44 @@
+-- Domain events are written to the outbox in the transaction of the change
+-- that raised them, then fanned out by the dispatcher to one delivery per
+-- matching webhook. Deliveries failing max_attempts times are dead letters.
+CREATE TABLE IF NOT EXISTS "outbox" (
+	"id"	INTEGER NOT NULL UNIQUE,
+	"event_type"	VARCHAR(64) NOT NULL,
+	"table_name"	VARCHAR(255) NOT NULL,
+	"row_id"	INTEGER NOT NULL,
+	"data"	TEXT NOT NULL,
+	"previous"	TEXT,
+	"created_at"	TIMESTAMP NOT NULL,
+	"dispatched"	INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY("id" AUTOINCREMENT)
+);
+
+CREATE INDEX IF NOT EXISTS "outbox_dispatched" ON "outbox" ("dispatched", "id");
+
+CREATE TABLE IF NOT EXISTS "webhooks" (
+	"id"	INTEGER NOT NULL UNIQUE,
+	"url"	VARCHAR(2048) NOT NULL,
+	"secret"	VARCHAR(255) NOT NULL,
+	"event_types"	TEXT NOT NULL,
+	"active"	INTEGER NOT NULL DEFAULT 1,
+	"created_at"	TIMESTAMP NOT NULL,
+	PRIMARY KEY("id" AUTOINCREMENT)
+);
+
+CREATE TABLE IF NOT EXISTS "webhook_deliveries" (
+	"id"	INTEGER NOT NULL UNIQUE,
+	"webhook_id"	INTEGER NOT NULL,
+	"event_id"	INTEGER NOT NULL,
+	"status"	VARCHAR(16) NOT NULL DEFAULT 'pending',
+	"attempts"	INTEGER NOT NULL DEFAULT 0,
+	"next_attempt_at"	INTEGER NOT NULL,
+	"last_status"	INTEGER,
+	"last_error"	TEXT,
+	"delivered_at"	TIMESTAMP,
+	FOREIGN KEY("webhook_id") REFERENCES "webhooks"("id") on delete cascade,
+	FOREIGN KEY("event_id") REFERENCES "outbox"("id") on delete cascade,
+	PRIMARY KEY("id" AUTOINCREMENT)
+);
+
+CREATE INDEX IF NOT EXISTS "webhook_deliveries_due" ON "webhook_deliveries" ("status", "next_attempt_at");
+CREATE INDEX IF NOT EXISTS "webhook_deliveries_webhook" ON "webhook_deliveries" ("webhook_id", "status");
@@ -0,0 +1,10 @@
+-- A lease names the instance allowed to run a periodic job until expires_at,
+-- so that the instances sharing the database do not all run it.
+CREATE TABLE IF NOT EXISTS "leases" (
+	"name"	VARCHAR(64) NOT NULL,
+	"holder"	VARCHAR(255) NOT NULL,
+	"expires_at"	INTEGER NOT NULL,
+	PRIMARY KEY("name")
+);
+
+CREATE INDEX IF NOT EXISTS "records_due" ON "records" ("rent_status", "due_date");
@@ -0,0 +1,34 @@
+-- Fines are charged to members for the loans returned late or lost, at most
+-- one of each kind per loan. Payments and waivers settle them; the balance of
+-- a member is what they were fined less what they paid or was waived.
+-- Amounts are in the minor unit of the currency, e.g. cents.
+CREATE TABLE IF NOT EXISTS "fines" (
+	"id"	INTEGER NOT NULL UNIQUE,
+	"member_id"	int NOT NULL,
+	"record_id"	int NOT NULL,
+	"kind"	VARCHAR(16) NOT NULL,
+	"days_late"	int NOT NULL DEFAULT 0,
+	"amount"	int NOT NULL,
+	"created_at"	TIMESTAMP NOT NULL,
+	FOREIGN KEY("member_id") REFERENCES "members"("id") on delete cascade on update cascade,
+	FOREIGN KEY("record_id") REFERENCES "records"("id") on delete cascade on update cascade,
+	PRIMARY KEY("id" AUTOINCREMENT)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS "fines_record_kind" ON "fines" ("record_id", "kind");
+CREATE INDEX IF NOT EXISTS "fines_member" ON "fines" ("member_id");
+
+CREATE TABLE IF NOT EXISTS "payments" (
+	"id"	INTEGER NOT NULL UNIQUE,
+	"member_id"	int NOT NULL,
+	"fine_id"	int,
+	"kind"	VARCHAR(16) NOT NULL,
+	"amount"	int NOT NULL,
+	"note"	VARCHAR(255),
+	"created_at"	TIMESTAMP NOT NULL,
+	FOREIGN KEY("member_id") REFERENCES "members"("id") on delete cascade on update cascade,
+	FOREIGN KEY("fine_id") REFERENCES "fines"("id") on delete set null on update cascade,
+	PRIMARY KEY("id" AUTOINCREMENT)
+);
+
+CREATE INDEX IF NOT EXISTS "payments_member" ON "payments" ("member_id");
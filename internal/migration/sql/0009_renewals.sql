@@ -0,0 +1,27 @@
+-- Members may belong to a category whose loan policy overrides the default
+-- one of the configuration. A loan counts its renewals, each of which is kept
+-- in renewals with the due dates it moved between.
+ALTER TABLE "members" ADD COLUMN "category" VARCHAR(64);
+ALTER TABLE "records" ADD COLUMN "renewals" int NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS "loan_policies" (
+	"id"	INTEGER NOT NULL UNIQUE,
+	"category"	VARCHAR(64) NOT NULL UNIQUE,
+	"loan_days"	int NOT NULL,
+	"renewal_days"	int NOT NULL,
+	"max_renewals"	int NOT NULL,
+	"max_overdue_days"	int NOT NULL,
+	PRIMARY KEY("id" AUTOINCREMENT)
+);
+
+CREATE TABLE IF NOT EXISTS "renewals" (
+	"id"	INTEGER NOT NULL UNIQUE,
+	"record_id"	int NOT NULL,
+	"previous_due_date"	timestamp NOT NULL,
+	"due_date"	timestamp NOT NULL,
+	"created_at"	TIMESTAMP NOT NULL,
+	FOREIGN KEY("record_id") REFERENCES "records"("id") on delete cascade on update cascade,
+	PRIMARY KEY("id" AUTOINCREMENT)
+);
+
+CREATE INDEX IF NOT EXISTS "renewals_record" ON "renewals" ("record_id");
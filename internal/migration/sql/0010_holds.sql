@@ -0,0 +1,19 @@
+-- Members waiting for a book hold it, first come first served. A copy coming
+-- back is set aside for the oldest waiting hold, ready_for_pickup until
+-- expires_at; the hold is fulfilled when its member checks the book out.
+CREATE TABLE IF NOT EXISTS "holds" (
+	"id"	INTEGER NOT NULL UNIQUE,
+	"book_id"	int NOT NULL,
+	"member_id"	int NOT NULL,
+	"status"	VARCHAR(32) NOT NULL DEFAULT 'waiting',
+	"created_at"	TIMESTAMP NOT NULL,
+	"ready_at"	TIMESTAMP,
+	"expires_at"	TIMESTAMP,
+	FOREIGN KEY("book_id") REFERENCES "books"("id") on delete cascade on update cascade,
+	FOREIGN KEY("member_id") REFERENCES "members"("id") on delete cascade on update cascade,
+	PRIMARY KEY("id" AUTOINCREMENT)
+);
+
+CREATE INDEX IF NOT EXISTS "holds_book" ON "holds" ("book_id", "status", "id");
+CREATE INDEX IF NOT EXISTS "holds_member" ON "holds" ("member_id", "status");
+CREATE INDEX IF NOT EXISTS "holds_expiry" ON "holds" ("status", "expires_at");
@@ -0,0 +1,117 @@
+-- Every physical copy of a book is a row of copies, found by its barcode. The
+-- stock of a book is no longer kept: v_books counts its available copies. A
+-- loan records the copy it lent, a ready hold the copy set aside for it.
+CREATE TABLE IF NOT EXISTS "copies" (
+	"id"	INTEGER NOT NULL UNIQUE,
+	"barcode"	VARCHAR(64) NOT NULL UNIQUE,
+	"book_id"	int NOT NULL,
+	"branch"	VARCHAR(64),
+	"condition"	VARCHAR(16) NOT NULL DEFAULT 'good',
+	"status"	VARCHAR(16) NOT NULL DEFAULT 'available',
+	"created_at"	TIMESTAMP NOT NULL,
+	"version"	INTEGER NOT NULL DEFAULT 1,
+	"updated_at"	TIMESTAMP,
+	"deleted_at"	TIMESTAMP,
+	FOREIGN KEY("book_id") REFERENCES "books"("id") on delete cascade on update cascade,
+	PRIMARY KEY("id" AUTOINCREMENT)
+);
+
+CREATE INDEX IF NOT EXISTS "copies_book" ON "copies" ("book_id", "status", "id");
+
+ALTER TABLE "records" ADD COLUMN "copy_id" int REFERENCES "copies"("id");
+ALTER TABLE "holds" ADD COLUMN "copy_id" int REFERENCES "copies"("id");
+
+-- The stock of a book becomes as many available copies, barcoded after the
+-- book; its open and lost loans get the copies numbered after those.
+INSERT INTO "copies" ("barcode", "book_id", "status", "created_at")
+WITH RECURSIVE seq("book_id", "n") AS (
+	SELECT "id", 1 FROM "books" WHERE "stock" > 0
+	UNION ALL
+	SELECT seq."book_id", seq."n" + 1 FROM seq INNER JOIN "books" ON "books"."id" = seq."book_id" WHERE seq."n" < "books"."stock"
+)
+SELECT printf('%06d-%03d', "book_id", "n"), "book_id", 'available', CURRENT_TIMESTAMP FROM seq ORDER BY "book_id", "n";
+
+INSERT INTO "copies" ("barcode", "book_id", "status", "created_at")
+SELECT
+	printf('%06d-%03d', "records"."book_id", MAX("books"."stock", 0) + ROW_NUMBER() OVER (PARTITION BY "records"."book_id" ORDER BY "records"."id")),
+	"records"."book_id",
+	CASE "records"."rent_status" WHEN 'lost' THEN 'lost' ELSE 'on_loan' END,
+	CURRENT_TIMESTAMP
+FROM "records"
+INNER JOIN "books" ON "books"."id" = "records"."book_id"
+WHERE "records"."rent_status" IN ('rented', 'overdue', 'lost')
+ORDER BY "records"."id";
+
+UPDATE "records" SET "copy_id" = (
+	SELECT "copies"."id" FROM "copies"
+	WHERE "copies"."barcode" = printf('%06d-%03d', "records"."book_id",
+		(SELECT MAX("stock", 0) FROM "books" WHERE "books"."id" = "records"."book_id") +
+		(SELECT COUNT(*) FROM "records" AS "earlier"
+			WHERE "earlier"."book_id" = "records"."book_id"
+			AND "earlier"."rent_status" IN ('rented', 'overdue', 'lost')
+			AND "earlier"."id" <= "records"."id"))
+)
+WHERE "rent_status" IN ('rented', 'overdue', 'lost');
+
+-- The ready holds of a book take its first available copies, in queue order.
+UPDATE "holds" SET "copy_id" = (
+	SELECT "shelf"."id" FROM (
+		SELECT "id", "book_id", ROW_NUMBER() OVER (PARTITION BY "book_id" ORDER BY "id") AS "n"
+		FROM "copies" WHERE "status" = 'available'
+	) AS "shelf"
+	WHERE "shelf"."book_id" = "holds"."book_id"
+	AND "shelf"."n" = (SELECT COUNT(*) FROM "holds" AS "earlier"
+		WHERE "earlier"."book_id" = "holds"."book_id"
+		AND "earlier"."status" = 'ready_for_pickup'
+		AND "earlier"."id" <= "holds"."id")
+)
+WHERE "status" = 'ready_for_pickup';
+
+UPDATE "copies" SET "status" = 'on_hold'
+WHERE "id" IN (SELECT "copy_id" FROM "holds" WHERE "status" = 'ready_for_pickup' AND "copy_id" IS NOT NULL);
+
+DROP VIEW IF EXISTS v_books;
+ALTER TABLE "books" DROP COLUMN "stock";
+CREATE VIEW v_books
+AS
+SELECT
+	books.id as book_id,
+	author.id as author_id,
+	books.title as title,
+	(SELECT COUNT(*) FROM copies WHERE copies.book_id = books.id AND copies.status = 'available' AND copies.deleted_at IS NULL) as available,
+	(SELECT COUNT(*) FROM copies WHERE copies.book_id = books.id AND copies.deleted_at IS NULL) as copies,
+	author.name as author_name,
+	books.deleted_at
+FROM
+	books
+INNER JOIN
+	author on books.author_id=author.id;
+
+DROP VIEW IF EXISTS v_rent;
+CREATE VIEW v_rent
+AS
+SELECT
+	records.id,
+	records.book_id,
+	records.member_id,
+	records.copy_id,
+	copies.barcode,
+	books.title as title,
+	author.name as author_name,
+	members.email,
+	members.firstname,
+	members.lastname,
+	records.rent_date,
+	records.due_date,
+	records.rent_status,
+	records.deleted_at
+FROM
+	records
+INNER JOIN
+	members on records.member_id=members.id
+INNER JOIN
+	books on records.book_id=books.id
+INNER JOIN
+	author on books.author_id=author.id
+LEFT JOIN
+	copies on records.copy_id=copies.id;
@@ -0,0 +1,10 @@
+-- The loan policies become membership categories, which also cap how many
+-- books their members may have on loan at once. A membership may expire, after
+-- which its member is refused checkouts.
+ALTER TABLE "loan_policies" RENAME TO "categories";
+ALTER TABLE "categories" ADD COLUMN "description" TEXT;
+ALTER TABLE "categories" ADD COLUMN "max_loans" int NOT NULL DEFAULT 0;
+
+ALTER TABLE "members" ADD COLUMN "expires_at" TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS "records_member" ON "records" ("member_id", "rent_status");
@@ -0,0 +1,103 @@
+-- The library may have several branches, found by their code. Every copy
+-- belongs to a branch, the main one unless it named another, and a loan to the
+-- branch of its copy. Staff, named by their principal, work at one branch. A
+-- copy moves to another branch through a transfer: requested, in transit, then
+-- received.
+CREATE TABLE IF NOT EXISTS "branches" (
+	"id"	INTEGER NOT NULL UNIQUE,
+	"code"	VARCHAR(64) NOT NULL UNIQUE,
+	"name"	VARCHAR(255) NOT NULL,
+	"address"	TEXT,
+	"created_at"	TIMESTAMP NOT NULL,
+	"version"	INTEGER NOT NULL DEFAULT 1,
+	"updated_at"	TIMESTAMP,
+	"deleted_at"	TIMESTAMP,
+	PRIMARY KEY("id" AUTOINCREMENT)
+);
+
+INSERT INTO "branches" ("code", "name", "created_at") VALUES ('main', 'Main library', CURRENT_TIMESTAMP);
+INSERT INTO "branches" ("code", "name", "created_at")
+SELECT DISTINCT "branch", "branch", CURRENT_TIMESTAMP FROM "copies" WHERE "branch" IS NOT NULL AND "branch" <> 'main';
+UPDATE "copies" SET "branch" = 'main' WHERE "branch" IS NULL;
+
+ALTER TABLE "records" ADD COLUMN "branch" VARCHAR(64);
+UPDATE "records" SET "branch" = (SELECT "branch" FROM "copies" WHERE "copies"."id" = "records"."copy_id")
+WHERE "copy_id" IS NOT NULL;
+
+CREATE TABLE IF NOT EXISTS "staff" (
+	"id"	INTEGER NOT NULL UNIQUE,
+	"principal"	VARCHAR(255) NOT NULL UNIQUE,
+	"branch"	VARCHAR(64) NOT NULL,
+	"created_at"	TIMESTAMP NOT NULL,
+	FOREIGN KEY("branch") REFERENCES "branches"("code") on delete cascade on update cascade,
+	PRIMARY KEY("id" AUTOINCREMENT)
+);
+
+CREATE TABLE IF NOT EXISTS "transfers" (
+	"id"	INTEGER NOT NULL UNIQUE,
+	"copy_id"	int NOT NULL,
+	"from_branch"	VARCHAR(64) NOT NULL,
+	"to_branch"	VARCHAR(64) NOT NULL,
+	"status"	VARCHAR(16) NOT NULL DEFAULT 'requested',
+	"requested_by"	VARCHAR(255),
+	"requested_at"	TIMESTAMP NOT NULL,
+	"shipped_at"	TIMESTAMP,
+	"received_at"	TIMESTAMP,
+	"version"	INTEGER NOT NULL DEFAULT 1,
+	"updated_at"	TIMESTAMP,
+	FOREIGN KEY("copy_id") REFERENCES "copies"("id") on delete cascade on update cascade,
+	PRIMARY KEY("id" AUTOINCREMENT)
+);
+
+CREATE INDEX IF NOT EXISTS "transfers_copy" ON "transfers" ("copy_id", "status");
+
+-- v_book_branches counts the copies of the books at each branch holding some.
+CREATE VIEW v_book_branches
+AS
+SELECT
+	books.id as book_id,
+	author.id as author_id,
+	books.title as title,
+	copies.branch as branch,
+	SUM(copies.status = 'available') as available,
+	COUNT(copies.id) as copies,
+	author.name as author_name,
+	books.deleted_at
+FROM
+	books
+INNER JOIN
+	author on books.author_id=author.id
+INNER JOIN
+	copies on copies.book_id=books.id AND copies.deleted_at IS NULL
+GROUP BY
+	books.id, copies.branch;
+
+DROP VIEW IF EXISTS v_rent;
+CREATE VIEW v_rent
+AS
+SELECT
+	records.id,
+	records.book_id,
+	records.member_id,
+	records.copy_id,
+	copies.barcode,
+	records.branch,
+	books.title as title,
+	author.name as author_name,
+	members.email,
+	members.firstname,
+	members.lastname,
+	records.rent_date,
+	records.due_date,
+	records.rent_status,
+	records.deleted_at
+FROM
+	records
+INNER JOIN
+	members on records.member_id=members.id
+INNER JOIN
+	books on records.book_id=books.id
+INNER JOIN
+	author on books.author_id=author.id
+LEFT JOIN
+	copies on records.copy_id=copies.id;
@@ -0,0 +1,13 @@
+-- The libraries hosted by the server when it hosts several, each in its own
+-- database, found by its code. The registry lives in the database of DB_PATH;
+-- the table is empty in the databases of the tenants.
+CREATE TABLE IF NOT EXISTS "tenants" (
+	"id"	INTEGER NOT NULL UNIQUE,
+	"code"	VARCHAR(63) NOT NULL UNIQUE,
+	"name"	VARCHAR(255) NOT NULL,
+	"created_at"	TIMESTAMP NOT NULL,
+	"version"	INTEGER NOT NULL DEFAULT 1,
+	"updated_at"	TIMESTAMP,
+	"deleted_at"	TIMESTAMP,
+	PRIMARY KEY("id" AUTOINCREMENT)
+);
@@ -0,0 +1,134 @@
+-- Books are described by their ISBN, publisher, year, edition, language, page
+-- count, description and subject headings. A book is told from another by its
+-- ISBN, kept as ISBN-13 with the ISBN-10 it has if any, and no longer by its
+-- title: different books may share one. SQLite drops no UNIQUE constraint, so
+-- the table is rebuilt, with the views on it.
+DROP VIEW IF EXISTS v_books;
+DROP VIEW IF EXISTS v_book_branches;
+DROP VIEW IF EXISTS v_rent;
+
+CREATE TABLE "books_new" (
+	"id"	INTEGER NOT NULL UNIQUE,
+	"title"	varchar(255) NOT NULL,
+	"author_id"	int NOT NULL,
+	"isbn"	VARCHAR(13) UNIQUE,
+	"isbn10"	VARCHAR(10),
+	"publisher"	VARCHAR(255),
+	"publication_year"	int,
+	"edition"	VARCHAR(64),
+	"language"	VARCHAR(16),
+	"page_count"	int,
+	"description"	TEXT,
+	"version"	INTEGER NOT NULL DEFAULT 1,
+	"updated_at"	TIMESTAMP,
+	"deleted_at"	TIMESTAMP,
+	FOREIGN KEY("author_id") REFERENCES "author"("id") on delete cascade on update cascade,
+	PRIMARY KEY("id" AUTOINCREMENT)
+);
+
+INSERT INTO "books_new" ("id", "title", "author_id", "version", "updated_at", "deleted_at")
+SELECT "id", "title", "author_id", "version", "updated_at", "deleted_at" FROM "books";
+
+-- The ids of purged books are not given again.
+DELETE FROM "sqlite_sequence" WHERE "name" = 'books_new';
+INSERT INTO "sqlite_sequence" ("name", "seq") SELECT 'books_new', "seq" FROM "sqlite_sequence" WHERE "name" = 'books';
+
+DROP TABLE "books";
+ALTER TABLE "books_new" RENAME TO "books";
+
+CREATE INDEX IF NOT EXISTS "books_title" ON "books" ("title");
+
+CREATE TABLE IF NOT EXISTS "book_subjects" (
+	"id"	INTEGER NOT NULL UNIQUE,
+	"book_id"	int NOT NULL,
+	"heading"	VARCHAR(255) NOT NULL,
+	FOREIGN KEY("book_id") REFERENCES "books"("id") on delete cascade on update cascade,
+	UNIQUE("book_id", "heading"),
+	PRIMARY KEY("id" AUTOINCREMENT)
+);
+
+CREATE INDEX IF NOT EXISTS "book_subjects_heading" ON "book_subjects" ("heading");
+
+-- subjects lists the headings of a book alphabetically, separated by "; ",
+-- for a filter to match one with like.
+CREATE VIEW v_books
+AS
+SELECT
+	books.id as book_id,
+	author.id as author_id,
+	books.title as title,
+	books.isbn,
+	books.isbn10,
+	books.publisher,
+	books.publication_year,
+	books.edition,
+	books.language,
+	books.page_count,
+	books.description,
+	(SELECT group_concat(heading, '; ') FROM (SELECT heading FROM book_subjects WHERE book_subjects.book_id = books.id ORDER BY heading)) as subjects,
+	(SELECT COUNT(*) FROM copies WHERE copies.book_id = books.id AND copies.status = 'available' AND copies.deleted_at IS NULL) as available,
+	(SELECT COUNT(*) FROM copies WHERE copies.book_id = books.id AND copies.deleted_at IS NULL) as copies,
+	author.name as author_name,
+	books.deleted_at
+FROM
+	books
+INNER JOIN
+	author on books.author_id=author.id;
+
+CREATE VIEW v_book_branches
+AS
+SELECT
+	books.id as book_id,
+	author.id as author_id,
+	books.title as title,
+	books.isbn,
+	books.isbn10,
+	books.publisher,
+	books.publication_year,
+	books.edition,
+	books.language,
+	books.page_count,
+	books.description,
+	(SELECT group_concat(heading, '; ') FROM (SELECT heading FROM book_subjects WHERE book_subjects.book_id = books.id ORDER BY heading)) as subjects,
+	copies.branch as branch,
+	SUM(copies.status = 'available') as available,
+	COUNT(copies.id) as copies,
+	author.name as author_name,
+	books.deleted_at
+FROM
+	books
+INNER JOIN
+	author on books.author_id=author.id
+INNER JOIN
+	copies on copies.book_id=books.id AND copies.deleted_at IS NULL
+GROUP BY
+	books.id, copies.branch;
+
+CREATE VIEW v_rent
+AS
+SELECT
+	records.id,
+	records.book_id,
+	records.member_id,
+	records.copy_id,
+	copies.barcode,
+	records.branch,
+	books.title as title,
+	author.name as author_name,
+	members.email,
+	members.firstname,
+	members.lastname,
+	records.rent_date,
+	records.due_date,
+	records.rent_status,
+	records.deleted_at
+FROM
+	records
+INNER JOIN
+	members on records.member_id=members.id
+INNER JOIN
+	books on records.book_id=books.id
+INNER JOIN
+	author on books.author_id=author.id
+LEFT JOIN
+	copies on records.copy_id=copies.id;
@@ -0,0 +1,292 @@
+package openapi
+
+import (
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	restful "github.com/emicklei/go-restful/v3"
+)
+
+const Version = "3.0.3"
+
+type Document struct {
+	OpenAPI    string               `json:"openapi"`
+	Info       Info                 `json:"info"`
+	Tags       []Tag                `json:"tags,omitempty"`
+	Paths      map[string]*PathItem `json:"paths"`
+	Components Components           `json:"components"`
+}
+
+type Info struct {
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+	Version     string `json:"version"`
+}
+
+type Tag struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+}
+
+type Components struct {
+	Schemas map[string]*Schema `json:"schemas"`
+}
+
+// PathItem maps lower case HTTP methods to operations.
+type PathItem map[string]*Operation
+
+type Operation struct {
+	Tags        []string            `json:"tags,omitempty"`
+	Summary     string              `json:"summary,omitempty"`
+	Description string              `json:"description,omitempty"`
+	OperationID string              `json:"operationId,omitempty"`
+	Parameters  []Parameter         `json:"parameters,omitempty"`
+	RequestBody *RequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]Response `json:"responses"`
+	Deprecated  bool                `json:"deprecated,omitempty"`
+}
+
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Description string               `json:"description,omitempty"`
+	Required    bool                 `json:"required,omitempty"`
+	Content     map[string]MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Route is a documented route as seen by the generator, one per
+// method and path of the registered WebServices.
+type Route struct {
+	WebService *restful.WebService
+	Route      restful.Route
+}
+
+// Routes lists the routes of every WebService registered in the container.
+func Routes(container *restful.Container) []Route {
+	routes := []Route{}
+	for _, service := range container.RegisteredWebServices() {
+		for _, route := range service.Routes() {
+			routes = append(routes, Route{WebService: service, Route: route})
+		}
+	}
+	return routes
+}
+
+var pathParamPattern = regexp.MustCompile(`\{([^}:]+)(:[^}]*)?\}`)
+
+// PathParameters returns the names of the {params} in a route path.
+func PathParameters(path string) []string {
+	names := []string{}
+	for _, match := range pathParamPattern.FindAllStringSubmatch(path, -1) {
+		names = append(names, strings.TrimSpace(match[1]))
+	}
+	return names
+}
+
+// Undocumented reports what is missing from the documentation of a route:
+// a summary, a response model and a description of every path parameter.
+func Undocumented(route restful.Route) []string {
+	missing := []string{}
+	if strings.TrimSpace(route.Doc) == "" {
+		missing = append(missing, "Doc")
+	}
+	if route.WriteSample == nil && len(route.ResponseErrors) == 0 {
+		missing = append(missing, "Writes or Returns")
+	}
+	documented := map[string]bool{}
+	for _, param := range route.ParameterDocs {
+		data := param.Data()
+		if data.Kind == restful.PathParameterKind && data.Description != "" {
+			documented[data.Name] = true
+		}
+	}
+	for _, name := range PathParameters(route.Path) {
+		if !documented[name] {
+			missing = append(missing, "path parameter "+name)
+		}
+	}
+	return missing
+}
+
+// Build generates an OpenAPI 3 document from the WebServices registered in
+// the container. Request and response models are taken from Reads, Writes
+// and Returns; the listed models are always part of components/schemas.
+func Build(container *restful.Container, info Info, models ...interface{}) *Document {
+	registry := newSchemaRegistry()
+	for _, model := range models {
+		registry.schemaOf(model)
+	}
+
+	doc := &Document{
+		OpenAPI: Version,
+		Info:    info,
+		Paths:   map[string]*PathItem{},
+	}
+	tags := map[string]string{}
+	for _, entry := range Routes(container) {
+		route := entry.Route
+		tag := strings.Trim(entry.WebService.RootPath(), "/")
+		if tag != "" {
+			if _, exist := tags[tag]; !exist || tags[tag] == "" {
+				tags[tag] = entry.WebService.Documentation()
+			}
+		}
+
+		// go-restful joins "" and "/" sub paths to the root path with a
+		// trailing slash, either form matches the same requests.
+		path := pathParamPattern.ReplaceAllString(route.Path, "{$1}")
+		if len(path) > 1 {
+			path = strings.TrimSuffix(path, "/")
+		}
+		item, exist := doc.Paths[path]
+		if !exist {
+			item = &PathItem{}
+			doc.Paths[path] = item
+		}
+		operation := &Operation{
+			Summary:     route.Doc,
+			Description: route.Notes,
+			OperationID: route.Operation,
+			Responses:   map[string]Response{},
+			Deprecated:  route.Deprecated,
+		}
+		if tag != "" {
+			operation.Tags = []string{tag}
+		}
+
+		for _, param := range route.ParameterDocs {
+			data := param.Data()
+			switch data.Kind {
+			case restful.BodyParameterKind:
+				operation.RequestBody = &RequestBody{
+					Description: data.Description,
+					Required:    data.Required,
+					Content:     content(route.Consumes, registry.schemaForBody(route.ReadSample, data.DataType)),
+				}
+			case restful.FormParameterKind:
+				// Form fields are not used by any route yet.
+			default:
+				operation.Parameters = append(operation.Parameters, Parameter{
+					Name:        data.Name,
+					In:          parameterLocation(data.Kind),
+					Description: data.Description,
+					Required:    data.Required || data.Kind == restful.PathParameterKind,
+					Schema:      parameterSchema(data),
+				})
+			}
+		}
+
+		if route.WriteSample != nil {
+			operation.Responses[strconv.Itoa(http.StatusOK)] = Response{
+				Description: http.StatusText(http.StatusOK),
+				Content:     content(route.Produces, registry.schemaOf(route.WriteSample)),
+			}
+		}
+		for code, returns := range route.ResponseErrors {
+			response := Response{Description: returns.Message}
+			if response.Description == "" {
+				response.Description = http.StatusText(code)
+			}
+			if returns.Model != nil {
+				response.Content = content(route.Produces, registry.schemaOf(returns.Model))
+			}
+			operation.Responses[strconv.Itoa(code)] = response
+		}
+		if len(operation.Responses) == 0 {
+			operation.Responses["default"] = Response{Description: "Undocumented response"}
+		}
+
+		(*item)[strings.ToLower(route.Method)] = operation
+	}
+
+	names := make([]string, 0, len(tags))
+	for name := range tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		doc.Tags = append(doc.Tags, Tag{Name: name, Description: tags[name]})
+	}
+	doc.Components.Schemas = registry.schemas
+	return doc
+}
+
+func parameterLocation(kind int) string {
+	switch kind {
+	case restful.PathParameterKind:
+		return "path"
+	case restful.HeaderParameterKind:
+		return "header"
+	default:
+		return "query"
+	}
+}
+
+func content(mimeTypes []string, schema *Schema) map[string]MediaType {
+	if len(mimeTypes) == 0 {
+		mimeTypes = []string{restful.MIME_JSON}
+	}
+	result := map[string]MediaType{}
+	for _, mime := range mimeTypes {
+		if strings.HasPrefix(mime, "text/") {
+			result[mime] = MediaType{Schema: &Schema{Type: "string"}}
+			continue
+		}
+		result[mime] = MediaType{Schema: schema}
+	}
+	return result
+}
+
+func (r *schemaRegistry) schemaForBody(sample interface{}, dataType string) *Schema {
+	if sample != nil {
+		return r.schemaOf(sample)
+	}
+	if _, known := r.schemas[dataType]; known {
+		return &Schema{Ref: refPrefix + dataType}
+	}
+	return primitive(dataType)
+}
+
+// parameterSchema describes a path, query or header parameter; repeatable
+// parameters are arrays of their data type.
+func parameterSchema(data restful.ParameterData) *Schema {
+	schema := primitive(data.DataType)
+	schema.Enum = data.PossibleValues
+	schema.Default = data.DefaultValue
+	if data.AllowMultiple {
+		return &Schema{Type: "array", Items: schema}
+	}
+	return schema
+}
+
+func primitive(dataType string) *Schema {
+	switch dataType {
+	case "integer", "int", "int64":
+		return &Schema{Type: "integer"}
+	case "number", "float", "float64":
+		return &Schema{Type: "number"}
+	case "boolean", "bool":
+		return &Schema{Type: "boolean"}
+	case "", "string":
+		return &Schema{Type: "string"}
+	}
+	return &Schema{Type: "string", Format: dataType}
+}
@@ -0,0 +1,76 @@
+package openapi
+
+import (
+	"net/http"
+	"testing"
+
+	restful "github.com/emicklei/go-restful/v3"
+)
+
+type item struct {
+	Id      int      `json:"id"`
+	Name    string   `json:"name"`
+	Tags    []string `json:"tags,omitempty"`
+	private string
+}
+
+type envelope struct {
+	Data   interface{} `json:"data"`
+	Errors []string    `json:"error"`
+}
+
+func noop(*restful.Request, *restful.Response) {}
+
+func TestBuild(t *testing.T) {
+	service := new(restful.WebService)
+	service.Path("/items").Doc("Items").Produces(restful.MIME_JSON)
+	service.Route(service.GET("/{item-id:[0-9]+}").To(noop).
+		Doc("Get item").
+		Param(service.PathParameter("item-id", "Identifier of item").DataType("integer")).
+		Writes(envelope{Data: item{}}))
+	service.Route(service.POST("").To(noop).
+		Doc("Create item").
+		Param(service.QueryParameter("on_conflict", "Conflict handling").PossibleValues([]string{"error", "ignore"}).DefaultValue("error")).
+		Param(service.QueryParameter("tag", "Tags").AllowMultiple(true)).
+		Reads(item{}).
+		Returns(http.StatusConflict, "Duplicate", envelope{}))
+	service.Route(service.DELETE("/{item-id}").To(noop))
+	container := restful.NewContainer()
+	container.Add(service)
+
+	doc := Build(container, Info{Title: "test", Version: "1"})
+
+	get := (*doc.Paths["/items/{item-id}"])["get"]
+	if get == nil || get.Parameters[0].Schema.Type != "integer" || !get.Parameters[0].Required {
+		t.Fatalf("path parameter not documented: %+v", get)
+	}
+	response := get.Responses["200"].Content[restful.MIME_JSON].Schema
+	if len(response.AllOf) != 2 || response.AllOf[1].Properties["data"].Ref != refPrefix+"item" {
+		t.Errorf("data should refine the envelope with item, got %+v", response)
+	}
+	schema := doc.Components.Schemas["item"]
+	if len(schema.Properties) != 3 || schema.Properties["tags"].Items.Type != "string" {
+		t.Errorf("unexpected item schema %+v", schema)
+	}
+	post := (*doc.Paths["/items"])["post"]
+	if post.RequestBody == nil || post.Responses["409"].Description != "Duplicate" {
+		t.Errorf("unexpected post operation %+v", post)
+	}
+	if len(post.Parameters) != 2 || len(post.Parameters[0].Schema.Enum) != 2 || post.Parameters[0].Schema.Default != "error" ||
+		post.Parameters[1].Schema.Type != "array" {
+		t.Errorf("unexpected query parameters %+v", post.Parameters)
+	}
+	if len(doc.Tags) != 1 || doc.Tags[0].Name != "items" {
+		t.Errorf("unexpected tags %+v", doc.Tags)
+	}
+
+	for _, route := range service.Routes() {
+		missing := Undocumented(route)
+		if route.Method == http.MethodDelete && len(missing) != 3 {
+			t.Errorf("DELETE should miss doc, response and path parameter, got %v", missing)
+		}
+		if route.Method != http.MethodDelete && len(missing) != 0 {
+			t.Errorf("%v %v should be documented, missing %v", route.Method, route.Path, missing)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package openapi
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+const refPrefix = "#/components/schemas/"
+
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	AllOf                []*Schema          `json:"allOf,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Default              string             `json:"default,omitempty"`
+}
+
+type schemaRegistry struct {
+	schemas map[string]*Schema
+}
+
+func newSchemaRegistry() *schemaRegistry {
+	return &schemaRegistry{schemas: map[string]*Schema{}}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// schemaOf returns the schema of a sample value, registering named structs as
+// components. Interface fields set in the sample, like ResponseObj{Data:
+// []Book{}}, refine the component for that use only.
+func (r *schemaRegistry) schemaOf(sample interface{}) *Schema {
+	if sample == nil {
+		return &Schema{}
+	}
+	return r.valueSchema(reflect.ValueOf(sample))
+}
+
+func (r *schemaRegistry) valueSchema(value reflect.Value) *Schema {
+	for value.Kind() == reflect.Pointer || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return r.typeSchema(value.Type())
+		}
+		value = value.Elem()
+	}
+	switch value.Kind() {
+	case reflect.Struct:
+		if value.Type() == timeType {
+			return r.typeSchema(value.Type())
+		}
+		schema := r.typeSchema(value.Type())
+		refined := map[string]*Schema{}
+		forEachField(value.Type(), func(name string, index []int, _ reflect.StructField) {
+			field := value.FieldByIndex(index)
+			if field.Kind() == reflect.Interface && !field.IsNil() {
+				refined[name] = r.valueSchema(field.Elem())
+			}
+		})
+		if len(refined) == 0 {
+			return schema
+		}
+		return &Schema{AllOf: []*Schema{schema, {Type: "object", Properties: refined}}}
+	case reflect.Slice, reflect.Array:
+		if value.Len() > 0 {
+			return &Schema{Type: "array", Items: r.valueSchema(value.Index(0))}
+		}
+	}
+	return r.typeSchema(value.Type())
+}
+
+func (r *schemaRegistry) typeSchema(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t == timeType {
+		return &Schema{Type: "string", Format: "date-time"}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if t.Kind() == reflect.Int64 || t.Kind() == reflect.Uint64 {
+			return &Schema{Type: "integer", Format: "int64"}
+		}
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: r.typeSchema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: r.typeSchema(t.Elem())}
+	case reflect.Interface:
+		return &Schema{}
+	case reflect.Struct:
+		name := t.Name()
+		if name == "" {
+			return r.structSchema(t)
+		}
+		if _, exist := r.schemas[name]; !exist {
+			// Register first so recursive types terminate.
+			r.schemas[name] = &Schema{Type: "object"}
+			r.schemas[name] = r.structSchema(t)
+		}
+		return &Schema{Ref: refPrefix + name}
+	}
+	return &Schema{}
+}
+
+func (r *schemaRegistry) structSchema(t reflect.Type) *Schema {
+	schema := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	forEachField(t, func(name string, _ []int, field reflect.StructField) {
+		schema.Properties[name] = r.typeSchema(field.Type)
+	})
+	return schema
+}
+
+// forEachField visits the JSON-visible fields of a struct, following
+// encoding/json naming and flattening embedded structs.
+func forEachField(t reflect.Type, visit func(name string, index []int, field reflect.StructField)) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			forEachField(field.Type, func(name string, index []int, inner reflect.StructField) {
+				visit(name, append([]int{i}, index...), inner)
+			})
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		visit(name, []int{i}, field)
+	}
+}
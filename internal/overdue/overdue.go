@@ -0,0 +1,217 @@
+package overdue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/riszkymf/golang-rest-boilerplate/internal/events"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/handler"
+	utils "github.com/riszkymf/golang-rest-boilerplate/internal/src"
+)
+
+// leaseName is the lease held by the instance running the scheduler.
+const leaseName = "overdue-scheduler"
+
+const dateLayout = "2006-01-02"
+
+// Result is what a run of the scheduler did.
+type Result struct {
+	Today   string `json:"today"`
+	Checked int    `json:"checked"`
+	Flagged int    `json:"flagged"`
+	RanAt   string `json:"ran_at"`
+}
+
+// MemberSummary sums up the overdue loans of a member.
+type MemberSummary struct {
+	MemberId         int    `json:"member_id"`
+	Email            string `json:"email"`
+	Firstname        string `json:"firstname"`
+	Lastname         string `json:"lastname"`
+	OverdueLoans     int    `json:"overdue_loans"`
+	MaxDaysOverdue   int    `json:"max_days_overdue"`
+	TotalDaysOverdue int    `json:"total_days_overdue"`
+	OldestDueDate    string `json:"oldest_due_date"`
+}
+
+// Scheduler marks the loans past their due date overdue. A loan is due on
+// the date of its due_date, in the time zone of the library, and overdue
+// from the following day.
+type Scheduler struct {
+	mu       sync.Mutex
+	location *time.Location
+	holder   string
+	now      func() time.Time
+}
+
+func NewScheduler(location *time.Location) *Scheduler {
+	host, _ := os.Hostname()
+	return &Scheduler{
+		location: location,
+		holder:   fmt.Sprintf("%v/%v/%v", host, os.Getpid(), uuid.NewString()[:8]),
+		now:      time.Now,
+	}
+}
+
+var Default = NewScheduler(time.UTC)
+
+func (s *Scheduler) SetLocation(location *time.Location) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.location = location
+}
+
+// Today is the current date in the time zone of the library.
+func (s *Scheduler) Today() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now().In(s.location)
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// DaysOverdue is how many days after dueDate today is, 0 if it is not past.
+// Only the date of dueDate counts.
+func DaysOverdue(dueDate string, today time.Time) int {
+	if len(dueDate) < len(dateLayout) {
+		return 0
+	}
+	due, err := time.Parse(dateLayout, dueDate[:len(dateLayout)])
+	if err != nil || !today.After(due) {
+		return 0
+	}
+	return int(today.Sub(due).Hours() / 24)
+}
+
+// Filter selects the loans of table, records or v_rent, that are overdue on
+// today, whether flagged yet or not. Lost books are no longer loans.
+func Filter(today time.Time) map[string][]handler.FieldFilter {
+	return map[string][]handler.FieldFilter{
+		"rent_status": {
+			{Operator: "not", Value: events.StatusReturned, ValueType: "string"},
+			{Operator: "not", Value: events.StatusLost, ValueType: "string"},
+		},
+		// due_date is stored as text starting with its date, which sorts
+		// before the bare date of the same day.
+		"due_date": {{Operator: "lt", Value: today.Format(dateLayout), ValueType: "string"}},
+	}
+}
+
+// Flag marks overdue the rented loans past their due date. Each is updated
+// on its own, only if unchanged since it was read, which raises its
+// rent.overdue event; a loan returned meanwhile is left alone.
+func (s *Scheduler) Flag(ctx context.Context) (Result, error) {
+	today := s.Today()
+	result := Result{Today: today.Format(dateLayout)}
+	filter := Filter(today)
+	filter["rent_status"] = []handler.FieldFilter{{Operator: "eq", Value: events.StatusRented, ValueType: "string"}}
+	loans, err := handler.GetRowByFilterContext(ctx, "records", handler.FilterQuery{And: filter})
+	if err != nil {
+		return result, err
+	}
+	result.Checked = len(loans)
+	for _, loan := range loans {
+		id, _ := loan["id"].(int)
+		change := map[string]any{"rent_status": events.StatusOverdue}
+		if version, ok := loan["version"].(int); ok {
+			err = handler.UpdateDataIfVersionContext(ctx, "records", change, id, version)
+		} else {
+			err = handler.UpdateDataContext(ctx, "records", change, id)
+		}
+		if errors.Is(err, handler.ErrVersionMismatch) || errors.Is(err, handler.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return result, err
+		}
+		result.Flagged++
+	}
+	result.RanAt = time.Now().UTC().Format(time.RFC3339)
+	return result, nil
+}
+
+// Run flags the overdue loans at once and then every interval until ctx is
+// done. Instances sharing the database take turns through a lease: the one
+// holding it runs, and keeps it while it keeps running.
+func (s *Scheduler) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		s.sweep(ctx, interval)
+		select {
+		case <-ctx.Done():
+			// Another instance may take over without waiting for the lease
+			// to expire.
+			err := handler.ReleaseLeaseContext(handler.Detach(ctx), leaseName, s.holder)
+			utils.CheckError(err, "[overdue]", "release lease")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sweep flags the overdue loans if the scheduler holds the lease, or takes
+// it, for interval.
+func (s *Scheduler) sweep(ctx context.Context, interval time.Duration) {
+	acquired, err := handler.AcquireLeaseContext(ctx, leaseName, s.holder, interval)
+	if err != nil {
+		utils.CheckErrorContext(ctx, err, "[overdue]", "acquire lease")
+		return
+	}
+	if !acquired {
+		return
+	}
+	result, err := s.Flag(ctx)
+	if err != nil {
+		utils.CheckErrorContext(ctx, err, "[overdue]", "flag overdue loans")
+		return
+	}
+	if result.Flagged > 0 {
+		utils.LogInfo("[overdue]", "flag overdue loans", strconv.Itoa(result.Flagged)+" loans overdue")
+	}
+}
+
+// Summarize groups overdue loans, rows of v_rent, by member, the members with
+// the longest overdue loan first.
+func Summarize(loans []map[string]any, today time.Time) []MemberSummary {
+	members := map[int]*MemberSummary{}
+	for _, loan := range loans {
+		memberId, _ := loan["member_id"].(int)
+		summary, ok := members[memberId]
+		if !ok {
+			summary = &MemberSummary{MemberId: memberId}
+			summary.Email, _ = loan["email"].(string)
+			summary.Firstname, _ = loan["firstname"].(string)
+			summary.Lastname, _ = loan["lastname"].(string)
+			members[memberId] = summary
+		}
+		dueDate, _ := loan["due_date"].(string)
+		days := DaysOverdue(dueDate, today)
+		summary.OverdueLoans++
+		summary.TotalDaysOverdue += days
+		if days > summary.MaxDaysOverdue {
+			summary.MaxDaysOverdue = days
+		}
+		if len(dueDate) >= len(dateLayout) && (summary.OldestDueDate == "" || dueDate[:len(dateLayout)] < summary.OldestDueDate) {
+			summary.OldestDueDate = dueDate[:len(dateLayout)]
+		}
+	}
+	result := make([]MemberSummary, 0, len(members))
+	for _, summary := range members {
+		result = append(result, *summary)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].MaxDaysOverdue != result[j].MaxDaysOverdue {
+			return result[i].MaxDaysOverdue > result[j].MaxDaysOverdue
+		}
+		return result[i].MemberId < result[j].MemberId
+	})
+	return result
+}
@@ -0,0 +1,119 @@
+package overdue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/riszkymf/golang-rest-boilerplate/internal/handler"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/testdb"
+)
+
+func TestFlag(t *testing.T) {
+	handler.Connection = testdb.Open(t)
+	ctx := context.Background()
+	insert := func(table string, data map[string]any) int {
+		id, err := handler.InsertData(table, data)
+		if err != nil {
+			t.Fatalf(`Error: %v`, err)
+		}
+		return id
+	}
+	author := insert("author", map[string]any{"name": "Herman Melville"})
+	book := insert("books", map[string]any{"title": "Moby Dick", "author_id": author})
+	member := insert("members", map[string]any{"firstname": "Ishmael", "lastname": "Sailor"})
+	loan := func(due string, status string) int {
+		return insert("records", map[string]any{"book_id": book, "member_id": member, "rent_date": "2024-02-01 00:00:00", "due_date": due, "rent_status": status})
+	}
+	past := loan("2024-03-01 00:00:00", "rented")
+	dueToday := loan("2024-03-02 00:00:00", "rented")
+	returned := loan("2024-02-15 00:00:00", "returned")
+
+	// 2024-03-02 01:00 in Tokyo is still March 1st in UTC.
+	tokyo, _ := time.LoadLocation("Asia/Tokyo")
+	scheduler := NewScheduler(tokyo)
+	scheduler.now = func() time.Time { return time.Date(2024, 3, 1, 16, 0, 0, 0, time.UTC) }
+	result, err := scheduler.Flag(ctx)
+	if err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	if result.Today != "2024-03-02" || result.Flagged != 1 {
+		t.Errorf("the loan due on March 1st should be overdue on March 2nd in Tokyo, got %+v", result)
+	}
+	for id, want := range map[int]string{past: "overdue", dueToday: "rented", returned: "returned"} {
+		row, _ := handler.GetRowById("records", id)
+		if row["rent_status"] != want {
+			t.Errorf("loan %v should be %v, got %v", id, want, row["rent_status"])
+		}
+	}
+	if result, _ := scheduler.Flag(ctx); result.Flagged != 0 {
+		t.Errorf("flagged loans should not be flagged again, got %+v", result)
+	}
+
+	scheduler.SetLocation(time.UTC)
+	if result, _ := scheduler.Flag(ctx); result.Today != "2024-03-01" {
+		t.Errorf("today should follow the time zone, got %v", result.Today)
+	}
+}
+
+func TestRun(t *testing.T) {
+	handler.Connection = testdb.Open(t)
+	author, _ := handler.InsertData("author", map[string]any{"name": "Herman Melville"})
+	book, _ := handler.InsertData("books", map[string]any{"title": "Moby Dick", "author_id": author})
+	member, _ := handler.InsertData("members", map[string]any{"firstname": "Ishmael", "lastname": "Sailor"})
+	loan, err := handler.InsertData("records", map[string]any{"book_id": book, "member_id": member, "rent_date": "2024-02-01 00:00:00", "due_date": "2024-03-01 00:00:00", "rent_status": "rented"})
+	if err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+
+	// The loan is flagged by the sweep at start, not an hour later.
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		NewScheduler(time.UTC).Run(ctx, time.Hour)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if row, _ := handler.GetRowById("records", loan); row["rent_status"] == "overdue" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Run should flag the overdue loans when it starts")
+		}
+	}
+}
+
+func TestDaysOverdue(t *testing.T) {
+	today := time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC)
+	for due, want := range map[string]int{
+		"2024-03-01 00:00:00":  9,
+		"2024-03-09T23:59:59Z": 1,
+		"2024-03-10":           0,
+		"2024-04-01 00:00:00":  0,
+		"":                     0,
+	} {
+		if got := DaysOverdue(due, today); got != want {
+			t.Errorf("%q should be %v days overdue, got %v", due, want, got)
+		}
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	today := time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC)
+	summaries := Summarize([]map[string]any{
+		{"member_id": 1, "firstname": "Ishmael", "due_date": "2024-03-08 00:00:00"},
+		{"member_id": 2, "firstname": "Ahab", "due_date": "2024-03-01 00:00:00"},
+		{"member_id": 1, "firstname": "Ishmael", "due_date": "2024-03-05 00:00:00"},
+	}, today)
+	if len(summaries) != 2 || summaries[0].MemberId != 2 {
+		t.Fatalf("members should be sorted by their longest overdue loan, got %+v", summaries)
+	}
+	ishmael := summaries[1]
+	if ishmael.OverdueLoans != 2 || ishmael.MaxDaysOverdue != 5 || ishmael.TotalDaysOverdue != 7 || ishmael.OldestDueDate != "2024-03-05" {
+		t.Errorf("summary should count and sum the loans, got %+v", ishmael)
+	}
+}
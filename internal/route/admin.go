@@ -0,0 +1,34 @@
+package route
+
+import (
+	"net/http"
+
+	restful "github.com/emicklei/go-restful/v3"
+
+	"github.com/riszkymf/golang-rest-boilerplate/internal/overdue"
+)
+
+func AdminRoute() *restful.WebService {
+	service := new(restful.WebService)
+	service.
+		Path("/admin").
+		Consumes(restful.MIME_JSON).
+		Produces(restful.MIME_JSON, restful.MIME_XML)
+
+	service.Route(service.POST("/overdue/run").
+		To(RunOverdueCheck).
+		Doc("Flag the loans past their due date overdue now, without waiting for the scheduler").
+		AllowedMethodsWithoutContentType([]string{http.MethodPost}).
+		Returns(http.StatusOK, "What the run did", ResponseObj{Data: overdue.Result{}}))
+
+	return service
+}
+
+func RunOverdueCheck(request *restful.Request, response *restful.Response) {
+	result, err := overdue.Default.Flag(request.Request.Context())
+	if err != nil {
+		resourceError(response, http.StatusInternalServerError, err)
+		return
+	}
+	response.WriteHeaderAndEntity(http.StatusOK, ResponseObj{Data: result, StatusCode: http.StatusOK, Item: "run"})
+}
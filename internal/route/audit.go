@@ -0,0 +1,158 @@
+package route
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	restful "github.com/emicklei/go-restful/v3"
+
+	"github.com/riszkymf/golang-rest-boilerplate/internal/handler"
+)
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+type AuditEntry struct {
+	Id        int            `json:"id"`
+	TableName string         `json:"table_name"`
+	RowId     int            `json:"row_id"`
+	Action    string         `json:"action"`
+	Before    map[string]any `json:"before"`
+	After     map[string]any `json:"after"`
+	Principal string         `json:"principal"`
+	RequestId string         `json:"request_id"`
+	CreatedAt string         `json:"created_at"`
+}
+
+func AuditRoute() *restful.WebService {
+	service := new(restful.WebService)
+	service.
+		Path("/audit").
+		Produces(restful.MIME_JSON, restful.MIME_XML)
+
+	service.Route(service.GET("/").
+		To(GetAuditLog).
+		Doc("Retrieve the changes written to the database, newest first").
+		Param(service.QueryParameter("table", "Only the changes to this table, e.g. members")).
+		Param(service.QueryParameter("row_id", "Only the changes to this row, with table").DataType("integer")).
+		Param(service.QueryParameter("actor", "Only the changes made by this principal")).
+		Param(service.QueryParameter("action", "Only these changes").PossibleValues([]string{
+			handler.ActionInsert, handler.ActionUpdate, handler.ActionDelete, handler.ActionRestore, handler.ActionPurge,
+		})).
+		Param(service.QueryParameter("from", "Changes made at or after this time, RFC 3339 or YYYY-MM-DD")).
+		Param(service.QueryParameter("to", "Changes made before this time, RFC 3339 or YYYY-MM-DD")).
+		Param(service.QueryParameter("limit", fmt.Sprintf("At most this many changes, up to %v", maxAuditLimit)).DataType("integer").DefaultValue(strconv.Itoa(defaultAuditLimit))).
+		Returns(http.StatusOK, "The changes", ResponseObj{Data: []AuditEntry{}}).
+		Returns(http.StatusBadRequest, "Invalid parameter", ResponseObj{}))
+	return service
+}
+
+func GetAuditLog(request *restful.Request, response *restful.Response) {
+	filter := handler.FilterQuery{And: map[string][]handler.FieldFilter{}}
+	equal := func(column string, param string, valueType string) {
+		if value := request.QueryParameter(param); value != "" {
+			filter.And[column] = append(filter.And[column], handler.FieldFilter{Operator: "eq", Value: value, ValueType: valueType})
+		}
+	}
+	equal("table_name", "table", "string")
+	equal("row_id", "row_id", "int")
+	equal("principal", "actor", "string")
+	equal("action", "action", "string")
+	for param, operator := range map[string]string{"from": "gte", "to": "lt"} {
+		value := request.QueryParameter(param)
+		if value == "" {
+			continue
+		}
+		at, err := parseTime(value)
+		if err != nil {
+			resourceError(response, http.StatusBadRequest, fmt.Errorf("%v: %v", param, err))
+			return
+		}
+		filter.And["created_at"] = append(filter.And["created_at"], handler.FieldFilter{Operator: operator, Value: at, ValueType: "string"})
+	}
+	if len(filter.And) == 0 {
+		filter.And = nil
+	}
+	limit := defaultAuditLimit
+	if value := request.QueryParameter("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxAuditLimit {
+			resourceError(response, http.StatusBadRequest, fmt.Errorf("limit must be between 1 and %v", maxAuditLimit))
+			return
+		}
+		limit = parsed
+	}
+	writeAuditLog(request, response, handler.ListQuery{
+		Filter: filter,
+		Sort:   []handler.SortField{{Column: "id", Desc: true}},
+		Limit:  limit,
+	})
+}
+
+// historyRoute lists the changes to the row of table identified by the path
+// parameter idParam, oldest first.
+func historyRoute(service *restful.WebService, idParam string, table string, item string) *restful.RouteBuilder {
+	return service.GET(fmt.Sprintf("/{%v}/history", idParam)).
+		To(historyHandler(idParam, table)).
+		Operation("Get" + strings.ToUpper(item[:1]) + item[1:] + "History").
+		Writes(ResponseObj{Data: []AuditEntry{}})
+}
+
+func historyHandler(idParam string, table string) restful.RouteFunction {
+	return func(request *restful.Request, response *restful.Response) {
+		id := request.PathParameter(idParam)
+		if _, err := strconv.Atoi(id); err != nil {
+			resourceError(response, http.StatusBadRequest, errors.New("ID must be numerical"))
+			return
+		}
+		writeAuditLog(request, response, handler.ListQuery{
+			Filter: handler.FilterQuery{And: map[string][]handler.FieldFilter{
+				"table_name": {{Operator: "eq", Value: table, ValueType: "string"}},
+				"row_id":     {{Operator: "eq", Value: id, ValueType: "int"}},
+			}},
+			Sort: []handler.SortField{{Column: "id"}},
+		})
+	}
+}
+
+func writeAuditLog(request *restful.Request, response *restful.Response, query handler.ListQuery) {
+	entries, err := handler.GetRowsContext(request.Request.Context(), handler.AuditTable, query)
+	if err != nil {
+		resourceError(response, http.StatusInternalServerError, err)
+		return
+	}
+	// before and after are stored as JSON, they are answered as objects.
+	for _, entry := range entries {
+		for _, column := range []string{"before", "after"} {
+			var change map[string]any
+			if text, _ := entry[column].(string); text != "" {
+				if err := json.Unmarshal([]byte(text), &change); err != nil {
+					resourceError(response, http.StatusInternalServerError, err)
+					return
+				}
+			}
+			entry[column] = change
+		}
+	}
+	response.WriteHeaderAndEntity(http.StatusOK, ResponseObj{Data: entries, StatusCode: http.StatusOK, Item: "entry"})
+}
+
+// parseTime reads an RFC 3339 time or a date, as the created_at of the audit
+// log is written.
+func parseTime(value string) (string, error) {
+	at, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		at, err = time.Parse("2006-01-02", value)
+	}
+	if err != nil {
+		return "", fmt.Errorf("%q is neither RFC 3339 nor YYYY-MM-DD", value)
+	}
+	return at.UTC().Format("2006-01-02 15:04:05"), nil
+}
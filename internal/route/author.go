@@ -17,42 +17,79 @@ type AuthorResponse struct {
 
 type Author struct {
 	Id   int    `json:"id" default:"-1"`
-	Name string `json:"title" default:""`
+	Name string `json:"name" default:""`
 }
 
 func AuthorRoute() *restful.WebService {
 	service := new(restful.WebService)
 	service.
 		Path("/author").
-		Consumes(restful.MIME_XML, restful.MIME_JSON).
-		Produces(restful.MIME_JSON, restful.MIME_XML)
+		Consumes(bodyMimes...).
+		Produces(resourceMimes...)
 
 	service.Route(service.GET("/{author-id}").
-		To(GetAuthor)).
+		To(GetAuthor).
 		Doc("Retrieve author by ID").
-		Param(service.PathParameter("author-id", "Identifier of author").DataType("integer"))
+		Param(service.PathParameter("author-id", "Identifier of author").DataType("integer")).
+		Do(readParams(service)).
+		Writes(ResponseObj{Data: Author{}}))
 	service.Route(service.GET("/").
-		To(GetAllAuthors)).
-		Doc("Retrieve available authors")
+		To(GetAllAuthors).
+		Produces(collectionMimes...).
+		Do(listParams(service)).
+		Doc("Retrieve available authors").
+		Writes(ResponseObj{Data: []Author{}}))
 	service.Route(service.POST("").
-		To(InsertAuthor)).
-		Doc("Insert new author")
+		To(InsertAuthor).
+		Doc("Insert new author").
+		Param(conflictParam(service, "name")).
+		Reads(Author{}, "Author to insert, the id is assigned by the database").
+		Writes(ResponseObj{Data: Author{}}))
 	service.Route(service.POST("/{author-id}").
-		To(UpdateAuthor)).
+		To(UpdateAuthor).
 		Doc("Update author by ID").
-		Param(service.PathParameter("author-id", "Identifier of author").DataType("integer"))
+		Param(service.PathParameter("author-id", "Identifier of author").DataType("integer")).
+		Do(writeParams(service)).
+		Reads(Author{}, "Fields to update, omitted fields are left unchanged").
+		Writes(ResponseObj{Data: Author{}}))
+	service.Route(service.DELETE("/{author-id}").
+		To(DeleteAuthor).
+		Doc("Delete author by ID").
+		Notes("The author is kept, marked deleted, until purged.").
+		Param(service.PathParameter("author-id", "Identifier of author").DataType("integer")).
+		Do(writeParams(service)).
+		Returns(http.StatusNoContent, "Deleted", nil))
+	service.Route(restoreRoute(service, "author-id", "author", "author").
+		Doc("Restore deleted author by ID").
+		Param(service.PathParameter("author-id", "Identifier of author").DataType("integer")).
+		Writes(ResponseObj{Data: Author{}}))
+	service.Route(historyRoute(service, "author-id", "author", "author").
+		Doc("Retrieve the changes to author by ID, oldest first").
+		Param(service.PathParameter("author-id", "Identifier of author").DataType("integer")))
 	return service
 }
 
 func GetAllAuthors(request *restful.Request, response *restful.Response) {
-	author, err := handler.GetRowsAll("author")
+	ctx := request.Request.Context()
+	columns, err := handler.ColumnsContext(ctx, "author")
 	if err != nil {
 		res := ResponseObj{Data: nil, Errors: []string{err.Error()}, StatusCode: http.StatusInternalServerError}
-		response.WriteAsJson(res)
+		response.WriteEntity(res)
 		return
 	}
-	res := ResponseObj{Data: author, Errors: nil, StatusCode: 200}
-	response.WriteAsJson(res)
+	query, err := listQuery(request, columns)
+	if err != nil {
+		resourceError(response, http.StatusBadRequest, err)
+		return
+	}
+	author, err := handler.GetRowsContext(ctx, "author", query)
+	if err != nil {
+		res := ResponseObj{Data: nil, Errors: []string{err.Error()}, StatusCode: http.StatusInternalServerError}
+		response.WriteEntity(res)
+		return
+	}
+	res := ResponseObj{Data: author, Errors: nil, StatusCode: 200, Item: "author", Columns: columns}
+	response.WriteEntity(res)
 }
 
 func GetAuthor(request *restful.Request, response *restful.Response) {
@@ -60,17 +97,15 @@ func GetAuthor(request *restful.Request, response *restful.Response) {
 	idParse, err := strconv.Atoi(id)
 	if err != nil {
 		utils.LogError("GetAuthor", "Invalid Id Type", err.Error())
-		response.WriteAsJson(ResponseObj{Data: nil, Errors: []string{"ID must be numerical"}, StatusCode: http.StatusBadRequest})
+		response.WriteEntity(ResponseObj{Data: nil, Errors: []string{"ID must be numerical"}, StatusCode: http.StatusBadRequest})
 		return
 	}
-	author, err := handler.GetRowById("author", idParse)
+	author, err := handler.GetRowByIdContext(request.Request.Context(), "author", idParse)
 	if err != nil {
-		res := ResponseObj{Data: nil, Errors: []string{err.Error()}, StatusCode: http.StatusInternalServerError}
-		response.WriteAsJson(res)
+		resourceError(response, http.StatusInternalServerError, err)
 		return
 	}
-	res := ResponseObj{Data: author, StatusCode: http.StatusOK}
-	response.WriteAsJson(res)
+	writeResource(request, response, author, "author")
 }
 
 func InsertAuthor(request *restful.Request, response *restful.Response) {
@@ -78,23 +113,29 @@ func InsertAuthor(request *restful.Request, response *restful.Response) {
 	err := request.ReadEntity(&author)
 	if err != nil {
 		res := ResponseObj{Errors: []string{err.Error()}, StatusCode: http.StatusBadRequest}
-		response.WriteAsJson(res)
+		response.WriteHeaderAndEntity(res.StatusCode, res)
+		return
+	}
+	mode, err := conflictMode(request)
+	if err != nil {
+		res := ResponseObj{Errors: []string{err.Error()}, StatusCode: http.StatusBadRequest}
+		response.WriteHeaderAndEntity(res.StatusCode, res)
 		return
 	}
 
 	inputData := map[string]any{
 		"name": author.Name,
 	}
-	addAuthor, err := handler.InsertData("author", inputData)
+	stored, err := insertResolving(request.Request.Context(), mode, "author", inputData, "name")
 	if err != nil {
 		res := ResponseObj{
 			Errors:     []string{err.Error()},
-			StatusCode: http.StatusInternalServerError,
+			StatusCode: insertErrorStatus(err),
 		}
-		response.WriteAsJson(res)
+		response.WriteHeaderAndEntity(res.StatusCode, res)
+		return
 	}
-	author.Id = addAuthor
-	response.WriteAsJson(ResponseObj{Data: author})
+	response.WriteHeaderAndEntity(http.StatusOK, ResponseObj{Data: stored, StatusCode: http.StatusOK, Item: "author"})
 
 }
 
@@ -103,7 +144,7 @@ func UpdateAuthor(request *restful.Request, response *restful.Response) {
 	idParse, err := strconv.Atoi(id)
 	if err != nil {
 		utils.LogError("Update Author", "Invalid Id Type", err.Error())
-		response.WriteAsJson(ResponseObj{Data: nil, Errors: []string{"ID must be numerical"}, StatusCode: http.StatusBadRequest})
+		response.WriteEntity(ResponseObj{Data: nil, Errors: []string{"ID must be numerical"}, StatusCode: http.StatusBadRequest})
 		return
 	}
 
@@ -119,18 +160,11 @@ func UpdateAuthor(request *restful.Request, response *restful.Response) {
 	filteredInput, err := utils.FilterInputMap(author, updateInput)
 	if err != nil {
 		res := ResponseObj{Errors: []string{err.Error()}, StatusCode: http.StatusInternalServerError}
-		response.WriteAsJson(res)
+		response.WriteEntity(res)
 		return
 	}
 
-	err = handler.UpdateData("author", filteredInput, author.Id)
-	if err != nil {
-		res := ResponseObj{Errors: []string{err.Error()}, StatusCode: http.StatusInternalServerError}
-		response.WriteAsJson(res)
-		return
-	}
-	filteredInput["id"] = author.Id
-	response.WriteAsJson(ResponseObj{Data: filteredInput})
+	updateResource(request, response, "author", author.Id, filteredInput, "author")
 
 }
 
@@ -139,12 +173,8 @@ func DeleteAuthor(request *restful.Request, response *restful.Response) {
 	idParse, err := strconv.Atoi(id)
 	if err != nil {
 		utils.LogError("DeleteAuthor", "Invalid Id Type", err.Error())
-		response.WriteAsJson(ResponseObj{Data: nil, Errors: []string{"ID must be numerical"}, StatusCode: http.StatusBadRequest})
-		return
-	}
-	err = handler.DeleteData("author", idParse)
-	if err != nil {
-		response.WriteAsJson(ResponseObj{Errors: []string{err.Error()}, StatusCode: http.StatusBadRequest})
+		response.WriteEntity(ResponseObj{Data: nil, Errors: []string{"ID must be numerical"}, StatusCode: http.StatusBadRequest})
 		return
 	}
+	deleteResource(request, response, "author", idParse)
 }
@@ -1,55 +1,167 @@
 package route
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 
 	restful "github.com/emicklei/go-restful/v3"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/catalogue"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/circulation"
 	"github.com/riszkymf/golang-rest-boilerplate/internal/handler"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/importer"
 	utils "github.com/riszkymf/golang-rest-boilerplate/internal/src"
 )
 
+// Book is a title of the catalogue. Copies is only read on insert: the book
+// starts with as many copies, barcoded after it. The ISBN is given in either
+// form and stored as ISBN-13, with the ISBN-10 it has, if any, in ISBN10.
 type Book struct {
-	Id       int    `json:"id" default:"-1"`
-	Title    string `json:"title" default:""`
-	AuthorId int    `json:"author_id" default:""`
-	Stock    int    `json:"stock" default:"-1"`
+	Id              int      `json:"id" default:"-1"`
+	Title           string   `json:"title" default:""`
+	AuthorId        int      `json:"author_id" default:""`
+	ISBN            string   `json:"isbn" default:""`
+	ISBN10          string   `json:"isbn10,omitempty"`
+	Publisher       string   `json:"publisher" default:""`
+	PublicationYear int      `json:"publication_year" default:""`
+	Edition         string   `json:"edition" default:""`
+	Language        string   `json:"language" default:""`
+	PageCount       int      `json:"page_count" default:""`
+	Description     string   `json:"description" default:""`
+	Subjects        []string `json:"subjects"`
+	Copies          int      `json:"copies,omitempty"`
 }
 
 func BooksRoute() *restful.WebService {
 	service := new(restful.WebService)
 	service.
 		Path("/books").
-		Consumes(restful.MIME_XML, restful.MIME_JSON).
-		Produces(restful.MIME_JSON, restful.MIME_XML)
+		Consumes(bodyMimes...).
+		Produces(resourceMimes...)
 
 	service.Route(service.GET("/{book-id}").
-		To(GetBook)).
+		To(GetBook).
 		Doc("Retrieve book by ID").
-		Param(service.PathParameter("book-id", "Identifier of book").DataType("integer"))
+		Notes("branches counts the copies of the book at each branch holding some.").
+		Param(service.PathParameter("book-id", "Identifier of book").DataType("integer")).
+		Do(readParams(service)).
+		Writes(ResponseObj{Data: Book{}}))
+	service.Route(service.GET("/isbn/{isbn}").
+		To(GetBookByISBN).
+		Doc("Retrieve book by ISBN").
+		Notes("The ISBN is given in either form, ISBN-10 or ISBN-13, hyphens allowed.").
+		Param(service.PathParameter("isbn", "ISBN of book, e.g. 978-0-306-40615-7")).
+		Do(readParams(service)).
+		Returns(http.StatusOK, "The book", ResponseObj{Data: Book{}}).
+		Returns(http.StatusBadRequest, "Invalid ISBN", ResponseObj{}))
 	service.Route(service.GET("/").
-		To(GetAllBooks)).
-		Doc("Retrieve available books")
+		To(GetAllBooks).
+		Produces(collectionMimes...).
+		Do(listParams(service)).
+		Param(branchParam(service)).
+		Do(branchScoped).
+		Doc("Retrieve available books").
+		Notes("available counts the copies of a book on the shelf, copies all of them; see /copies. " +
+			"Scoped to a branch, the books with copies there are listed with the counts of the branch. " +
+			"subjects lists the subject headings of a book alphabetically, separated by \"; \": filter=subjects:like:%Fantasy% finds a heading.").
+		Writes(ResponseObj{Data: []Book{}}))
 	service.Route(service.POST("").
-		To(InsertBook)).
-		Doc("Insert new book")
+		To(InsertBook).
+		Doc("Insert new book").
+		Notes("The ISBN is checked and stored as ISBN-13; books without one are never taken for another, so on_conflict other than error needs an ISBN. Titles need not be unique.").
+		Param(conflictParam(service, "isbn")).
+		Reads(Book{}, "Book to insert, the id is assigned by the database").
+		Returns(http.StatusOK, "The book", ResponseObj{Data: Book{}}).
+		Returns(http.StatusBadRequest, "Invalid book", ResponseObj{}).
+		Returns(http.StatusConflict, "A book has the ISBN", ResponseObj{}))
+	service.Route(importRoute(service, "books", importBook).
+		Doc("Import books from CSV or NDJSON, updating books with the same ISBN").
+		Notes("Columns: title, author (a name, created if unknown) or author_id, copies, the number of copies a new book starts with, " +
+			"isbn, publisher, publication_year, edition, language, page_count, description and subjects, separated by \";\". " +
+			"A row updates the book with its ISBN, otherwise the only one with its title and author and no other ISBN."))
 	service.Route(service.POST("/{book-id}").
-		To(UpdateBook)).
+		To(UpdateBook).
 		Doc("Update book by ID").
 		Param(service.PathParameter("book-id", "Identifier of book").DataType("integer")).
-		Param(service.BodyParameter("body", "testing").DataType("Book"))
+		Notes("A field given empty or null is cleared; subjects, if given, replace those of the book.").
+		Do(writeParams(service)).
+		Reads(Book{}, "Fields to update, omitted fields are left unchanged").
+		Writes(ResponseObj{Data: Book{}}))
+	service.Route(service.DELETE("/{book-id}").
+		To(DeleteBook).
+		Doc("Delete book by ID").
+		Notes("The book is kept, marked deleted, until purged; its loans stay in records.").
+		Param(service.PathParameter("book-id", "Identifier of book").DataType("integer")).
+		Do(writeParams(service)).
+		Returns(http.StatusNoContent, "Deleted", nil))
+	service.Route(restoreRoute(service, "book-id", "books", "book").
+		Doc("Restore deleted book by ID").
+		Param(service.PathParameter("book-id", "Identifier of book").DataType("integer")).
+		Writes(ResponseObj{Data: Book{}}))
+	service.Route(service.POST("/{book-id}/holds").
+		To(PlaceHold).
+		Doc("Queue a member for a book with no copy available").
+		Notes("Holds are served first come first served: a copy returned is set aside for the oldest waiting hold, "+
+			"ready_for_pickup until its expires_at. Refused with 409 if a copy is available or the member already holds the book.").
+		Param(service.PathParameter("book-id", "Identifier of book").DataType("integer")).
+		Reads(HoldInput{}, "The member").
+		Returns(http.StatusCreated, "The hold, with its position in the queue", ResponseObj{Data: circulation.Hold{}}).
+		Returns(http.StatusNotFound, "No such book or member", ResponseObj{}).
+		Returns(http.StatusConflict, "Refused", ResponseObj{}))
+	service.Route(service.GET("/{book-id}/holds").
+		To(GetBookHolds).
+		Doc("Retrieve the holds of book by ID, in queue order").
+		Param(service.PathParameter("book-id", "Identifier of book").DataType("integer")).
+		Param(allParam(service)).
+		Writes(ResponseObj{Data: []circulation.Hold{}}))
+	service.Route(service.DELETE("/{book-id}/holds/{hold-id}").
+		To(CancelHold).
+		Doc("Cancel a hold").
+		Notes("A copy set aside for the hold goes to the next one.").
+		Param(service.PathParameter("book-id", "Identifier of book").DataType("integer")).
+		Param(service.PathParameter("hold-id", "Identifier of hold").DataType("integer")).
+		Returns(http.StatusOK, "The hold", ResponseObj{Data: circulation.Hold{}}).
+		Returns(http.StatusNotFound, "No such hold", ResponseObj{}).
+		Returns(http.StatusConflict, "The hold is no longer active", ResponseObj{}))
+	service.Route(historyRoute(service, "book-id", "books", "book").
+		Doc("Retrieve the changes to book by ID, oldest first").
+		Param(service.PathParameter("book-id", "Identifier of book").DataType("integer")))
 	return service
 }
 
 func GetAllBooks(request *restful.Request, response *restful.Response) {
-	books, err := handler.GetRowsAll("v_books")
+	ctx := request.Request.Context()
+	branch, ok := branchScope(request, response)
+	if !ok {
+		return
+	}
+	table := "v_books"
+	if branch != "" {
+		table = "v_book_branches"
+	}
+	columns, err := handler.ColumnsContext(ctx, table)
+	if err != nil {
+		res := ResponseObj{Data: nil, Errors: []string{err.Error()}, StatusCode: http.StatusInternalServerError}
+		response.WriteEntity(res)
+		return
+	}
+	query, err := listQuery(request, columns)
+	if err != nil {
+		resourceError(response, http.StatusBadRequest, err)
+		return
+	}
+	scopeQuery(&query, branch)
+	books, err := handler.GetRowsContext(ctx, table, query)
 	if err != nil {
 		res := ResponseObj{Data: nil, Errors: []string{err.Error()}, StatusCode: http.StatusInternalServerError}
-		response.WriteAsJson(res)
+		response.WriteEntity(res)
 		return
 	}
-	res := ResponseObj{Data: books, Errors: nil, StatusCode: 200}
-	response.WriteAsJson(res)
+	res := ResponseObj{Data: books, Errors: nil, StatusCode: 200, Item: "book", Columns: columns}
+	response.WriteEntity(res)
 }
 
 func GetBook(request *restful.Request, response *restful.Response) {
@@ -57,17 +169,53 @@ func GetBook(request *restful.Request, response *restful.Response) {
 	idParse, err := strconv.Atoi(id)
 	if err != nil {
 		utils.LogError("GetBook", "Invalid Id Type", err.Error())
-		response.WriteAsJson(ResponseObj{Data: nil, Errors: []string{"ID must be numerical"}, StatusCode: http.StatusBadRequest})
+		response.WriteEntity(ResponseObj{Data: nil, Errors: []string{"ID must be numerical"}, StatusCode: http.StatusBadRequest})
 		return
 	}
-	book, err := handler.GetRowById("books", idParse)
+	book, err := handler.GetRowByIdContext(request.Request.Context(), "books", idParse)
 	if err != nil {
-		res := ResponseObj{Data: nil, Errors: []string{err.Error()}, StatusCode: http.StatusInternalServerError}
-		response.WriteAsJson(res)
+		resourceError(response, http.StatusInternalServerError, err)
+		return
+	}
+	writeBook(request, response, book)
+}
+
+func GetBookByISBN(request *restful.Request, response *restful.Response) {
+	isbn, err := catalogue.ParseISBN(request.PathParameter("isbn"))
+	if err != nil {
+		resourceError(response, http.StatusBadRequest, err)
 		return
 	}
-	res := ResponseObj{Data: book, StatusCode: http.StatusOK}
-	response.WriteAsJson(res)
+	books, err := handler.GetRowByFilterContext(request.Request.Context(), "books", handler.FilterQuery{And: map[string][]handler.FieldFilter{
+		"isbn": {{Operator: "eq", Value: isbn.ISBN13, ValueType: "string"}},
+	}})
+	if err != nil {
+		resourceError(response, http.StatusInternalServerError, err)
+		return
+	}
+	book := map[string]any{}
+	if len(books) > 0 {
+		book = books[0]
+	}
+	writeBook(request, response, book)
+}
+
+// writeBook answers with the book, its subjects and the copies of it at each
+// branch.
+func writeBook(request *restful.Request, response *restful.Response, book map[string]any) {
+	ctx := request.Request.Context()
+	if id, ok := book["id"].(int); ok {
+		var err error
+		if book["subjects"], err = catalogue.Subjects(ctx, id); err != nil {
+			resourceError(response, http.StatusInternalServerError, err)
+			return
+		}
+		if book["branches"], err = bookAvailability(ctx, id); err != nil {
+			resourceError(response, http.StatusInternalServerError, err)
+			return
+		}
+	}
+	writeResource(request, response, book, "book")
 }
 
 func InsertBook(request *restful.Request, response *restful.Response) {
@@ -75,39 +223,84 @@ func InsertBook(request *restful.Request, response *restful.Response) {
 	err := request.ReadEntity(&book)
 	if err != nil {
 		res := ResponseObj{Errors: []string{err.Error()}, StatusCode: http.StatusBadRequest}
-		response.WriteAsJson(res)
+		response.WriteHeaderAndEntity(res.StatusCode, res)
+		return
+	}
+	mode, err := conflictMode(request)
+	if err != nil {
+		res := ResponseObj{Errors: []string{err.Error()}, StatusCode: http.StatusBadRequest}
+		response.WriteHeaderAndEntity(res.StatusCode, res)
 		return
 	}
 	authorId := book.AuthorId
-	author, err := handler.GetRowById("author", authorId)
+	author, err := handler.GetRowByIdContext(request.Request.Context(), "author", authorId)
 	if err != nil {
 		res := ResponseObj{Errors: []string{err.Error()}, StatusCode: http.StatusInternalServerError}
-		response.WriteAsJson(res)
+		response.WriteHeaderAndEntity(res.StatusCode, res)
 		return
 	}
 	if author["id"] == nil {
 		res := ResponseObj{
 			Errors:     []string{"author_id does not exist"},
-			StatusCode: http.StatusNoContent,
+			StatusCode: http.StatusUnprocessableEntity,
 		}
-		response.WriteAsJson(res)
+		response.WriteHeaderAndEntity(res.StatusCode, res)
+		return
+	}
+	if book.Copies < 0 {
+		resourceError(response, http.StatusBadRequest, errors.New("copies must not be negative"))
 		return
 	}
 	inputData := map[string]any{
-		"title":     book.Title,
-		"stock":     book.Stock,
-		"author_id": book.AuthorId,
+		"title":            book.Title,
+		"author_id":        book.AuthorId,
+		"isbn":             book.ISBN,
+		"publisher":        book.Publisher,
+		"publication_year": optional(book.PublicationYear),
+		"edition":          book.Edition,
+		"language":         book.Language,
+		"page_count":       optional(book.PageCount),
+		"description":      book.Description,
 	}
-	addBook, err := handler.InsertData("books", inputData)
+	if err := catalogue.Normalize(inputData); err != nil {
+		resourceError(response, http.StatusBadRequest, err)
+		return
+	}
+	if mode != "error" && inputData["isbn"] == nil {
+		resourceError(response, http.StatusBadRequest, fmt.Errorf("on_conflict=%v needs an isbn: a book without one matches no other", mode))
+		return
+	}
+	subjects, err := catalogue.NormalizeSubjects(book.Subjects)
+	if err != nil {
+		resourceError(response, http.StatusBadRequest, err)
+		return
+	}
+	var stored map[string]any
+	err = handler.WithTransaction(request.Request.Context(), func(ctx context.Context) error {
+		stored, err = insertResolving(ctx, mode, "books", inputData, "isbn")
+		if err != nil {
+			return err
+		}
+		id, _ := stored["id"].(int)
+		if book.Subjects != nil {
+			if err := addSubjects(ctx, id, subjects, mode != "ignore"); err != nil {
+				return err
+			}
+		}
+		if stored["subjects"], err = catalogue.Subjects(ctx, id); err != nil {
+			return err
+		}
+		return addCopies(ctx, id, book.Copies)
+	})
 	if err != nil {
 		res := ResponseObj{
 			Errors:     []string{err.Error()},
-			StatusCode: http.StatusInternalServerError,
+			StatusCode: insertErrorStatus(err),
 		}
-		response.WriteAsJson(res)
+		response.WriteHeaderAndEntity(res.StatusCode, res)
+		return
 	}
-	book.Id = addBook
-	response.WriteAsJson(ResponseObj{Data: book})
+	response.WriteHeaderAndEntity(http.StatusOK, ResponseObj{Data: stored, StatusCode: http.StatusOK, Item: "book"})
 
 }
 
@@ -116,7 +309,7 @@ func UpdateBook(request *restful.Request, response *restful.Response) {
 	idParse, err := strconv.Atoi(id)
 	if err != nil {
 		utils.LogError("Update Book", "Invalid Id Type", err.Error())
-		response.WriteAsJson(ResponseObj{Data: nil, Errors: []string{"ID must be numerical"}, StatusCode: http.StatusBadRequest})
+		response.WriteEntity(ResponseObj{Data: nil, Errors: []string{"ID must be numerical"}, StatusCode: http.StatusBadRequest})
 		return
 	}
 
@@ -125,41 +318,260 @@ func UpdateBook(request *restful.Request, response *restful.Response) {
 	book := Book{
 		Id:       idParse,
 		Title:    "",
-		Stock:    -1,
 		AuthorId: -1,
 	}
 
 	err = request.ReadEntity(&updateInput)
 
+	subjects, replaceSubjects := updateInput["subjects"]
+	delete(updateInput, "subjects")
 	filteredInput, err := utils.FilterInputMap(book, updateInput)
 	if err != nil {
 		res := ResponseObj{Errors: []string{err.Error()}, StatusCode: http.StatusInternalServerError}
-		response.WriteAsJson(res)
+		response.WriteEntity(res)
 		return
 	}
-
-	err = handler.UpdateData("books", filteredInput, book.Id)
+	// FilterInputMap drops the values equal to the empty ones of the
+	// reference, which clear the optional columns.
+	for _, column := range catalogue.Columns {
+		if value, ok := updateInput[column]; ok && (value == nil || value == "") {
+			filteredInput[column] = nil
+		}
+	}
+	if err := catalogue.Normalize(filteredInput); err != nil {
+		resourceError(response, http.StatusBadRequest, err)
+		return
+	}
+	headings, err := subjectHeadings(subjects)
+	if err == nil {
+		headings, err = catalogue.NormalizeSubjects(headings)
+	}
 	if err != nil {
-		res := ResponseObj{Errors: []string{err.Error()}, StatusCode: http.StatusInternalServerError}
-		response.WriteAsJson(res)
+		resourceError(response, http.StatusBadRequest, err)
 		return
 	}
-	filteredInput["id"] = book.Id
-	response.WriteAsJson(ResponseObj{Data: filteredInput})
+
+	updateResource(request, response, "books", book.Id, filteredInput, "book", func(ctx context.Context, row map[string]any) error {
+		if replaceSubjects {
+			if err := catalogue.SetSubjects(ctx, book.Id, headings); err != nil {
+				return err
+			}
+		}
+		var err error
+		row["subjects"], err = catalogue.Subjects(ctx, book.Id)
+		return err
+	})
 
 }
 
+// subjectHeadings reads the subjects of a JSON body, a list of headings or
+// null for none.
+func subjectHeadings(value any) ([]string, error) {
+	list, ok := value.([]any)
+	if !ok && value != nil {
+		return nil, fmt.Errorf("%w: subjects must be a list of headings", catalogue.ErrInvalidBook)
+	}
+	headings := make([]string, len(list))
+	for i, item := range list {
+		if headings[i], ok = item.(string); !ok {
+			return nil, fmt.Errorf("%w: subjects must be a list of headings", catalogue.ErrInvalidBook)
+		}
+	}
+	return headings, nil
+}
+
+// optional is n, or nil for a column left empty when n is 0.
+func optional(n int) any {
+	if n == 0 {
+		return nil
+	}
+	return n
+}
+
+// addSubjects gives the book bookId the subject headings, replacing those it
+// has if replace, otherwise only if it has none.
+func addSubjects(ctx context.Context, bookId int, headings []string, replace bool) error {
+	if !replace {
+		existing, err := catalogue.Subjects(ctx, bookId)
+		if err != nil || len(existing) > 0 {
+			return err
+		}
+	}
+	return catalogue.SetSubjects(ctx, bookId, headings)
+}
+
 func DeleteBook(request *restful.Request, response *restful.Response) {
 	id := request.PathParameter("book-id")
 	idParse, err := strconv.Atoi(id)
 	if err != nil {
 		utils.LogError("Delete Book", "Invalid Id Type", err.Error())
-		response.WriteAsJson(ResponseObj{Data: nil, Errors: []string{"ID must be numerical"}, StatusCode: http.StatusBadRequest})
+		response.WriteEntity(ResponseObj{Data: nil, Errors: []string{"ID must be numerical"}, StatusCode: http.StatusBadRequest})
 		return
 	}
-	err = handler.DeleteData("books", idParse)
+	deleteResource(request, response, "books", idParse)
+}
+
+// importBook upserts a book by its ISBN or, without one, by its title and
+// author, looking up or creating its author by name. The columns describing
+// the book are set when present in the file, cleared when given empty. A new
+// book starts with its copies; those of a book already in the catalogue are
+// managed at /copies.
+func importBook(ctx context.Context, row importer.Row, result *importer.Result) []string {
+	messages := []string{}
+	title := row.Value("title")
+	if title == "" {
+		messages = append(messages, "title is required")
+	}
+	var err error
+	copies := 0
+	if raw := row.Value("copies"); raw != "" {
+		if copies, err = strconv.Atoi(raw); err != nil || copies < 0 {
+			messages = append(messages, "copies must be a non-negative integer")
+		}
+	}
+	authorName := row.Value("author")
+	if authorName == "" {
+		authorName = row.Value("author_name")
+	}
+	authorId := 0
+	if raw := row.Value("author_id"); raw != "" {
+		authorId, err = strconv.Atoi(raw)
+		if err != nil {
+			messages = append(messages, "author_id must be an integer")
+		}
+	} else if authorName == "" {
+		messages = append(messages, "author or author_id is required")
+	}
+	data := map[string]any{"title": title}
+	for _, column := range catalogue.Columns {
+		if _, ok := row.Values[column]; ok {
+			data[column] = row.Value(column)
+		}
+	}
+	if err := catalogue.Normalize(data); err != nil {
+		messages = append(messages, err.Error())
+	}
+	var subjects []string
+	if _, ok := row.Values["subjects"]; ok {
+		if raw := row.Value("subjects"); raw != "" {
+			subjects = strings.Split(raw, ";")
+		}
+		if subjects, err = catalogue.NormalizeSubjects(subjects); err != nil {
+			messages = append(messages, err.Error())
+		}
+	}
+	if len(messages) > 0 {
+		return messages
+	}
+
+	if authorId == 0 {
+		authorId, err = lookupOrCreateAuthor(ctx, authorName, result)
+		if err != nil {
+			return []string{err.Error()}
+		}
+	} else {
+		author, err := handler.GetRowByIdContext(ctx, "author", authorId)
+		if err != nil {
+			return []string{err.Error()}
+		}
+		if author["id"] == nil {
+			return []string{"author_id does not exist"}
+		}
+	}
+	data["author_id"] = authorId
+
+	book, err := findBook(ctx, data)
 	if err != nil {
-		response.WriteAsJson(ResponseObj{Errors: []string{err.Error()}, StatusCode: http.StatusBadRequest})
-		return
+		return []string{err.Error()}
+	}
+	var id int
+	if book != nil {
+		id, _ = book["id"].(int)
+		if err := handler.UpdateDataContext(ctx, "books", data, id); err != nil {
+			return []string{err.Error()}
+		}
+		result.Updated++
+	} else {
+		if id, err = handler.InsertDataContext(ctx, "books", data); err != nil {
+			return []string{err.Error()}
+		}
+		if err := addCopies(ctx, id, copies); err != nil {
+			return []string{err.Error()}
+		}
+		result.Inserted++
+	}
+	if subjects != nil {
+		if err := catalogue.SetSubjects(ctx, id, subjects); err != nil {
+			return []string{err.Error()}
+		}
+	}
+	return nil
+}
+
+// findBook returns the book with the ISBN of data or else the only book with
+// its title and author, and no other ISBN, nil if there is none. A deleted
+// book is restored.
+func findBook(ctx context.Context, data map[string]any) (map[string]any, error) {
+	filter := map[string][]handler.FieldFilter{
+		"title":     {{Operator: "eq", Value: fmt.Sprint(data["title"]), ValueType: "string"}},
+		"author_id": {{Operator: "eq", Value: fmt.Sprint(data["author_id"]), ValueType: "int"}},
+	}
+	if isbn, ok := data["isbn"].(string); ok {
+		book, err := findByKey(ctx, "books", "isbn", isbn)
+		if err != nil || book != nil {
+			return book, err
+		}
+		filter["isbn"] = []handler.FieldFilter{{Operator: "isEmpty"}}
+	}
+	books, err := handler.GetRowByFilterContext(handler.WithDeleted(ctx), "books", handler.FilterQuery{And: filter})
+	if err != nil || len(books) == 0 {
+		return nil, err
+	}
+	if len(books) > 1 {
+		return nil, fmt.Errorf("%v books are titled %q by this author, give the isbn of the one to update", len(books), data["title"])
+	}
+	if handler.IsDeleted(books[0]) {
+		id, _ := books[0]["id"].(int)
+		if err := handler.RestoreDataContext(ctx, "books", id); err != nil {
+			return nil, err
+		}
+	}
+	return books[0], nil
+}
+
+func lookupOrCreateAuthor(ctx context.Context, name string, result *importer.Result) (int, error) {
+	author, err := findByKey(ctx, "author", "name", name)
+	if err != nil {
+		return 0, err
+	}
+	if author != nil {
+		id, _ := author["id"].(int)
+		return id, nil
+	}
+	id, err := handler.InsertDataContext(ctx, "author", map[string]any{"name": name})
+	if err != nil {
+		return 0, err
+	}
+	result.Created["author"]++
+	return id, nil
+}
+
+// addCopies shelves n copies of the book bookId, unless it has copies
+// already: a book existing before the insert keeps its own.
+func addCopies(ctx context.Context, bookId int, n int) error {
+	if n == 0 {
+		return nil
+	}
+	existing, err := handler.CountRowsContext(handler.WithDeleted(ctx), "copies", handler.FilterQuery{And: map[string][]handler.FieldFilter{
+		"book_id": {{Operator: "eq", Value: strconv.Itoa(bookId), ValueType: "int"}},
+	}})
+	if err != nil || existing > 0 {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		if _, err := circulation.Default.AddCopy(ctx, circulation.Copy{BookId: bookId}); err != nil {
+			return err
+		}
 	}
+	return nil
 }
@@ -0,0 +1,291 @@
+package route
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	restful "github.com/emicklei/go-restful/v3"
+
+	"github.com/riszkymf/golang-rest-boilerplate/internal/circulation"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/handler"
+	utils "github.com/riszkymf/golang-rest-boilerplate/internal/src"
+)
+
+// BranchUpdate is the fields of a branch POST /branches/{code} changes.
+type BranchUpdate struct {
+	Name    string `json:"name" default:""`
+	Address string `json:"address" default:""`
+}
+
+// BranchAvailability counts the copies of a book at a branch, as listed by
+// GET /books/{book-id}.
+type BranchAvailability struct {
+	Branch    string `json:"branch"`
+	Available int    `json:"available"`
+	Copies    int    `json:"copies"`
+}
+
+func BranchesRoute() *restful.WebService {
+	service := new(restful.WebService)
+	service.
+		Path("/branches").
+		Consumes(bodyMimes...).
+		Produces(resourceMimes...)
+
+	code := service.PathParameter("code", "Code of branch, e.g. main")
+	service.Route(service.GET("/").
+		To(GetAllBranches).
+		Produces(collectionMimes...).
+		Do(listParams(service)).
+		Doc("Retrieve the branches of the library").
+		Writes(ResponseObj{Data: []circulation.Branch{}}))
+	service.Route(service.GET("/{code}").
+		To(GetBranch).
+		Doc("Retrieve branch by code").
+		Param(code).
+		Do(readParams(service)).
+		Writes(ResponseObj{Data: circulation.Branch{}}))
+	service.Route(service.POST("").
+		To(InsertBranch).
+		Doc("Open a branch").
+		Reads(circulation.Branch{}, "Branch to open").
+		Returns(http.StatusCreated, "The branch", ResponseObj{Data: circulation.Branch{}}).
+		Returns(http.StatusBadRequest, "Invalid branch", ResponseObj{}).
+		Returns(http.StatusConflict, "The code is taken", ResponseObj{}))
+	service.Route(service.POST("/{code}").
+		To(UpdateBranch).
+		Doc("Update the name or address of branch by code").
+		Param(code).
+		Do(writeParams(service)).
+		Reads(BranchUpdate{}, "Fields to update, omitted fields are left unchanged").
+		Writes(ResponseObj{Data: circulation.Branch{}}))
+	service.Route(service.DELETE("/{code}").
+		To(DeleteBranch).
+		Doc("Close branch by code").
+		Notes("A branch is closed once its copies are transferred or withdrawn. Its staff are unassigned.").
+		Param(code).
+		Returns(http.StatusNoContent, "Closed", nil).
+		Returns(http.StatusNotFound, "No such branch", ResponseObj{}).
+		Returns(http.StatusConflict, "Copies belong to the branch", ResponseObj{}))
+	service.Route(service.GET("/{code}/staff").
+		To(GetBranchStaff).
+		Doc("Retrieve the staff of branch by code").
+		Param(code).
+		Writes(ResponseObj{Data: []circulation.Staff{}}))
+	service.Route(service.POST("/{code}/staff").
+		To(AssignBranchStaff).
+		Doc("Make a principal staff of branch by code").
+		Notes("Requests naming the principal in the principal header are scoped to the branch: they list its books and loans, "+
+			"lend and manage its copies, and ship, receive or cancel its transfers. A principal is staff of one branch at a time.").
+		Param(code).
+		Reads(circulation.Staff{}, "The principal; the branch is that of the path").
+		Returns(http.StatusOK, "The staff", ResponseObj{Data: circulation.Staff{}}).
+		Returns(http.StatusNotFound, "No such branch", ResponseObj{}))
+	service.Route(service.DELETE("/{code}/staff/{principal}").
+		To(RemoveBranchStaff).
+		Doc("Unassign a principal from branch by code").
+		Param(code).
+		Param(service.PathParameter("principal", "Principal of staff")).
+		Returns(http.StatusNoContent, "Unassigned", nil).
+		Returns(http.StatusNotFound, "No such staff", ResponseObj{}))
+	return service
+}
+
+func GetAllBranches(request *restful.Request, response *restful.Response) {
+	ctx := request.Request.Context()
+	columns, err := handler.ColumnsContext(ctx, "branches")
+	if err != nil {
+		resourceError(response, http.StatusInternalServerError, err)
+		return
+	}
+	query, err := listQuery(request, columns)
+	if err != nil {
+		resourceError(response, http.StatusBadRequest, err)
+		return
+	}
+	branches, err := handler.GetRowsContext(ctx, "branches", query)
+	if err != nil {
+		resourceError(response, http.StatusInternalServerError, err)
+		return
+	}
+	response.WriteEntity(ResponseObj{Data: branches, StatusCode: http.StatusOK, Item: "branch", Columns: columns})
+}
+
+func GetBranch(request *restful.Request, response *restful.Response) {
+	branch, err := circulation.FindBranch(request.Request.Context(), request.PathParameter("code"))
+	if err != nil {
+		resourceError(response, resourceErrorStatus(err), err)
+		return
+	}
+	writeResource(request, response, branch, "branch")
+}
+
+func InsertBranch(request *restful.Request, response *restful.Response) {
+	input := circulation.Branch{}
+	if err := request.ReadEntity(&input); err != nil {
+		resourceError(response, http.StatusBadRequest, err)
+		return
+	}
+	branch, err := circulation.Default.AddBranch(request.Request.Context(), input)
+	if err != nil {
+		branchError(response, err)
+		return
+	}
+	response.WriteHeaderAndEntity(http.StatusCreated, ResponseObj{Data: branch, StatusCode: http.StatusCreated, Item: "branch"})
+}
+
+func UpdateBranch(request *restful.Request, response *restful.Response) {
+	ctx := request.Request.Context()
+	branch, err := circulation.FindBranch(ctx, request.PathParameter("code"))
+	if err != nil {
+		resourceError(response, resourceErrorStatus(err), err)
+		return
+	}
+	var updateInput map[string]interface{}
+	if err := request.ReadEntity(&updateInput); err != nil {
+		resourceError(response, http.StatusBadRequest, err)
+		return
+	}
+	filteredInput, err := utils.FilterInputMap(BranchUpdate{}, updateInput)
+	if err != nil {
+		resourceError(response, http.StatusInternalServerError, err)
+		return
+	}
+	id, _ := branch["id"].(int)
+	updateResource(request, response, "branches", id, filteredInput, "branch")
+}
+
+func DeleteBranch(request *restful.Request, response *restful.Response) {
+	if err := circulation.DeleteBranch(request.Request.Context(), request.PathParameter("code")); err != nil {
+		circulationError(response, err)
+		return
+	}
+	response.WriteHeader(http.StatusNoContent)
+}
+
+func GetBranchStaff(request *restful.Request, response *restful.Response) {
+	ctx := request.Request.Context()
+	code := request.PathParameter("code")
+	if _, err := circulation.FindBranch(ctx, code); err != nil {
+		resourceError(response, resourceErrorStatus(err), err)
+		return
+	}
+	staff, err := circulation.ListStaff(ctx, code)
+	if err != nil {
+		resourceError(response, http.StatusInternalServerError, err)
+		return
+	}
+	response.WriteHeaderAndEntity(http.StatusOK, ResponseObj{Data: staff, StatusCode: http.StatusOK, Item: "staff"})
+}
+
+func AssignBranchStaff(request *restful.Request, response *restful.Response) {
+	staff := circulation.Staff{}
+	if err := request.ReadEntity(&staff); err != nil {
+		resourceError(response, http.StatusBadRequest, err)
+		return
+	}
+	staff.Branch = request.PathParameter("code")
+	if err := circulation.Default.AssignStaff(request.Request.Context(), staff); err != nil {
+		branchError(response, err)
+		return
+	}
+	response.WriteHeaderAndEntity(http.StatusOK, ResponseObj{Data: staff, StatusCode: http.StatusOK, Item: "staff"})
+}
+
+func RemoveBranchStaff(request *restful.Request, response *restful.Response) {
+	err := circulation.RemoveStaff(request.Request.Context(), request.PathParameter("code"), request.PathParameter("principal"))
+	if err != nil {
+		resourceError(response, resourceErrorStatus(err), err)
+		return
+	}
+	response.WriteHeader(http.StatusNoContent)
+}
+
+// branchError answers an invalid branch with 400, otherwise as
+// circulationError.
+func branchError(response *restful.Response, err error) {
+	if errors.Is(err, circulation.ErrInvalidBranch) {
+		resourceError(response, http.StatusBadRequest, err)
+		return
+	}
+	circulationError(response, err)
+}
+
+// branchParam documents the branch query parameter read by branchScope.
+func branchParam(service *restful.WebService) *restful.Parameter {
+	return service.QueryParameter("branch", "Code of branch to scope to, that of the staff making the request by default")
+}
+
+// BranchScoped is the metadata key marking the routes scoped to the branch of
+// the staff making the request.
+const BranchScoped = "branch-scoped"
+
+// branchScoped marks a route scoped to the branch of staff. Once principals are
+// authenticated, anonymous requests to it are refused.
+func branchScoped(builder *restful.RouteBuilder) {
+	builder.Metadata(BranchScoped, true).
+		Returns(http.StatusUnauthorized, "No authenticated principal", ResponseObj{})
+}
+
+// branchScope reads the branch a list is scoped to, that of ?branch= or of
+// the staff making the request, "" for every branch. Staff may not see other
+// branches; the failure is answered.
+func branchScope(request *restful.Request, response *restful.Response) (string, bool) {
+	branch, err := scopedBranch(request)
+	if err != nil {
+		resourceError(response, http.StatusForbidden, err)
+		return "", false
+	}
+	return branch, true
+}
+
+// scopedBranch is branchScope leaving the failure to the caller.
+func scopedBranch(request *restful.Request) (string, error) {
+	branch := request.QueryParameter("branch")
+	staff := utils.BranchFromContext(request.Request.Context())
+	if staff == "" {
+		return branch, nil
+	}
+	if branch != "" && branch != staff {
+		return "", fmt.Errorf("staff of branch %v may not see branch %v", staff, branch)
+	}
+	return staff, nil
+}
+
+// scopeQuery selects the rows of the branch branch, all of them if it is
+// empty.
+func scopeQuery(query *handler.ListQuery, branch string) {
+	if branch == "" {
+		return
+	}
+	if query.Filter.And == nil {
+		query.Filter.And = map[string][]handler.FieldFilter{}
+	}
+	query.Filter.And["branch"] = append(query.Filter.And["branch"], handler.FieldFilter{Operator: "eq", Value: branch, ValueType: "string"})
+}
+
+// bookAvailability counts the copies of the book bookId at each branch
+// holding some.
+func bookAvailability(ctx context.Context, bookId int) ([]BranchAvailability, error) {
+	rows, err := handler.GetRowsContext(ctx, "v_book_branches", handler.ListQuery{
+		Filter: handler.FilterQuery{And: map[string][]handler.FieldFilter{
+			"book_id": {{Operator: "eq", Value: strconv.Itoa(bookId), ValueType: "int"}},
+		}},
+		Sort: []handler.SortField{{Column: "branch"}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	result := make([]BranchAvailability, 0, len(rows))
+	for _, row := range rows {
+		availability := BranchAvailability{}
+		availability.Branch, _ = row["branch"].(string)
+		availability.Available, _ = row["available"].(int)
+		availability.Copies, _ = row["copies"].(int)
+		result = append(result, availability)
+	}
+	return result, nil
+}
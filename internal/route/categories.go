@@ -0,0 +1,108 @@
+package route
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	restful "github.com/emicklei/go-restful/v3"
+
+	"github.com/riszkymf/golang-rest-boilerplate/internal/circulation"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/handler"
+)
+
+func CategoriesRoute() *restful.WebService {
+	service := new(restful.WebService)
+	service.
+		Path("/categories").
+		Consumes(restful.MIME_JSON).
+		Produces(restful.MIME_JSON, restful.MIME_XML)
+
+	category := service.PathParameter("category", "Name of category, e.g. student")
+	service.Route(service.GET("/").
+		To(GetAllCategories).
+		Doc("Retrieve the membership categories, by name").
+		Notes("Members without a category get the default policy, configured with the LOANS_ variables, which GET /categories/default answers.").
+		Writes(ResponseObj{Data: []circulation.Policy{}}))
+	service.Route(service.GET("/default").
+		To(GetDefaultCategory).
+		Doc("Retrieve the default policy, of the members without a category").
+		Writes(ResponseObj{Data: circulation.Policy{}}))
+	service.Route(service.GET("/{category}").
+		To(GetCategory).
+		Doc("Retrieve membership category by name").
+		Param(category).
+		Returns(http.StatusOK, "The category", ResponseObj{Data: circulation.Policy{}}).
+		Returns(http.StatusNotFound, "No such category", ResponseObj{}))
+	service.Route(service.POST("/{category}").
+		To(SaveCategory).
+		Doc("Create or replace membership category by name").
+		Notes("max_loans is how many books its members may have on loan at once, 0 for no limit.").
+		Param(category).
+		Reads(circulation.Policy{}, "The category; its name is that of the path").
+		Returns(http.StatusOK, "The category", ResponseObj{Data: circulation.Policy{}}).
+		Returns(http.StatusBadRequest, "Invalid category", ResponseObj{}))
+	service.Route(service.DELETE("/{category}").
+		To(DeleteCategory).
+		Doc("Delete membership category by name").
+		Notes("A category is deleted once no member belongs to it.").
+		Param(category).
+		Returns(http.StatusNoContent, "Deleted", nil).
+		Returns(http.StatusNotFound, "No such category", ResponseObj{}).
+		Returns(http.StatusConflict, "Members belong to the category", ResponseObj{}))
+	return service
+}
+
+func GetAllCategories(request *restful.Request, response *restful.Response) {
+	categories, err := circulation.ListPolicies(request.Request.Context())
+	if err != nil {
+		resourceError(response, http.StatusInternalServerError, err)
+		return
+	}
+	response.WriteHeaderAndEntity(http.StatusOK, ResponseObj{Data: categories, StatusCode: http.StatusOK, Item: "category"})
+}
+
+func GetDefaultCategory(request *restful.Request, response *restful.Response) {
+	response.WriteHeaderAndEntity(http.StatusOK, ResponseObj{Data: circulation.Default.Policy(), StatusCode: http.StatusOK, Item: "category"})
+}
+
+func GetCategory(request *restful.Request, response *restful.Response) {
+	name := request.PathParameter("category")
+	category, ok, err := circulation.GetPolicy(request.Request.Context(), name)
+	if err != nil {
+		resourceError(response, http.StatusInternalServerError, err)
+		return
+	}
+	if !ok {
+		resourceError(response, http.StatusNotFound, fmt.Errorf("category %v: %w", name, handler.ErrNotFound))
+		return
+	}
+	response.WriteHeaderAndEntity(http.StatusOK, ResponseObj{Data: category, StatusCode: http.StatusOK, Item: "category"})
+}
+
+func SaveCategory(request *restful.Request, response *restful.Response) {
+	category := circulation.Policy{}
+	if err := request.ReadEntity(&category); err != nil {
+		resourceError(response, http.StatusBadRequest, err)
+		return
+	}
+	category.Category = request.PathParameter("category")
+	err := circulation.SavePolicy(request.Request.Context(), category)
+	if errors.Is(err, circulation.ErrInvalidPolicy) {
+		resourceError(response, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		resourceError(response, http.StatusInternalServerError, err)
+		return
+	}
+	response.WriteHeaderAndEntity(http.StatusOK, ResponseObj{Data: category, StatusCode: http.StatusOK, Item: "category"})
+}
+
+func DeleteCategory(request *restful.Request, response *restful.Response) {
+	if err := circulation.DeletePolicy(request.Request.Context(), request.PathParameter("category")); err != nil {
+		circulationError(response, err)
+		return
+	}
+	response.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,70 @@
+package route
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+
+	restful "github.com/emicklei/go-restful/v3"
+
+	"github.com/riszkymf/golang-rest-boilerplate/internal/handler"
+)
+
+// conflictParam documents the on_conflict parameter read by conflictMode.
+func conflictParam(service *restful.WebService, key string) *restful.Parameter {
+	return service.QueryParameter("on_conflict", fmt.Sprintf("When the %v exists already: error (409), update the existing row or ignore the insert", key)).
+		PossibleValues([]string{"error", "update", "ignore"}).
+		DefaultValue("error")
+}
+
+func conflictMode(request *restful.Request) (string, error) {
+	mode := request.QueryParameter("on_conflict")
+	switch mode {
+	case "":
+		return "error", nil
+	case "error", "update", "ignore":
+		return mode, nil
+	}
+	return "", fmt.Errorf("on_conflict must be error, update or ignore, not %q", mode)
+}
+
+// insertResolving inserts data into table and returns the stored row. When
+// a row with the same unique key exists, mode decides: error fails, update
+// overwrites the other columns of data and ignore keeps the row as is.
+func insertResolving(ctx context.Context, mode string, table string, data map[string]any, key ...string) (map[string]any, error) {
+	switch mode {
+	case "update":
+		return handler.UpsertRowContext(ctx, table, data, key, updateColumns(data, key))
+	case "ignore":
+		return handler.UpsertRowContext(ctx, table, data, key, nil)
+	}
+	id, err := handler.InsertDataContext(ctx, table, data)
+	if err != nil {
+		return nil, err
+	}
+	return handler.GetRowByIdContext(ctx, table, id)
+}
+
+func updateColumns(data map[string]any, key []string) []string {
+	isKey := map[string]bool{"id": true}
+	for _, column := range key {
+		isKey[column] = true
+	}
+	columns := []string{}
+	for column := range data {
+		if !isKey[column] {
+			columns = append(columns, column)
+		}
+	}
+	sort.Strings(columns)
+	return columns
+}
+
+// insertErrorStatus tells a duplicate key from other insert failures.
+func insertErrorStatus(err error) int {
+	if handler.IsConflict(err) {
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
@@ -0,0 +1,197 @@
+package route
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	restful "github.com/emicklei/go-restful/v3"
+
+	"github.com/riszkymf/golang-rest-boilerplate/internal/circulation"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/handler"
+	utils "github.com/riszkymf/golang-rest-boilerplate/internal/src"
+)
+
+// CopyUpdate is the fields of a copy POST /copies/{barcode} changes; its
+// status changes through the loans and the lost, damaged and available
+// routes, its branch through /transfers.
+type CopyUpdate struct {
+	Condition string `json:"condition" default:""`
+}
+
+func CopiesRoute() *restful.WebService {
+	service := new(restful.WebService)
+	service.
+		Path("/copies").
+		Consumes(bodyMimes...).
+		Produces(resourceMimes...)
+
+	service.Route(service.GET("/").
+		To(GetAllCopies).
+		Produces(collectionMimes...).
+		Do(listParams(service)).
+		Param(branchParam(service)).
+		Do(branchScoped).
+		Doc("Retrieve the copies of the books").
+		Notes("e.g. filter=book_id:eq:3&filter=status:eq:available. A copy is available, on_loan, on_hold, in_transit, lost, damaged or withdrawn.").
+		Writes(ResponseObj{Data: []circulation.Copy{}}))
+	service.Route(service.GET("/{barcode}").
+		To(GetCopy).
+		Doc("Retrieve copy by barcode").
+		Param(service.PathParameter("barcode", "Barcode of copy")).
+		Do(readParams(service)).
+		Writes(ResponseObj{Data: circulation.Copy{}}))
+	service.Route(service.POST("").
+		To(InsertCopy).
+		Do(branchScoped).
+		Doc("Add a copy of a book").
+		Notes("The barcode defaults to the id of the book and the number of the copy, e.g. 000003-004; the condition to good; "+
+			"the branch to that of the staff adding it, or main. "+
+			"The copy is set aside for the oldest waiting hold of the book, if any.").
+		Reads(circulation.Copy{}, "Copy to add").
+		Returns(http.StatusCreated, "The copy", ResponseObj{Data: circulation.Copy{}}).
+		Returns(http.StatusBadRequest, "Unknown condition", ResponseObj{}).
+		Returns(http.StatusNotFound, "No such book", ResponseObj{}).
+		Returns(http.StatusConflict, "The barcode is taken", ResponseObj{}))
+	service.Route(service.POST("/{barcode}").
+		To(UpdateCopy).
+		Do(branchScoped).
+		Doc("Update the condition of copy by barcode").
+		Param(service.PathParameter("barcode", "Barcode of copy")).
+		Do(writeParams(service)).
+		Reads(CopyUpdate{}, "Fields to update, omitted fields are left unchanged").
+		Writes(ResponseObj{Data: circulation.Copy{}}))
+	service.Route(service.DELETE("/{barcode}").
+		To(WithdrawCopy).
+		Do(branchScoped).
+		Doc("Withdraw copy by barcode").
+		Notes("A copy set aside for a hold passes the hold on. A copy on loan must be returned first.").
+		Param(service.PathParameter("barcode", "Barcode of copy")).
+		Returns(http.StatusNoContent, "Withdrawn", nil).
+		Returns(http.StatusNotFound, "No such copy", ResponseObj{}).
+		Returns(http.StatusConflict, "The copy is on loan", ResponseObj{}))
+	for _, status := range []string{circulation.CopyLost, circulation.CopyDamaged, circulation.CopyAvailable} {
+		service.Route(service.POST("/{barcode}/"+status).
+			To(markCopy(status)).
+			Do(branchScoped).
+			Operation("MarkCopy"+strings.ToUpper(status[:1])+status[1:]).
+			Doc("Mark copy by barcode "+status).
+			Notes("A copy on loan is marked through its loan, see POST /rent/{record-id}/lost and POST /rent/return. "+
+				"A copy set aside for a hold passes the hold on; a copy available again goes to the oldest waiting hold.").
+			Param(service.PathParameter("barcode", "Barcode of copy")).
+			AllowedMethodsWithoutContentType([]string{http.MethodPost}).
+			Returns(http.StatusOK, "The copy", ResponseObj{Data: circulation.Copy{}}).
+			Returns(http.StatusNotFound, "No such copy", ResponseObj{}).
+			Returns(http.StatusConflict, "Refused", ResponseObj{}))
+	}
+	return service
+}
+
+func GetAllCopies(request *restful.Request, response *restful.Response) {
+	ctx := request.Request.Context()
+	branch, ok := branchScope(request, response)
+	if !ok {
+		return
+	}
+	columns, err := handler.ColumnsContext(ctx, "copies")
+	if err != nil {
+		resourceError(response, http.StatusInternalServerError, err)
+		return
+	}
+	query, err := listQuery(request, columns)
+	if err != nil {
+		resourceError(response, http.StatusBadRequest, err)
+		return
+	}
+	scopeQuery(&query, branch)
+	copies, err := handler.GetRowsContext(ctx, "copies", query)
+	if err != nil {
+		resourceError(response, http.StatusInternalServerError, err)
+		return
+	}
+	response.WriteEntity(ResponseObj{Data: copies, StatusCode: http.StatusOK, Item: "copy", Columns: columns})
+}
+
+func GetCopy(request *restful.Request, response *restful.Response) {
+	copy, err := circulation.FindCopy(request.Request.Context(), request.PathParameter("barcode"))
+	if err != nil {
+		resourceError(response, resourceErrorStatus(err), err)
+		return
+	}
+	writeResource(request, response, copy, "copy")
+}
+
+func InsertCopy(request *restful.Request, response *restful.Response) {
+	input := circulation.Copy{}
+	if err := request.ReadEntity(&input); err != nil {
+		resourceError(response, http.StatusBadRequest, err)
+		return
+	}
+	copy, err := circulation.Default.AddCopy(request.Request.Context(), input)
+	if err != nil {
+		copyError(response, err)
+		return
+	}
+	response.WriteHeaderAndEntity(http.StatusCreated, ResponseObj{Data: copy, StatusCode: http.StatusCreated, Item: "copy"})
+}
+
+func UpdateCopy(request *restful.Request, response *restful.Response) {
+	ctx := request.Request.Context()
+	copy, err := circulation.FindCopy(ctx, request.PathParameter("barcode"))
+	if err != nil {
+		resourceError(response, resourceErrorStatus(err), err)
+		return
+	}
+	if err := circulation.Scope(ctx, "copy "+request.PathParameter("barcode"), fmt.Sprint(copy["branch"])); err != nil {
+		circulationError(response, err)
+		return
+	}
+	var updateInput map[string]interface{}
+	if err := request.ReadEntity(&updateInput); err != nil {
+		resourceError(response, http.StatusBadRequest, err)
+		return
+	}
+	filteredInput, err := utils.FilterInputMap(CopyUpdate{}, updateInput)
+	if err != nil {
+		resourceError(response, http.StatusInternalServerError, err)
+		return
+	}
+	if condition, ok := filteredInput["condition"]; ok {
+		condition, _ := condition.(string)
+		if err := circulation.ValidateCondition(condition); err != nil {
+			resourceError(response, http.StatusBadRequest, err)
+			return
+		}
+	}
+	id, _ := copy["id"].(int)
+	updateResource(request, response, "copies", id, filteredInput, "copy")
+}
+
+func WithdrawCopy(request *restful.Request, response *restful.Response) {
+	if err := circulation.Default.WithdrawCopy(request.Request.Context(), request.PathParameter("barcode")); err != nil {
+		copyError(response, err)
+		return
+	}
+	response.WriteHeader(http.StatusNoContent)
+}
+
+func markCopy(status string) restful.RouteFunction {
+	return func(request *restful.Request, response *restful.Response) {
+		copy, err := circulation.Default.MarkCopy(request.Request.Context(), request.PathParameter("barcode"), status)
+		if err != nil {
+			copyError(response, err)
+			return
+		}
+		response.WriteHeaderAndEntity(http.StatusOK, ResponseObj{Data: copy, StatusCode: http.StatusOK, Item: "copy"})
+	}
+}
+
+// copyError answers an invalid copy with 400, otherwise as circulationError.
+func copyError(response *restful.Response, err error) {
+	if errors.Is(err, circulation.ErrInvalidCopy) {
+		resourceError(response, http.StatusBadRequest, err)
+		return
+	}
+	circulationError(response, err)
+}
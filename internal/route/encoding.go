@@ -0,0 +1,133 @@
+package route
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"reflect"
+	"strings"
+
+	restful "github.com/emicklei/go-restful/v3"
+
+	"github.com/riszkymf/golang-rest-boilerplate/internal/encoding"
+)
+
+// Collection endpoints produce every representation, single resources all
+// but CSV.
+var (
+	collectionMimes = []string{restful.MIME_JSON, restful.MIME_XML, encoding.MIME_CSV, encoding.MIME_NDJSON}
+	resourceMimes   = []string{restful.MIME_JSON, restful.MIME_XML, encoding.MIME_NDJSON}
+	bodyMimes       = []string{restful.MIME_JSON, restful.MIME_XML, encoding.MIME_CSV, encoding.MIME_NDJSON}
+)
+
+// RegisterEntityAccessors replaces the go-restful XML accessor, which cannot
+// marshal maps, and adds CSV and NDJSON, so that WriteEntity and ReadEntity
+// honour Accept and Content-Type.
+func RegisterEntityAccessors() {
+	restful.RegisterEntityAccessor(restful.MIME_XML, xmlAccess{})
+	restful.RegisterEntityAccessor(encoding.MIME_CSV, csvAccess{})
+	restful.RegisterEntityAccessor(encoding.MIME_NDJSON, ndjsonAccess{})
+}
+
+type xmlAccess struct{}
+
+func (xmlAccess) Read(req *restful.Request, v interface{}) error {
+	record, err := encoding.DecodeXMLRecord(req.Request.Body)
+	if err != nil {
+		return err
+	}
+	return encoding.Assign(v, record)
+}
+
+func (xmlAccess) Write(resp *restful.Response, status int, v interface{}) error {
+	if v == nil {
+		resp.WriteHeader(status)
+		return nil
+	}
+	resp.Header().Set(restful.HEADER_ContentType, restful.MIME_XML)
+	resp.WriteHeader(status)
+	if _, err := resp.Write([]byte(xml.Header)); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(resp)
+	if restful.PrettyPrintResponses {
+		enc.Indent("", " ")
+	}
+	if err := encoding.EncodeXML(enc, encoding.Element(rootName(v)), v, "", nil); err != nil {
+		return err
+	}
+	return enc.Flush()
+}
+
+// rootName names the document element after the type, Health as <health>.
+func rootName(v interface{}) string {
+	t := reflect.TypeOf(v)
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Name() == "" {
+		return "response"
+	}
+	return strings.ToLower(t.Name()[:1]) + t.Name()[1:]
+}
+
+type csvAccess struct{}
+
+func (csvAccess) Read(req *restful.Request, v interface{}) error {
+	record, err := encoding.ReadCSVRecord(req.Request.Body)
+	if err != nil {
+		return err
+	}
+	return encoding.Assign(v, record)
+}
+
+// Write streams the rows of Data under a header row; an error response is
+// written as a single error column.
+func (csvAccess) Write(resp *restful.Response, status int, v interface{}) error {
+	if v == nil {
+		resp.WriteHeader(status)
+		return nil
+	}
+	resp.Header().Set(restful.HEADER_ContentType, encoding.MIME_CSV+"; charset=utf-8")
+	resp.WriteHeader(status)
+	res, isResponse := v.(ResponseObj)
+	switch {
+	case isResponse && len(res.Errors) > 0:
+		return encoding.WriteCSV(resp, res.Errors, []string{"error"})
+	case isResponse:
+		return encoding.WriteCSV(resp, res.Data, res.Columns)
+	}
+	return encoding.WriteCSV(resp, v, nil)
+}
+
+type ndjsonAccess struct{}
+
+func (ndjsonAccess) Read(req *restful.Request, v interface{}) error {
+	decoder := json.NewDecoder(req.Request.Body)
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// Write streams one JSON document per row of Data; an error response is
+// written whole, on one line.
+func (ndjsonAccess) Write(resp *restful.Response, status int, v interface{}) error {
+	if v == nil {
+		resp.WriteHeader(status)
+		return nil
+	}
+	resp.Header().Set(restful.HEADER_ContentType, encoding.MIME_NDJSON)
+	resp.WriteHeader(status)
+	rows := []interface{}{v}
+	if res, isResponse := v.(ResponseObj); isResponse && len(res.Errors) == 0 {
+		rows = encoding.Rows(res.Data)
+	}
+	enc := json.NewEncoder(resp)
+	for i, row := range rows {
+		if err := enc.Encode(row); err != nil {
+			return err
+		}
+		if (i+1)%100 == 0 {
+			resp.Flush()
+		}
+	}
+	return nil
+}
@@ -0,0 +1,218 @@
+package route
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+
+	restful "github.com/emicklei/go-restful/v3"
+
+	"github.com/riszkymf/golang-rest-boilerplate/internal/encoding"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/handler"
+	utils "github.com/riszkymf/golang-rest-boilerplate/internal/src"
+)
+
+// exportResources maps the resources that can be exported to the table or
+// view their list endpoint reads.
+var exportResources = map[string]string{
+	"author":  "author",
+	"books":   "v_books",
+	"members": "members",
+	"records": "records",
+	"copies":  "copies",
+	"rent":    "v_rent",
+}
+
+// exportBranches maps the resources scoped to a branch to the table or view
+// whose branch column selects its rows, as their list endpoint does.
+var exportBranches = map[string]string{
+	"books":   "v_book_branches",
+	"records": "records",
+	"copies":  "copies",
+	"rent":    "v_rent",
+}
+
+var exportFormats = map[string]string{
+	"csv":    encoding.MIME_CSV,
+	"ndjson": encoding.MIME_NDJSON,
+	"json":   restful.MIME_JSON,
+	"xlsx":   encoding.MIME_XLSX,
+}
+
+func ExportRoute() *restful.WebService {
+	service := new(restful.WebService)
+	service.
+		Path("/export").
+		Produces(restful.MIME_JSON, encoding.MIME_CSV, encoding.MIME_NDJSON, encoding.MIME_XLSX)
+
+	service.Route(service.GET("/{resource}").
+		To(ExportResource).
+		Doc("Download the rows of a resource as a file").
+		Notes("Rows are streamed from the database as they are read, in the format given by the format parameter whatever the Accept header. Books, copies, records and rent are scoped to a branch as their lists are.").
+		Param(service.PathParameter("resource", "One of author, books, copies, members, records and rent")).
+		Param(branchParam(service)).
+		Do(branchScoped).
+		Param(service.QueryParameter("format", "csv, ndjson, json or xlsx").DefaultValue("csv")).
+		Do(listParams(service)).
+		Returns(http.StatusOK, "The rows, as an attachment", nil).
+		Returns(http.StatusBadRequest, "Unknown format or invalid filter", ResponseObj{}).
+		Returns(http.StatusForbidden, "Branch of other staff", ResponseObj{}).
+		Returns(http.StatusNotFound, "Unknown resource", ResponseObj{}))
+	return service
+}
+
+func ExportResource(request *restful.Request, response *restful.Response) {
+	ctx := request.Request.Context()
+	resource := request.PathParameter("resource")
+	table, exist := exportResources[resource]
+	if !exist {
+		exportError(response, http.StatusNotFound, fmt.Sprintf("resource %q cannot be exported", resource))
+		return
+	}
+	format := request.QueryParameter("format")
+	if format == "" {
+		format = "csv"
+	}
+	mime, exist := exportFormats[format]
+	if !exist {
+		exportError(response, http.StatusBadRequest, fmt.Sprintf("unknown format %q, use csv, ndjson, json or xlsx", format))
+		return
+	}
+	var branch string
+	if branchTable, scoped := exportBranches[resource]; scoped {
+		var err error
+		if branch, err = scopedBranch(request); err != nil {
+			exportError(response, http.StatusForbidden, err.Error())
+			return
+		}
+		if branch != "" {
+			table = branchTable
+		}
+	}
+	columns, err := handler.ColumnsContext(ctx, table)
+	if err != nil {
+		exportError(response, http.StatusInternalServerError, err.Error())
+		return
+	}
+	query, err := listQuery(request, columns)
+	if err != nil {
+		exportError(response, http.StatusBadRequest, err.Error())
+		return
+	}
+	scopeQuery(&query, branch)
+	rows, err := handler.QueryRowsContext(ctx, table, query)
+	if err != nil {
+		exportError(response, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer rows.Close()
+
+	response.Header().Set(restful.HEADER_ContentType, mime)
+	response.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%v.%v"`, resource, format))
+	response.WriteHeader(http.StatusOK)
+	err = writeExport(response, format, resource, rows)
+	if err == nil {
+		err = rows.Close()
+	}
+	if err != nil {
+		// The status is sent already, the client gets a truncated file.
+		utils.LogErrorContext(ctx, "ExportResource", "Stream "+resource, err.Error())
+	}
+}
+
+// exportError answers in JSON, the requested format being a file format.
+func exportError(response *restful.Response, status int, message string) {
+	response.WriteHeaderAndJson(status, ResponseObj{Errors: []string{message}, StatusCode: status}, restful.MIME_JSON)
+}
+
+func writeExport(out io.Writer, format string, name string, rows *handler.Rows) error {
+	columns := rows.Columns()
+	switch format {
+	case "csv":
+		writer := encoding.NewCSVWriter(out, columns)
+		if err := writer.WriteHeader(); err != nil {
+			return err
+		}
+		for rows.Next() {
+			if err := writer.Write(rows.Row()); err != nil {
+				return err
+			}
+		}
+		return writer.Flush()
+	case "xlsx":
+		writer, err := encoding.NewXLSXWriter(out, name, columns)
+		if err != nil {
+			return err
+		}
+		if err := writer.WriteHeader(); err != nil {
+			return err
+		}
+		for rows.Next() {
+			if err := writer.Write(rows.Row()); err != nil {
+				return err
+			}
+		}
+		return writer.Close()
+	}
+
+	// ndjson and json: one object per row, with the keys in column order.
+	if format == "json" {
+		if _, err := io.WriteString(out, "["); err != nil {
+			return err
+		}
+	}
+	written := 0
+	for rows.Next() {
+		line, err := marshalRow(rows.Row(), columns)
+		if err != nil {
+			return err
+		}
+		switch {
+		case format == "ndjson":
+			line = append(line, '\n')
+		case written > 0:
+			line = append([]byte(",\n"), line...)
+		default:
+			line = append([]byte("\n"), line...)
+		}
+		if _, err := out.Write(line); err != nil {
+			return err
+		}
+		written++
+		if flusher, ok := out.(http.Flusher); ok && written%100 == 0 {
+			flusher.Flush()
+		}
+	}
+	if format == "json" {
+		_, err := io.WriteString(out, "\n]\n")
+		return err
+	}
+	return nil
+}
+
+// marshalRow encodes a row as a JSON object whose keys follow columns, which
+// encoding/json would sort.
+func marshalRow(row map[string]any, columns []string) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, column := range columns {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, err := json.Marshal(column)
+		if err != nil {
+			return nil, err
+		}
+		value, err := json.Marshal(row[column])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
@@ -1,7 +1,10 @@
 package route
 
 import (
+	"net/http"
+
 	restful "github.com/emicklei/go-restful/v3"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/health"
 )
 
 type Health struct {
@@ -9,6 +12,12 @@ type Health struct {
 	StatusCode int    `json:"status"`
 }
 
+type Readiness struct {
+	Message    string          `json:"message"`
+	StatusCode int             `json:"status"`
+	Checks     []health.Result `json:"checks"`
+}
+
 func HealthRoute() *restful.WebService {
 	service := new(restful.WebService)
 	service.
@@ -17,13 +26,33 @@ func HealthRoute() *restful.WebService {
 		Produces(restful.MIME_JSON, restful.MIME_XML)
 
 	service.Route(service.GET("/").
-		To(GetUser)).
-		Doc("Health Check")
+		To(GetLiveness).
+		Operation("GetHealth").
+		Doc("Health Check").
+		Writes(Health{}))
+	service.Route(service.GET("/live").
+		To(GetLiveness).
+		Doc("Liveness check, answers as long as the process serves requests").
+		Writes(Health{}))
+	service.Route(service.GET("/ready").
+		To(GetReadiness).
+		Doc("Readiness check, runs every registered health checker").
+		Returns(http.StatusOK, "Every check passed", Readiness{}).
+		Returns(http.StatusServiceUnavailable, "At least one check failed", Readiness{}))
 	return service
 }
 
-func GetUser(request *restful.Request, response *restful.Response) {
-	res := Health{Message: "OK", StatusCode: 200}
-	response.WriteAsJson(res)
-	return
+func GetLiveness(request *restful.Request, response *restful.Response) {
+	res := Health{Message: "OK", StatusCode: http.StatusOK}
+	response.WriteEntity(res)
+}
+
+func GetReadiness(request *restful.Request, response *restful.Response) {
+	report := health.Run(request.Request.Context())
+	res := Readiness{Message: "OK", StatusCode: http.StatusOK, Checks: report.Checks}
+	if !report.Healthy() {
+		res.Message = "Service Unavailable"
+		res.StatusCode = http.StatusServiceUnavailable
+	}
+	response.WriteHeaderAndEntity(res.StatusCode, res)
 }
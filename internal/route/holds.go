@@ -0,0 +1,87 @@
+package route
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	restful "github.com/emicklei/go-restful/v3"
+
+	"github.com/riszkymf/golang-rest-boilerplate/internal/circulation"
+)
+
+// HoldInput is the hold asked by POST /books/{book-id}/holds.
+type HoldInput struct {
+	MemberId int `json:"member_id"`
+}
+
+func allParam(service *restful.WebService) *restful.Parameter {
+	return service.QueryParameter("all", "Include the fulfilled, cancelled and expired holds").DataType("boolean").DefaultValue("false")
+}
+
+func PlaceHold(request *restful.Request, response *restful.Response) {
+	bookId, err := strconv.Atoi(request.PathParameter("book-id"))
+	if err != nil {
+		resourceError(response, http.StatusBadRequest, errors.New("ID must be numerical"))
+		return
+	}
+	input := HoldInput{}
+	if err := request.ReadEntity(&input); err != nil {
+		resourceError(response, http.StatusBadRequest, err)
+		return
+	}
+	hold, err := circulation.Default.PlaceHold(request.Request.Context(), bookId, input.MemberId)
+	if err != nil {
+		circulationError(response, err)
+		return
+	}
+	response.WriteHeaderAndEntity(http.StatusCreated, ResponseObj{Data: hold, StatusCode: http.StatusCreated, Item: "hold"})
+}
+
+func CancelHold(request *restful.Request, response *restful.Response) {
+	bookId, err := strconv.Atoi(request.PathParameter("book-id"))
+	if err != nil {
+		resourceError(response, http.StatusBadRequest, errors.New("ID must be numerical"))
+		return
+	}
+	id, err := strconv.Atoi(request.PathParameter("hold-id"))
+	if err != nil {
+		resourceError(response, http.StatusBadRequest, errors.New("ID must be numerical"))
+		return
+	}
+	hold, err := circulation.Default.CancelHold(request.Request.Context(), bookId, id)
+	if err != nil {
+		circulationError(response, err)
+		return
+	}
+	response.WriteHeaderAndEntity(http.StatusOK, ResponseObj{Data: hold, StatusCode: http.StatusOK, Item: "hold"})
+}
+
+func GetBookHolds(request *restful.Request, response *restful.Response) {
+	writeHolds(request, response, "book_id", request.PathParameter("book-id"))
+}
+
+func GetMemberHolds(request *restful.Request, response *restful.Response) {
+	writeHolds(request, response, "member_id", request.PathParameter("member-id"))
+}
+
+// writeHolds answers the holds whose column is id, those closed too if the
+// request asks for all.
+func writeHolds(request *restful.Request, response *restful.Response, column string, id string) {
+	idParse, err := strconv.Atoi(id)
+	if err != nil {
+		resourceError(response, http.StatusBadRequest, errors.New("ID must be numerical"))
+		return
+	}
+	all, err := queryBool(request, "all")
+	if err != nil {
+		resourceError(response, http.StatusBadRequest, err)
+		return
+	}
+	holds, err := circulation.ListHolds(request.Request.Context(), column, idParse, all)
+	if err != nil {
+		resourceError(response, http.StatusInternalServerError, err)
+		return
+	}
+	response.WriteHeaderAndEntity(http.StatusOK, ResponseObj{Data: holds, StatusCode: http.StatusOK, Item: "hold"})
+}
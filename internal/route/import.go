@@ -0,0 +1,155 @@
+package route
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	restful "github.com/emicklei/go-restful/v3"
+
+	"github.com/riszkymf/golang-rest-boilerplate/internal/encoding"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/handler"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/importer"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/jobs"
+	utils "github.com/riszkymf/golang-rest-boilerplate/internal/src"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/tenant"
+)
+
+// importRoute documents and binds POST /import on a resource WebService.
+func importRoute(service *restful.WebService, kind string, apply importer.ApplyFunc) *restful.RouteBuilder {
+	return service.POST("/import").
+		To(importHandler(kind, apply)).
+		Operation("Import"+strings.ToUpper(kind[:1])+kind[1:]).
+		Consumes(encoding.MIME_CSV, encoding.MIME_NDJSON).
+		Produces(restful.MIME_JSON, restful.MIME_XML).
+		Param(service.BodyParameter("body", "CSV with a header row, or NDJSON with one object per line").DataType("string")).
+		Param(service.QueryParameter("dry_run", "Validate and report without writing anything").DataType("boolean")).
+		Param(service.QueryParameter("async", "Run as a background job whatever the number of rows").DataType("boolean")).
+		Returns(http.StatusOK, "Rows imported, or checked in a dry run", ResponseObj{Data: importer.Result{}}).
+		Returns(http.StatusAccepted, "Import started as a background job, see Location", ResponseObj{Data: jobs.Job{}}).
+		Returns(http.StatusUnprocessableEntity, "Rows rejected, nothing imported", ResponseObj{Data: importer.Result{}})
+}
+
+func importHandler(kind string, apply importer.ApplyFunc) restful.RouteFunction {
+	return func(request *restful.Request, response *restful.Response) {
+		dryRun, err := queryBool(request, "dry_run")
+		if err != nil {
+			response.WriteHeaderAndEntity(http.StatusBadRequest, ResponseObj{Errors: []string{err.Error()}, StatusCode: http.StatusBadRequest})
+			return
+		}
+		async, err := queryBool(request, "async")
+		if err != nil {
+			response.WriteHeaderAndEntity(http.StatusBadRequest, ResponseObj{Errors: []string{err.Error()}, StatusCode: http.StatusBadRequest})
+			return
+		}
+
+		limits := importer.DefaultLimits
+		body := importer.LimitReader(request.Request.Body, limits.MaxBytes)
+		rows, rowErrors, err := importer.Parse(body, request.HeaderParameter(restful.HEADER_ContentType))
+		if err != nil {
+			status := http.StatusBadRequest
+			if errors.Is(err, importer.ErrTooLarge) {
+				status = http.StatusRequestEntityTooLarge
+			}
+			utils.LogErrorContext(request.Request.Context(), "Import "+kind, "Parse body", err.Error())
+			response.WriteHeaderAndEntity(status, ResponseObj{Errors: []string{err.Error()}, StatusCode: status})
+			return
+		}
+		if len(rows)+len(rowErrors) == 0 {
+			response.WriteHeaderAndEntity(http.StatusBadRequest, ResponseObj{Errors: []string{"body holds no rows"}, StatusCode: http.StatusBadRequest})
+			return
+		}
+
+		if async || len(rows) > limits.AsyncRows {
+			// The database of a tenant stays open for the job until it is
+			// done, even if the tenant is deleted meanwhile.
+			requestCtx := request.Request.Context()
+			release, err := tenant.Hold(requestCtx)
+			if err != nil {
+				resourceError(response, resourceErrorStatus(err), err)
+				return
+			}
+			// The job outlives the request, keep only its database, tenant,
+			// request id and principal, with the branch of staff, for the
+			// job to be audited and scoped as the request would be.
+			ctx := utils.ContextWithRequestId(handler.Detach(requestCtx), utils.RequestIdFromContext(requestCtx))
+			ctx = utils.ContextWithTenant(ctx, utils.TenantFromContext(requestCtx))
+			ctx = utils.ContextWithPrincipal(ctx, utils.PrincipalFromContext(requestCtx))
+			ctx = utils.ContextWithBranch(ctx, utils.BranchFromContext(requestCtx))
+			job := jobs.Start(ctx, kind+".import", func(ctx context.Context) (interface{}, error) {
+				defer release()
+				result, err := importer.Run(ctx, rows, rowErrors, dryRun, apply)
+				if err == nil && !dryRun && !result.Applied() {
+					err = fmt.Errorf("%v rows rejected, nothing imported", len(result.Errors))
+				}
+				return result, err
+			})
+			location := "/jobs/" + job.Id
+			response.AddHeader("Location", location)
+			response.WriteHeaderAndEntity(http.StatusAccepted, ResponseObj{Data: job, StatusCode: http.StatusAccepted, Item: "job"})
+			return
+		}
+
+		result, err := importer.Run(request.Request.Context(), rows, rowErrors, dryRun, apply)
+		if err != nil {
+			response.WriteHeaderAndEntity(http.StatusInternalServerError, ResponseObj{Errors: []string{err.Error()}, StatusCode: http.StatusInternalServerError})
+			return
+		}
+		res := ResponseObj{Data: result, StatusCode: http.StatusOK, Item: "import"}
+		if len(result.Errors) > 0 {
+			res.StatusCode = http.StatusUnprocessableEntity
+			res.Errors = []string{fmt.Sprintf("%v rows rejected, nothing imported", len(result.Errors))}
+		}
+		response.WriteHeaderAndEntity(res.StatusCode, res)
+	}
+}
+
+func queryBool(request *restful.Request, name string) (bool, error) {
+	raw := request.QueryParameter(name)
+	if raw == "" {
+		return false, nil
+	}
+	value, err := strconv.ParseBool(raw)
+	if err != nil {
+		return false, fmt.Errorf("%v must be true or false", name)
+	}
+	return value, nil
+}
+
+// findByKey returns the row of table whose unique key column equals value,
+// nil if there is none. A deleted row is restored, the key being taken.
+func findByKey(ctx context.Context, table string, key string, value string) (map[string]any, error) {
+	existing, err := handler.GetRowByFilterContext(handler.WithDeleted(ctx), table, handler.FilterQuery{
+		And: map[string][]handler.FieldFilter{
+			key: {{Operator: "eq", Value: value, ValueType: "string"}},
+		},
+	})
+	if err != nil || len(existing) == 0 {
+		return nil, err
+	}
+	if handler.IsDeleted(existing[0]) {
+		id, _ := existing[0]["id"].(int)
+		if err := handler.RestoreDataContext(ctx, table, id); err != nil {
+			return nil, err
+		}
+	}
+	return existing[0], nil
+}
+
+// upsertByKey updates the row of table whose unique key column equals value,
+// or inserts data when there is none. It reports whether a row was inserted.
+func upsertByKey(ctx context.Context, table string, key string, value string, data map[string]any) (bool, error) {
+	existing, err := findByKey(ctx, table, key, value)
+	if err != nil {
+		return false, err
+	}
+	if existing != nil {
+		id, _ := existing["id"].(int)
+		return false, handler.UpdateDataContext(ctx, table, data, id)
+	}
+	_, err = handler.InsertDataContext(ctx, table, data)
+	return true, err
+}
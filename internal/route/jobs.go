@@ -0,0 +1,34 @@
+package route
+
+import (
+	"net/http"
+
+	restful "github.com/emicklei/go-restful/v3"
+
+	"github.com/riszkymf/golang-rest-boilerplate/internal/jobs"
+	utils "github.com/riszkymf/golang-rest-boilerplate/internal/src"
+)
+
+func JobsRoute() *restful.WebService {
+	service := new(restful.WebService)
+	service.
+		Path("/jobs").
+		Produces(restful.MIME_JSON, restful.MIME_XML)
+
+	service.Route(service.GET("/{job-id}").
+		To(GetJob).
+		Doc("Retrieve the status of a background job, e.g. an import").
+		Param(service.PathParameter("job-id", "Identifier of job")).
+		Returns(http.StatusOK, "Job status, with its result once finished", ResponseObj{Data: jobs.Job{}}).
+		Returns(http.StatusNotFound, "Unknown or expired job", ResponseObj{}))
+	return service
+}
+
+func GetJob(request *restful.Request, response *restful.Response) {
+	job, exist := jobs.Get(request.PathParameter("job-id"))
+	if !exist || job.Tenant != utils.TenantFromContext(request.Request.Context()) {
+		response.WriteHeaderAndEntity(http.StatusNotFound, ResponseObj{Errors: []string{"job not found"}, StatusCode: http.StatusNotFound})
+		return
+	}
+	response.WriteEntity(ResponseObj{Data: job, StatusCode: http.StatusOK, Item: "job"})
+}
@@ -0,0 +1,103 @@
+package route
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	restful "github.com/emicklei/go-restful/v3"
+
+	"github.com/riszkymf/golang-rest-boilerplate/internal/handler"
+)
+
+var filterOperators = map[string]bool{
+	"eq": true, "gt": true, "gte": true, "lt": true, "lte": true,
+	"like": true, "not": true, "isEmpty": true, "isNotEmpty": true,
+}
+
+// listParams documents the filter, or and sort query parameters read by
+// listQuery.
+func listParams(service *restful.WebService) func(*restful.RouteBuilder) {
+	return func(builder *restful.RouteBuilder) {
+		builder.
+			Param(service.QueryParameter("filter", "column:operator:value, every filter must match, e.g. available:lt:10").AllowMultiple(true)).
+			Param(service.QueryParameter("or", "column:operator:value, one filter per column must match").AllowMultiple(true)).
+			Param(service.QueryParameter("sort", "Comma separated columns, descending when prefixed with -, e.g. -available,title")).
+			Param(includeDeletedParam(service))
+	}
+}
+
+// listQuery reads the filter, or and sort query parameters of a list
+// request. Only the given columns may be used, since column names end up in
+// the SQL.
+func listQuery(request *restful.Request, columns []string) (handler.ListQuery, error) {
+	known := map[string]bool{}
+	for _, column := range columns {
+		known[column] = true
+	}
+	query := handler.ListQuery{}
+	params := request.Request.URL.Query()
+
+	var err error
+	query.Filter.And, err = parseFilters(params["filter"], known)
+	if err != nil {
+		return query, err
+	}
+	query.Filter.Or, err = parseFilters(params["or"], known)
+	if err != nil {
+		return query, err
+	}
+	for _, field := range strings.Split(params.Get("sort"), ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		sort := handler.SortField{Column: strings.TrimPrefix(field, "-"), Desc: strings.HasPrefix(field, "-")}
+		if !known[sort.Column] {
+			return query, fmt.Errorf("cannot sort on unknown column %q", sort.Column)
+		}
+		query.Sort = append(query.Sort, sort)
+	}
+	return query, nil
+}
+
+func parseFilters(raw []string, known map[string]bool) (map[string][]handler.FieldFilter, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	filters := map[string][]handler.FieldFilter{}
+	for _, filter := range raw {
+		parts := strings.SplitN(filter, ":", 3)
+		if len(parts) < 2 {
+			return nil, fmt.Errorf("filter %q must be column:operator:value", filter)
+		}
+		column, operator := parts[0], parts[1]
+		if !known[column] {
+			return nil, fmt.Errorf("cannot filter on unknown column %q", column)
+		}
+		if !filterOperators[operator] {
+			return nil, fmt.Errorf("unknown filter operator %q", operator)
+		}
+		value := ""
+		if len(parts) == 3 {
+			value = parts[2]
+		} else if operator != "isEmpty" && operator != "isNotEmpty" {
+			return nil, fmt.Errorf("filter %q has no value", filter)
+		}
+		filters[column] = append(filters[column], handler.FieldFilter{Operator: operator, Value: value, ValueType: valueType(value)})
+	}
+	return filters, nil
+}
+
+// valueType binds numbers written in their canonical form as numbers, so
+// that they compare as such with view columns that have no type; SQLite
+// converts them back to text when compared with a text column.
+func valueType(value string) string {
+	if n, err := strconv.Atoi(value); err == nil && strconv.Itoa(n) == value {
+		return "int"
+	}
+	if f, err := strconv.ParseFloat(value, 64); err == nil && strconv.FormatFloat(f, 'f', -1, 64) == value {
+		return "float"
+	}
+	return "string"
+}
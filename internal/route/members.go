@@ -1,11 +1,17 @@
 package route
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"net/mail"
 	"strconv"
 
 	restful "github.com/emicklei/go-restful/v3"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/circulation"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/fines"
 	"github.com/riszkymf/golang-rest-boilerplate/internal/handler"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/importer"
 	utils "github.com/riszkymf/golang-rest-boilerplate/internal/src"
 )
 
@@ -21,41 +27,116 @@ type Members struct {
 	Lastname  string `json:"lastname" default:""`
 	Email     string `json:"email" default:""`
 	Address   string `json:"address" default:""`
+	Category  string `json:"category" default:""`
+	ExpiresAt string `json:"expires_at" default:""`
+}
+
+// PaymentInput is a payment of POST /members/{member-id}/payments.
+type PaymentInput struct {
+	Kind   string `json:"kind"`
+	Amount int    `json:"amount"`
+	FineId int    `json:"fine_id"`
+	Note   string `json:"note"`
 }
 
 func MembersRoute() *restful.WebService {
 	service := new(restful.WebService)
 	service.
 		Path("/members").
-		Consumes(restful.MIME_XML, restful.MIME_JSON).
-		Produces(restful.MIME_JSON, restful.MIME_XML)
+		Consumes(bodyMimes...).
+		Produces(resourceMimes...)
 
 	service.Route(service.GET("/{member-id}").
-		To(GetMember)).
+		To(GetMember).
 		Doc("Retrieve member by ID").
-		Param(service.PathParameter("member-id", "Identifier of member").DataType("integer"))
+		Param(service.PathParameter("member-id", "Identifier of member").DataType("integer")).
+		Do(readParams(service)).
+		Writes(ResponseObj{Data: Members{}}))
 	service.Route(service.GET("/").
-		To(GetAllMembers)).
-		Doc("Retrieve available members")
+		To(GetAllMembers).
+		Produces(collectionMimes...).
+		Do(listParams(service)).
+		Doc("Retrieve available members").
+		Writes(ResponseObj{Data: []Members{}}))
 	service.Route(service.POST("").
-		To(InsertMember)).
-		Doc("Insert new member")
+		To(InsertMember).
+		Doc("Insert new member").
+		Notes("The category must be one of /categories. A membership with expires_at ends after that day, e.g. 2030-12-31.").
+		Param(conflictParam(service, "email")).
+		Reads(Members{}, "Member to insert, the id is assigned by the database").
+		Returns(http.StatusBadRequest, "Invalid member", ResponseObj{}).
+		Writes(ResponseObj{Data: Members{}}))
+	service.Route(importRoute(service, "members", importMember).
+		Doc("Import members from CSV or NDJSON, updating members with the same email").
+		Notes("Columns: email, firstname, lastname and optionally address, category and expires_at."))
 	service.Route(service.POST("/{member-id}").
-		To(UpdateMember)).
+		To(UpdateMember).
 		Doc("Update member by ID").
-		Param(service.PathParameter("member-id", "Identifier of member").DataType("integer"))
+		Param(service.PathParameter("member-id", "Identifier of member").DataType("integer")).
+		Do(writeParams(service)).
+		Reads(Members{}, "Fields to update, omitted fields are left unchanged").
+		Writes(ResponseObj{Data: Members{}}))
+	service.Route(service.DELETE("/{member-id}").
+		To(DeleteMember).
+		Doc("Delete member by ID").
+		Notes("The member is kept, marked deleted, until purged; their loans stay in records.").
+		Param(service.PathParameter("member-id", "Identifier of member").DataType("integer")).
+		Do(writeParams(service)).
+		Returns(http.StatusNoContent, "Deleted", nil))
+	service.Route(restoreRoute(service, "member-id", "members", "member").
+		Doc("Restore deleted member by ID").
+		Param(service.PathParameter("member-id", "Identifier of member").DataType("integer")).
+		Writes(ResponseObj{Data: Members{}}))
+	service.Route(service.GET("/{member-id}/balance").
+		To(GetMemberBalance).
+		Doc("Retrieve what member by ID owes, with their fines and payments").
+		Notes("Amounts are in the minor unit of the currency, e.g. cents. A blocked member may not check out books.").
+		Param(service.PathParameter("member-id", "Identifier of member").DataType("integer")).
+		Returns(http.StatusOK, "The balance", ResponseObj{Data: fines.Balance{}}).
+		Returns(http.StatusNotFound, "No such member", ResponseObj{}))
+	service.Route(service.POST("/{member-id}/payments").
+		To(InsertMemberPayment).
+		Doc("Record a payment, or a waiver, of the fines of member by ID").
+		Notes("Partial payments are accepted; a payment may not exceed the balance, nor what is left of its fine_id if given.").
+		Param(service.PathParameter("member-id", "Identifier of member").DataType("integer")).
+		Reads(PaymentInput{}, "The payment; kind defaults to payment").
+		Returns(http.StatusCreated, "The payment", ResponseObj{Data: fines.Payment{}}).
+		Returns(http.StatusBadRequest, "Invalid payment", ResponseObj{}).
+		Returns(http.StatusNotFound, "No such member", ResponseObj{}))
+	service.Route(service.GET("/{member-id}/holds").
+		To(GetMemberHolds).
+		Doc("Retrieve the holds of member by ID, oldest first").
+		Notes("Waiting holds have their position in the queue of their book; ready ones their expires_at.").
+		Param(service.PathParameter("member-id", "Identifier of member").DataType("integer")).
+		Param(allParam(service)).
+		Writes(ResponseObj{Data: []circulation.Hold{}}))
+	service.Route(historyRoute(service, "member-id", "members", "member").
+		Doc("Retrieve the changes to member by ID, oldest first").
+		Param(service.PathParameter("member-id", "Identifier of member").DataType("integer")))
 	return service
 }
 
 func GetAllMembers(request *restful.Request, response *restful.Response) {
-	member, err := handler.GetRowsAll("members")
+	ctx := request.Request.Context()
+	columns, err := handler.ColumnsContext(ctx, "members")
+	if err != nil {
+		res := ResponseObj{Data: nil, Errors: []string{err.Error()}, StatusCode: http.StatusInternalServerError}
+		response.WriteEntity(res)
+		return
+	}
+	query, err := listQuery(request, columns)
+	if err != nil {
+		resourceError(response, http.StatusBadRequest, err)
+		return
+	}
+	member, err := handler.GetRowsContext(ctx, "members", query)
 	if err != nil {
 		res := ResponseObj{Data: nil, Errors: []string{err.Error()}, StatusCode: http.StatusInternalServerError}
-		response.WriteAsJson(res)
+		response.WriteEntity(res)
 		return
 	}
-	res := ResponseObj{Data: member, Errors: nil, StatusCode: 200}
-	response.WriteAsJson(res)
+	res := ResponseObj{Data: member, Errors: nil, StatusCode: 200, Item: "member", Columns: columns}
+	response.WriteEntity(res)
 }
 
 func GetMember(request *restful.Request, response *restful.Response) {
@@ -63,17 +144,15 @@ func GetMember(request *restful.Request, response *restful.Response) {
 	idParse, err := strconv.Atoi(id)
 	if err != nil {
 		utils.LogError("GetMember", "Invalid Id Type", err.Error())
-		response.WriteAsJson(ResponseObj{Data: nil, Errors: []string{"ID must be numerical"}, StatusCode: http.StatusBadRequest})
+		response.WriteEntity(ResponseObj{Data: nil, Errors: []string{"ID must be numerical"}, StatusCode: http.StatusBadRequest})
 		return
 	}
-	member, err := handler.GetRowById("members", idParse)
+	member, err := handler.GetRowByIdContext(request.Request.Context(), "members", idParse)
 	if err != nil {
-		res := ResponseObj{Data: nil, Errors: []string{err.Error()}, StatusCode: http.StatusInternalServerError}
-		response.WriteAsJson(res)
+		resourceError(response, http.StatusInternalServerError, err)
 		return
 	}
-	res := ResponseObj{Data: member, StatusCode: http.StatusOK}
-	response.WriteAsJson(res)
+	writeResource(request, response, member, "member")
 }
 
 func InsertMember(request *restful.Request, response *restful.Response) {
@@ -81,7 +160,13 @@ func InsertMember(request *restful.Request, response *restful.Response) {
 	err := request.ReadEntity(&member)
 	if err != nil {
 		res := ResponseObj{Errors: []string{err.Error()}, StatusCode: http.StatusBadRequest}
-		response.WriteAsJson(res)
+		response.WriteHeaderAndEntity(res.StatusCode, res)
+		return
+	}
+	mode, err := conflictMode(request)
+	if err != nil {
+		res := ResponseObj{Errors: []string{err.Error()}, StatusCode: http.StatusBadRequest}
+		response.WriteHeaderAndEntity(res.StatusCode, res)
 		return
 	}
 
@@ -91,16 +176,26 @@ func InsertMember(request *restful.Request, response *restful.Response) {
 		"email":     member.Email,
 		"address":   member.Address,
 	}
-	addMember, err := handler.InsertData("members", inputData)
+	if member.Category != "" {
+		inputData["category"] = member.Category
+	}
+	if member.ExpiresAt != "" {
+		inputData["expires_at"] = member.ExpiresAt
+	}
+	if err := circulation.ValidateMembership(request.Request.Context(), inputData); err != nil {
+		membershipError(response, err)
+		return
+	}
+	stored, err := insertResolving(request.Request.Context(), mode, "members", inputData, "email")
 	if err != nil {
 		res := ResponseObj{
 			Errors:     []string{err.Error()},
-			StatusCode: http.StatusInternalServerError,
+			StatusCode: insertErrorStatus(err),
 		}
-		response.WriteAsJson(res)
+		response.WriteHeaderAndEntity(res.StatusCode, res)
+		return
 	}
-	member.Id = addMember
-	response.WriteAsJson(ResponseObj{Data: member})
+	response.WriteHeaderAndEntity(http.StatusOK, ResponseObj{Data: stored, StatusCode: http.StatusOK, Item: "member"})
 
 }
 
@@ -109,7 +204,7 @@ func UpdateMember(request *restful.Request, response *restful.Response) {
 	idParse, err := strconv.Atoi(id)
 	if err != nil {
 		utils.LogError("Update Member", "Invalid Id Type", err.Error())
-		response.WriteAsJson(ResponseObj{Data: nil, Errors: []string{"ID must be numerical"}, StatusCode: http.StatusBadRequest})
+		response.WriteEntity(ResponseObj{Data: nil, Errors: []string{"ID must be numerical"}, StatusCode: http.StatusBadRequest})
 		return
 	}
 
@@ -121,6 +216,8 @@ func UpdateMember(request *restful.Request, response *restful.Response) {
 		Firstname: "",
 		Lastname:  "",
 		Email:     "",
+		Category:  "",
+		ExpiresAt: "",
 	}
 
 	err = request.ReadEntity(&updateInput)
@@ -128,18 +225,15 @@ func UpdateMember(request *restful.Request, response *restful.Response) {
 	filteredInput, err := utils.FilterInputMap(member, updateInput)
 	if err != nil {
 		res := ResponseObj{Errors: []string{err.Error()}, StatusCode: http.StatusInternalServerError}
-		response.WriteAsJson(res)
+		response.WriteEntity(res)
 		return
 	}
-
-	err = handler.UpdateData("member", filteredInput, member.Id)
-	if err != nil {
-		res := ResponseObj{Errors: []string{err.Error()}, StatusCode: http.StatusInternalServerError}
-		response.WriteAsJson(res)
+	if err := circulation.ValidateMembership(request.Request.Context(), filteredInput); err != nil {
+		membershipError(response, err)
 		return
 	}
-	filteredInput["id"] = member.Id
-	response.WriteAsJson(ResponseObj{Data: filteredInput})
+
+	updateResource(request, response, "members", member.Id, filteredInput, "member")
 
 }
 
@@ -148,12 +242,129 @@ func DeleteMember(request *restful.Request, response *restful.Response) {
 	idParse, err := strconv.Atoi(id)
 	if err != nil {
 		utils.LogError("DeleteMember", "Invalid Id Type", err.Error())
-		response.WriteAsJson(ResponseObj{Data: nil, Errors: []string{"ID must be numerical"}, StatusCode: http.StatusBadRequest})
+		response.WriteEntity(ResponseObj{Data: nil, Errors: []string{"ID must be numerical"}, StatusCode: http.StatusBadRequest})
 		return
 	}
-	err = handler.DeleteData("members", idParse)
+	deleteResource(request, response, "members", idParse)
+}
+
+// membershipError answers an invalid membership with 400, otherwise 500.
+func membershipError(response *restful.Response, err error) {
+	if errors.Is(err, circulation.ErrInvalidMembership) {
+		resourceError(response, http.StatusBadRequest, err)
+		return
+	}
+	resourceError(response, http.StatusInternalServerError, err)
+}
+
+// memberParam reads the member of the request, answering the failure itself
+// if there is none.
+func memberParam(request *restful.Request, response *restful.Response) (int, bool) {
+	id, err := strconv.Atoi(request.PathParameter("member-id"))
+	if err != nil {
+		resourceError(response, http.StatusBadRequest, errors.New("ID must be numerical"))
+		return 0, false
+	}
+	member, err := handler.GetRowByIdContext(request.Request.Context(), "members", id)
 	if err != nil {
-		response.WriteAsJson(ResponseObj{Errors: []string{err.Error()}, StatusCode: http.StatusBadRequest})
+		resourceError(response, http.StatusInternalServerError, err)
+		return 0, false
+	}
+	if member["id"] == nil {
+		resourceError(response, http.StatusNotFound, handler.ErrNotFound)
+		return 0, false
+	}
+	return id, true
+}
+
+func GetMemberBalance(request *restful.Request, response *restful.Response) {
+	id, ok := memberParam(request, response)
+	if !ok {
 		return
 	}
+	balance, err := fines.Default.Balance(request.Request.Context(), id)
+	if err != nil {
+		resourceError(response, http.StatusInternalServerError, err)
+		return
+	}
+	response.WriteHeaderAndEntity(http.StatusOK, ResponseObj{Data: balance, StatusCode: http.StatusOK, Item: "balance"})
+}
+
+func InsertMemberPayment(request *restful.Request, response *restful.Response) {
+	id, ok := memberParam(request, response)
+	if !ok {
+		return
+	}
+	input := PaymentInput{}
+	if err := request.ReadEntity(&input); err != nil {
+		resourceError(response, http.StatusBadRequest, err)
+		return
+	}
+	payment, err := fines.Default.Pay(request.Request.Context(), fines.Payment{
+		MemberId: id,
+		FineId:   input.FineId,
+		Kind:     input.Kind,
+		Amount:   input.Amount,
+		Note:     input.Note,
+	})
+	if errors.Is(err, fines.ErrInvalidPayment) {
+		resourceError(response, http.StatusBadRequest, err)
+		return
+	}
+	if err != nil {
+		resourceError(response, http.StatusInternalServerError, err)
+		return
+	}
+	response.WriteHeaderAndEntity(http.StatusCreated, ResponseObj{Data: payment, StatusCode: http.StatusCreated, Item: "payment"})
+}
+
+// importMember upserts a member by its unique email. Columns missing from the
+// import are left unchanged on existing members.
+func importMember(ctx context.Context, row importer.Row, result *importer.Result) []string {
+	email := row.Value("email")
+	if address, err := mail.ParseAddress(email); err != nil || address.Address != email {
+		return []string{"email must be a valid address"}
+	}
+	data := map[string]any{"email": email}
+	for _, column := range []string{"firstname", "lastname", "address", "category", "expires_at"} {
+		if _, exist := row.Values[column]; exist {
+			data[column] = row.Value(column)
+		}
+	}
+	if expires, ok := data["expires_at"]; ok && expires == "" {
+		data["expires_at"] = nil
+	}
+	if err := circulation.ValidateMembership(ctx, data); err != nil {
+		return []string{err.Error()}
+	}
+
+	existing, err := findByKey(ctx, "members", "email", email)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	if existing != nil {
+		id, _ := existing["id"].(int)
+		err = handler.UpdateDataContext(ctx, "members", data, id)
+		if err != nil {
+			return []string{err.Error()}
+		}
+		result.Updated++
+		return nil
+	}
+
+	messages := []string{}
+	for _, column := range []string{"firstname", "lastname"} {
+		if data[column] == nil || data[column] == "" {
+			messages = append(messages, column+" is required for a new member")
+		}
+	}
+	if len(messages) > 0 {
+		return messages
+	}
+	_, err = handler.InsertDataContext(ctx, "members", data)
+	if err != nil {
+		return []string{err.Error()}
+	}
+	result.Inserted++
+	return nil
 }
@@ -0,0 +1,88 @@
+package route
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"sync"
+	"time"
+
+	restful "github.com/emicklei/go-restful/v3"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/handler"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/metrics"
+	utils "github.com/riszkymf/golang-rest-boilerplate/internal/src"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/tenant"
+)
+
+var registerLibraryMetrics sync.Once
+
+// MetricsRoute serves the metrics. The domain gauges count the rows of the
+// library or, with tenants, of each tenant, labelled with its code.
+func MetricsRoute(tenants bool) *restful.WebService {
+	registerLibraryMetrics.Do(func() {
+		metrics.RegisterDBStats(func() *sql.DB { return handler.Connection })
+		gauges := []struct {
+			name, help string
+			count      func(ctx context.Context) (float64, error)
+		}{
+			{"library_books_out_of_stock", "Number of books with no copy available.", countBooksOutOfStock},
+			{"library_rentals_overdue", "Number of rentals past their due date that are not returned.", countOverdueRentals},
+		}
+		for _, gauge := range gauges {
+			count := gauge.count
+			if tenants {
+				metrics.NewGaugeVecFunc(gauge.name, gauge.help, func(set func(float64, ...string)) error {
+					return tenant.Default.Each(context.Background(), func(ctx context.Context, code string) error {
+						value, err := count(ctx)
+						if err == nil {
+							set(value, code)
+						}
+						return err
+					})
+				}, "tenant")
+				continue
+			}
+			metrics.NewGaugeFunc(gauge.name, gauge.help, func() (float64, error) { return count(context.Background()) })
+		}
+	})
+
+	service := new(restful.WebService)
+	service.
+		Path("/metrics").
+		Produces("text/plain")
+
+	service.Route(service.GET("").
+		To(GetMetrics).
+		Doc("Prometheus metrics").
+		Returns(http.StatusOK, "Metrics in the Prometheus text format", nil))
+	return service
+}
+
+func GetMetrics(request *restful.Request, response *restful.Response) {
+	response.Header().Set("Content-Type", metrics.ContentType)
+	response.WriteHeader(http.StatusOK)
+	err := metrics.Default.WriteText(response)
+	utils.CheckError(err, "GetMetrics", "Write metrics")
+}
+
+func countBooksOutOfStock(ctx context.Context) (float64, error) {
+	count, err := handler.CountRowsContext(ctx, "v_books", handler.FilterQuery{
+		And: map[string][]handler.FieldFilter{
+			"available": {{Operator: "lte", Value: "0", ValueType: "int"}},
+		},
+	})
+	return float64(count), err
+}
+
+func countOverdueRentals(ctx context.Context) (float64, error) {
+	count, err := handler.CountRowsContext(ctx, "records", handler.FilterQuery{
+		And: map[string][]handler.FieldFilter{
+			"due_date": {{Operator: "lt", Value: time.Now().Format("2006-01-02 15:04:05"), ValueType: "string"}},
+			"rent_status": {
+				{Operator: "not", Value: "returned", ValueType: "string"},
+				{Operator: "not", Value: "lost", ValueType: "string"},
+			},
+		},
+	})
+	return float64(count), err
+}
@@ -0,0 +1,107 @@
+package route
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	restful "github.com/emicklei/go-restful/v3"
+	swaggerFiles "github.com/swaggo/files/v2"
+
+	"github.com/riszkymf/golang-rest-boilerplate/internal/openapi"
+)
+
+var apiInfo = openapi.Info{
+	Title:       "Library REST API",
+	Description: "Books, authors, members and rentals of the library.",
+	Version:     "1.0.0",
+}
+
+// Models are part of the generated components even if no route reads or
+// writes them directly.
+var apiModels = []interface{}{Book{}, Author{}, Members{}, Records{}, RentData{}, ResponseObj{}}
+
+const swaggerInitializer = `window.onload = function() {
+  window.ui = SwaggerUIBundle({
+    url: "/openapi.json",
+    dom_id: "#swagger-ui",
+    deepLinking: true,
+    presets: [SwaggerUIBundle.presets.apis, SwaggerUIStandalonePreset],
+    plugins: [SwaggerUIBundle.plugins.DownloadUrl],
+    layout: "StandaloneLayout"
+  });
+};
+`
+
+// OpenAPIRoute serves the OpenAPI document of every WebService registered in
+// the container, so it must be added after them. The document is generated
+// and encoded here, a failure panics at startup rather than on a request.
+func OpenAPIRoute(container *restful.Container) *restful.WebService {
+	var document []byte
+	service := new(restful.WebService)
+	service.
+		Path("/openapi.json").
+		Produces(restful.MIME_JSON)
+
+	service.Route(service.GET("").
+		To(func(request *restful.Request, response *restful.Response) {
+			response.Header().Set(restful.HEADER_ContentType, restful.MIME_JSON)
+			response.Write(document)
+		}).
+		Operation("GetOpenAPI").
+		Doc("OpenAPI 3 document of this API").
+		Returns(http.StatusOK, "OpenAPI document", nil))
+
+	// The document describes itself too, though it is not added yet.
+	documented := restful.NewContainer()
+	for _, registered := range container.RegisteredWebServices() {
+		documented.Add(registered)
+	}
+	documented.Add(service)
+	document, err := json.MarshalIndent(openapi.Build(documented, apiInfo, apiModels...), "", " ")
+	if err != nil {
+		panic(fmt.Errorf("openapi: encode document: %w", err))
+	}
+	return service
+}
+
+func DocsRoute() *restful.WebService {
+	service := new(restful.WebService)
+	service.
+		Path("/docs").
+		Produces("text/html")
+
+	service.Route(service.GET("").
+		To(GetDocs).
+		Doc("Swagger UI for the OpenAPI document").
+		Returns(http.StatusOK, "Swagger UI page", nil))
+	service.Route(service.GET("/{file}").
+		To(GetDocsFile).
+		Doc("Static assets of the Swagger UI").
+		Param(service.PathParameter("file", "Name of the asset")).
+		Returns(http.StatusOK, "Asset", nil).
+		Returns(http.StatusNotFound, "Unknown asset", nil))
+	return service
+}
+
+var docsFiles = http.StripPrefix("/docs/", http.FileServer(http.FS(swaggerFiles.FS)))
+
+func GetDocs(request *restful.Request, response *restful.Response) {
+	// The page refers to its assets relatively, so it has to be served
+	// from /docs/ rather than /docs.
+	if !strings.HasSuffix(request.Request.URL.Path, "/") {
+		http.Redirect(response, request.Request, request.Request.URL.Path+"/", http.StatusMovedPermanently)
+		return
+	}
+	docsFiles.ServeHTTP(response, request.Request)
+}
+
+func GetDocsFile(request *restful.Request, response *restful.Response) {
+	if request.PathParameter("file") == "swagger-initializer.js" {
+		response.Header().Set("Content-Type", "application/javascript")
+		response.Write([]byte(swaggerInitializer))
+		return
+	}
+	docsFiles.ServeHTTP(response, request.Request)
+}
@@ -6,6 +6,8 @@ import (
 	"time"
 
 	restful "github.com/emicklei/go-restful/v3"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/circulation"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/events"
 	"github.com/riszkymf/golang-rest-boilerplate/internal/handler"
 	utils "github.com/riszkymf/golang-rest-boilerplate/internal/src"
 )
@@ -23,35 +25,79 @@ func RecordsRoute() *restful.WebService {
 	service := new(restful.WebService)
 	service.
 		Path("/records").
-		Consumes(restful.MIME_XML, restful.MIME_JSON).
-		Produces(restful.MIME_JSON, restful.MIME_XML)
+		Consumes(bodyMimes...).
+		Produces(resourceMimes...)
 
-	service.Route(service.GET("/{records-id}").
-		To(GetRecord)).
+	service.Route(service.GET("/{record-id}").
+		To(GetRecord).
 		Doc("Retrieve record by ID").
-		Param(service.PathParameter("record-id", "Identifier of record").DataType("integer"))
+		Param(service.PathParameter("record-id", "Identifier of record").DataType("integer")).
+		Do(readParams(service)).
+		Writes(ResponseObj{Data: Records{}}))
 	service.Route(service.GET("/").
-		To(GetAllRecords)).
-		Doc("Retrieve available records")
+		To(GetAllRecords).
+		Produces(collectionMimes...).
+		Do(listParams(service)).
+		Param(branchParam(service)).
+		Do(branchScoped).
+		Doc("Retrieve available records").
+		Writes(ResponseObj{Data: []Records{}}))
 	service.Route(service.POST("").
-		To(InsertRecord)).
-		Doc("Insert new record")
+		To(InsertRecord).
+		Doc("Insert new record").
+		Notes("A rented record is refused with 409 if its member owes more than FINES_MAX_BALANCE; POST /rent/checkout also lends a copy.").
+		Reads(Records{}, "Record to insert, the id is assigned by the database").
+		Writes(ResponseObj{Data: Records{}}))
 	service.Route(service.POST("/{record-id}").
-		To(UpdateRecord)).
+		To(UpdateRecord).
 		Doc("Update record by ID").
-		Param(service.PathParameter("record-id", "Identifier of record").DataType("integer"))
+		Param(service.PathParameter("record-id", "Identifier of record").DataType("integer")).
+		Do(writeParams(service)).
+		Reads(Records{}, "Fields to update, omitted fields are left unchanged").
+		Writes(ResponseObj{Data: Records{}}))
+	service.Route(service.DELETE("/{record-id}").
+		To(DeleteRecord).
+		Doc("Delete record by ID").
+		Notes("The record is kept, marked deleted, until purged.").
+		Param(service.PathParameter("record-id", "Identifier of record").DataType("integer")).
+		Do(writeParams(service)).
+		Returns(http.StatusNoContent, "Deleted", nil))
+	service.Route(restoreRoute(service, "record-id", "records", "record").
+		Doc("Restore deleted record by ID").
+		Param(service.PathParameter("record-id", "Identifier of record").DataType("integer")).
+		Writes(ResponseObj{Data: Records{}}))
+	service.Route(historyRoute(service, "record-id", "records", "record").
+		Doc("Retrieve the changes to record by ID, oldest first").
+		Param(service.PathParameter("record-id", "Identifier of record").DataType("integer")))
 	return service
 }
 
 func GetAllRecords(request *restful.Request, response *restful.Response) {
-	records, err := handler.GetRowsAll("records")
+	ctx := request.Request.Context()
+	branch, ok := branchScope(request, response)
+	if !ok {
+		return
+	}
+	columns, err := handler.ColumnsContext(ctx, "records")
 	if err != nil {
 		res := ResponseObj{Data: nil, Errors: []string{err.Error()}, StatusCode: http.StatusInternalServerError}
-		response.WriteAsJson(res)
+		response.WriteEntity(res)
+		return
+	}
+	query, err := listQuery(request, columns)
+	if err != nil {
+		resourceError(response, http.StatusBadRequest, err)
 		return
 	}
-	res := ResponseObj{Data: records, Errors: nil, StatusCode: 200}
-	response.WriteAsJson(res)
+	scopeQuery(&query, branch)
+	records, err := handler.GetRowsContext(ctx, "records", query)
+	if err != nil {
+		res := ResponseObj{Data: nil, Errors: []string{err.Error()}, StatusCode: http.StatusInternalServerError}
+		response.WriteEntity(res)
+		return
+	}
+	res := ResponseObj{Data: records, Errors: nil, StatusCode: 200, Item: "record", Columns: columns}
+	response.WriteEntity(res)
 }
 
 func GetRecord(request *restful.Request, response *restful.Response) {
@@ -59,17 +105,15 @@ func GetRecord(request *restful.Request, response *restful.Response) {
 	idParse, err := strconv.Atoi(id)
 	if err != nil {
 		utils.LogError("GetRecord", "Invalid Id Type", err.Error())
-		response.WriteAsJson(ResponseObj{Data: nil, Errors: []string{"ID must be numerical"}, StatusCode: http.StatusBadRequest})
+		response.WriteEntity(ResponseObj{Data: nil, Errors: []string{"ID must be numerical"}, StatusCode: http.StatusBadRequest})
 		return
 	}
-	record, err := handler.GetRowById("records", idParse)
+	record, err := handler.GetRowByIdContext(request.Request.Context(), "records", idParse)
 	if err != nil {
-		res := ResponseObj{Data: nil, Errors: []string{err.Error()}, StatusCode: http.StatusInternalServerError}
-		response.WriteAsJson(res)
+		resourceError(response, http.StatusInternalServerError, err)
 		return
 	}
-	res := ResponseObj{Data: record, StatusCode: http.StatusOK}
-	response.WriteAsJson(res)
+	writeResource(request, response, record, "record")
 }
 
 func InsertRecord(request *restful.Request, response *restful.Response) {
@@ -77,10 +121,23 @@ func InsertRecord(request *restful.Request, response *restful.Response) {
 	err := request.ReadEntity(&record)
 	if err != nil {
 		res := ResponseObj{Errors: []string{err.Error()}, StatusCode: http.StatusBadRequest}
-		response.WriteAsJson(res)
+		response.WriteHeaderAndEntity(res.StatusCode, res)
 		return
 	}
 
+	// Inserting a rented loan checks a book out.
+	if record.RentStatus == events.StatusRented {
+		reasons, err := circulation.Default.Refusals(request.Request.Context(), record.MemberId)
+		if err != nil {
+			resourceError(response, resourceErrorStatus(err), err)
+			return
+		}
+		if len(reasons) > 0 {
+			circulationError(response, &circulation.Refusal{Reasons: reasons})
+			return
+		}
+	}
+
 	inputData := map[string]any{
 		"member_id":   record.MemberId,
 		"book_id":     record.BookId,
@@ -88,16 +145,17 @@ func InsertRecord(request *restful.Request, response *restful.Response) {
 		"due_date":    record.DueDate,
 		"rent_status": record.RentStatus,
 	}
-	addRecord, err := handler.InsertData("records", inputData)
+	addRecord, err := handler.InsertDataContext(request.Request.Context(), "records", inputData)
 	if err != nil {
 		res := ResponseObj{
 			Errors:     []string{err.Error()},
 			StatusCode: http.StatusInternalServerError,
 		}
-		response.WriteAsJson(res)
+		response.WriteHeaderAndEntity(res.StatusCode, res)
+		return
 	}
 	record.Id = addRecord
-	response.WriteAsJson(ResponseObj{Data: record})
+	response.WriteHeaderAndEntity(http.StatusOK, ResponseObj{Data: record, StatusCode: http.StatusOK, Item: "record"})
 
 }
 
@@ -106,7 +164,7 @@ func UpdateRecord(request *restful.Request, response *restful.Response) {
 	idParse, err := strconv.Atoi(id)
 	if err != nil {
 		utils.LogError("Update Record", "Invalid Id Type", err.Error())
-		response.WriteAsJson(ResponseObj{Data: nil, Errors: []string{"ID must be numerical"}, StatusCode: http.StatusBadRequest})
+		response.WriteEntity(ResponseObj{Data: nil, Errors: []string{"ID must be numerical"}, StatusCode: http.StatusBadRequest})
 		return
 	}
 
@@ -126,18 +184,11 @@ func UpdateRecord(request *restful.Request, response *restful.Response) {
 	filteredInput, err := utils.FilterInputMap(record, updateInput)
 	if err != nil {
 		res := ResponseObj{Errors: []string{err.Error()}, StatusCode: http.StatusInternalServerError}
-		response.WriteAsJson(res)
+		response.WriteEntity(res)
 		return
 	}
 
-	err = handler.UpdateData("records", filteredInput, record.Id)
-	if err != nil {
-		res := ResponseObj{Errors: []string{err.Error()}, StatusCode: http.StatusInternalServerError}
-		response.WriteAsJson(res)
-		return
-	}
-	filteredInput["id"] = record.Id
-	response.WriteAsJson(ResponseObj{Data: filteredInput})
+	updateResource(request, response, "records", record.Id, filteredInput, "record")
 
 }
 
@@ -146,12 +197,8 @@ func DeleteRecord(request *restful.Request, response *restful.Response) {
 	idParse, err := strconv.Atoi(id)
 	if err != nil {
 		utils.LogError("Delete Records", "Invalid Id Type", err.Error())
-		response.WriteAsJson(ResponseObj{Data: nil, Errors: []string{"ID must be numerical"}, StatusCode: http.StatusBadRequest})
-		return
-	}
-	err = handler.DeleteData("records", idParse)
-	if err != nil {
-		response.WriteAsJson(ResponseObj{Errors: []string{err.Error()}, StatusCode: http.StatusBadRequest})
+		response.WriteEntity(ResponseObj{Data: nil, Errors: []string{"ID must be numerical"}, StatusCode: http.StatusBadRequest})
 		return
 	}
+	deleteResource(request, response, "records", idParse)
 }
@@ -1,11 +1,16 @@
 package route
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"strconv"
+	"time"
 
 	restful "github.com/emicklei/go-restful/v3"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/circulation"
 	"github.com/riszkymf/golang-rest-boilerplate/internal/handler"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/overdue"
 	utils "github.com/riszkymf/golang-rest-boilerplate/internal/src"
 )
 
@@ -13,6 +18,8 @@ type RentData struct {
 	Id         int    `json:"id" default:"-1"`
 	BookId     int    `json:"book_id" default:"-1"`
 	MemberId   int    `json:"member_id" default:"-1"`
+	CopyId     int    `json:"copy_id" default:"-1"`
+	Barcode    string `json:"barcode" default:""`
 	AuthorName string `json:"author_name" default:""`
 	Email      string `json:"email" default:""`
 	Firstname  string `json:"firstname" default:""`
@@ -22,32 +29,150 @@ type RentData struct {
 	RentStatus string `json:"rent_status" default:""`
 }
 
+// CheckoutInput is the loan asked by POST /rent/checkout, of the copy
+// Barcode or else of a copy of the book BookId.
+type CheckoutInput struct {
+	Barcode  string `json:"barcode,omitempty"`
+	BookId   int    `json:"book_id,omitempty"`
+	MemberId int    `json:"member_id"`
+}
+
+// ReturnInput is the copy checked back in by POST /rent/return.
+type ReturnInput struct {
+	Barcode string `json:"barcode"`
+	Damaged bool   `json:"damaged,omitempty"`
+}
+
+// OverdueRentData is a loan of GET /rent/overdue.
+type OverdueRentData struct {
+	RentData
+	Title       string `json:"title"`
+	DaysOverdue int    `json:"days_overdue"`
+}
+
 func RentRoute() *restful.WebService {
 	service := new(restful.WebService)
 	service.
 		Path("/rent").
-		Consumes(restful.MIME_XML, restful.MIME_JSON).
-		Produces(restful.MIME_JSON, restful.MIME_XML)
+		Consumes(bodyMimes...).
+		Produces(resourceMimes...)
 
-	service.Route(service.GET("/{records-id}").
-		To(GetRentData)).
+	service.Route(service.GET("/overdue").
+		To(GetOverdueRentData).
+		Produces(collectionMimes...).
+		Do(listParams(service)).
+		Param(branchParam(service)).
+		Do(branchScoped).
+		Doc("Retrieve the loans past their due date, with the days they are overdue").
+		Notes("A loan is overdue from the day after its due date, in the time zone of the library, whether the scheduler flagged it yet or not. Sorted by due date unless sort is given.").
+		Writes(ResponseObj{Data: []OverdueRentData{}}))
+	service.Route(service.GET("/overdue/members").
+		To(GetOverdueMembers).
+		Do(listParams(service)).
+		Param(branchParam(service)).
+		Do(branchScoped).
+		Doc("Retrieve the members with overdue loans, the longest overdue first").
+		Notes("filter and or select the loans summed up, e.g. filter=member_id:eq:3.").
+		Writes(ResponseObj{Data: []overdue.MemberSummary{}}))
+	service.Route(service.POST("/checkout").
+		To(CheckoutBook).
+		Do(branchScoped).
+		Doc("Lend a copy of a book to a member").
+		Notes("Lends the copy scanned, or given the book the copy set aside for the hold of the member or the first available; "+
+			"the loan is due in LOANS_PERIOD_DAYS days. "+
+			"Staff lend the copies of their branch, to which the loan belongs. "+
+			"Refused with 409 and the reasons if the copy is not available or the member owes more than FINES_MAX_BALANCE.").
+		Reads(CheckoutInput{}, "The copy or the book, and the member").
+		Returns(http.StatusCreated, "The loan", ResponseObj{Data: Records{}}).
+		Returns(http.StatusNotFound, "No such copy, book or member", ResponseObj{}).
+		Returns(http.StatusConflict, "Refused", ResponseObj{}))
+	service.Route(service.POST("/return").
+		To(ReturnCopy).
+		Doc("Return the copy scanned").
+		Notes("Closes the open or lost loan of the copy, as POST /rent/{record-id}/return does.").
+		Reads(ReturnInput{}, "The copy, and whether it came back damaged").
+		Returns(http.StatusOK, "The loan", ResponseObj{Data: Records{}}).
+		Returns(http.StatusNotFound, "No such copy", ResponseObj{}).
+		Returns(http.StatusConflict, "The copy is not on loan", ResponseObj{}))
+	service.Route(service.POST("/{record-id}/return").
+		To(ReturnBook).
+		Doc("Return the book of a loan").
+		Notes("Puts the copy back on the shelf, or aside for the oldest waiting hold; a damaged copy is taken out of circulation. "+
+			"A loan returned late fines its member, see FINES_DAILY_RATE.").
+		Param(service.PathParameter("record-id", "Identifier of record").DataType("integer")).
+		Param(service.QueryParameter("damaged", "The copy came back damaged").DataType("boolean").DefaultValue("false")).
+		AllowedMethodsWithoutContentType([]string{http.MethodPost}).
+		Returns(http.StatusOK, "The loan", ResponseObj{Data: Records{}}).
+		Returns(http.StatusNotFound, "No such loan", ResponseObj{}).
+		Returns(http.StatusConflict, "The loan is already returned", ResponseObj{}))
+	service.Route(service.POST("/{record-id}/lost").
+		To(MarkBookLost).
+		Doc("Mark the book of a loan lost").
+		Notes("Marks its copy lost and fines its member FINES_LOST_COST and the late fee so far. Returning it later puts it back on the shelf.").
+		Param(service.PathParameter("record-id", "Identifier of record").DataType("integer")).
+		AllowedMethodsWithoutContentType([]string{http.MethodPost}).
+		Returns(http.StatusOK, "The loan", ResponseObj{Data: Records{}}).
+		Returns(http.StatusNotFound, "No such loan", ResponseObj{}).
+		Returns(http.StatusConflict, "The loan is not open", ResponseObj{}))
+	service.Route(service.POST("/{record-id}/renew").
+		To(RenewLoan).
+		Doc("Renew a loan").
+		Notes("Extends the due date by the renewal days of the loan policy of the member, from the due date or today if later. "+
+			"Refused with 409 and the reasons past the most renewals allowed, when the loan is overdue by more than allowed, "+
+			"or when the book is on hold for another member.").
+		Param(service.PathParameter("record-id", "Identifier of record").DataType("integer")).
+		AllowedMethodsWithoutContentType([]string{http.MethodPost}).
+		Returns(http.StatusOK, "The loan", ResponseObj{Data: Records{}}).
+		Returns(http.StatusNotFound, "No such loan", ResponseObj{}).
+		Returns(http.StatusConflict, "Refused", ResponseObj{}))
+	service.Route(service.GET("/{record-id}/renewals").
+		To(GetLoanRenewals).
+		Doc("Retrieve the renewals of a loan, oldest first").
+		Param(service.PathParameter("record-id", "Identifier of record").DataType("integer")).
+		Writes(ResponseObj{Data: []circulation.Renewal{}}))
+	service.Route(service.GET("/{record-id}").
+		To(GetRentData).
 		Doc("Retrieve rent by ID").
-		Param(service.PathParameter("record-id", "Identifier of record").DataType("integer"))
+		Param(service.PathParameter("record-id", "Identifier of record").DataType("integer")).
+		Do(readParams(service)).
+		Writes(ResponseObj{Data: Records{}}))
 	service.Route(service.GET("/").
-		To(GetAllRentData)).
-		Doc("Retrieve available rent data")
+		To(GetAllRentData).
+		Produces(collectionMimes...).
+		Do(listParams(service)).
+		Param(branchParam(service)).
+		Do(branchScoped).
+		Doc("Retrieve available rent data").
+		Writes(ResponseObj{Data: []RentData{}}))
 	return service
 }
 
 func GetAllRentData(request *restful.Request, response *restful.Response) {
-	data, err := handler.GetRowsAll("v_rent")
+	ctx := request.Request.Context()
+	branch, ok := branchScope(request, response)
+	if !ok {
+		return
+	}
+	columns, err := handler.ColumnsContext(ctx, "v_rent")
+	if err != nil {
+		res := ResponseObj{Data: nil, Errors: []string{err.Error()}, StatusCode: http.StatusInternalServerError}
+		response.WriteEntity(res)
+		return
+	}
+	query, err := listQuery(request, columns)
+	if err != nil {
+		resourceError(response, http.StatusBadRequest, err)
+		return
+	}
+	scopeQuery(&query, branch)
+	data, err := handler.GetRowsContext(ctx, "v_rent", query)
 	if err != nil {
 		res := ResponseObj{Data: nil, Errors: []string{err.Error()}, StatusCode: http.StatusInternalServerError}
-		response.WriteAsJson(res)
+		response.WriteEntity(res)
 		return
 	}
-	res := ResponseObj{Data: data, Errors: nil, StatusCode: 200}
-	response.WriteAsJson(res)
+	res := ResponseObj{Data: data, Errors: nil, StatusCode: 200, Item: "rent", Columns: columns}
+	response.WriteEntity(res)
 }
 
 func GetRentData(request *restful.Request, response *restful.Response) {
@@ -55,15 +180,185 @@ func GetRentData(request *restful.Request, response *restful.Response) {
 	idParse, err := strconv.Atoi(id)
 	if err != nil {
 		utils.LogError("GetRecord", "Invalid Id Type", err.Error())
-		response.WriteAsJson(ResponseObj{Data: nil, Errors: []string{"ID must be numerical"}, StatusCode: http.StatusBadRequest})
+		response.WriteEntity(ResponseObj{Data: nil, Errors: []string{"ID must be numerical"}, StatusCode: http.StatusBadRequest})
 		return
 	}
-	data, err := handler.GetRowById("records", idParse)
+	data, err := handler.GetRowByIdContext(request.Request.Context(), "records", idParse)
 	if err != nil {
-		res := ResponseObj{Data: nil, Errors: []string{err.Error()}, StatusCode: http.StatusInternalServerError}
-		response.WriteAsJson(res)
+		resourceError(response, http.StatusInternalServerError, err)
+		return
+	}
+	writeResource(request, response, data, "rent")
+}
+
+// overdueQuery reads the list parameters of a request for the overdue loans
+// of v_rent, of the branch branch unless it is empty.
+func overdueQuery(request *restful.Request, columns []string, branch string) (handler.ListQuery, time.Time, error) {
+	query, err := listQuery(request, columns)
+	if err != nil {
+		return query, time.Time{}, err
+	}
+	scopeQuery(&query, branch)
+	today := overdue.Default.Today()
+	if query.Filter.And == nil {
+		query.Filter.And = map[string][]handler.FieldFilter{}
+	}
+	for column, filters := range overdue.Filter(today) {
+		query.Filter.And[column] = append(query.Filter.And[column], filters...)
+	}
+	if len(query.Sort) == 0 {
+		query.Sort = []handler.SortField{{Column: "due_date"}, {Column: "id"}}
+	}
+	return query, today, nil
+}
+
+func GetOverdueRentData(request *restful.Request, response *restful.Response) {
+	ctx := request.Request.Context()
+	branch, ok := branchScope(request, response)
+	if !ok {
+		return
+	}
+	columns, err := handler.ColumnsContext(ctx, "v_rent")
+	if err != nil {
+		resourceError(response, http.StatusInternalServerError, err)
+		return
+	}
+	query, today, err := overdueQuery(request, columns, branch)
+	if err != nil {
+		resourceError(response, http.StatusBadRequest, err)
+		return
+	}
+	data, err := handler.GetRowsContext(ctx, "v_rent", query)
+	if err != nil {
+		resourceError(response, http.StatusInternalServerError, err)
+		return
+	}
+	for _, row := range data {
+		dueDate, _ := row["due_date"].(string)
+		row["days_overdue"] = overdue.DaysOverdue(dueDate, today)
+	}
+	response.WriteHeaderAndEntity(http.StatusOK, ResponseObj{
+		Data: data, StatusCode: http.StatusOK, Item: "rent", Columns: append(columns, "days_overdue"),
+	})
+}
+
+func GetOverdueMembers(request *restful.Request, response *restful.Response) {
+	ctx := request.Request.Context()
+	branch, ok := branchScope(request, response)
+	if !ok {
+		return
+	}
+	columns, err := handler.ColumnsContext(ctx, "v_rent")
+	if err != nil {
+		resourceError(response, http.StatusInternalServerError, err)
+		return
+	}
+	query, today, err := overdueQuery(request, columns, branch)
+	if err != nil {
+		resourceError(response, http.StatusBadRequest, err)
+		return
+	}
+	data, err := handler.GetRowsContext(ctx, "v_rent", query)
+	if err != nil {
+		resourceError(response, http.StatusInternalServerError, err)
+		return
+	}
+	response.WriteHeaderAndEntity(http.StatusOK, ResponseObj{
+		Data: overdue.Summarize(data, today), StatusCode: http.StatusOK, Item: "member",
+	})
+}
+
+func CheckoutBook(request *restful.Request, response *restful.Response) {
+	input := CheckoutInput{}
+	if err := request.ReadEntity(&input); err != nil {
+		resourceError(response, http.StatusBadRequest, err)
+		return
+	}
+	var record map[string]any
+	var err error
+	if input.Barcode != "" {
+		record, err = circulation.Default.CheckoutCopy(request.Request.Context(), input.Barcode, input.MemberId)
+	} else {
+		record, err = circulation.Default.Checkout(request.Request.Context(), input.BookId, input.MemberId)
+	}
+	if err != nil {
+		circulationError(response, err)
+		return
+	}
+	response.WriteHeaderAndEntity(http.StatusCreated, ResponseObj{Data: record, StatusCode: http.StatusCreated, Item: "record"})
+}
+
+func ReturnBook(request *restful.Request, response *restful.Response) {
+	damaged, err := queryBool(request, "damaged")
+	if err != nil {
+		resourceError(response, http.StatusBadRequest, err)
+		return
+	}
+	if damaged {
+		loanOperation(request, response, circulation.Default.ReturnDamaged)
+		return
+	}
+	loanOperation(request, response, circulation.Default.Return)
+}
+
+func ReturnCopy(request *restful.Request, response *restful.Response) {
+	input := ReturnInput{}
+	if err := request.ReadEntity(&input); err != nil {
+		resourceError(response, http.StatusBadRequest, err)
+		return
+	}
+	record, err := circulation.Default.ReturnCopy(request.Request.Context(), input.Barcode, input.Damaged)
+	if err != nil {
+		circulationError(response, err)
+		return
+	}
+	response.WriteHeaderAndEntity(http.StatusOK, ResponseObj{Data: record, StatusCode: http.StatusOK, Item: "record"})
+}
+
+func MarkBookLost(request *restful.Request, response *restful.Response) {
+	loanOperation(request, response, circulation.Default.MarkLost)
+}
+
+func RenewLoan(request *restful.Request, response *restful.Response) {
+	loanOperation(request, response, circulation.Default.Renew)
+}
+
+func GetLoanRenewals(request *restful.Request, response *restful.Response) {
+	id, err := strconv.Atoi(request.PathParameter("record-id"))
+	if err != nil {
+		resourceError(response, http.StatusBadRequest, errors.New("ID must be numerical"))
+		return
+	}
+	renewals, err := circulation.Renewals(request.Request.Context(), id)
+	if err != nil {
+		resourceError(response, http.StatusInternalServerError, err)
+		return
+	}
+	response.WriteHeaderAndEntity(http.StatusOK, ResponseObj{Data: renewals, StatusCode: http.StatusOK, Item: "renewal"})
+}
+
+// loanOperation answers the operation of the desk on the loan of the request.
+func loanOperation(request *restful.Request, response *restful.Response, operation func(context.Context, int) (map[string]any, error)) {
+	id, err := strconv.Atoi(request.PathParameter("record-id"))
+	if err != nil {
+		resourceError(response, http.StatusBadRequest, errors.New("ID must be numerical"))
+		return
+	}
+	record, err := operation(request.Request.Context(), id)
+	if err != nil {
+		circulationError(response, err)
+		return
+	}
+	response.WriteHeaderAndEntity(http.StatusOK, ResponseObj{Data: record, StatusCode: http.StatusOK, Item: "record"})
+}
+
+// circulationError answers a refused operation with 409 and its reasons.
+func circulationError(response *restful.Response, err error) {
+	var refusal *circulation.Refusal
+	if errors.As(err, &refusal) {
+		res := ResponseObj{Errors: refusal.Reasons, StatusCode: http.StatusConflict}
+		response.WriteHeaderAndEntity(res.StatusCode, res)
 		return
 	}
-	res := ResponseObj{Data: data, StatusCode: http.StatusOK}
-	response.WriteAsJson(res)
+	resourceError(response, resourceErrorStatus(err), err)
 }
@@ -0,0 +1,241 @@
+package route
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	restful "github.com/emicklei/go-restful/v3"
+
+	"github.com/riszkymf/golang-rest-boilerplate/internal/handler"
+)
+
+// RequireIfMatch makes updates and deletes sent without an If-Match header
+// fail with 428, so that no client overwrites changes it has not seen.
+var RequireIfMatch = false
+
+// readParams documents the conditional GET of a single resource.
+func readParams(service *restful.WebService) func(*restful.RouteBuilder) {
+	return func(builder *restful.RouteBuilder) {
+		builder.
+			Param(service.HeaderParameter("If-None-Match", "ETag of the copy held by the client")).
+			Param(includeDeletedParam(service)).
+			Returns(http.StatusNotModified, "The copy held by the client is current", nil).
+			Returns(http.StatusNotFound, "No such resource", ResponseObj{})
+	}
+}
+
+// writeParams documents the If-Match precondition of updates and deletes.
+func writeParams(service *restful.WebService) func(*restful.RouteBuilder) {
+	return func(builder *restful.RouteBuilder) {
+		builder.
+			Param(service.HeaderParameter("If-Match", "ETag returned by the last read, the change fails with 412 if the resource was modified since")).
+			Returns(http.StatusNotFound, "No such resource", ResponseObj{}).
+			Returns(http.StatusPreconditionFailed, "The resource was modified since it was read", ResponseObj{}).
+			Returns(http.StatusPreconditionRequired, "If-Match is required and missing", ResponseObj{})
+	}
+}
+
+// includeDeletedParam documents the parameter read by the include_deleted
+// filter of the container.
+func includeDeletedParam(service *restful.WebService) *restful.Parameter {
+	return service.QueryParameter("include_deleted", "Include the deleted rows").DataType("boolean").DefaultValue("false")
+}
+
+// restoreRoute undoes the soft delete of the row of table identified by the
+// path parameter idParam.
+func restoreRoute(service *restful.WebService, idParam string, table string, item string) *restful.RouteBuilder {
+	return service.POST(fmt.Sprintf("/{%v}/restore", idParam)).
+		To(restoreHandler(idParam, table, item)).
+		Operation("Restore"+strings.ToUpper(item[:1])+item[1:]).
+		AllowedMethodsWithoutContentType([]string{http.MethodPost}).
+		Returns(http.StatusNotFound, "No such resource", ResponseObj{})
+}
+
+func restoreHandler(idParam string, table string, item string) restful.RouteFunction {
+	return func(request *restful.Request, response *restful.Response) {
+		ctx := request.Request.Context()
+		id, err := strconv.Atoi(request.PathParameter(idParam))
+		if err != nil {
+			resourceError(response, http.StatusBadRequest, errors.New("ID must be numerical"))
+			return
+		}
+		if err := handler.RestoreDataContext(ctx, table, id); err != nil {
+			resourceError(response, resourceErrorStatus(err), err)
+			return
+		}
+		row, err := handler.GetRowByIdContext(ctx, table, id)
+		if err != nil {
+			resourceError(response, http.StatusInternalServerError, err)
+			return
+		}
+		if tag := etag(row); tag != "" {
+			response.Header().Set("ETag", tag)
+		}
+		response.WriteHeaderAndEntity(http.StatusOK, ResponseObj{Data: row, StatusCode: http.StatusOK, Item: item})
+	}
+}
+
+// etag is the entity tag of a row: its version, quoted. Rows of tables
+// without a version column have none.
+func etag(row map[string]any) string {
+	version, ok := row["version"].(int)
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+// matchETag reports whether the If-Match or If-None-Match header lists tag.
+// If-None-Match compares weakly, ignoring the W/ prefix.
+func matchETag(header string, tag string, weak bool) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == tag {
+			return true
+		}
+	}
+	return false
+}
+
+func resourceError(response *restful.Response, status int, err error) {
+	res := ResponseObj{Errors: []string{err.Error()}, StatusCode: status}
+	response.WriteHeaderAndEntity(status, res)
+}
+
+// resourceErrorStatus maps the errors of the handler to a status.
+func resourceErrorStatus(err error) int {
+	switch {
+	case errors.Is(err, handler.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, handler.ErrVersionMismatch):
+		return http.StatusPreconditionFailed
+	case handler.IsConflict(err):
+		return http.StatusConflict
+	}
+	return http.StatusInternalServerError
+}
+
+// writeResource answers a single-resource read with the row and its ETag, or
+// with 304 when If-None-Match names the current ETag.
+func writeResource(request *restful.Request, response *restful.Response, row map[string]any, item string) {
+	if row["id"] == nil {
+		resourceError(response, http.StatusNotFound, handler.ErrNotFound)
+		return
+	}
+	if tag := etag(row); tag != "" {
+		response.Header().Set("ETag", tag)
+		if header := request.HeaderParameter("If-None-Match"); header != "" && matchETag(header, tag, true) {
+			response.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	response.WriteHeaderAndEntity(http.StatusOK, ResponseObj{Data: row, StatusCode: http.StatusOK, Item: item})
+}
+
+// precondition checks the If-Match header of a change to the row id of
+// table. It returns the version the change must apply to, 0 for an
+// unconditional change, or the status to fail with.
+func precondition(ctx context.Context, request *restful.Request, table string, id int) (int, int, error) {
+	header := request.HeaderParameter("If-Match")
+	if header == "" {
+		if RequireIfMatch {
+			return 0, http.StatusPreconditionRequired, errors.New("If-Match is required, send the ETag of the last read")
+		}
+		return 0, 0, nil
+	}
+	row, err := handler.GetRowByIdContext(ctx, table, id)
+	if err != nil {
+		return 0, http.StatusInternalServerError, err
+	}
+	if row["id"] == nil {
+		return 0, http.StatusNotFound, handler.ErrNotFound
+	}
+	tag := etag(row)
+	if tag == "" {
+		if header == "*" {
+			return 0, 0, nil
+		}
+		return 0, http.StatusPreconditionFailed, errors.New("the resource has no ETag")
+	}
+	if !matchETag(header, tag, false) {
+		return 0, http.StatusPreconditionFailed, handler.ErrVersionMismatch
+	}
+	version, _ := row["version"].(int)
+	return version, 0, nil
+}
+
+// updateResource applies data to the row id, honouring If-Match, and answers
+// with the stored row and its new ETag. Each of related, in the same
+// transaction, writes what the row refers to and adds it to the row answered.
+func updateResource(request *restful.Request, response *restful.Response, table string, id int, data map[string]any, item string, related ...func(ctx context.Context, row map[string]any) error) {
+	ctx := request.Request.Context()
+	version, status, err := precondition(ctx, request, table, id)
+	if err != nil {
+		resourceError(response, status, err)
+		return
+	}
+	var row map[string]any
+	err = handler.WithTransaction(ctx, func(ctx context.Context) error {
+		var err error
+		if version > 0 {
+			err = handler.UpdateDataIfVersionContext(ctx, table, data, id, version)
+		} else {
+			err = handler.UpdateDataContext(ctx, table, data, id)
+		}
+		if err != nil {
+			return err
+		}
+		if row, err = handler.GetRowByIdContext(ctx, table, id); err != nil || row["id"] == nil {
+			return err
+		}
+		for _, write := range related {
+			if err := write(ctx, row); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		resourceError(response, resourceErrorStatus(err), err)
+		return
+	}
+	if row["id"] == nil {
+		resourceError(response, http.StatusNotFound, handler.ErrNotFound)
+		return
+	}
+	if tag := etag(row); tag != "" {
+		response.Header().Set("ETag", tag)
+	}
+	response.WriteHeaderAndEntity(http.StatusOK, ResponseObj{Data: row, StatusCode: http.StatusOK, Item: item})
+}
+
+// deleteResource deletes the row id, honouring If-Match. Rows of the core
+// tables are only marked deleted, see restoreRoute.
+func deleteResource(request *restful.Request, response *restful.Response, table string, id int) {
+	ctx := request.Request.Context()
+	version, status, err := precondition(ctx, request, table, id)
+	if err != nil {
+		resourceError(response, status, err)
+		return
+	}
+	if version > 0 {
+		err = handler.DeleteDataIfVersionContext(ctx, table, id, version)
+	} else {
+		err = handler.DeleteDataContext(ctx, table, id)
+	}
+	if err != nil {
+		resourceError(response, resourceErrorStatus(err), err)
+		return
+	}
+	response.WriteHeader(http.StatusNoContent)
+}
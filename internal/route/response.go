@@ -1,7 +1,50 @@
 package route
 
+import (
+	"encoding/xml"
+	"net/http"
+
+	"github.com/riszkymf/golang-rest-boilerplate/internal/encoding"
+)
+
 type ResponseObj struct {
 	Data       interface{} `json:"data"`
 	Errors     []string    `json:"error"`
 	StatusCode int         `json:"status"`
+
+	// Item names the XML element of each row of Data and Columns orders the
+	// fields of rows read into maps; neither is part of the body.
+	Item    string   `json:"-"`
+	Columns []string `json:"-"`
+}
+
+// MarshalXML writes <response><status/><errors/><data><book/>...</data></response>.
+func (r ResponseObj) MarshalXML(enc *xml.Encoder, start xml.StartElement) error {
+	start.Name = xml.Name{Local: "response"}
+	if err := enc.EncodeToken(start); err != nil {
+		return err
+	}
+	status := r.StatusCode
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if err := encoding.EncodeXML(enc, encoding.Element("status"), status, "", nil); err != nil {
+		return err
+	}
+	if len(r.Errors) > 0 {
+		if err := encoding.EncodeXML(enc, encoding.Element("errors"), r.Errors, "error", nil); err != nil {
+			return err
+		}
+	}
+	if r.Data != nil {
+		item := r.Item
+		if item == "" {
+			item = "item"
+		}
+		rows := encoding.Rows(r.Data)
+		if err := encoding.EncodeXML(enc, encoding.Element("data"), rows, item, r.Columns); err != nil {
+			return err
+		}
+	}
+	return enc.EncodeToken(start.End())
 }
@@ -0,0 +1,156 @@
+package route
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	restful "github.com/emicklei/go-restful/v3"
+
+	"github.com/riszkymf/golang-rest-boilerplate/internal/events"
+	utils "github.com/riszkymf/golang-rest-boilerplate/internal/src"
+)
+
+const mimeEventStream = "text/event-stream"
+
+var (
+	// StreamHeartbeat is how often an idle event stream sends a comment, for
+	// proxies not to close it.
+	StreamHeartbeat = 15 * time.Second
+	// StreamDuration ends event streams before the write timeout of the
+	// server does; clients reconnect and resume. Zero leaves them open.
+	StreamDuration = 25 * time.Second
+	// StreamRetry is the reconnection delay advised to clients.
+	StreamRetry = 2 * time.Second
+)
+
+func StreamEvents(request *restful.Request, response *restful.Response) {
+	ctx := request.Request.Context()
+	topics, err := events.ParseTopics(request.Request.URL.Query()["topics"]...)
+	if err != nil {
+		resourceError(response, http.StatusBadRequest, err)
+		return
+	}
+	// EventSource sends Last-Event-ID when it reconnects; last_event_id lets
+	// a new page resume too.
+	lastId := request.HeaderParameter("Last-Event-ID")
+	if lastId == "" {
+		lastId = request.QueryParameter("last_event_id")
+	}
+	after := -1
+	if lastId != "" {
+		if after, err = strconv.Atoi(lastId); err != nil || after < 0 {
+			resourceError(response, http.StatusBadRequest, fmt.Errorf("invalid Last-Event-ID %q", lastId))
+			return
+		}
+	}
+	sub, replay, complete, err := events.BrokerFromContext(ctx).Subscribe(ctx, after)
+	if err != nil {
+		resourceError(response, http.StatusServiceUnavailable, err)
+		return
+	}
+	defer sub.Close()
+
+	header := response.Header()
+	header.Set(restful.HEADER_ContentType, mimeEventStream)
+	header.Set("Cache-Control", "no-cache")
+	header.Set("X-Accel-Buffering", "no")
+	response.WriteHeader(http.StatusOK)
+
+	stream := eventStream{out: response, topics: topics, position: after, marked: after}
+	stream.write(fmt.Sprintf("retry: %d\n\n", StreamRetry.Milliseconds()))
+	if !complete {
+		stream.reset()
+		stream.position = sub.Start
+		stream.mark()
+	}
+	for _, event := range replay {
+		stream.send(event)
+	}
+	response.Flush()
+
+	heartbeat := time.NewTicker(StreamHeartbeat)
+	defer heartbeat.Stop()
+	var deadline <-chan time.Time
+	if StreamDuration > 0 {
+		timer := time.NewTimer(StreamDuration)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	for stream.err == nil {
+		select {
+		case <-ctx.Done():
+			return
+		case <-deadline:
+			stream.mark()
+			response.Flush()
+			return
+		case <-heartbeat.C:
+			stream.mark()
+			stream.comment(": heartbeat")
+		case event, open := <-sub.Events:
+			if !open {
+				// Stopped, or too far behind: the client resumes from
+				// the last id it got.
+				stream.mark()
+				response.Flush()
+				return
+			}
+			stream.send(event)
+		}
+		response.Flush()
+	}
+	utils.LogErrorContext(ctx, "StreamEvents", "write event", stream.err.Error())
+}
+
+// eventStream writes Server-Sent Events. position is the id of the last event
+// read, selected or not; marked the last id told to the client.
+type eventStream struct {
+	out      io.Writer
+	topics   events.Topics
+	position int
+	marked   int
+	err      error
+}
+
+func (s *eventStream) write(text string) {
+	if s.err == nil {
+		_, s.err = io.WriteString(s.out, text)
+	}
+}
+
+func (s *eventStream) comment(line string) {
+	s.write(line + "\n\n")
+}
+
+func (s *eventStream) send(event events.Event) {
+	s.position = event.Id
+	if !s.topics.Match(event.Type) {
+		return
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		s.err = err
+		return
+	}
+	s.write(fmt.Sprintf("id: %d\nevent: %v\ndata: %s\n\n", event.Id, event.Type, data))
+	s.marked = event.Id
+}
+
+// mark tells the client the id to resume from when events it did not select
+// followed the last one it got. A message without data is not dispatched.
+func (s *eventStream) mark() {
+	if s.position > s.marked {
+		s.write(fmt.Sprintf("id: %d\n\n", s.position))
+		s.marked = s.position
+	}
+}
+
+// reset tells the client that it missed too many events to replay, and
+// should reload what it shows.
+func (s *eventStream) reset() {
+	s.write("event: reset\ndata: {}\n\n")
+}
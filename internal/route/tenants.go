@@ -0,0 +1,117 @@
+package route
+
+import (
+	"crypto/subtle"
+	"errors"
+	"net/http"
+
+	restful "github.com/emicklei/go-restful/v3"
+
+	"github.com/riszkymf/golang-rest-boilerplate/internal/tenant"
+)
+
+// TenantAdminToken is the bearer token the tenant administration requires;
+// while it is empty the administration is refused.
+var TenantAdminToken string
+
+func TenantsRoute() *restful.WebService {
+	service := new(restful.WebService)
+	service.
+		Path("/tenants").
+		Consumes(restful.MIME_JSON).
+		Produces(restful.MIME_JSON, restful.MIME_XML).
+		Filter(tenantAdministration)
+
+	code := service.PathParameter("code", "Code of tenant, e.g. eastside")
+	service.Route(service.GET("/").
+		To(GetAllTenants).
+		Doc("Retrieve the libraries hosted by the server, by code").
+		Notes("Tenants are served when TENANTS_ENABLED is set; the requests for a library name its code in a header, a subdomain or a token claim.").
+		Writes(ResponseObj{Data: []tenant.Tenant{}}))
+	service.Route(service.GET("/{code}").
+		To(GetTenant).
+		Doc("Retrieve tenant by code").
+		Param(code).
+		Returns(http.StatusOK, "The tenant", ResponseObj{Data: tenant.Tenant{}}).
+		Returns(http.StatusNotFound, "No such tenant", ResponseObj{}))
+	service.Route(service.POST("").
+		To(InsertTenant).
+		Doc("Create a tenant and its database").
+		Notes("The code is lowercase letters, digits and dashes, a DNS label. The database is created with every migration applied.").
+		Reads(tenant.Tenant{}, "Tenant to create").
+		Returns(http.StatusCreated, "The tenant", ResponseObj{Data: tenant.Tenant{}}).
+		Returns(http.StatusBadRequest, "Invalid tenant", ResponseObj{}).
+		Returns(http.StatusConflict, "The code is taken", ResponseObj{}))
+	service.Route(service.DELETE("/{code}").
+		To(DeleteTenant).
+		Doc("Delete tenant by code").
+		Notes("Its database is closed and kept on disk; the code is not given to another tenant.").
+		Param(code).
+		Returns(http.StatusNoContent, "Deleted", nil).
+		Returns(http.StatusNotFound, "No such tenant", ResponseObj{}))
+	return service
+}
+
+// tenantAdministration requires TenantAdminToken as bearer token.
+func tenantAdministration(request *restful.Request, response *restful.Response, chain *restful.FilterChain) {
+	token := tenant.Bearer(request.Request)
+	if TenantAdminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(TenantAdminToken)) != 1 {
+		response.Header().Set("WWW-Authenticate", "Bearer")
+		resourceError(response, http.StatusUnauthorized, errors.New("the tenant administration requires its token"))
+		return
+	}
+	chain.ProcessFilter(request, response)
+}
+
+func GetAllTenants(request *restful.Request, response *restful.Response) {
+	tenants, err := tenant.List(request.Request.Context())
+	if err != nil {
+		resourceError(response, http.StatusInternalServerError, err)
+		return
+	}
+	response.WriteHeaderAndEntity(http.StatusOK, ResponseObj{Data: tenants, StatusCode: http.StatusOK, Item: "tenant"})
+}
+
+func GetTenant(request *restful.Request, response *restful.Response) {
+	row, err := tenant.Get(request.Request.Context(), request.PathParameter("code"))
+	if err != nil {
+		resourceError(response, resourceErrorStatus(err), err)
+		return
+	}
+	response.WriteHeaderAndEntity(http.StatusOK, ResponseObj{Data: row, StatusCode: http.StatusOK, Item: "tenant"})
+}
+
+func InsertTenant(request *restful.Request, response *restful.Response) {
+	input := tenant.Tenant{}
+	if err := request.ReadEntity(&input); err != nil {
+		resourceError(response, http.StatusBadRequest, err)
+		return
+	}
+	row, err := tenant.Default.Create(request.Request.Context(), input)
+	if err != nil {
+		tenantError(response, err)
+		return
+	}
+	response.WriteHeaderAndEntity(http.StatusCreated, ResponseObj{Data: row, StatusCode: http.StatusCreated, Item: "tenant"})
+}
+
+func DeleteTenant(request *restful.Request, response *restful.Response) {
+	if err := tenant.Default.Delete(request.Request.Context(), request.PathParameter("code")); err != nil {
+		tenantError(response, err)
+		return
+	}
+	response.WriteHeader(http.StatusNoContent)
+}
+
+// tenantError answers an invalid tenant with 400 and a taken code with 409,
+// otherwise as resourceErrorStatus.
+func tenantError(response *restful.Response, err error) {
+	switch {
+	case errors.Is(err, tenant.ErrInvalidTenant):
+		resourceError(response, http.StatusBadRequest, err)
+	case errors.Is(err, tenant.ErrTenantExists):
+		resourceError(response, http.StatusConflict, err)
+	default:
+		resourceError(response, resourceErrorStatus(err), err)
+	}
+}
@@ -0,0 +1,133 @@
+package route
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	restful "github.com/emicklei/go-restful/v3"
+
+	"github.com/riszkymf/golang-rest-boilerplate/internal/circulation"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/handler"
+)
+
+func TransfersRoute() *restful.WebService {
+	service := new(restful.WebService)
+	service.
+		Path("/transfers").
+		Consumes(bodyMimes...).
+		Produces(resourceMimes...)
+
+	id := service.PathParameter("transfer-id", "Identifier of transfer").DataType("integer")
+	service.Route(service.GET("/").
+		To(GetAllTransfers).
+		Produces(collectionMimes...).
+		Do(listParams(service)).
+		Doc("Retrieve the transfers of copies between branches").
+		Notes("e.g. filter=to_branch:eq:east&filter=status:eq:in_transit. A transfer is requested, in_transit, received or cancelled.").
+		Writes(ResponseObj{Data: []circulation.Transfer{}}))
+	service.Route(service.GET("/{transfer-id}").
+		To(GetTransfer).
+		Doc("Retrieve transfer by ID").
+		Param(id).
+		Do(readParams(service)).
+		Writes(ResponseObj{Data: circulation.Transfer{}}))
+	service.Route(service.POST("").
+		To(RequestTransfer).
+		Do(branchScoped).
+		Doc("Request a copy to be moved to another branch").
+		Notes("to_branch defaults to the branch of the staff requesting. A copy is moved by one transfer at a time.").
+		Reads(circulation.Transfer{}, "The copy and the branch to move it to").
+		Returns(http.StatusCreated, "The transfer", ResponseObj{Data: circulation.Transfer{}}).
+		Returns(http.StatusBadRequest, "No branch to move to", ResponseObj{}).
+		Returns(http.StatusNotFound, "No such copy or branch", ResponseObj{}).
+		Returns(http.StatusConflict, "Refused", ResponseObj{}))
+	steps := []struct {
+		name      string
+		doc       string
+		operation func(context.Context, int) (map[string]any, error)
+	}{
+		{"ship", "Send the copy of a requested transfer, by the branch it belongs to; the copy must be available", circulation.Default.ShipTransfer},
+		{"receive", "Receive the copy of a transfer in transit, by the branch it was sent to, which it belongs to from then on", circulation.Default.ReceiveTransfer},
+		{"cancel", "Cancel a transfer before its copy is sent, by either branch", circulation.Default.CancelTransfer},
+	}
+	for _, step := range steps {
+		service.Route(service.POST("/{transfer-id}/"+step.name).
+			To(transferOperation(step.operation)).
+			Do(branchScoped).
+			Operation(strings.ToUpper(step.name[:1])+step.name[1:]+"Transfer").
+			Doc(step.doc).
+			Param(id).
+			AllowedMethodsWithoutContentType([]string{http.MethodPost}).
+			Returns(http.StatusOK, "The transfer", ResponseObj{Data: circulation.Transfer{}}).
+			Returns(http.StatusNotFound, "No such transfer", ResponseObj{}).
+			Returns(http.StatusConflict, "Refused", ResponseObj{}))
+	}
+	return service
+}
+
+func GetAllTransfers(request *restful.Request, response *restful.Response) {
+	ctx := request.Request.Context()
+	columns, err := handler.ColumnsContext(ctx, "transfers")
+	if err != nil {
+		resourceError(response, http.StatusInternalServerError, err)
+		return
+	}
+	query, err := listQuery(request, columns)
+	if err != nil {
+		resourceError(response, http.StatusBadRequest, err)
+		return
+	}
+	transfers, err := handler.GetRowsContext(ctx, "transfers", query)
+	if err != nil {
+		resourceError(response, http.StatusInternalServerError, err)
+		return
+	}
+	response.WriteEntity(ResponseObj{Data: transfers, StatusCode: http.StatusOK, Item: "transfer", Columns: columns})
+}
+
+func GetTransfer(request *restful.Request, response *restful.Response) {
+	id, err := strconv.Atoi(request.PathParameter("transfer-id"))
+	if err != nil {
+		resourceError(response, http.StatusBadRequest, errors.New("ID must be numerical"))
+		return
+	}
+	transfer, err := handler.GetRowByIdContext(request.Request.Context(), "transfers", id)
+	if err != nil {
+		resourceError(response, http.StatusInternalServerError, err)
+		return
+	}
+	writeResource(request, response, transfer, "transfer")
+}
+
+func RequestTransfer(request *restful.Request, response *restful.Response) {
+	input := circulation.Transfer{}
+	if err := request.ReadEntity(&input); err != nil {
+		resourceError(response, http.StatusBadRequest, err)
+		return
+	}
+	transfer, err := circulation.Default.RequestTransfer(request.Request.Context(), input)
+	if err != nil {
+		branchError(response, err)
+		return
+	}
+	response.WriteHeaderAndEntity(http.StatusCreated, ResponseObj{Data: transfer, StatusCode: http.StatusCreated, Item: "transfer"})
+}
+
+func transferOperation(operation func(context.Context, int) (map[string]any, error)) restful.RouteFunction {
+	return func(request *restful.Request, response *restful.Response) {
+		id, err := strconv.Atoi(request.PathParameter("transfer-id"))
+		if err != nil {
+			resourceError(response, http.StatusBadRequest, errors.New("ID must be numerical"))
+			return
+		}
+		transfer, err := operation(request.Request.Context(), id)
+		if err != nil {
+			circulationError(response, err)
+			return
+		}
+		response.WriteHeaderAndEntity(http.StatusOK, ResponseObj{Data: transfer, StatusCode: http.StatusOK, Item: "transfer"})
+	}
+}
@@ -0,0 +1,345 @@
+package route
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	restful "github.com/emicklei/go-restful/v3"
+
+	"github.com/riszkymf/golang-rest-boilerplate/internal/events"
+)
+
+const (
+	defaultEventLimit = 100
+	maxEventLimit     = 1000
+)
+
+// WebhookInput is the body of the webhook routes; on update the omitted
+// fields are left unchanged.
+type WebhookInput struct {
+	Url    *string  `json:"url"`
+	Secret string   `json:"secret"`
+	Events []string `json:"events"`
+	Active *bool    `json:"active"`
+}
+
+// PingResult is the answer of a webhook to a test delivery.
+type PingResult struct {
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+func EventsRoute() *restful.WebService {
+	service := new(restful.WebService)
+	service.
+		Path("/events").
+		Produces(restful.MIME_JSON, restful.MIME_XML)
+
+	service.Route(service.GET("/").
+		To(GetEvents).
+		Doc("Retrieve the domain events, oldest first").
+		Notes(fmt.Sprintf("Events: %v. Poll with after set to the id of the last event read.", events.Types)).
+		Param(service.QueryParameter("after", "Only the events following this one").DataType("integer").DefaultValue("0")).
+		Param(service.QueryParameter("limit", fmt.Sprintf("At most this many events, up to %v", maxEventLimit)).DataType("integer").DefaultValue(strconv.Itoa(defaultEventLimit))).
+		Returns(http.StatusOK, "The events", ResponseObj{Data: []events.Event{}}).
+		Returns(http.StatusBadRequest, "Invalid parameter", ResponseObj{}))
+	service.Route(service.GET("/stream").
+		To(StreamEvents).
+		Produces(mimeEventStream, restful.MIME_JSON).
+		Doc("Stream the domain events as Server-Sent Events").
+		Notes("Each event is sent with its id, its type as the event name and itself as JSON data. "+
+			"A reconnecting client resumes after Last-Event-ID; if it missed too many events it gets a reset event instead and should reload. "+
+			"Streams send a heartbeat comment when idle and end before the write timeout of the server, to be resumed.").
+		Param(service.QueryParameter("topics", "Only these topics, comma separated: book, member, rent or event types")).
+		Param(service.HeaderParameter("Last-Event-ID", "Id of the last event received, to resume after")).
+		Param(service.QueryParameter("last_event_id", "Like Last-Event-ID, for clients that cannot set it").DataType("integer")).
+		Returns(http.StatusOK, "The event stream", nil).
+		Returns(http.StatusBadRequest, "Invalid parameter", ResponseObj{}).
+		Returns(http.StatusServiceUnavailable, "The server is stopping", ResponseObj{}))
+	return service
+}
+
+func GetEvents(request *restful.Request, response *restful.Response) {
+	after, err := intParam(request, "after", 0, 0, -1)
+	if err != nil {
+		resourceError(response, http.StatusBadRequest, err)
+		return
+	}
+	limit, err := intParam(request, "limit", defaultEventLimit, 1, maxEventLimit)
+	if err != nil {
+		resourceError(response, http.StatusBadRequest, err)
+		return
+	}
+	list, err := events.List(request.Request.Context(), after, limit)
+	if err != nil {
+		resourceError(response, http.StatusInternalServerError, err)
+		return
+	}
+	response.WriteHeaderAndEntity(http.StatusOK, ResponseObj{Data: list, StatusCode: http.StatusOK, Item: "event"})
+}
+
+// intParam reads the query parameter name, fallback if absent, which must be
+// at least min and, unless max is negative, at most max.
+func intParam(request *restful.Request, name string, fallback int, min int, max int) (int, error) {
+	value := request.QueryParameter(name)
+	if value == "" {
+		return fallback, nil
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < min || (max >= 0 && parsed > max) {
+		if max < 0 {
+			return 0, fmt.Errorf("%v must be an integer of at least %v", name, min)
+		}
+		return 0, fmt.Errorf("%v must be between %v and %v", name, min, max)
+	}
+	return parsed, nil
+}
+
+func WebhooksRoute() *restful.WebService {
+	service := new(restful.WebService)
+	service.
+		Path("/webhooks").
+		Consumes(restful.MIME_JSON).
+		Produces(restful.MIME_JSON, restful.MIME_XML)
+
+	webhookId := service.PathParameter("webhook-id", "Identifier of webhook").DataType("integer")
+	service.Route(service.GET("/").
+		To(GetWebhooks).
+		Doc("Retrieve the webhooks").
+		Writes(ResponseObj{Data: []events.Webhook{}}))
+	service.Route(service.POST("").
+		To(InsertWebhook).
+		Doc("Subscribe a URL to events").
+		Notes(fmt.Sprintf("Events: %v, or * for all. Every delivery is a POST of the event as JSON, signed in %v "+
+			"with the hex HMAC-SHA256 of %v, a dot and the body, keyed with the secret. "+
+			"The secret is generated unless given, and only answered here.", events.Types, events.SignatureHeader, events.TimestampHeader)).
+		Reads(WebhookInput{}, "The webhook; active defaults to true").
+		Returns(http.StatusCreated, "The webhook, with its secret", ResponseObj{Data: events.Webhook{}}).
+		Returns(http.StatusBadRequest, "Invalid webhook", ResponseObj{}))
+	service.Route(service.GET("/{webhook-id}").
+		To(GetWebhook).
+		Doc("Retrieve webhook by ID").
+		Param(webhookId).
+		Returns(http.StatusOK, "The webhook", ResponseObj{Data: events.Webhook{}}).
+		Returns(http.StatusNotFound, "No such webhook", ResponseObj{}))
+	service.Route(service.POST("/{webhook-id}").
+		To(UpdateWebhook).
+		Doc("Update webhook by ID").
+		Notes("Set active to false to pause the deliveries, which resume once it is true again.").
+		Param(webhookId).
+		Reads(WebhookInput{}, "Fields to update, omitted fields are left unchanged").
+		Returns(http.StatusOK, "The webhook", ResponseObj{Data: events.Webhook{}}).
+		Returns(http.StatusBadRequest, "Invalid webhook", ResponseObj{}).
+		Returns(http.StatusNotFound, "No such webhook", ResponseObj{}))
+	service.Route(service.DELETE("/{webhook-id}").
+		To(DeleteWebhook).
+		Doc("Delete webhook by ID, with its deliveries").
+		Param(webhookId).
+		Returns(http.StatusNoContent, "Deleted", nil).
+		Returns(http.StatusNotFound, "No such webhook", ResponseObj{}))
+	service.Route(service.GET("/{webhook-id}/deliveries").
+		To(GetWebhookDeliveries).
+		Doc("Retrieve the deliveries to webhook by ID, newest first").
+		Notes("The dead deliveries, which failed every attempt, are the dead letters of the webhook.").
+		Param(webhookId).
+		Param(service.QueryParameter("status", "Only the deliveries in this status").PossibleValues([]string{
+			events.DeliveryPending, events.DeliveryDelivered, events.DeliveryDead,
+		})).
+		Param(service.QueryParameter("limit", fmt.Sprintf("At most this many deliveries, up to %v", maxEventLimit)).DataType("integer").DefaultValue(strconv.Itoa(defaultEventLimit))).
+		Returns(http.StatusOK, "The deliveries", ResponseObj{Data: []events.Delivery{}}).
+		Returns(http.StatusNotFound, "No such webhook", ResponseObj{}))
+	service.Route(service.POST("/{webhook-id}/deliveries/{delivery-id}/redeliver").
+		To(RedeliverWebhook).
+		Doc("Queue a delivery again, e.g. a dead letter").
+		Param(webhookId).
+		Param(service.PathParameter("delivery-id", "Identifier of delivery").DataType("integer")).
+		AllowedMethodsWithoutContentType([]string{http.MethodPost}).
+		Returns(http.StatusAccepted, "Queued", nil).
+		Returns(http.StatusNotFound, "No such delivery", ResponseObj{}))
+	service.Route(service.POST("/{webhook-id}/ping").
+		To(PingWebhook).
+		Doc("Send a test event to webhook by ID and answer how it responded").
+		Notes("The test event has type webhook.ping and id 0; it is not retried.").
+		Param(webhookId).
+		AllowedMethodsWithoutContentType([]string{http.MethodPost}).
+		Returns(http.StatusOK, "The response of the webhook", ResponseObj{Data: PingResult{}}).
+		Returns(http.StatusNotFound, "No such webhook", ResponseObj{}))
+	return service
+}
+
+// webhookParam reads the webhook of the request, answering the failure
+// itself if there is none.
+func webhookParam(request *restful.Request, response *restful.Response) (events.Webhook, bool) {
+	id, err := strconv.Atoi(request.PathParameter("webhook-id"))
+	if err != nil {
+		resourceError(response, http.StatusBadRequest, errors.New("ID must be numerical"))
+		return events.Webhook{}, false
+	}
+	webhook, found, err := events.GetWebhook(request.Request.Context(), id)
+	if err != nil {
+		resourceError(response, http.StatusInternalServerError, err)
+		return events.Webhook{}, false
+	}
+	if !found {
+		resourceError(response, http.StatusNotFound, errors.New("webhook not found"))
+		return events.Webhook{}, false
+	}
+	return webhook, true
+}
+
+func GetWebhooks(request *restful.Request, response *restful.Response) {
+	list, err := events.ListWebhooks(request.Request.Context(), true)
+	if err != nil {
+		resourceError(response, http.StatusInternalServerError, err)
+		return
+	}
+	for i := range list {
+		list[i].Secret = ""
+	}
+	response.WriteHeaderAndEntity(http.StatusOK, ResponseObj{Data: list, StatusCode: http.StatusOK, Item: "webhook"})
+}
+
+func InsertWebhook(request *restful.Request, response *restful.Response) {
+	input := WebhookInput{}
+	if err := request.ReadEntity(&input); err != nil {
+		resourceError(response, http.StatusBadRequest, err)
+		return
+	}
+	webhook := events.Webhook{Secret: input.Secret, Events: input.Events, Active: true}
+	if input.Url != nil {
+		webhook.Url = *input.Url
+	}
+	if input.Active != nil {
+		webhook.Active = *input.Active
+	}
+	webhook, err := events.CreateWebhook(request.Request.Context(), webhook)
+	if err != nil {
+		resourceError(response, webhookErrorStatus(err), err)
+		return
+	}
+	response.WriteHeaderAndEntity(http.StatusCreated, ResponseObj{Data: webhook, StatusCode: http.StatusCreated, Item: "webhook"})
+}
+
+func GetWebhook(request *restful.Request, response *restful.Response) {
+	webhook, ok := webhookParam(request, response)
+	if !ok {
+		return
+	}
+	webhook.Secret = ""
+	response.WriteHeaderAndEntity(http.StatusOK, ResponseObj{Data: webhook, StatusCode: http.StatusOK, Item: "webhook"})
+}
+
+func UpdateWebhook(request *restful.Request, response *restful.Response) {
+	webhook, ok := webhookParam(request, response)
+	if !ok {
+		return
+	}
+	input := WebhookInput{}
+	if err := request.ReadEntity(&input); err != nil {
+		resourceError(response, http.StatusBadRequest, err)
+		return
+	}
+	if input.Url != nil {
+		webhook.Url = *input.Url
+	}
+	if input.Events != nil {
+		webhook.Events = input.Events
+	}
+	if input.Active != nil {
+		webhook.Active = *input.Active
+	}
+	webhook.Secret = input.Secret
+	if err := events.UpdateWebhook(request.Request.Context(), webhook); err != nil {
+		resourceError(response, webhookErrorStatus(err), err)
+		return
+	}
+	webhook.Secret = ""
+	response.WriteHeaderAndEntity(http.StatusOK, ResponseObj{Data: webhook, StatusCode: http.StatusOK, Item: "webhook"})
+}
+
+func DeleteWebhook(request *restful.Request, response *restful.Response) {
+	webhook, ok := webhookParam(request, response)
+	if !ok {
+		return
+	}
+	if err := events.DeleteWebhook(request.Request.Context(), webhook.Id); err != nil {
+		resourceError(response, http.StatusInternalServerError, err)
+		return
+	}
+	response.WriteHeader(http.StatusNoContent)
+}
+
+func GetWebhookDeliveries(request *restful.Request, response *restful.Response) {
+	webhook, ok := webhookParam(request, response)
+	if !ok {
+		return
+	}
+	status := request.QueryParameter("status")
+	switch status {
+	case "", events.DeliveryPending, events.DeliveryDelivered, events.DeliveryDead:
+	default:
+		resourceError(response, http.StatusBadRequest, fmt.Errorf("unknown status %q", status))
+		return
+	}
+	limit, err := intParam(request, "limit", defaultEventLimit, 1, maxEventLimit)
+	if err != nil {
+		resourceError(response, http.StatusBadRequest, err)
+		return
+	}
+	list, err := events.ListDeliveries(request.Request.Context(), webhook.Id, status, limit)
+	if err != nil {
+		resourceError(response, http.StatusInternalServerError, err)
+		return
+	}
+	response.WriteHeaderAndEntity(http.StatusOK, ResponseObj{Data: list, StatusCode: http.StatusOK, Item: "delivery"})
+}
+
+func RedeliverWebhook(request *restful.Request, response *restful.Response) {
+	webhook, ok := webhookParam(request, response)
+	if !ok {
+		return
+	}
+	id, err := strconv.Atoi(request.PathParameter("delivery-id"))
+	if err != nil {
+		resourceError(response, http.StatusBadRequest, errors.New("ID must be numerical"))
+		return
+	}
+	if err := events.Redeliver(request.Request.Context(), webhook.Id, id); err != nil {
+		resourceError(response, resourceErrorStatus(err), err)
+		return
+	}
+	response.WriteHeader(http.StatusAccepted)
+}
+
+func PingWebhook(request *restful.Request, response *restful.Response) {
+	webhook, ok := webhookParam(request, response)
+	if !ok {
+		return
+	}
+	ping := events.Event{
+		Type:      "webhook.ping",
+		Table:     "webhooks",
+		RowId:     webhook.Id,
+		Data:      map[string]any{"url": webhook.Url},
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	result := PingResult{}
+	status, err := events.Default.Send(request.Request.Context(), webhook, ping, 0)
+	result.Status = status
+	if err != nil {
+		result.Error = err.Error()
+	}
+	response.WriteHeaderAndEntity(http.StatusOK, ResponseObj{Data: result, StatusCode: http.StatusOK, Item: "ping"})
+}
+
+// webhookErrorStatus tells the invalid webhooks from the failures to store
+// them.
+func webhookErrorStatus(err error) int {
+	if errors.Is(err, events.ErrInvalidWebhook) {
+		return http.StatusBadRequest
+	}
+	return resourceErrorStatus(err)
+}
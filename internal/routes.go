@@ -1,28 +1,84 @@
 package routes
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	uuid "github.com/google/uuid"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/circulation"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/handler"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/idempotency"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/metrics"
 	route "github.com/riszkymf/golang-rest-boilerplate/internal/route"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/tenant"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/tracing"
 
 	restful "github.com/emicklei/go-restful/v3"
 	utils "github.com/riszkymf/golang-rest-boilerplate/internal/src"
 )
 
+var httpRequestsTotal = metrics.NewCounterVec(
+	"http_requests_total",
+	"Number of HTTP requests by method, route template and status.",
+	"method", "route", "status",
+)
+
+var httpRequestDuration = metrics.NewHistogramVec(
+	"http_request_duration_seconds",
+	"Latency of HTTP requests by method, route template and status.",
+	nil,
+	"method", "route", "status",
+)
+
 type RouteFilterConfig struct {
-	WebServiceLogging string
-	Auth              string
+	WebServiceLogging bool
+	Auth              bool
+	RequireIfMatch    bool
+	PrincipalHeader   string
+	PrincipalKey      string
+	PrincipalClaim    string
+	StreamHeartbeat   time.Duration
+	StreamDuration    time.Duration
+	Tenants           bool
+	TenantResolver    tenant.Resolver
+	TenantAdminToken  string
 }
 
-func SetRoutes(routeContainer *restful.Container) *restful.Container {
+// SetRoutes adds the routes to routeContainer; /tenants only when config
+// enables tenants.
+func SetRoutes(routeContainer *restful.Container, config RouteFilterConfig) *restful.Container {
 	// Setting routes for restful endpoint, imported from route package.
+	route.RegisterEntityAccessors()
 
 	routeContainer.Add(route.HealthRoute())
 	routeContainer.Add(route.BooksRoute())
+	routeContainer.Add(route.CopiesRoute())
+	routeContainer.Add(route.BranchesRoute())
+	routeContainer.Add(route.TransfersRoute())
 	routeContainer.Add(route.AuthorRoute())
 	routeContainer.Add(route.MembersRoute())
+	routeContainer.Add(route.CategoriesRoute())
 	routeContainer.Add(route.RecordsRoute())
 	routeContainer.Add(route.RentRoute())
+	routeContainer.Add(route.JobsRoute())
+	routeContainer.Add(route.ExportRoute())
+	routeContainer.Add(route.AuditRoute())
+	routeContainer.Add(route.EventsRoute())
+	routeContainer.Add(route.WebhooksRoute())
+	routeContainer.Add(route.AdminRoute())
+	if config.Tenants {
+		routeContainer.Add(route.TenantsRoute())
+	}
+	routeContainer.Add(route.MetricsRoute(config.Tenants))
+	routeContainer.Add(route.DocsRoute())
+	// Added last, the document covers every WebService above.
+	routeContainer.Add(route.OpenAPIRoute(routeContainer))
 	return routeContainer
 
 }
@@ -31,13 +87,46 @@ func SetFilters(routeContainer *restful.Container, config RouteFilterConfig) *re
 	/*
 		Config Model:
 		type RouteFilterConfig struct{
-			webServiceLogging string
-			Authorization	  string
-			Authentication    string
+			WebServiceLogging bool
+			Auth              bool
+			RequireIfMatch    bool
+			PrincipalHeader   string
+			PrincipalKey      string
+			PrincipalClaim    string
+			StreamHeartbeat   time.Duration
+			StreamDuration    time.Duration
+			Tenants           bool
+			TenantResolver    tenant.Resolver
+			TenantAdminToken  string
 		}
 	*/
 
-	if config.WebServiceLogging == "TRUE" {
+	route.RequireIfMatch = config.RequireIfMatch
+	if config.StreamHeartbeat > 0 {
+		route.StreamHeartbeat = config.StreamHeartbeat
+	}
+	route.StreamDuration = config.StreamDuration
+	route.TenantAdminToken = config.TenantAdminToken
+
+	routeContainer.Filter(webserviceRequestId)
+	// Before the tenant filter, for its rejections to be traced and counted.
+	routeContainer.Filter(webserviceTracing)
+	routeContainer.Filter(webserviceMetrics)
+	if config.Tenants {
+		// Before every filter querying the database.
+		routeContainer.Filter(webserviceTenant(config.TenantResolver))
+	}
+	if config.PrincipalHeader != "" || config.PrincipalKey != "" {
+		routeContainer.Filter(webservicePrincipal(config.PrincipalHeader, config.PrincipalKey, config.PrincipalClaim))
+	}
+	if config.PrincipalKey != "" {
+		// Only a principal a token vouches for is scoped to a branch.
+		routeContainer.Filter(webserviceStaffBranch)
+	}
+	routeContainer.Filter(webserviceIdempotency)
+	routeContainer.Filter(webserviceIncludeDeleted)
+
+	if config.WebServiceLogging {
 		utils.LogInfo("[webservice-init]", "initalizing filter", "adding logging to filters")
 		routeContainer.Filter(webserviceLogging)
 	}
@@ -47,6 +136,250 @@ func SetFilters(routeContainer *restful.Container, config RouteFilterConfig) *re
 
 func webserviceLogging(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
 	logMsg := fmt.Sprintf("%s,%s,%s\n", req.Request.Method, req.Request.URL, req.Request.RemoteAddr)
-	utils.LogInfo("[webservice-logging] ", "log", logMsg)
+	utils.LogInfoContext(req.Request.Context(), "[webservice-logging] ", "log", logMsg)
+	chain.ProcessFilter(req, resp)
+}
+
+func webserviceMetrics(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
+	start := time.Now()
 	chain.ProcessFilter(req, resp)
+	// Label by route template rather than raw URL to keep cardinality bounded.
+	routePath := req.SelectedRoutePath()
+	if routePath == "" {
+		routePath = "unmatched"
+	}
+	status := strconv.Itoa(resp.StatusCode())
+	httpRequestsTotal.Inc(req.Request.Method, routePath, status)
+	httpRequestDuration.Observe(time.Since(start).Seconds(), req.Request.Method, routePath, status)
+}
+
+const requestIdHeader = "X-Request-ID"
+
+func webserviceRequestId(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
+	requestId := req.HeaderParameter(requestIdHeader)
+	if requestId == "" {
+		requestId = uuid.NewString()
+	}
+	resp.Header().Set(requestIdHeader, requestId)
+	req.Request = req.Request.WithContext(utils.ContextWithRequestId(req.Request.Context(), requestId))
+	chain.ProcessFilter(req, resp)
+}
+
+// tenantExempt are the paths served without a tenant, which query no
+// library: the tenant administration, health, metrics and documentation.
+var tenantExempt = []string{"/tenants", "/health", "/metrics", "/docs", "/openapi.json"}
+
+// webserviceTenant routes every other request to the database of the tenant
+// resolver finds for it, which it must name.
+func webserviceTenant(resolver tenant.Resolver) restful.FilterFunction {
+	return func(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
+		path := req.Request.URL.Path
+		for _, exempt := range tenantExempt {
+			if path == exempt || strings.HasPrefix(path, exempt+"/") {
+				chain.ProcessFilter(req, resp)
+				return
+			}
+		}
+		reject := func(status int, err error) {
+			resp.WriteHeaderAndEntity(status, route.ResponseObj{Errors: []string{err.Error()}, StatusCode: status})
+		}
+		code, err := resolver.Resolve(req.Request)
+		switch {
+		case errors.Is(err, tenant.ErrInvalidToken):
+			resp.Header().Set("WWW-Authenticate", "Bearer")
+			reject(http.StatusUnauthorized, err)
+			return
+		case err != nil:
+			reject(http.StatusBadRequest, err)
+			return
+		case code == "":
+			reject(http.StatusBadRequest, errors.New("the request names no tenant"))
+			return
+		}
+		ctx, release, err := tenant.Default.Context(req.Request.Context(), code)
+		switch {
+		case errors.Is(err, handler.ErrNotFound):
+			reject(http.StatusNotFound, err)
+			return
+		case errors.Is(err, tenant.ErrInvalidTenant):
+			reject(http.StatusBadRequest, err)
+			return
+		case err != nil:
+			utils.CheckErrorContext(req.Request.Context(), err, "[webservice-tenant]", "open tenant")
+			reject(http.StatusServiceUnavailable, err)
+			return
+		}
+		// The database of the tenant is closed by its deletion only once the
+		// request is done with it.
+		defer release()
+		req.Request = req.Request.WithContext(ctx)
+		chain.ProcessFilter(req, resp)
+	}
+}
+
+// maxPrincipalLength bounds the principal recorded in the audit log.
+const maxPrincipalLength = 255
+
+// webservicePrincipal records who makes the request, for the audit log and the
+// branch of staff. With key, the principal is the claim of a bearer token
+// signed with it and header is ignored, a token failing verification is
+// refused; without, it is whatever header names.
+func webservicePrincipal(header, key, claim string) restful.FilterFunction {
+	return func(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
+		var principal string
+		if key == "" {
+			principal = req.HeaderParameter(header)
+		} else if token := tenant.Bearer(req.Request); token != "" {
+			claims, err := tenant.VerifyToken(token, key, time.Now())
+			if err != nil {
+				resp.Header().Set("WWW-Authenticate", "Bearer")
+				resp.WriteHeaderAndEntity(http.StatusUnauthorized, route.ResponseObj{Errors: []string{err.Error()}, StatusCode: http.StatusUnauthorized})
+				return
+			}
+			principal, _ = claims[claim].(string)
+		}
+		if len(principal) > maxPrincipalLength {
+			principal = principal[:maxPrincipalLength]
+		}
+		if principal != "" {
+			req.Request = req.Request.WithContext(utils.ContextWithPrincipal(req.Request.Context(), principal))
+		}
+		chain.ProcessFilter(req, resp)
+	}
+}
+
+// webserviceStaffBranch scopes the requests of staff to the branch they work
+// at. It runs only when principals are authenticated, so the branch-scoped
+// routes are refused to requests naming none rather than left unscoped.
+func webserviceStaffBranch(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
+	ctx := req.Request.Context()
+	principal := utils.PrincipalFromContext(ctx)
+	if principal == "" {
+		if selected := req.SelectedRoute(); selected != nil && selected.Metadata()[route.BranchScoped] == true {
+			resp.Header().Set("WWW-Authenticate", "Bearer")
+			resp.WriteHeaderAndEntity(http.StatusUnauthorized, route.ResponseObj{Errors: []string{"the request names no authenticated principal"}, StatusCode: http.StatusUnauthorized})
+			return
+		}
+		chain.ProcessFilter(req, resp)
+		return
+	}
+	branch, err := circulation.StaffBranch(ctx, principal)
+	if err != nil {
+		resp.WriteHeaderAndEntity(http.StatusInternalServerError, route.ResponseObj{Errors: []string{err.Error()}, StatusCode: http.StatusInternalServerError})
+		return
+	}
+	if branch != "" {
+		req.Request = req.Request.WithContext(utils.ContextWithBranch(ctx, branch))
+	}
+	chain.ProcessFilter(req, resp)
+}
+
+func webserviceTracing(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
+	routePath := req.SelectedRoutePath()
+	if routePath == "" {
+		routePath = "unmatched"
+	}
+	ctx := tracing.Extract(req.Request.Context(), req.Request.Header)
+	ctx, span := tracing.StartSpan(ctx, fmt.Sprintf("%v %v", req.Request.Method, routePath), tracing.SpanKindServer)
+	defer span.Finish()
+	span.SetAttribute("http.method", req.Request.Method)
+	span.SetAttribute("http.route", routePath)
+	span.SetAttribute("http.target", req.Request.URL.RequestURI())
+	span.SetAttribute("request.id", utils.RequestIdFromContext(ctx))
+	resp.Header().Set(tracing.TraceparentHeader, tracing.FormatTraceparent(span.Context))
+	req.Request = req.Request.WithContext(ctx)
+
+	chain.ProcessFilter(req, resp)
+
+	span.SetAttribute("http.status_code", resp.StatusCode())
+	if resp.StatusCode() >= 500 {
+		span.SetStatus(tracing.StatusError, http.StatusText(resp.StatusCode()))
+	}
+}
+
+// webserviceIncludeDeleted lets a GET with ?include_deleted=true see the
+// soft-deleted rows.
+func webserviceIncludeDeleted(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
+	value := req.QueryParameter("include_deleted")
+	if value == "" || req.Request.Method != http.MethodGet {
+		chain.ProcessFilter(req, resp)
+		return
+	}
+	include, err := strconv.ParseBool(value)
+	if err != nil {
+		message := fmt.Sprintf("include_deleted must be true or false, not %q", value)
+		resp.WriteHeaderAndEntity(http.StatusBadRequest, route.ResponseObj{Errors: []string{message}, StatusCode: http.StatusBadRequest})
+		return
+	}
+	if include {
+		req.Request = req.Request.WithContext(handler.WithDeleted(req.Request.Context()))
+	}
+	chain.ProcessFilter(req, resp)
+}
+
+// webserviceIdempotency replays the stored response of a POST sent again with
+// the same Idempotency-Key. Server errors are not stored, so that they can be
+// retried.
+func webserviceIdempotency(req *restful.Request, resp *restful.Response, chain *restful.FilterChain) {
+	key := req.HeaderParameter(idempotency.Header)
+	if key == "" || req.Request.Method != http.MethodPost {
+		chain.ProcessFilter(req, resp)
+		return
+	}
+	reject := func(status int, message string) {
+		resp.WriteHeaderAndEntity(status, route.ResponseObj{Errors: []string{message}, StatusCode: status})
+	}
+	if len(key) > idempotency.MaxKeyLength {
+		reject(http.StatusBadRequest, fmt.Sprintf("%v must be at most %v characters", idempotency.Header, idempotency.MaxKeyLength))
+		return
+	}
+	body, err := io.ReadAll(req.Request.Body)
+	if err != nil {
+		reject(http.StatusBadRequest, err.Error())
+		return
+	}
+	req.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+	ctx := req.Request.Context()
+	hash := idempotency.Hash(req.Request, body)
+	entry, claimed, err := idempotency.Default.Begin(ctx, key, hash)
+	switch {
+	case err != nil:
+		utils.CheckErrorContext(ctx, err, "[webservice-idempotency]", "claim key")
+		reject(http.StatusInternalServerError, err.Error())
+		return
+	case claimed:
+	case entry.RequestHash != hash:
+		reject(http.StatusUnprocessableEntity, fmt.Sprintf("%v was already used for a different request", idempotency.Header))
+		return
+	case entry.Pending():
+		reject(http.StatusConflict, fmt.Sprintf("a request with this %v is still being processed", idempotency.Header))
+		return
+	default:
+		resp.Header().Set(restful.HEADER_ContentType, entry.ContentType)
+		resp.Header().Set(idempotency.ReplayedHeader, "true")
+		resp.WriteHeader(entry.Status)
+		resp.Write([]byte(entry.Body))
+		return
+	}
+
+	// The outcome is stored even if the client went away meanwhile.
+	storeCtx := utils.ContextWithRequestId(handler.Detach(ctx), utils.RequestIdFromContext(ctx))
+	recorder := idempotency.NewRecorder(resp.ResponseWriter)
+	resp.ResponseWriter = recorder
+	stored := false
+	defer func() {
+		resp.ResponseWriter = recorder.ResponseWriter
+		if !stored {
+			utils.CheckErrorContext(ctx, idempotency.Default.Release(storeCtx, entry), "[webservice-idempotency]", "release key")
+		}
+	}()
+
+	chain.ProcessFilter(req, resp)
+
+	if resp.StatusCode() < 500 {
+		err = idempotency.Default.Complete(storeCtx, entry, resp.StatusCode(), resp.Header().Get(restful.HEADER_ContentType), recorder.Body())
+		utils.CheckErrorContext(ctx, err, "[webservice-idempotency]", "store response")
+		stored = err == nil
+	}
 }
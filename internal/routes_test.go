@@ -0,0 +1,265 @@
+package routes
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	restful "github.com/emicklei/go-restful/v3"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/circulation"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/fines"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/handler"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/jobs"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/openapi"
+	utils "github.com/riszkymf/golang-rest-boilerplate/internal/src"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/testdb"
+)
+
+// token signs claims with secret as an HS256 JSON Web Token.
+func token(secret string, claims string) string {
+	encode := base64.RawURLEncoding.EncodeToString
+	unsigned := encode([]byte(`{"alg":"HS256","typ":"JWT"}`)) + "." + encode([]byte(claims))
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(unsigned))
+	return unsigned + "." + encode(mac.Sum(nil))
+}
+
+func TestRoutesDocumented(t *testing.T) {
+	container := SetRoutes(restful.NewContainer(), RouteFilterConfig{Tenants: true})
+	operations := map[string]string{}
+	for _, entry := range openapi.Routes(container) {
+		route := entry.Route
+		name := route.Method + " " + route.Path
+		if missing := openapi.Undocumented(route); len(missing) > 0 {
+			t.Errorf("%v is missing documentation: %v", name, strings.Join(missing, ", "))
+		}
+		if other, exist := operations[route.Operation]; exist {
+			t.Errorf("%v and %v share the operation id %v", other, name, route.Operation)
+		}
+		operations[route.Operation] = name
+	}
+}
+
+func TestOpenAPIDocument(t *testing.T) {
+	container := SetRoutes(restful.NewContainer(), RouteFilterConfig{Tenants: true})
+	recorder := httptest.NewRecorder()
+	container.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("GET /openapi.json returned %v", recorder.Code)
+	}
+
+	var doc openapi.Document
+	if err := json.Unmarshal(recorder.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("invalid document: %v", err)
+	}
+	for _, model := range []string{"Book", "Author", "Members", "Records", "RentData", "ResponseObj"} {
+		if doc.Components.Schemas[model] == nil {
+			t.Errorf("schema %v missing from components", model)
+		}
+	}
+	getBook := (*doc.Paths["/books/{book-id}"])["get"]
+	if getBook == nil || len(getBook.Parameters) != 3 || getBook.Parameters[0].In != "path" {
+		t.Fatalf("GET /books/{book-id} not documented with its path parameter: %+v", getBook)
+	}
+	if getBook.Parameters[1].Name != "If-None-Match" || getBook.Parameters[1].In != "header" {
+		t.Errorf("GET /books/{book-id} should document If-None-Match: %+v", getBook.Parameters[1])
+	}
+	if _, exist := getBook.Responses["304"]; !exist {
+		t.Errorf("GET /books/{book-id} should document 304: %+v", getBook.Responses)
+	}
+	if (*doc.Paths["/books/{book-id}"])["delete"] == nil || doc.Paths["/books/{book-id}/restore"] == nil {
+		t.Errorf("books should be deleted and restored by ID")
+	}
+	insertBook := (*doc.Paths["/books"])["post"]
+	if insertBook == nil || insertBook.RequestBody.Content[restful.MIME_JSON].Schema.Ref != "#/components/schemas/Book" {
+		t.Errorf("POST /books should read a Book: %+v", insertBook)
+	}
+
+	recorder = httptest.NewRecorder()
+	container.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/docs/", nil))
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), "swagger-ui") {
+		t.Errorf("GET /docs/ should serve the Swagger UI, got %v", recorder.Code)
+	}
+}
+
+func TestPrincipal(t *testing.T) {
+	serve := func(key string, headers map[string]string) (int, string) {
+		ws := new(restful.WebService).Produces(restful.MIME_JSON)
+		ws.Route(ws.GET("/whoami").To(func(request *restful.Request, response *restful.Response) {
+			response.Write([]byte(utils.PrincipalFromContext(request.Request.Context())))
+		}))
+		container := restful.NewContainer()
+		container.Add(ws)
+		container.Filter(webservicePrincipal("X-Principal", key, "sub"))
+		request := httptest.NewRequest(http.MethodGet, "/whoami", nil)
+		for key, value := range headers {
+			request.Header.Set(key, value)
+		}
+		recorder := httptest.NewRecorder()
+		container.ServeHTTP(recorder, request)
+		return recorder.Code, recorder.Body.String()
+	}
+
+	tests := []struct {
+		name    string
+		key     string
+		headers map[string]string
+		status  int
+		want    string
+	}{
+		{"header", "", map[string]string{"X-Principal": "eve"}, http.StatusOK, "eve"},
+		{"token without key", "", map[string]string{"Authorization": "Bearer " + token("secret", `{"sub":"ada"}`)}, http.StatusOK, ""},
+		{"token", "secret", map[string]string{"Authorization": "Bearer " + token("secret", `{"sub":"ada"}`)}, http.StatusOK, "ada"},
+		{"header with key", "secret", map[string]string{"X-Principal": "eve"}, http.StatusOK, ""},
+		{"header against token", "secret", map[string]string{"X-Principal": "eve", "Authorization": "Bearer " + token("secret", `{"sub":"ada"}`)}, http.StatusOK, "ada"},
+		{"forged token", "secret", map[string]string{"Authorization": "Bearer " + token("guess", `{"sub":"eve"}`)}, http.StatusUnauthorized, ""},
+	}
+	for _, test := range tests {
+		status, body := serve(test.key, test.headers)
+		if status != test.status || (status == http.StatusOK && body != test.want) {
+			t.Errorf("%v: got %v %q, want %v %q", test.name, status, body, test.status, test.want)
+		}
+	}
+}
+
+func TestStaffScope(t *testing.T) {
+	handler.Connection = testdb.Open(t)
+	ctx := context.Background()
+	desk := circulation.NewDesk(circulation.Policy{LoanDays: 14, RenewalDays: 14}, fines.NewLedger(fines.Policy{MaxBalance: 1000}))
+	author, _ := handler.InsertData("author", map[string]any{"name": "Herman Melville"})
+	book, _ := handler.InsertData("books", map[string]any{"title": "Moby Dick", "author_id": author})
+	member, _ := handler.InsertData("members", map[string]any{"firstname": "Ishmael", "lastname": "Sailor"})
+	for _, branch := range []string{"east", "west"} {
+		if _, err := desk.AddBranch(ctx, circulation.Branch{Code: branch, Name: branch}); err != nil {
+			t.Fatalf(`Error: %v`, err)
+		}
+		for i := 0; i < 2; i++ {
+			if _, err := desk.AddCopy(ctx, circulation.Copy{BookId: book, Branch: branch}); err != nil {
+				t.Fatalf(`Error: %v`, err)
+			}
+		}
+		if _, err := desk.Checkout(utils.ContextWithBranch(ctx, branch), book, member); err != nil {
+			t.Fatalf(`Error: %v`, err)
+		}
+	}
+	if err := desk.AssignStaff(ctx, circulation.Staff{Principal: "eve", Branch: "east"}); err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+
+	config := RouteFilterConfig{PrincipalHeader: "X-Principal", PrincipalKey: "secret", PrincipalClaim: "sub"}
+	container := SetFilters(SetRoutes(restful.NewContainer(), config), config)
+	// serve answers the status and the branches of the rows listed or
+	// exported by GET path.
+	serve := func(path string, headers map[string]string) (int, []string) {
+		request := httptest.NewRequest(http.MethodGet, path, nil)
+		request.Header.Set("Accept", restful.MIME_JSON)
+		for key, value := range headers {
+			request.Header.Set(key, value)
+		}
+		recorder := httptest.NewRecorder()
+		container.ServeHTTP(recorder, request)
+		var rows []map[string]any
+		if strings.HasPrefix(path, "/export/") {
+			scanner := bufio.NewScanner(recorder.Body)
+			for scanner.Scan() {
+				row := map[string]any{}
+				json.Unmarshal(scanner.Bytes(), &row)
+				rows = append(rows, row)
+			}
+		} else {
+			var body struct{ Data []map[string]any }
+			json.Unmarshal(recorder.Body.Bytes(), &body)
+			rows = body.Data
+		}
+		var branches []string
+		for _, row := range rows {
+			branches = append(branches, fmt.Sprint(row["branch"]))
+		}
+		return recorder.Code, branches
+	}
+
+	eve := map[string]string{"Authorization": "Bearer " + token("secret", `{"sub":"eve"}`)}
+	claimed := map[string]string{"X-Principal": "eve"}
+	for _, path := range []string{"/copies/", "/records/", "/export/copies?format=ndjson", "/export/records?format=ndjson"} {
+		west := path + "?branch=west"
+		if strings.Contains(path, "?") {
+			west = path + "&branch=west"
+		}
+		// Naming a principal by header neither scopes nor lets the request in.
+		for _, path := range []string{path, west} {
+			if status, _ := serve(path, claimed); status != http.StatusUnauthorized {
+				t.Errorf("GET %v without a token should be refused, got %v", path, status)
+			}
+		}
+		if status, _ := serve(west, eve); status != http.StatusForbidden {
+			t.Errorf("GET %v by staff of east should be forbidden, got %v", west, status)
+		}
+		status, branches := serve(path, eve)
+		if status != http.StatusOK || len(branches) == 0 {
+			t.Errorf("GET %v by staff of east should list east, got %v %v", path, status, branches)
+		}
+		for _, branch := range branches {
+			if branch != "east" {
+				t.Errorf("GET %v by staff of east listed a row of %v", path, branch)
+			}
+		}
+	}
+}
+
+func TestImportAudited(t *testing.T) {
+	handler.Connection = testdb.Open(t)
+	config := RouteFilterConfig{PrincipalHeader: "X-Principal"}
+	container := SetFilters(SetRoutes(restful.NewContainer(), config), config)
+	body := "firstname,lastname,email\nIshmael,Sailor,ishmael@example.com\n"
+	request := httptest.NewRequest(http.MethodPost, "/members/import?async=true", strings.NewReader(body))
+	request.Header.Set("Content-Type", "text/csv")
+	request.Header.Set("Accept", restful.MIME_JSON)
+	request.Header.Set("X-Principal", "alice")
+	recorder := httptest.NewRecorder()
+	container.ServeHTTP(recorder, request)
+	if recorder.Code != http.StatusAccepted {
+		t.Fatalf("async import should be started, got %v %v", recorder.Code, recorder.Body)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := jobs.Default.Wait(ctx); err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+
+	entries, err := handler.GetRowsContext(context.Background(), handler.AuditTable, handler.ListQuery{
+		Filter: handler.FilterQuery{And: map[string][]handler.FieldFilter{
+			"table_name": {{Operator: "eq", Value: "members", ValueType: "string"}},
+		}},
+	})
+	if err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	if len(entries) != 1 || entries[0]["principal"] != "alice" {
+		t.Errorf("imported member should be audited as made by alice, got %v", entries)
+	}
+}
+
+func TestListQueryRefused(t *testing.T) {
+	handler.Connection = testdb.Open(t)
+	container := SetRoutes(restful.NewContainer(), RouteFilterConfig{})
+	for _, path := range []string{"/author/", "/books/", "/members/", "/records/", "/rent/", "/copies/", "/transfers/"} {
+		request := httptest.NewRequest(http.MethodGet, path+"?sort=nope", nil)
+		request.Header.Set("Accept", restful.MIME_JSON)
+		recorder := httptest.NewRecorder()
+		container.ServeHTTP(recorder, request)
+		var body struct{ Status int }
+		json.Unmarshal(recorder.Body.Bytes(), &body)
+		if recorder.Code != http.StatusBadRequest || body.Status != http.StatusBadRequest {
+			t.Errorf("GET %v sorted on an unknown column should answer 400, got %v %v", path, recorder.Code, body.Status)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	utils "github.com/riszkymf/golang-rest-boilerplate/internal/src"
+)
+
+type Config struct {
+	Host              string
+	Port              string
+	UnixSocket        string
+	ReadTimeout       time.Duration
+	ReadHeaderTimeout time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+	MaxHeaderBytes    int
+	TLSCertFile       string
+	TLSKeyFile        string
+	ShutdownTimeout   time.Duration
+}
+
+func (c Config) Address() string {
+	if c.UnixSocket != "" {
+		return "unix:" + c.UnixSocket
+	}
+	return net.JoinHostPort(c.Host, c.Port)
+}
+
+func (c Config) TLSEnabled() bool {
+	return c.TLSCertFile != "" && c.TLSKeyFile != ""
+}
+
+type Server struct {
+	config Config
+	http   *http.Server
+	certs  *certReloader
+}
+
+func New(config Config, handler http.Handler) (*Server, error) {
+	if (config.TLSCertFile == "") != (config.TLSKeyFile == "") {
+		return nil, errors.New("both TLS certificate and key must be set to enable TLS")
+	}
+	srv := &Server{
+		config: config,
+		http: &http.Server{
+			Handler:           handler,
+			ReadTimeout:       config.ReadTimeout,
+			ReadHeaderTimeout: config.ReadHeaderTimeout,
+			WriteTimeout:      config.WriteTimeout,
+			IdleTimeout:       config.IdleTimeout,
+			MaxHeaderBytes:    config.MaxHeaderBytes,
+		},
+	}
+	if config.TLSEnabled() {
+		certs, err := newCertReloader(config.TLSCertFile, config.TLSKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		srv.certs = certs
+		srv.http.TLSConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: certs.GetCertificate,
+		}
+	}
+	return srv, nil
+}
+
+func (s *Server) listen() (net.Listener, error) {
+	if s.config.UnixSocket == "" {
+		return net.Listen("tcp", s.config.Address())
+	}
+	// A socket left behind by a previous run would make Listen fail.
+	if info, err := os.Stat(s.config.UnixSocket); err == nil && info.Mode()&os.ModeSocket != 0 {
+		os.Remove(s.config.UnixSocket)
+	}
+	return net.Listen("unix", s.config.UnixSocket)
+}
+
+// Run serves until ctx is cancelled, then stops accepting connections and
+// waits up to ShutdownTimeout for in-flight requests before closing them.
+func (s *Server) Run(ctx context.Context) error {
+	listener, err := s.listen()
+	if err != nil {
+		return err
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		if s.certs != nil {
+			serveErr <- s.http.ServeTLS(listener, "", "")
+		} else {
+			serveErr <- s.http.Serve(listener)
+		}
+	}()
+	utils.LogInfo("[server]", "start", fmt.Sprintf("Running App on %v (tls: %v)", s.config.Address(), s.certs != nil))
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	utils.LogInfo("[server]", "shutdown", fmt.Sprintf("draining requests for up to %v", s.config.ShutdownTimeout))
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.config.ShutdownTimeout)
+	defer cancel()
+	err = s.http.Shutdown(shutdownCtx)
+	if err != nil {
+		utils.CheckError(err, "[server]", "shutdown", "forcing remaining connections closed")
+		s.http.Close()
+	}
+	if err := <-serveErr; err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return err
+}
@@ -0,0 +1,305 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// start runs a server for handler on a Unix socket in a temporary directory
+// and returns the socket, the cancel stopping it and the error Run returns.
+func start(t *testing.T, config Config, handler http.Handler) (string, context.CancelFunc, <-chan error) {
+	t.Helper()
+	if config.UnixSocket == "" {
+		config.UnixSocket = filepath.Join(t.TempDir(), "server.sock")
+	}
+	if config.ShutdownTimeout == 0 {
+		config.ShutdownTimeout = 5 * time.Second
+	}
+	srv, err := New(config, handler)
+	if err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	stopped := make(chan struct{})
+	go func() {
+		done <- srv.Run(ctx)
+		close(stopped)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-stopped
+	})
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if connection, err := net.Dial("unix", config.UnixSocket); err == nil {
+			connection.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("server not listening on %v", config.UnixSocket)
+		}
+	}
+	return config.UnixSocket, cancel, done
+}
+
+// client sends its requests to the server listening on socket, over TLS if
+// tlsConfig is not nil.
+func client(socket string, tlsConfig *tls.Config) *http.Client {
+	return &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+			},
+			TLSClientConfig:   tlsConfig,
+			DisableKeepAlives: true,
+		},
+	}
+}
+
+func get(client *http.Client, url string) (int, string, error) {
+	response, err := client.Get(url)
+	if err != nil {
+		return 0, "", err
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	return response.StatusCode, string(body), err
+}
+
+// writeCert writes a self-signed certificate for commonName and its key to
+// certFile and keyFile, modified at modTime.
+func writeCert(t *testing.T, certFile string, keyFile string, commonName string, modTime time.Time) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0o600); err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	for _, file := range []string{certFile, keyFile} {
+		if err := os.Chtimes(file, modTime, modTime); err != nil {
+			t.Fatalf(`Error: %v`, err)
+		}
+	}
+}
+
+func TestConfig(t *testing.T) {
+	if got := (Config{Host: "127.0.0.1", Port: "8080"}).Address(); got != "127.0.0.1:8080" {
+		t.Errorf("address should be host:port, got %v", got)
+	}
+	if got := (Config{Host: "127.0.0.1", Port: "8080", UnixSocket: "/run/app.sock"}).Address(); got != "unix:/run/app.sock" {
+		t.Errorf("address should be the socket, got %v", got)
+	}
+	if _, err := New(Config{TLSCertFile: "cert.pem"}, http.NotFoundHandler()); err == nil {
+		t.Errorf("a certificate without its key should be refused")
+	}
+	if _, err := New(Config{TLSCertFile: "missing.pem", TLSKeyFile: "missing.key"}, http.NotFoundHandler()); err == nil {
+		t.Errorf("a missing key pair should be refused")
+	}
+}
+
+func TestUnixSocket(t *testing.T) {
+	// A socket left behind by a previous run is replaced.
+	socket := filepath.Join(t.TempDir(), "server.sock")
+	stale, err := net.ListenUnix("unix", &net.UnixAddr{Name: socket, Net: "unix"})
+	if err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	stale.SetUnlinkOnClose(false)
+	stale.Close()
+
+	socket, cancel, done := start(t, Config{UnixSocket: socket}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello "+r.URL.Path)
+	}))
+	status, body, err := get(client(socket, nil), "http://unix/books")
+	if err != nil || status != http.StatusOK || body != "hello /books" {
+		t.Fatalf("request over the socket should be served, got %v %q %v", status, body, err)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("stopped server should return nil, got %v", err)
+	}
+	if _, err := net.Dial("unix", socket); err == nil {
+		t.Errorf("stopped server should not accept connections")
+	}
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	socket, cancel, done := start(t, Config{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			close(entered)
+			<-release
+		}
+		io.WriteString(w, "done")
+	}))
+
+	type result struct {
+		status int
+		body   string
+		err    error
+	}
+	slow := make(chan result, 1)
+	go func() {
+		status, body, err := get(client(socket, nil), "http://unix/slow")
+		slow <- result{status, body, err}
+	}()
+	<-entered
+	cancel()
+
+	// Once shutting down, new connections are refused while the request in
+	// flight goes on.
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		if _, _, err := get(client(socket, nil), "http://unix/"); err != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("shutting down server should refuse new connections")
+		}
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("server should wait for the request in flight, returned %v", err)
+	default:
+	}
+
+	close(release)
+	if got := <-slow; got.err != nil || got.status != http.StatusOK || got.body != "done" {
+		t.Errorf("request in flight should be answered, got %v %q %v", got.status, got.body, got.err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("drained server should return nil, got %v", err)
+	}
+}
+
+func TestShutdownTimeout(t *testing.T) {
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+	socket, cancel, done := start(t, Config{ShutdownTimeout: 50 * time.Millisecond}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(entered)
+		<-release
+	}))
+	go get(client(socket, nil), "http://unix/stuck")
+	<-entered
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("server closing a stuck request should say so, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("server should stop once ShutdownTimeout is over")
+	}
+}
+
+func TestCertificateReload(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	modTime := time.Now().Add(-time.Minute)
+	writeCert(t, certFile, keyFile, "first", modTime)
+
+	reloader, err := newCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	commonName := func() string {
+		cert, err := reloader.GetCertificate(&tls.ClientHelloInfo{ServerName: "localhost"})
+		if err != nil {
+			t.Fatalf(`Error: %v`, err)
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatalf(`Error: %v`, err)
+		}
+		return leaf.Subject.CommonName
+	}
+	if got := commonName(); got != "first" {
+		t.Errorf("certificate should be loaded, got %v", got)
+	}
+
+	writeCert(t, certFile, keyFile, "second", modTime.Add(time.Second))
+	if got := commonName(); got != "second" {
+		t.Errorf("renewed certificate should be served, got %v", got)
+	}
+
+	// A key not matching the certificate, as while only one file has been
+	// replaced, keeps the previous pair.
+	writeCert(t, certFile, filepath.Join(dir, "other.pem"), "third", modTime.Add(2*time.Second))
+	if got := commonName(); got != "second" {
+		t.Errorf("invalid pair should keep the previous certificate, got %v", got)
+	}
+}
+
+func TestTLS(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	modTime := time.Now().Add(-time.Minute)
+	writeCert(t, certFile, keyFile, "first", modTime)
+
+	socket, _, _ := start(t, Config{TLSCertFile: certFile, TLSKeyFile: keyFile}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.TLS.ServerName)
+	}))
+	served := func() string {
+		var commonName string
+		tlsConfig := &tls.Config{
+			ServerName:         "localhost",
+			InsecureSkipVerify: true,
+			VerifyConnection: func(state tls.ConnectionState) error {
+				commonName = state.PeerCertificates[0].Subject.CommonName
+				return nil
+			},
+		}
+		status, body, err := get(client(socket, tlsConfig), "https://localhost/")
+		if err != nil || status != http.StatusOK || body != "localhost" {
+			t.Fatalf("request over TLS should be served, got %v %q %v", status, body, err)
+		}
+		return commonName
+	}
+	if got := served(); got != "first" {
+		t.Errorf("server should present its certificate, got %v", got)
+	}
+	writeCert(t, certFile, keyFile, "second", modTime.Add(time.Second))
+	if got := served(); got != "second" {
+		t.Errorf("server should present the renewed certificate without a restart, got %v", got)
+	}
+}
@@ -0,0 +1,111 @@
+package src
+
+import (
+	"context"
+
+	uuid "github.com/google/uuid"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/tracing"
+	"github.com/sirupsen/logrus"
+)
+
+type requestIdKey struct{}
+
+func ContextWithRequestId(ctx context.Context, requestId string) context.Context {
+	return context.WithValue(ctx, requestIdKey{}, requestId)
+}
+
+func RequestIdFromContext(ctx context.Context) string {
+	requestId, _ := ctx.Value(requestIdKey{}).(string)
+	return requestId
+}
+
+type principalKey struct{}
+
+// ContextWithPrincipal records who makes the request, as named by the
+// principal header or bearer token.
+func ContextWithPrincipal(ctx context.Context, principal string) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+func PrincipalFromContext(ctx context.Context) string {
+	principal, _ := ctx.Value(principalKey{}).(string)
+	return principal
+}
+
+type branchKey struct{}
+
+// ContextWithBranch records the branch of the staff making the request, to
+// which what they see and do is scoped.
+func ContextWithBranch(ctx context.Context, branch string) context.Context {
+	return context.WithValue(ctx, branchKey{}, branch)
+}
+
+// BranchFromContext returns the branch of the staff making the request, ""
+// if they are not staff of a branch.
+func BranchFromContext(ctx context.Context) string {
+	branch, _ := ctx.Value(branchKey{}).(string)
+	return branch
+}
+
+type tenantKey struct{}
+
+// ContextWithTenant records the tenant, the library, the request is made for.
+func ContextWithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey{}, tenant)
+}
+
+// TenantFromContext returns the tenant the request is made for, "" when the
+// server hosts a single library.
+func TenantFromContext(ctx context.Context) string {
+	tenant, _ := ctx.Value(tenantKey{}).(string)
+	return tenant
+}
+
+// contextFields adds the request id, trace id, tenant and principal carried by ctx so
+// log lines can be correlated with traces.
+func contextFields(ctx context.Context, fields logrus.Fields) logrus.Fields {
+	if requestId := RequestIdFromContext(ctx); requestId != "" {
+		fields["request_id"] = requestId
+	}
+	if traceId := tracing.TraceIDFromContext(ctx); traceId != "" {
+		fields["trace_id"] = traceId
+	}
+	if tenant := TenantFromContext(ctx); tenant != "" {
+		fields["tenant"] = tenant
+	}
+	if principal := PrincipalFromContext(ctx); principal != "" {
+		fields["principal"] = principal
+	}
+	return fields
+}
+
+func LogInfoContext(ctx context.Context, location string, event string, message ...string) {
+	mess := ""
+	for _, msg := range message {
+		mess = mess + msg
+	}
+	Logger.WithFields(contextFields(ctx, logrus.Fields{
+		"location": location,
+		"event":    event,
+	})).Info(mess)
+}
+
+func LogErrorContext(ctx context.Context, location string, event string, message ...string) {
+	errorId := uuid.New()
+	mess := ""
+	for _, msg := range message {
+		mess = mess + msg
+	}
+	Logger.WithFields(contextFields(ctx, logrus.Fields{
+		"id":       errorId,
+		"location": location,
+		"event":    event,
+	})).Error(mess)
+}
+
+func CheckErrorContext(ctx context.Context, err error, location string, event string, message ...string) {
+	if err != nil {
+		message = append(message, ":", err.Error())
+		LogErrorContext(ctx, location, event, message...)
+	}
+}
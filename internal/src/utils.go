@@ -22,6 +22,17 @@ func LogInfo(location string, event string, message ...string) {
 	}).Info(mess)
 }
 
+func LogWarning(location string, event string, message ...string) {
+	mess := ""
+	for _, msg := range message {
+		mess = mess + msg
+	}
+	Logger.WithFields(logrus.Fields{
+		"location": location,
+		"event":    event,
+	}).Warn(mess)
+}
+
 func LogError(location string, event string, message ...string) {
 	errorId := uuid.New()
 	mess := ""
@@ -0,0 +1,159 @@
+package tenant
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ErrInvalidToken is returned for a bearer token that is malformed, not
+// signed with the key of the resolver, or expired.
+var ErrInvalidToken = errors.New("invalid token")
+
+// Resolver finds the tenant a request is made for, from a header, a subdomain
+// of Domain, or a claim of a bearer token signed with Key (HS256). A
+// source is not used when what it needs is empty. When several sources name a
+// tenant they must agree, so that a token for a tenant opens no other.
+type Resolver struct {
+	Header string
+	Domain string
+	Key    string
+	Claim  string
+	// Now is the time tokens expire against, time.Now if nil.
+	Now func() time.Time
+}
+
+// Resolve returns the code of the tenant of request, "" if it names none.
+func (r Resolver) Resolve(request *http.Request) (string, error) {
+	type source struct{ name, code string }
+	var sources []source
+	if r.Header != "" {
+		if code := strings.TrimSpace(request.Header.Get(r.Header)); code != "" {
+			sources = append(sources, source{"header " + r.Header, code})
+		}
+	}
+	if code := r.subdomain(request.Host); code != "" {
+		sources = append(sources, source{"host", code})
+	}
+	if r.Key != "" {
+		if token := Bearer(request); token != "" {
+			code, err := r.claim(token)
+			if err != nil {
+				return "", err
+			}
+			if code != "" {
+				sources = append(sources, source{"token", code})
+			}
+		}
+	}
+	if len(sources) == 0 {
+		return "", nil
+	}
+	for _, other := range sources[1:] {
+		if other.code != sources[0].code {
+			return "", fmt.Errorf("%w: the %v names %v but the %v names %v", ErrInvalidTenant, sources[0].name, sources[0].code, other.name, other.code)
+		}
+	}
+	return sources[0].code, nil
+}
+
+// subdomain returns the label host adds to Domain, "" if host is not one of
+// its subdomains.
+func (r Resolver) subdomain(host string) string {
+	if r.Domain == "" {
+		return ""
+	}
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		host = name
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	label := strings.TrimSuffix(host, "."+strings.ToLower(r.Domain))
+	if label == host || label == "" || strings.Contains(label, ".") {
+		return ""
+	}
+	return label
+}
+
+// claim verifies token and returns its tenant claim, "" if it has none.
+func (r Resolver) claim(token string) (string, error) {
+	now := time.Now
+	if r.Now != nil {
+		now = r.Now
+	}
+	claims, err := VerifyToken(token, r.Key, now())
+	if err != nil {
+		return "", err
+	}
+	claim := r.Claim
+	if claim == "" {
+		claim = "tenant"
+	}
+	code, _ := claims[claim].(string)
+	return code, nil
+}
+
+// VerifyToken checks that token is a JWT signed with key (HS256) and valid
+// at now, and returns its claims.
+func VerifyToken(token, key string, now time.Time) (map[string]any, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: not a JWT", ErrInvalidToken)
+	}
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	if header.Alg != "HS256" {
+		return nil, fmt.Errorf("%w: alg %q is not HS256", ErrInvalidToken, header.Alg)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return nil, fmt.Errorf("%w: bad signature", ErrInvalidToken)
+	}
+	claims := map[string]any{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	if exp, ok := claims["exp"].(float64); ok && float64(now.Unix()) >= exp {
+		return nil, fmt.Errorf("%w: expired", ErrInvalidToken)
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && float64(now.Unix()) < nbf {
+		return nil, fmt.Errorf("%w: not valid yet", ErrInvalidToken)
+	}
+	return claims, nil
+}
+
+func decodeSegment(segment string, v any) error {
+	raw, err := base64.RawURLEncoding.DecodeString(segment)
+	if err == nil {
+		err = json.Unmarshal(raw, v)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	return nil
+}
+
+// Bearer returns the token of the Authorization header of request, "" if it
+// has none.
+func Bearer(request *http.Request) string {
+	scheme, token, found := strings.Cut(request.Header.Get("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
@@ -0,0 +1,483 @@
+package tenant
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/riszkymf/golang-rest-boilerplate/internal/events"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/handler"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/migration"
+	utils "github.com/riszkymf/golang-rest-boilerplate/internal/src"
+)
+
+const table = "tenants"
+
+var (
+	// ErrInvalidTenant wraps the reasons a tenant is refused.
+	ErrInvalidTenant = errors.New("invalid tenant")
+	// ErrTenantExists is returned when creating a tenant whose code was
+	// taken, even by a deleted tenant, whose database is kept.
+	ErrTenantExists = errors.New("tenant exists")
+	// ErrRegistryClosed is returned once the registry is closed.
+	ErrRegistryClosed = errors.New("tenant registry closed")
+)
+
+// codePattern is a DNS label, for the code to name a subdomain and a file.
+var codePattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// Tenant is a library hosted by the server, found by its code.
+type Tenant struct {
+	Code string `json:"code"`
+	Name string `json:"name"`
+}
+
+func (t Tenant) Validate() error {
+	if err := ValidateCode(t.Code); err != nil {
+		return err
+	}
+	if t.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidTenant)
+	}
+	return nil
+}
+
+// ValidateCode refuses the codes that are not lowercase letters, digits and
+// inner dashes, at most 63 of them.
+func ValidateCode(code string) error {
+	if !codePattern.MatchString(code) {
+		return fmt.Errorf("%w: code %q must be lowercase letters, digits and dashes", ErrInvalidTenant, code)
+	}
+	return nil
+}
+
+type Options struct {
+	// Dir holds the database of each tenant, named after its code.
+	Dir string
+	// AutoMigrate applies the pending migrations to the database of a tenant
+	// when it is opened. Those of a new tenant are always applied.
+	AutoMigrate bool
+	// ReplayLimit is that of the event broker of each tenant.
+	ReplayLimit int
+}
+
+// Registry keeps the tenants in the tenants table of Connection, the control
+// database, and opens the database of each one the first time it is asked
+// for. A context given by Context queries the database of its tenant only,
+// and its event streams follow the outbox of that database.
+type Registry struct {
+	mu        sync.Mutex
+	options   Options
+	hooks     []func(ctx context.Context)
+	libraries map[string]*library
+	closed    bool
+}
+
+// library is the open database of a tenant and what works on it. It is
+// registered before it is opened, for the requests to the tenant to wait for
+// ready rather than open it again.
+type library struct {
+	// ready is closed once the database is opened, or failed to be with err.
+	ready chan struct{}
+	err   error
+
+	db      *sql.DB
+	broker  *events.Broker
+	cancel  context.CancelFunc
+	running sync.WaitGroup
+
+	// users counts the contexts given out and not yet released, the
+	// database being closed once they are. Once closing is set, with the
+	// error the contexts asked for then fail with, no more are given out.
+	mu      sync.Mutex
+	closing error
+	users   sync.WaitGroup
+}
+
+func NewRegistry(options Options) *Registry {
+	return &Registry{options: options, libraries: map[string]*library{}}
+}
+
+var Default = NewRegistry(Options{Dir: "tenants", AutoMigrate: true, ReplayLimit: 1000})
+
+// SetOptions applies to the databases opened from then on.
+func (r *Registry) SetOptions(options Options) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.options = options
+}
+
+// OnOpen runs hook in its own goroutine for each tenant once its database is
+// opened, e.g. to run its background work, with a context of the tenant done
+// when the tenant is deleted or the registry closed. The registry waits for
+// hook to return before closing the database.
+func (r *Registry) OnOpen(hook func(ctx context.Context)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hooks = append(r.hooks, hook)
+}
+
+// Control returns a context querying the control database, that of the
+// registry, whatever database ctx queried.
+func Control(ctx context.Context) context.Context {
+	return handler.WithDatabase(ctx, handler.Connection)
+}
+
+// Get returns the tenant code, or ErrNotFound.
+func Get(ctx context.Context, code string) (map[string]any, error) {
+	rows, err := handler.GetRowByFilterContext(Control(ctx), table, handler.FilterQuery{And: map[string][]handler.FieldFilter{
+		"code": {{Operator: "eq", Value: code, ValueType: "string"}},
+	}})
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("tenant %v: %w", code, handler.ErrNotFound)
+	}
+	return rows[0], nil
+}
+
+// List returns the tenants, by code.
+func List(ctx context.Context) ([]map[string]any, error) {
+	return handler.GetRowsContext(Control(ctx), table, handler.ListQuery{
+		Sort: []handler.SortField{{Column: "code"}},
+	})
+}
+
+// Context returns ctx scoped to the tenant code: its handler functions
+// query the database of the tenant, opened if need be. The database is kept
+// open until release is called, once ctx is no longer used. It fails with
+// ErrNotFound for a tenant that does not exist or was deleted.
+func (r *Registry) Context(ctx context.Context, code string) (scoped context.Context, release func(), err error) {
+	lib, err := r.library(ctx, code)
+	if err != nil {
+		return nil, nil, err
+	}
+	release, err = lib.hold()
+	if err != nil {
+		return nil, nil, err
+	}
+	return lib.scope(ctx, code), release, nil
+}
+
+// Hold keeps the database of the tenant ctx is scoped to open until release
+// is called, for work outliving the context it was given with, e.g. a
+// background job. It fails once the tenant is deleted, and does nothing for
+// a context scoped to no tenant.
+func Hold(ctx context.Context) (release func(), err error) {
+	lib, ok := ctx.Value(libraryKey{}).(*library)
+	if !ok {
+		return func() {}, nil
+	}
+	return lib.hold()
+}
+
+// Each calls fn with a context scoped to each tenant in turn, stopping at
+// the first error.
+func (r *Registry) Each(ctx context.Context, fn func(ctx context.Context, code string) error) error {
+	tenants, err := List(ctx)
+	if err != nil {
+		return err
+	}
+	for _, row := range tenants {
+		code, _ := row["code"].(string)
+		scoped, release, err := r.Context(ctx, code)
+		if err != nil {
+			return err
+		}
+		err = fn(scoped, code)
+		release()
+		if err != nil {
+			return fmt.Errorf("tenant %v: %w", code, err)
+		}
+	}
+	return nil
+}
+
+// OpenAll opens the database of every tenant, for the hooks to run on each
+// from the start rather than from its first request. A tenant failing to
+// open is logged and the others are still opened; the first error is
+// returned.
+func (r *Registry) OpenAll(ctx context.Context) error {
+	tenants, err := List(ctx)
+	if err != nil {
+		return err
+	}
+	var first error
+	for _, row := range tenants {
+		code, _ := row["code"].(string)
+		if _, err := r.library(ctx, code); err != nil {
+			utils.LogError("[tenant]", "open", fmt.Sprintf("tenant %v: %v", code, err))
+			if first == nil {
+				first = fmt.Errorf("tenant %v: %w", code, err)
+			}
+		}
+	}
+	return first
+}
+
+// Create registers the tenant and creates its database, with every
+// migration applied.
+func (r *Registry) Create(ctx context.Context, tenant Tenant) (row map[string]any, err error) {
+	if err := tenant.Validate(); err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil, ErrRegistryClosed
+	}
+	if _, taken := r.libraries[tenant.Code]; taken {
+		r.mu.Unlock()
+		return nil, fmt.Errorf("%w: %v", ErrTenantExists, tenant.Code)
+	}
+	lib := &library{ready: make(chan struct{})}
+	r.libraries[tenant.Code] = lib
+	options := r.options
+	r.mu.Unlock()
+
+	err = handler.WithTransaction(Control(ctx), func(ctx context.Context) error {
+		id, err := handler.InsertDataContext(ctx, table, map[string]any{
+			"code":       tenant.Code,
+			"name":       tenant.Name,
+			"created_at": time.Now().UTC().Format(time.RFC3339),
+		})
+		if handler.IsConflict(err) {
+			return fmt.Errorf("%w: %v", ErrTenantExists, tenant.Code)
+		}
+		if err != nil {
+			return err
+		}
+		if err := open(ctx, lib, tenant.Code, options, true); err != nil {
+			return err
+		}
+		row, err = handler.GetRowByIdContext(ctx, table, id)
+		return err
+	})
+	if err != nil {
+		if lib.db != nil {
+			lib.db.Close()
+			lib.db = nil
+			os.Remove(options.path(tenant.Code))
+		}
+		// Those waiting for the tenant find it was not created.
+		r.finish(tenant.Code, lib, fmt.Errorf("tenant %v: %w", tenant.Code, handler.ErrNotFound))
+		return nil, err
+	}
+	if err := r.finish(tenant.Code, lib, nil); err != nil {
+		return nil, err
+	}
+	return row, nil
+}
+
+// Delete unregisters the tenant code and closes its database, once the work
+// started on it is done and the contexts given for it are released. The
+// database file is kept, and the code is not given to another tenant.
+func (r *Registry) Delete(ctx context.Context, code string) error {
+	row, err := Get(ctx, code)
+	if err != nil {
+		return err
+	}
+	id, _ := row["id"].(int)
+	if err := handler.DeleteDataContext(Control(ctx), table, id); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	lib := r.libraries[code]
+	delete(r.libraries, code)
+	r.mu.Unlock()
+	if lib != nil {
+		lib.close(fmt.Errorf("tenant %v: %w", code, handler.ErrNotFound))
+	}
+	return nil
+}
+
+// Close stops the work started on the open databases, waits for it and for
+// the contexts given for them to be released, and closes them. The registry
+// opens no database afterwards.
+func (r *Registry) Close() error {
+	r.mu.Lock()
+	r.closed = true
+	libraries := r.libraries
+	r.libraries = map[string]*library{}
+	r.mu.Unlock()
+
+	var errs []error
+	for _, lib := range libraries {
+		if err := lib.close(ErrRegistryClosed); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+func (o Options) path(code string) string {
+	return filepath.Join(o.Dir, code+".sqlite")
+}
+
+// library returns the open database of the tenant code, opening it if it is
+// registered. r.mu is only held to find or reserve the code: the database is
+// opened and migrated without it, once, by the first request asking for it,
+// while those for the same tenant wait and those for others go on.
+func (r *Registry) library(ctx context.Context, code string) (*library, error) {
+	if err := ValidateCode(code); err != nil {
+		return nil, err
+	}
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil, ErrRegistryClosed
+	}
+	lib, opening := r.libraries[code]
+	if !opening {
+		lib = &library{ready: make(chan struct{})}
+		r.libraries[code] = lib
+	}
+	options := r.options
+	r.mu.Unlock()
+
+	if !opening {
+		_, err := Get(ctx, code)
+		if err == nil {
+			err = open(ctx, lib, code, options, false)
+		}
+		r.finish(code, lib, err)
+	}
+	select {
+	case <-lib.ready:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if lib.err != nil {
+		return nil, lib.err
+	}
+	return lib, nil
+}
+
+// open opens the database of the tenant code into lib, creating it if
+// create. A database is migrated when it is created, or if the options say
+// so.
+func open(ctx context.Context, lib *library, code string, options Options, create bool) error {
+	path := options.path(code)
+	_, err := os.Stat(path)
+	switch {
+	case create && err == nil:
+		return fmt.Errorf("%w: database %v exists", ErrTenantExists, path)
+	case create:
+		if err := os.MkdirAll(options.Dir, 0o755); err != nil {
+			return err
+		}
+	case err != nil:
+		return fmt.Errorf("database of tenant %v: %w", code, err)
+	}
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return err
+	}
+	if create || options.AutoMigrate {
+		if _, err := migration.Migrate(ctx, db); err != nil {
+			db.Close()
+			if create {
+				os.Remove(path)
+			}
+			return fmt.Errorf("migrate tenant %v: %w", code, err)
+		}
+	}
+	utils.LogInfo("[tenant]", "open", fmt.Sprintf("opened the database of tenant %v", code))
+	lib.db = db
+	lib.broker = events.NewBroker(options.ReplayLimit)
+	return nil
+}
+
+// finish ends the opening of lib, reserved for the tenant code, err telling
+// why it failed. lib is started if the code is still reserved for it, and
+// closed if the tenant was deleted or the registry closed meanwhile; the
+// error it is left with is returned.
+func (r *Registry) finish(code string, lib *library, err error) error {
+	defer close(lib.ready)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch {
+	case err != nil:
+	case r.closed:
+		err = ErrRegistryClosed
+	case r.libraries[code] != lib:
+		err = fmt.Errorf("tenant %v: %w", code, handler.ErrNotFound)
+	default:
+		r.start(code, lib)
+		return nil
+	}
+	if lib.db != nil {
+		lib.db.Close()
+	}
+	if r.libraries[code] == lib {
+		delete(r.libraries, code)
+	}
+	lib.err = err
+	return err
+}
+
+// start runs the hooks on lib, the open database of the tenant code. r.mu is
+// held.
+func (r *Registry) start(code string, lib *library) {
+	ctx, cancel := context.WithCancel(context.Background())
+	lib.cancel = cancel
+	ctx = lib.scope(ctx, code)
+	for _, hook := range r.hooks {
+		lib.running.Add(1)
+		go func(hook func(ctx context.Context)) {
+			defer lib.running.Done()
+			hook(ctx)
+		}(hook)
+	}
+}
+
+type libraryKey struct{}
+
+func (l *library) scope(ctx context.Context, code string) context.Context {
+	ctx = context.WithValue(ctx, libraryKey{}, l)
+	ctx = handler.WithDatabase(ctx, l.db)
+	ctx = events.WithBroker(ctx, l.broker)
+	return utils.ContextWithTenant(ctx, code)
+}
+
+// hold counts a user of the database until release is called, refused once
+// it is closing. Calling release again does nothing.
+func (l *library) hold() (release func(), err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closing != nil {
+		return nil, l.closing
+	}
+	l.users.Add(1)
+	var once sync.Once
+	return func() { once.Do(l.users.Done) }, nil
+}
+
+// close waits for lib to be opened, then stops the work started on it and
+// closes it once its users are done, refusing new ones with reason. A
+// library that failed to open has nothing to close.
+func (l *library) close(reason error) error {
+	<-l.ready
+	if l.err != nil {
+		return nil
+	}
+	l.mu.Lock()
+	l.closing = reason
+	l.mu.Unlock()
+	// Cancelling ends the event streams, for the requests following them to
+	// return.
+	l.cancel()
+	l.running.Wait()
+	l.users.Wait()
+	return l.db.Close()
+}
@@ -0,0 +1,235 @@
+package tenant
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/riszkymf/golang-rest-boilerplate/internal/events"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/handler"
+	utils "github.com/riszkymf/golang-rest-boilerplate/internal/src"
+	"github.com/riszkymf/golang-rest-boilerplate/internal/testdb"
+)
+
+func TestRegistry(t *testing.T) {
+	handler.Connection = testdb.Open(t)
+	ctx := context.Background()
+	registry := NewRegistry(Options{Dir: t.TempDir(), AutoMigrate: true, ReplayLimit: 10})
+	defer registry.Close()
+	opened := make(chan string, 4)
+	stopped := make(chan string, 4)
+	registry.OnOpen(func(ctx context.Context) {
+		opened <- utils.TenantFromContext(ctx)
+		<-ctx.Done()
+		stopped <- utils.TenantFromContext(ctx)
+	})
+
+	if _, err := registry.Create(ctx, Tenant{Code: "East", Name: "East library"}); !errors.Is(err, ErrInvalidTenant) {
+		t.Errorf("uppercase code should be refused, got %v", err)
+	}
+	for _, code := range []string{"east", "west"} {
+		if _, err := registry.Create(ctx, Tenant{Code: code, Name: code + " library"}); err != nil {
+			t.Fatalf(`Error: %v`, err)
+		}
+		if code := <-opened; code == "" {
+			t.Errorf("hook should get a context of the tenant")
+		}
+	}
+	if _, err := registry.Create(ctx, Tenant{Code: "east", Name: "again"}); !errors.Is(err, ErrTenantExists) {
+		t.Errorf("taken code should be refused, got %v", err)
+	}
+
+	east, releaseEast, err := registry.Context(ctx, "east")
+	if err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	defer releaseEast()
+	west, releaseWest, _ := registry.Context(ctx, "west")
+	defer releaseWest()
+	if _, err := handler.InsertDataContext(east, "author", map[string]any{"name": "Ursula K. Le Guin"}); err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	if count, _ := handler.CountRowsContext(east, "author", handler.FilterQuery{}); count != 1 {
+		t.Errorf("east should see its author, got %v", count)
+	}
+	if count, _ := handler.CountRowsContext(west, "author", handler.FilterQuery{}); count != 0 {
+		t.Errorf("west should not see the author of east, got %v", count)
+	}
+	if count, _ := handler.CountRowsContext(ctx, "author", handler.FilterQuery{}); count != 0 {
+		t.Errorf("the control database should not see the author of east, got %v", count)
+	}
+	if events.BrokerFromContext(east) == events.BrokerFromContext(west) || events.BrokerFromContext(east) == events.DefaultBroker {
+		t.Errorf("each tenant should have its own event broker")
+	}
+	if _, _, err := registry.Context(ctx, "north"); !errors.Is(err, handler.ErrNotFound) {
+		t.Errorf("unknown tenant should not be found, got %v", err)
+	}
+
+	var codes []string
+	registry.Each(ctx, func(ctx context.Context, code string) error {
+		codes = append(codes, code)
+		return nil
+	})
+	if len(codes) != 2 || codes[0] != "east" || codes[1] != "west" {
+		t.Errorf("Each should visit the tenants by code, got %v", codes)
+	}
+
+	// Deleting west stops its work at once, but closes its database only
+	// once the context still querying it is released.
+	deleted := make(chan error, 1)
+	go func() { deleted <- registry.Delete(ctx, "west") }()
+	select {
+	case code := <-stopped:
+		if code != "west" {
+			t.Errorf("deleting west should stop its work, got %v", code)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("deleting a tenant should stop its work")
+	}
+	select {
+	case err := <-deleted:
+		t.Fatalf("Delete should wait for the context of west to be released, returned %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if _, err := handler.CountRowsContext(west, "author", handler.FilterQuery{}); err != nil {
+		t.Errorf("database of west should stay open until released, got %v", err)
+	}
+	releaseWest()
+	if err := <-deleted; err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	if _, err := handler.CountRowsContext(west, "author", handler.FilterQuery{}); err == nil {
+		t.Errorf("database of west should be closed once released")
+	}
+	if _, _, err := registry.Context(ctx, "west"); !errors.Is(err, handler.ErrNotFound) {
+		t.Errorf("deleted tenant should not be found, got %v", err)
+	}
+	if _, err := registry.Create(ctx, Tenant{Code: "west", Name: "new west"}); !errors.Is(err, ErrTenantExists) {
+		t.Errorf("code of a deleted tenant should not be reused, got %v", err)
+	}
+
+	// A registry opens the databases of the tenants created by another, all
+	// at once with OpenAll.
+	other := NewRegistry(Options{Dir: registry.options.Dir, AutoMigrate: true})
+	defer other.Close()
+	other.OnOpen(func(ctx context.Context) { opened <- utils.TenantFromContext(ctx) })
+	if err := other.OpenAll(ctx); err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	select {
+	case code := <-opened:
+		if code != "east" {
+			t.Errorf("OpenAll should open east only, got %v", code)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("OpenAll should run the hooks of east")
+	}
+	reopened, release, err := other.Context(ctx, "east")
+	if err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	defer release()
+	if count, _ := handler.CountRowsContext(reopened, "author", handler.FilterQuery{}); count != 1 {
+		t.Errorf("reopened east should see its author, got %v", count)
+	}
+
+	releaseEast()
+	registry.Close()
+	if _, _, err := registry.Context(ctx, "east"); !errors.Is(err, ErrRegistryClosed) {
+		t.Errorf("closed registry should open nothing, got %v", err)
+	}
+}
+
+func TestRegistryOpensOnce(t *testing.T) {
+	handler.Connection = testdb.Open(t)
+	ctx := context.Background()
+	dir := t.TempDir()
+	creator := NewRegistry(Options{Dir: dir})
+	if _, err := creator.Create(ctx, Tenant{Code: "east", Name: "East library"}); err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	creator.Close()
+
+	registry := NewRegistry(Options{Dir: dir, AutoMigrate: true})
+	defer registry.Close()
+	var opened int32
+	registry.OnOpen(func(ctx context.Context) { atomic.AddInt32(&opened, 1) })
+	contexts := make(chan context.Context, 8)
+	var wg sync.WaitGroup
+	for i := 0; i < cap(contexts); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			scoped, release, err := registry.Context(ctx, "east")
+			if err != nil {
+				t.Errorf(`Error: %v`, err)
+				return
+			}
+			release()
+			contexts <- scoped
+		}()
+	}
+	wg.Wait()
+	close(contexts)
+	brokers := map[*events.Broker]bool{}
+	for scoped := range contexts {
+		brokers[events.BrokerFromContext(scoped)] = true
+	}
+	if len(brokers) != 1 {
+		t.Errorf("requests at once should share the database of the tenant, got %v", len(brokers))
+	}
+	registry.Close()
+	if got := atomic.LoadInt32(&opened); got != 1 {
+		t.Errorf("database should be opened once, got %v", got)
+	}
+}
+
+func TestResolver(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	resolver := Resolver{Header: "X-Tenant", Domain: "library.example", Key: "secret", Claim: "tenant", Now: func() time.Time { return now }}
+	token := func(secret string, claims string) string {
+		encode := base64.RawURLEncoding.EncodeToString
+		unsigned := encode([]byte(`{"alg":"HS256","typ":"JWT"}`)) + "." + encode([]byte(claims))
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(unsigned))
+		return unsigned + "." + encode(mac.Sum(nil))
+	}
+
+	tests := []struct {
+		name    string
+		host    string
+		headers map[string]string
+		want    string
+		err     error
+	}{
+		{"none", "library.example", nil, "", nil},
+		{"header", "localhost:8080", map[string]string{"X-Tenant": "east"}, "east", nil},
+		{"subdomain", "East.library.example:8080", nil, "east", nil},
+		{"nested subdomain", "a.east.library.example", nil, "", nil},
+		{"other domain", "east.example", nil, "", nil},
+		{"token", "localhost", map[string]string{"Authorization": "Bearer " + token("secret", `{"tenant":"east","exp":1709298000}`)}, "east", nil},
+		{"token without claim", "localhost", map[string]string{"Authorization": "Bearer " + token("secret", `{"sub":"ada"}`)}, "", nil},
+		{"expired token", "localhost", map[string]string{"Authorization": "Bearer " + token("secret", `{"tenant":"east","exp":1709290800}`)}, "", ErrInvalidToken},
+		{"forged token", "localhost", map[string]string{"Authorization": "Bearer " + token("guess", `{"tenant":"east"}`)}, "", ErrInvalidToken},
+		{"agreeing sources", "east.library.example", map[string]string{"X-Tenant": "east"}, "east", nil},
+		{"header against token", "localhost", map[string]string{"X-Tenant": "west", "Authorization": "Bearer " + token("secret", `{"tenant":"east"}`)}, "", ErrInvalidTenant},
+	}
+	for _, test := range tests {
+		request := httptest.NewRequest("GET", "/books", nil)
+		request.Host = test.host
+		for key, value := range test.headers {
+			request.Header.Set(key, value)
+		}
+		got, err := resolver.Resolve(request)
+		if got != test.want || !errors.Is(err, test.err) {
+			t.Errorf("%v: got %q %v, want %q %v", test.name, got, err, test.want, test.err)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+// Package testdb opens the databases the tests of the other packages run
+// against.
+package testdb
+
+import (
+	"context"
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/riszkymf/golang-rest-boilerplate/internal/migration"
+)
+
+// Open returns a migrated SQLite database in a temporary directory of t,
+// closed when t ends. Tests using the handler set it as handler.Connection.
+func Open(t testing.TB) *sql.DB {
+	t.Helper()
+	connection, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.sqlite"))
+	if err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	t.Cleanup(func() { connection.Close() })
+	if _, err := migration.Migrate(context.Background(), connection); err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+	return connection
+}
@@ -0,0 +1,225 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const instrumentationScope = "github.com/riszkymf/golang-rest-boilerplate"
+
+type spanRecord struct {
+	TraceID      string         `json:"trace_id"`
+	SpanID       string         `json:"span_id"`
+	ParentSpanID string         `json:"parent_span_id,omitempty"`
+	Name         string         `json:"name"`
+	Kind         string         `json:"kind"`
+	Start        time.Time      `json:"start"`
+	End          time.Time      `json:"end"`
+	DurationMs   float64        `json:"duration_ms"`
+	Attributes   map[string]any `json:"attributes,omitempty"`
+	Status       string         `json:"status"`
+	StatusMsg    string         `json:"status_message,omitempty"`
+	Service      string         `json:"service,omitempty"`
+}
+
+// WriterExporter writes one JSON document per span, usable offline with
+// stdout or a file.
+type WriterExporter struct {
+	mu     sync.Mutex
+	out    io.Writer
+	closer io.Closer
+}
+
+func NewWriterExporter(out io.Writer) *WriterExporter {
+	return &WriterExporter{out: out}
+}
+
+func NewFileExporter(path string) (*WriterExporter, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &WriterExporter{out: file, closer: file}, nil
+}
+
+func (e *WriterExporter) ExportSpans(ctx context.Context, spans []*Span) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	encoder := json.NewEncoder(e.out)
+	for _, span := range spans {
+		span.mu.Lock()
+		record := spanRecord{
+			TraceID:    span.Context.TraceID.String(),
+			SpanID:     span.Context.SpanID.String(),
+			Name:       span.Name,
+			Kind:       kindName(span.Kind),
+			Start:      span.Start,
+			End:        span.End,
+			DurationMs: float64(span.End.Sub(span.Start).Microseconds()) / 1000,
+			Attributes: span.Attributes,
+			Status:     statusName(span.Status),
+			StatusMsg:  span.StatusMsg,
+			Service:    span.tracer.ServiceName,
+		}
+		if span.Parent.SpanID.IsValid() {
+			record.ParentSpanID = span.Parent.SpanID.String()
+		}
+		err := encoder.Encode(record)
+		span.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *WriterExporter) Shutdown(ctx context.Context) error {
+	if e.closer != nil {
+		return e.closer.Close()
+	}
+	return nil
+}
+
+// OTLPExporter sends spans to an OpenTelemetry collector using OTLP/HTTP
+// with the JSON encoding.
+type OTLPExporter struct {
+	Endpoint string
+	Headers  map[string]string
+	Client   *http.Client
+}
+
+func NewOTLPExporter(endpoint string, headers map[string]string) *OTLPExporter {
+	endpoint = strings.TrimRight(endpoint, "/")
+	if !strings.HasSuffix(endpoint, "/v1/traces") {
+		endpoint = endpoint + "/v1/traces"
+	}
+	return &OTLPExporter{
+		Endpoint: endpoint,
+		Headers:  headers,
+		Client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (e *OTLPExporter) ExportSpans(ctx context.Context, spans []*Span) error {
+	if len(spans) == 0 {
+		return nil
+	}
+	body, err := json.Marshal(otlpPayload(spans))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range e.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := e.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("otlp exporter: collector answered %v", resp.Status)
+	}
+	return nil
+}
+
+func (e *OTLPExporter) Shutdown(ctx context.Context) error {
+	e.Client.CloseIdleConnections()
+	return nil
+}
+
+type otlpKeyValue struct {
+	Key   string         `json:"key"`
+	Value map[string]any `json:"value"`
+}
+
+func otlpPayload(spans []*Span) map[string]any {
+	service := spans[0].tracer.ServiceName
+	otlpSpans := make([]map[string]any, 0, len(spans))
+	for _, span := range spans {
+		span.mu.Lock()
+		item := map[string]any{
+			"traceId":           span.Context.TraceID.String(),
+			"spanId":            span.Context.SpanID.String(),
+			"name":              span.Name,
+			"kind":              int(span.Kind),
+			"startTimeUnixNano": strconv.FormatInt(span.Start.UnixNano(), 10),
+			"endTimeUnixNano":   strconv.FormatInt(span.End.UnixNano(), 10),
+			"attributes":        otlpAttributes(span.Attributes),
+			"status":            map[string]any{"code": int(span.Status), "message": span.StatusMsg},
+		}
+		if span.Parent.SpanID.IsValid() {
+			item["parentSpanId"] = span.Parent.SpanID.String()
+		}
+		span.mu.Unlock()
+		otlpSpans = append(otlpSpans, item)
+	}
+	return map[string]any{
+		"resourceSpans": []map[string]any{{
+			"resource": map[string]any{
+				"attributes": otlpAttributes(map[string]any{"service.name": service}),
+			},
+			"scopeSpans": []map[string]any{{
+				"scope": map[string]any{"name": instrumentationScope},
+				"spans": otlpSpans,
+			}},
+		}},
+	}
+}
+
+func otlpAttributes(attributes map[string]any) []otlpKeyValue {
+	result := make([]otlpKeyValue, 0, len(attributes))
+	for k, v := range attributes {
+		var value map[string]any
+		switch typed := v.(type) {
+		case string:
+			value = map[string]any{"stringValue": typed}
+		case bool:
+			value = map[string]any{"boolValue": typed}
+		case int:
+			value = map[string]any{"intValue": strconv.Itoa(typed)}
+		case int64:
+			value = map[string]any{"intValue": strconv.FormatInt(typed, 10)}
+		case float64:
+			value = map[string]any{"doubleValue": typed}
+		default:
+			value = map[string]any{"stringValue": fmt.Sprintf("%v", typed)}
+		}
+		result = append(result, otlpKeyValue{Key: k, Value: value})
+	}
+	return result
+}
+
+func kindName(kind SpanKind) string {
+	switch kind {
+	case SpanKindServer:
+		return "server"
+	case SpanKindClient:
+		return "client"
+	}
+	return "internal"
+}
+
+func statusName(status StatusCode) string {
+	switch status {
+	case StatusOk:
+		return "ok"
+	case StatusError:
+		return "error"
+	}
+	return "unset"
+}
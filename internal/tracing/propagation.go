@@ -0,0 +1,73 @@
+package tracing
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+const (
+	TraceparentHeader = "traceparent"
+	TracestateHeader  = "tracestate"
+)
+
+// ParseTraceparent decodes a W3C trace context header of the form
+// 00-<trace-id>-<parent-id>-<flags>.
+func ParseTraceparent(value string) (SpanContext, error) {
+	parts := strings.Split(strings.TrimSpace(value), "-")
+	if len(parts) < 4 {
+		return SpanContext{}, fmt.Errorf("invalid traceparent %q", value)
+	}
+	version, traceHex, spanHex, flagsHex := parts[0], parts[1], parts[2], parts[3]
+	if len(version) != 2 || version == "ff" || (version == "00" && len(parts) != 4) {
+		return SpanContext{}, fmt.Errorf("unsupported traceparent version %q", version)
+	}
+	var sc SpanContext
+	if len(traceHex) != 32 || len(spanHex) != 16 || len(flagsHex) != 2 {
+		return SpanContext{}, fmt.Errorf("invalid traceparent %q", value)
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(traceHex)); err != nil {
+		return SpanContext{}, err
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(spanHex)); err != nil {
+		return SpanContext{}, err
+	}
+	flags, err := hex.DecodeString(flagsHex)
+	if err != nil {
+		return SpanContext{}, err
+	}
+	if !sc.IsValid() {
+		return SpanContext{}, fmt.Errorf("invalid traceparent %q", value)
+	}
+	sc.Sampled = flags[0]&0x01 == 0x01
+	sc.Remote = true
+	return sc, nil
+}
+
+func FormatTraceparent(sc SpanContext) string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return fmt.Sprintf("00-%v-%v-%v", sc.TraceID, sc.SpanID, flags)
+}
+
+// Extract returns ctx carrying the remote parent found in the headers, if any.
+func Extract(ctx context.Context, header http.Header) context.Context {
+	sc, err := ParseTraceparent(header.Get(TraceparentHeader))
+	if err != nil {
+		return ctx
+	}
+	return ContextWithRemoteSpanContext(ctx, sc)
+}
+
+// Inject writes the current span of ctx as traceparent on outgoing headers.
+func Inject(ctx context.Context, header http.Header) {
+	sc := SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return
+	}
+	header.Set(TraceparentHeader, FormatTraceparent(sc))
+}
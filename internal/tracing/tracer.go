@@ -0,0 +1,171 @@
+package tracing
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	batchSize     = 256
+	queueSize     = 2048
+	flushInterval = 5 * time.Second
+)
+
+type Exporter interface {
+	ExportSpans(ctx context.Context, spans []*Span) error
+	Shutdown(ctx context.Context) error
+}
+
+// Tracer creates spans and exports the finished ones in batches. A tracer
+// without exporter still creates ids so requests can be correlated in logs.
+type Tracer struct {
+	ServiceName string
+	exporter    Exporter
+	onError     func(error)
+	queue       chan *Span
+	flush       chan chan struct{}
+	stopped     chan struct{}
+	stopOnce    sync.Once
+}
+
+var (
+	globalMu     sync.RWMutex
+	globalTracer = &Tracer{}
+)
+
+func NewTracer(serviceName string, exporter Exporter, onError func(error)) *Tracer {
+	t := &Tracer{
+		ServiceName: serviceName,
+		exporter:    exporter,
+		onError:     onError,
+	}
+	if exporter != nil {
+		t.queue = make(chan *Span, queueSize)
+		t.flush = make(chan chan struct{})
+		t.stopped = make(chan struct{})
+		go t.run()
+	}
+	return t
+}
+
+func SetTracer(t *Tracer) {
+	globalMu.Lock()
+	defer globalMu.Unlock()
+	globalTracer = t
+}
+
+func GetTracer() *Tracer {
+	globalMu.RLock()
+	defer globalMu.RUnlock()
+	return globalTracer
+}
+
+// StartSpan starts a span on the global tracer as a child of the span (or
+// remote parent) carried by ctx.
+func StartSpan(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	return GetTracer().StartSpan(ctx, name, kind)
+}
+
+func (t *Tracer) StartSpan(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	parent := SpanContextFromContext(ctx)
+	span := &Span{
+		tracer:     t,
+		Name:       name,
+		Kind:       kind,
+		Parent:     parent,
+		Start:      time.Now(),
+		Attributes: map[string]any{},
+	}
+	if parent.TraceID.IsValid() {
+		span.Context = SpanContext{TraceID: parent.TraceID, SpanID: newSpanID(), Sampled: parent.Sampled}
+	} else {
+		span.Context = SpanContext{TraceID: newTraceID(), SpanID: newSpanID(), Sampled: true}
+	}
+	return ContextWithSpan(ctx, span), span
+}
+
+func (t *Tracer) export(span *Span) {
+	if t.exporter == nil {
+		return
+	}
+	select {
+	case <-t.stopped:
+	case t.queue <- span:
+	default:
+		// Queue full, drop the span rather than blocking the request.
+	}
+}
+
+func (t *Tracer) run() {
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	batch := make([]*Span, 0, batchSize)
+	send := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := t.exporter.ExportSpans(ctx, batch); err != nil && t.onError != nil {
+			t.onError(err)
+		}
+		batch = make([]*Span, 0, batchSize)
+	}
+	for {
+		select {
+		case span := <-t.queue:
+			batch = append(batch, span)
+			if len(batch) >= batchSize {
+				send()
+			}
+		case <-ticker.C:
+			send()
+		case ack := <-t.flush:
+			for len(t.queue) > 0 {
+				batch = append(batch, <-t.queue)
+			}
+			send()
+			close(ack)
+		case <-t.stopped:
+			for len(t.queue) > 0 {
+				batch = append(batch, <-t.queue)
+			}
+			send()
+			return
+		}
+	}
+}
+
+// ForceFlush exports every queued span before returning.
+func (t *Tracer) ForceFlush(ctx context.Context) error {
+	if t.exporter == nil {
+		return nil
+	}
+	ack := make(chan struct{})
+	select {
+	case t.flush <- ack:
+	case <-t.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case <-ack:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t.exporter == nil {
+		return nil
+	}
+	err := t.ForceFlush(ctx)
+	t.stopOnce.Do(func() { close(t.stopped) })
+	if shutdownErr := t.exporter.Shutdown(ctx); err == nil {
+		err = shutdownErr
+	}
+	return err
+}
@@ -0,0 +1,70 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestTraceparentPropagation(t *testing.T) {
+	header := http.Header{}
+	header.Set(TraceparentHeader, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+
+	var out bytes.Buffer
+	tracer := NewTracer("test", NewWriterExporter(&out), func(err error) { t.Errorf(`Error: %v`, err) })
+	ctx := Extract(context.Background(), header)
+	ctx, requestSpan := tracer.StartSpan(ctx, "GET /books/{book-id}", SpanKindServer)
+	_, querySpan := tracer.StartSpan(ctx, "handler.select books", SpanKindClient)
+	querySpan.SetAttribute("db.rows", 1)
+	querySpan.Finish()
+	requestSpan.Finish()
+	if err := tracer.Shutdown(context.Background()); err != nil {
+		t.Fatalf(`Error: %v`, err)
+	}
+
+	if TraceIDFromContext(ctx) != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("trace id was not propagated, got %v", TraceIDFromContext(ctx))
+	}
+	if querySpan.Parent.SpanID != requestSpan.Context.SpanID {
+		t.Errorf("handler span should be a child of the request span")
+	}
+	if requestSpan.Parent.SpanID.String() != "00f067aa0ba902b7" {
+		t.Errorf("request span should continue the remote parent, got %v", requestSpan.Parent.SpanID)
+	}
+
+	outgoing := http.Header{}
+	Inject(ctx, outgoing)
+	if outgoing.Get(TraceparentHeader) != FormatTraceparent(requestSpan.Context) {
+		t.Errorf("unexpected traceparent %v", outgoing.Get(TraceparentHeader))
+	}
+
+	decoder := json.NewDecoder(&out)
+	exported := 0
+	for decoder.More() {
+		var record spanRecord
+		if err := decoder.Decode(&record); err != nil {
+			t.Fatalf(`Error: %v`, err)
+		}
+		exported++
+	}
+	if exported != 2 {
+		t.Errorf("expected 2 exported spans, got %v", exported)
+	}
+}
+
+func TestParseTraceparentRejectsInvalid(t *testing.T) {
+	invalid := []string{
+		"",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+	}
+	for _, value := range invalid {
+		if _, err := ParseTraceparent(value); err == nil {
+			t.Errorf("expected %q to be rejected", value)
+		}
+	}
+}